@@ -1,6 +1,7 @@
 package activitypub
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/x509"
 	"encoding/pem"
@@ -8,39 +9,74 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/davecheney/pub/internal/httpx"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/to"
+	"github.com/davecheney/pub/internal/urls"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-json-experiment/json"
+	"gorm.io/gorm"
 )
 
 type Env struct {
 	*models.Env
+	// URLs builds the URLs of the instance the request was made to.
+	URLs *urls.Builder
 }
 
-func (e *Env) GetKey(keyID string) (crypto.PublicKey, error) {
-
-	// defer resolving the admin actor until we need use it to fetch the remote actor
-	fetch := func(uri string) (*models.Actor, error) {
-		var instance models.Instance
-		if err := e.DB.Joins("Admin").Preload("Admin.Actor").Take(&instance, "admin_id is not null").Error; err != nil {
-			return nil, err
-		}
-		fetcher := NewRemoteActorFetcher(instance.Admin, e.DB)
-		return fetcher.Fetch(uri)
+// keyOwner returns the actor which owns keyID. If the actor is not already
+// known, or is stale, it is fetched, signed by the service account of the
+// instance for domain.
+func (e *Env) keyOwner(domain, keyID string) (*models.Actor, error) {
+	instance, err := models.NewInstances(e.DB).FindByDomain(domain)
+	if err != nil {
+		return nil, err
 	}
+	fetcher := NewRemoteActorFetcher(instance.ServiceAccount, e.DB, e.ClientTimeout)
+	return e.Actors(instance).FindOrCreate(trimKeyId(keyID), fetcher.Fetch)
+}
 
-	actor, err := models.NewActors(e.DB).FindOrCreate(trimKeyId(keyID), fetch)
+// refreshPublicKey refetches a remote actor, signed by the service account of
+// the instance for domain, and updates its cached public key.
+func (e *Env) refreshPublicKey(domain string, actor *models.Actor) error {
+	var count int64
+	if err := e.DB.Model(&models.Instance{}).Where("domain = ?", actor.Domain).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("refreshPublicKey: %s: signature does not match local actor", actor.URI)
+	}
+	// avoid refetching the actor for every request with an invalid signature.
+	if time.Since(actor.UpdatedAt) < time.Minute {
+		return fmt.Errorf("refreshPublicKey: %s: public key recently refreshed", actor.URI)
+	}
+	instance, err := models.NewInstances(e.DB).FindByDomain(domain)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	fetched, err := NewRemoteActorFetcher(instance.ServiceAccount, e.DB, e.ClientTimeout).Fetch(actor.URI)
+	if err != nil {
+		return err
+	}
+	unchanged := bytes.Equal(fetched.PublicKey, actor.PublicKey)
+	// always update, this records the time of the refresh in updated_at.
+	if err := e.DB.Model(actor).Update("public_key", fetched.PublicKey).Error; err != nil {
+		return err
+	}
+	if unchanged {
+		return fmt.Errorf("refreshPublicKey: %s: public key unchanged", actor.URI)
 	}
-	return pemToPublicKey(actor.PublicKey)
+	return nil
 }
 
 func pemToPublicKey(key []byte) (crypto.PublicKey, error) {
 	block, _ := pem.Decode(key)
+	if block == nil {
+		return nil, errors.New("pemToPublicKey: no pem block found")
+	}
 	if block.Type != "PUBLIC KEY" {
 		return nil, fmt.Errorf("pemToPublicKey: invalid pem type: %s", block.Type)
 	}
@@ -60,33 +96,65 @@ func trimKeyId(id string) string {
 	return id
 }
 
-func FollowersIndex(w http.ResponseWriter, r *http.Request) {
-	to.JSON(w, map[string]any{
+func FollowersIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return to.JSON(w, map[string]any{
 		"@context":     "https://www.w3.org/ns/activitystreams",
-		"id":           fmt.Sprintf("https://%s/users/%s/followers", r.Host, chi.URLParam(r, "username")),
+		"id":           env.URLs.Followers(chi.URLParam(r, "username")),
 		"type":         "OrderedCollection",
 		"totalItems":   0,
 		"orderedItems": []any{},
 	})
 }
 
-func FollowingIndex(w http.ResponseWriter, r *http.Request) {
-	to.JSON(w, map[string]any{
+func FollowingIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return to.JSON(w, map[string]any{
 		"@context":     "https://www.w3.org/ns/activitystreams",
-		"id":           fmt.Sprintf("https://%s/users/%s/following", r.Host, chi.URLParam(r, "username")),
+		"id":           env.URLs.Following(chi.URLParam(r, "username")),
 		"type":         "OrderedCollection",
 		"totalItems":   0,
 		"orderedItems": []any{},
 	})
 }
 
-func CollectionsShow(w http.ResponseWriter, r *http.Request) {
-	to.JSON(w, map[string]any{
-		"@context":     "https://www.w3.org/ns/activitystreams",
-		"id":           fmt.Sprintf("https://%s/users/%s/collections/%s", r.Host, chi.URLParam(r, "username"), chi.URLParam(r, "collection")),
-		"type":         "OrderedCollection",
-		"totalItems":   0,
-		"orderedItems": []any{},
+// CollectionsShow returns the named collection of the actor. The tags
+// collection holds the actor's featured tags, the other collections are empty.
+func CollectionsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	name, collection := chi.URLParam(r, "username"), chi.URLParam(r, "collection")
+	id := env.URLs.Collection(name, collection)
+	if collection != "tags" {
+		return to.JSON(w, map[string]any{
+			"@context":     "https://www.w3.org/ns/activitystreams",
+			"id":           id,
+			"type":         "OrderedCollection",
+			"totalItems":   0,
+			"orderedItems": []any{},
+		})
+	}
+	var actor models.Actor
+	if err := env.DB.Take(&actor, "name = ? AND domain = ?", name, r.Host).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	featured, err := models.NewTags(env.DB).Featured(&actor)
+	if err != nil {
+		return err
+	}
+	items := make([]any, 0, len(featured))
+	for _, f := range featured {
+		items = append(items, map[string]any{
+			"type": "Hashtag",
+			"href": env.URLs.Tag(f.Tag.Name),
+			"name": "#" + f.Tag.Name,
+		})
+	}
+	return to.JSON(w, map[string]any{
+		"@context":   "https://www.w3.org/ns/activitystreams",
+		"id":         id,
+		"type":       "Collection",
+		"totalItems": len(items),
+		"items":      items,
 	})
 }
 
@@ -105,6 +173,14 @@ func mapFromAny(v any) map[string]any {
 	return m
 }
 
+// idFromAny returns the id of v, which may be a URI or an object.
+func idFromAny(v any) string {
+	if m, ok := v.(map[string]any); ok {
+		return stringFromAny(m["id"])
+	}
+	return stringFromAny(v)
+}
+
 func timeFromAnyOrZero(v any) time.Time {
 	switch v := v.(type) {
 	case string:
@@ -139,6 +215,24 @@ func intFromAny(v any) int {
 	return 0
 }
 
+// stringsFromAny returns the strings in v, which may be a string or a slice.
+func stringsFromAny(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		var s []string
+		for _, e := range v {
+			if e, ok := e.(string); ok {
+				s = append(s, e)
+			}
+		}
+		return s
+	default:
+		return nil
+	}
+}
+
 func anyToSlice(v any) []any {
 	switch v := v.(type) {
 	case []any:
@@ -154,3 +248,33 @@ func marshalIndent(v any) ([]byte, error) {
 	}, v)
 	return b, err
 }
+
+// forEach calls fn for each of items, running at most workers calls at once.
+// forEach waits for every call to return, and returns the first error, if any.
+func forEach[T any](workers int, items []T, fn func(T) error) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+	)
+	sem := make(chan struct{}, workers)
+	for _, item := range items {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(item T) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := fn(item); err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}(item)
+	}
+	wg.Wait()
+	return first
+}
@@ -0,0 +1,248 @@
+package activitypub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/davecheney/pub/internal/activitypub"
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxActorAttempts is the number of times an actor request is attempted
+// before it is abandoned.
+const maxActorAttempts = 5
+
+// actorRetryDelay returns the time to wait after the last of attempts
+// before an actor request is retried. The delay doubles with each attempt.
+func actorRetryDelay(attempts uint32) time.Duration {
+	return 5 * time.Minute << (attempts - 1)
+}
+
+// ActorRequestProcessor handles delivery of actor requests.
+type ActorRequestProcessor struct {
+	db      *gorm.DB
+	workers int
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
+}
+
+func NewActorRequestProcessor(db *gorm.DB, workers int, timeout time.Duration) *ActorRequestProcessor {
+	return &ActorRequestProcessor{
+		db:      db,
+		workers: workers,
+		timeout: timeout,
+	}
+}
+
+func (arp *ActorRequestProcessor) Run(stop <-chan struct{}) error {
+	fmt.Println("ActorRequestProcessor.Run started")
+	defer fmt.Println("ActorRequestProcessor.Run stopped")
+
+	for {
+		if err := arp.process(); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(30 * time.Second):
+			// continue
+		}
+	}
+}
+
+// process make one pass through the ActorRequest table, processing
+// any pending requests.
+func (arp *ActorRequestProcessor) process() error {
+	var requests []*models.ActorRequest
+	if err := arp.db.Preload("Actor").Preload("Instance.ServiceAccount.Actor").Find(&requests).Error; err != nil {
+		return err
+	}
+	// failed requests wait before they are retried.
+	var due []*models.ActorRequest
+	for _, request := range requests {
+		if request.Attempts == 0 || time.Since(request.LastAttempt) >= actorRetryDelay(request.Attempts) {
+			due = append(due, request)
+		}
+	}
+
+	return forEach(arp.workers, due, func(request *models.ActorRequest) error {
+		if err := arp.processRequest(request); err != nil {
+			request.LastAttempt = time.Now()
+			request.Attempts++
+			request.LastResult = err.Error()
+			if request.Attempts < maxActorAttempts {
+				return arp.db.Save(request).Error
+			}
+			fmt.Println("ActorRequestProcessor.process: actor:", request.Actor.URI, "action:", request.Action, "abandoned:", err)
+		}
+		if err := arp.db.Delete(request).Error; err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (arp *ActorRequestProcessor) processRequest(request *models.ActorRequest) error {
+	fmt.Println("ActorRequestProcessor.processRequest: actor:", request.Actor.URI, "action:", request.Action)
+
+	if request.Action == "refresh" {
+		return arp.processRefreshRequest(request)
+	}
+
+	accounts := models.NewAccounts(arp.db)
+	account, err := accounts.AccountForActor(request.Actor)
+	if err != nil {
+		return err
+	}
+	// reload the actor with the associations needed to serialise it.
+	if err := arp.db.Preload("Attributes").Preload("MovedTo").First(account.Actor, account.ActorID).Error; err != nil {
+		return err
+	}
+
+	switch request.Action {
+	case "update":
+		return arp.processUpdateRequest(request, account)
+	case "move":
+		return arp.processMoveRequest(request, account)
+	default:
+		return fmt.Errorf("unknown action %q", request.Action)
+	}
+}
+
+// processUpdateRequest sends an Update activity for the account's actor to the
+// inboxes of its followers.
+func (arp *ActorRequestProcessor) processUpdateRequest(request *models.ActorRequest, account *models.Account) error {
+	client, err := activitypub.NewClient(arp.db.Statement.Context, account, arp.timeout)
+	if err != nil {
+		return err
+	}
+	actor := account.Actor
+	update := map[string]any{
+		"@context": actorContext,
+		"id":       actor.URI + "#updates/" + strconv.FormatInt(request.CreatedAt.Unix(), 10),
+		"type":     "Update",
+		"actor":    actor.URI,
+		"to":       []any{"https://www.w3.org/ns/activitystreams#Public"},
+		"object":   serialiseActor(actor),
+	}
+	return deliverToFollowers(arp.db, client, account, request, update)
+}
+
+// processMoveRequest sends a Move activity from the account's actor to the
+// actor it has moved to, to the inboxes of its followers.
+func (arp *ActorRequestProcessor) processMoveRequest(request *models.ActorRequest, account *models.Account) error {
+	actor := account.Actor
+	if actor.MovedTo == nil {
+		return fmt.Errorf("actor %q has not moved", actor.URI)
+	}
+	client, err := activitypub.NewClient(arp.db.Statement.Context, account, arp.timeout)
+	if err != nil {
+		return err
+	}
+	move := map[string]any{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"id":       actor.URI + "#moves/" + strconv.FormatInt(request.CreatedAt.Unix(), 10),
+		"type":     "Move",
+		"actor":    actor.URI,
+		"object":   actor.URI,
+		"target":   actor.MovedTo.URI,
+	}
+	return deliverToFollowers(arp.db, client, account, request, move)
+}
+
+// processRefreshRequest refetches a remote actor, signed by the service
+// account of the instance which requested the refresh, unless the instance
+// has suspended the actor's domain. If the actor is gone it is deleted.
+// Other failures are logged and the refresh is retried once the actor is
+// stale again.
+func (arp *ActorRequestProcessor) processRefreshRequest(request *models.ActorRequest) error {
+	actor, instance := request.Actor, request.Instance
+	if instance == nil {
+		// queued before refreshes recorded their instance, it will be
+		// queued again when the actor is next found to be stale.
+		return nil
+	}
+	if instance.ServiceAccount == nil {
+		return fmt.Errorf("instance %s has no service account", instance.Domain)
+	}
+	if actor.IsLocal() || actor.Domain == instance.Domain {
+		return nil
+	}
+	if _, err := domainPolicy(arp.db, instance.ServiceAccount, actor.URI); err != nil {
+		if errors.Is(err, models.ErrDomainSuspended) {
+			// don't refresh actors on suspended domains.
+			return nil
+		}
+		return err
+	}
+	client, err := activitypub.NewClient(arp.db.Statement.Context, instance.ServiceAccount, arp.timeout)
+	if err != nil {
+		return err
+	}
+	obj, err := client.Get(actor.URI)
+	if err != nil {
+		var apErr *activitypub.Error
+		if errors.As(err, &apErr) && apErr.StatusCode == http.StatusGone {
+			// the actor has been deleted, its statuses are deleted with it.
+			return arp.db.Delete(actor).Error
+		}
+	} else if id := stringFromAny(obj["id"]); id != actor.URI {
+		err = fmt.Errorf("fetched actor has id %q", id)
+	}
+	if err != nil {
+		fmt.Println("ActorRequestProcessor.processRefreshRequest: actor:", actor.URI, "error:", err)
+		return arp.db.Model(actor).UpdateColumn("updated_at", time.Now()).Error
+	}
+	updateActor(actor, obj)
+	return saveActor(arp.db, actor)
+}
+
+// deliverToFollowers posts the activity to the inboxes of the remote followers
+// of the account's actor, delivering at most once to each shared inbox.
+// Followers on domains the account's instance does not federate with are
+// skipped. The inboxes delivered to are recorded in the request, and skipped
+// if it is retried. Failures to deliver to an inbox are logged, and the last
+// is returned once every inbox has been tried so the request is retried.
+func deliverToFollowers(db *gorm.DB, client *activitypub.Client, account *models.Account, request *models.ActorRequest, activity map[string]any) error {
+	actor := account.Actor
+	var followers []*models.Relationship
+	if err := db.Joins("Actor").Where("target_id = ? and following = true", actor.ID).Find(&followers).Error; err != nil {
+		return err
+	}
+	inboxes := make(map[string]bool)
+	for _, inbox := range request.Delivered {
+		inboxes[inbox] = true
+	}
+	var lastErr error
+	for _, follower := range followers {
+		if follower.Actor.IsLocal() {
+			// local followers share our database, there is nothing to deliver.
+			continue
+		}
+		if _, err := domainPolicy(db, account, follower.Actor.URI); err != nil {
+			fmt.Println("deliverToFollowers: follower:", follower.Actor.URI, "error:", err)
+			continue
+		}
+		inbox, err := client.Inbox(follower.Actor.URI)
+		if err != nil {
+			fmt.Println("deliverToFollowers: follower:", follower.Actor.URI, "error:", err)
+			continue
+		}
+		if inboxes[inbox] {
+			continue
+		}
+		inboxes[inbox] = true
+		if err := client.Post(inbox, activity); err != nil {
+			fmt.Println("deliverToFollowers: inbox:", inbox, "error:", err)
+			lastErr = err
+			continue
+		}
+		request.Delivered = append(request.Delivered, inbox)
+	}
+	return lastErr
+}
@@ -0,0 +1,166 @@
+package activitypub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB returns an empty, migrated, in memory SQLite database.
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// each connection to :memory: is a new database.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	require.NoError(t, models.AutoMigrate(db))
+	return db
+}
+
+func TestActorRequestProcessorRetries(t *testing.T) {
+	// bob's inbox fails, carol's accepts.
+	posts := make(map[string]int)
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			name := path.Base(r.URL.Path)
+			fmt.Fprintf(w, `{"id":"%[1]s/users/%[2]s","type":"Person","inbox":"%[1]s/users/%[2]s/inbox"}`, srv.URL, name)
+		case http.MethodPost:
+			name := path.Base(path.Dir(r.URL.Path))
+			posts[name]++
+			if name == "bob" {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	db := setupTestDB(t)
+	instance := &models.Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	alice, err := models.NewAccounts(db).Create(instance, models.NewAccount{Name: "alice", Email: "alice@example.com", Password: "sssh", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	for _, name := range []string{"bob", "carol"} {
+		follower := &models.Actor{
+			ID:        snowflake.Now(),
+			Name:      name,
+			Domain:    u.Host,
+			URI:       srv.URL + "/users/" + name,
+			PublicKey: []byte("public key"),
+		}
+		require.NoError(t, db.Create(follower).Error)
+		require.NoError(t, db.Create(&models.Relationship{ActorID: follower.ID, TargetID: alice.ActorID, Following: true}).Error)
+	}
+
+	require.NoError(t, models.NewActors(db).QueueUpdate(alice.Actor))
+	arp := NewActorRequestProcessor(db, 1, 5*time.Second)
+	for i := 1; i < maxActorAttempts; i++ {
+		require.NoError(t, arp.process())
+		var request models.ActorRequest
+		require.NoError(t, db.Take(&request, "actor_id = ?", alice.ActorID).Error)
+		require.EqualValues(t, i, request.Attempts)
+		require.NotEmpty(t, request.LastResult)
+		require.Equal(t, []string{srv.URL + "/users/carol/inbox"}, request.Delivered)
+		require.Equal(t, map[string]int{"bob": i, "carol": 1}, posts)
+
+		// the request is not retried until its delay has passed.
+		require.NoError(t, arp.process())
+		require.Equal(t, map[string]int{"bob": i, "carol": 1}, posts)
+		require.NoError(t, db.Model(&request).Update("last_attempt", time.Now().Add(-actorRetryDelay(request.Attempts))).Error)
+	}
+
+	// the last attempt abandons the request.
+	require.NoError(t, arp.process())
+	var count int64
+	require.NoError(t, db.Model(&models.ActorRequest{}).Count(&count).Error)
+	require.EqualValues(t, 0, count)
+	require.Equal(t, map[string]int{"bob": maxActorAttempts, "carol": 1}, posts)
+}
+
+func TestActorRequestProcessorRefresh(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/bob":
+			fmt.Fprintf(w, `{"id":"%s/users/bob","type":"Person","preferredUsername":"bob","name":"Bob","attachment":[{"type":"PropertyValue","name":"new","value":"field"}]}`, srv.URL)
+		default:
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	db := setupTestDB(t)
+	instance := &models.Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	alice, err := models.NewAccounts(db).Create(instance, models.NewAccount{Name: "alice", Email: "alice@example.com", Password: "sssh", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	// the refresh is signed by the service account of the instance.
+	require.NoError(t, db.Model(instance).Update("service_account_id", alice.ID).Error)
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	actors := models.NewActors(db)
+	remote := make(map[string]*models.Actor)
+	// other.example has suspended the remote server.
+	other := &models.Instance{ID: snowflake.Now(), Domain: "other.example"}
+	require.NoError(t, db.Create(other).Error)
+	eve, err := models.NewAccounts(db).Create(other, models.NewAccount{Name: "eve", Email: "eve@other.example", Password: "sssh", URLs: urls.New("https://" + other.Domain)})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(other).Update("service_account_id", eve.ID).Error)
+	require.NoError(t, db.Create(&models.DomainBlock{InstanceID: other.ID, Domain: u.Host, Severity: models.SeveritySuspend}).Error)
+	for _, name := range []string{"bob", "carol", "dave", "erin"} {
+		actor := &models.Actor{
+			ID:         snowflake.Now(),
+			Name:       name,
+			Domain:     u.Host,
+			URI:        srv.URL + "/users/" + name,
+			PublicKey:  []byte("public key"),
+			Attributes: []*models.ActorAttribute{{Name: "old", Value: "field"}},
+		}
+		require.NoError(t, db.Create(actor).Error)
+		remote[name] = actor
+	}
+	require.NoError(t, actors.Refresh(instance, remote["bob"]))
+	require.NoError(t, actors.Refresh(instance, remote["carol"]))
+	// dave's refresh is requested by the instance which suspended his
+	// server, so he is not fetched, and so not found to be gone.
+	require.NoError(t, actors.Refresh(other, remote["dave"]))
+	// erin's refresh was queued before refreshes recorded their instance.
+	require.NoError(t, db.Create(&models.ActorRequest{ActorID: remote["erin"].ID, Action: "refresh"}).Error)
+
+	require.NoError(t, NewActorRequestProcessor(db, 1, 5*time.Second).process())
+	var bob models.Actor
+	require.NoError(t, db.Preload("Attributes").Take(&bob, remote["bob"].ID).Error)
+	require.Equal(t, "Bob", bob.DisplayName)
+	require.Len(t, bob.Attributes, 1)
+	require.Equal(t, "new", bob.Attributes[0].Name)
+	// carol is gone.
+	require.ErrorIs(t, db.Take(&models.Actor{}, remote["carol"].ID).Error, gorm.ErrRecordNotFound)
+	require.NoError(t, db.Take(&models.Actor{}, remote["dave"].ID).Error)
+	require.NoError(t, db.Take(&models.Actor{}, remote["erin"].ID).Error)
+	var count int64
+	require.NoError(t, db.Model(&models.ActorRequest{}).Count(&count).Error)
+	require.EqualValues(t, 0, count)
+}
@@ -0,0 +1,228 @@
+package activitypub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davecheney/pub/internal/activitypub"
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxBackfillAttempts is the number of times a backfill is attempted before
+// it is abandoned until it is requested again.
+const maxBackfillAttempts = 5
+
+// errLimitReached stops walking a collection once enough statuses have been fetched.
+var errLimitReached = errors.New("limit reached")
+
+// BackfillProcessor fetches the statuses requested by BackfillRequests.
+type BackfillProcessor struct {
+	db      *gorm.DB
+	workers int
+	// outboxLimit is the maximum number of statuses fetched from an actor's outbox.
+	outboxLimit int
+	// repliesLimit is the maximum number of statuses fetched for a thread.
+	repliesLimit int
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
+}
+
+func NewBackfillProcessor(db *gorm.DB, workers, outboxLimit, repliesLimit int, timeout time.Duration) *BackfillProcessor {
+	return &BackfillProcessor{
+		db:           db,
+		workers:      workers,
+		outboxLimit:  outboxLimit,
+		repliesLimit: repliesLimit,
+		timeout:      timeout,
+	}
+}
+
+func (bp *BackfillProcessor) Run(stop <-chan struct{}) error {
+	fmt.Println("BackfillProcessor.Run started")
+	defer fmt.Println("BackfillProcessor.Run stopped")
+
+	for {
+		if err := bp.process(); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(30 * time.Second):
+			// continue
+		}
+	}
+}
+
+// process make one pass through the BackfillRequest table, processing
+// any pending requests.
+func (bp *BackfillProcessor) process() error {
+	var requests []*models.BackfillRequest
+	if err := bp.db.Preload("Instance.ServiceAccount.Actor").Where("completed_at is null and attempts < ?", maxBackfillAttempts).Find(&requests).Error; err != nil {
+		return err
+	}
+
+	return forEach(bp.workers, requests, func(request *models.BackfillRequest) error {
+		if err := bp.processRequest(request); err != nil {
+			request.LastAttempt = time.Now()
+			request.Attempts++
+			request.LastResult = err.Error()
+			if err := bp.db.Save(request).Error; err != nil {
+				return err
+			}
+			return nil
+		}
+		if err := bp.db.Model(request).Update("completed_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func (bp *BackfillProcessor) processRequest(request *models.BackfillRequest) error {
+	fmt.Println("BackfillProcessor.processRequest: uri:", request.URI, "kind:", request.Kind)
+
+	if request.Instance == nil || request.Instance.ServiceAccount == nil {
+		return fmt.Errorf("instance %d has no service account", request.InstanceID)
+	}
+	if _, err := domainPolicy(bp.db, request.Instance.ServiceAccount, request.URI); err != nil {
+		return err
+	}
+	b := &backfill{
+		db:      bp.db,
+		fetcher: NewRemoteStatusFetcher(request.Instance.ServiceAccount, bp.db, bp.timeout),
+	}
+	var err error
+	b.client, err = activitypub.NewClient(bp.db.Statement.Context, request.Instance.ServiceAccount, bp.timeout)
+	if err != nil {
+		return err
+	}
+
+	switch request.Kind {
+	case "outbox":
+		return b.outbox(request.URI, bp.outboxLimit)
+	case "replies":
+		return b.replies(request.URI, bp.repliesLimit)
+	case "context":
+		return b.context(request.URI, bp.repliesLimit)
+	default:
+		return fmt.Errorf("unknown kind %q", request.Kind)
+	}
+}
+
+// backfill holds the state of a single backfill request.
+type backfill struct {
+	db      *gorm.DB
+	client  *activitypub.Client
+	fetcher *RemoteStatusFetcher
+	// fetched is the number of statuses fetched so far.
+	fetched int
+}
+
+// outbox fetches up to limit of the statuses in the outbox of the actor at uri.
+func (b *backfill) outbox(uri string, limit int) error {
+	actor, err := b.client.Get(uri)
+	if err != nil {
+		return err
+	}
+	outbox := idFromAny(actor["outbox"])
+	if outbox == "" {
+		return nil
+	}
+	return b.walk(outbox, limit, nil)
+}
+
+// replies fetches up to limit of the replies to the status at uri, and the
+// replies to those replies.
+func (b *backfill) replies(uri string, limit int) error {
+	queue := []string{uri}
+	seen := map[string]bool{uri: true}
+	for len(queue) > 0 && b.fetched < limit {
+		obj, err := b.client.Get(queue[0])
+		if err != nil {
+			return err
+		}
+		queue = queue[1:]
+		replies := idFromAny(obj["replies"])
+		if replies == "" {
+			continue
+		}
+		if err := b.walk(replies, limit, func(uri string) {
+			if !seen[uri] {
+				seen[uri] = true
+				queue = append(queue, uri)
+			}
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// context fetches up to limit of the statuses in the conversation of the
+// status at uri, if the status's server publishes the conversation as a
+// collection.
+func (b *backfill) context(uri string, limit int) error {
+	obj, err := b.client.Get(uri)
+	if err != nil {
+		return err
+	}
+	context := idFromAny(obj["context"])
+	if !strings.HasPrefix(context, "https://") {
+		// Mastodon's context is a tag URI, there is nothing to fetch.
+		return nil
+	}
+	return b.walk(context, limit, nil)
+}
+
+// walk fetches the statuses in the collection at uri until limit statuses
+// have been fetched, calling fn, if not nil, with the URI of each status.
+// Statuses that cannot be fetched are skipped.
+func (b *backfill) walk(uri string, limit int, fn func(string)) error {
+	statuses := models.NewStatuses(b.db)
+	err := b.client.Walk(uri, func(page string, items []any) error {
+		for _, item := range items {
+			if b.fetched >= limit {
+				return errLimitReached
+			}
+			uri := statusURIFromItem(item)
+			if uri == "" {
+				continue
+			}
+			b.fetched++
+			if _, err := statuses.FindOrCreate(uri, b.fetcher.Fetch); err != nil {
+				fmt.Println("backfill.walk: status:", uri, "error:", err)
+				continue
+			}
+			if fn != nil {
+				fn(uri)
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errLimitReached) {
+		return nil
+	}
+	return err
+}
+
+// statusURIFromItem returns the URI of the status in a collection item, which
+// may be the status, its URI, or the activity which created it. Other
+// activities are ignored.
+func statusURIFromItem(item any) string {
+	obj, ok := item.(map[string]any)
+	if !ok {
+		return stringFromAny(item)
+	}
+	switch stringFromAny(obj["type"]) {
+	case "Create":
+		return idFromAny(obj["object"])
+	case "Note", "Question":
+		return idFromAny(obj)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,415 @@
+package activitypub
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/davecheney/pub/internal/algorithms"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/media"
+	"gorm.io/gorm"
+)
+
+// An Exporter exports a local account as a Mastodon compatible archive.
+type Exporter struct {
+	db      *gorm.DB
+	account *models.Account
+	store   *media.Store
+
+	tw *tar.Writer
+	// files are the names of the media files already written to the archive.
+	files map[string]bool
+}
+
+// NewExporter returns an Exporter for account. Uploaded media is read from store.
+func NewExporter(db *gorm.DB, account *models.Account, store *media.Store) *Exporter {
+	return &Exporter{
+		db:      db,
+		account: account,
+		store:   store,
+		files:   make(map[string]bool),
+	}
+}
+
+// Export writes the archive to w as a gzipped tar file.
+func (e *Exporter) Export(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	e.tw = tar.NewWriter(gz)
+	for _, fn := range []func() error{
+		e.exportActor,
+		e.exportOutbox,
+		e.exportLikes,
+		e.exportBookmarks,
+		e.exportFollowing,
+		e.exportLists,
+		e.exportBlocks,
+		e.exportMutes,
+		e.exportDomainBlocks,
+	} {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	if err := e.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// exportActor writes actor.json, and the actor's avatar and header if they were uploaded.
+func (e *Exporter) exportActor() error {
+	actor := e.account.Actor
+	if err := e.db.Preload("Attributes").Preload("MovedTo").First(actor, actor.ID).Error; err != nil {
+		return err
+	}
+	obj := serialiseActor(actor)
+	obj["@context"] = actorContext
+	for _, key := range []string{"icon", "image"} {
+		img := mapFromAny(obj[key])
+		path, err := e.exportMedia(stringFromAny(img["url"]))
+		if err != nil {
+			return err
+		}
+		img["url"] = path
+	}
+	return e.writeJSON("actor.json", obj)
+}
+
+// exportOutbox writes the actor's statuses to outbox.json as Create and Announce activities.
+func (e *Exporter) exportOutbox() error {
+	var statuses []*models.Status
+	query := e.db.Preload("Reblog").Preload("Attachments")
+	query = query.Preload("Mentions").Preload("Mentions.Actor").Preload("Tags").Preload("Tags.Tag")
+	if err := query.Where("actor_id = ?", e.account.ActorID).Order("id asc").Find(&statuses).Error; err != nil {
+		return err
+	}
+	// find the URIs of the statuses being replied to.
+	var parentIDs []snowflake.ID
+	for _, status := range statuses {
+		if status.InReplyToID != nil {
+			parentIDs = append(parentIDs, *status.InReplyToID)
+		}
+	}
+	parents := make(map[snowflake.ID]string)
+	if len(parentIDs) > 0 {
+		var ps []*models.Status
+		if err := e.db.Select("id", "uri").Where("id in (?)", parentIDs).Find(&ps).Error; err != nil {
+			return err
+		}
+		for _, p := range ps {
+			parents[p.ID] = p.URI
+		}
+	}
+
+	var items []any
+	for _, status := range statuses {
+		if status.ReblogID != nil && status.Reblog == nil {
+			// the reblogged status has been deleted, there is nothing to announce.
+			continue
+		}
+		activity, err := e.statusToActivity(status, parents)
+		if err != nil {
+			return err
+		}
+		items = append(items, activity)
+	}
+	return e.writeJSON("outbox.json", orderedCollection(e.account.Actor.URI+"/outbox", items))
+}
+
+func (e *Exporter) statusToActivity(status *models.Status, parents map[snowflake.ID]string) (map[string]any, error) {
+	actor := e.account.Actor
+	to, cc := addressing(actor, status.Visibility)
+	// the importer derives status IDs from the published time, keep the
+	// milliseconds so statuses posted in the same second remain distinct.
+	published := status.ID.ToTime().UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	if status.Reblog != nil {
+		return map[string]any{
+			"id":        status.URI + "/activity",
+			"type":      "Announce",
+			"actor":     actor.URI,
+			"published": published,
+			"to":        to,
+			"cc":        cc,
+			"object":    status.Reblog.URI,
+		}, nil
+	}
+	var attachments []any
+	for _, att := range status.Attachments {
+		url, err := e.exportMedia(att.URL)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, map[string]any{
+			"type":      "Document",
+			"mediaType": att.MediaType,
+			"url":       url,
+			"name":      att.Name,
+			"blurhash":  att.Blurhash,
+			"width":     att.Width,
+			"height":    att.Height,
+		})
+	}
+	tags := []any{}
+	for _, mention := range status.Mentions {
+		tags = append(tags, map[string]any{
+			"type": "Mention",
+			"href": mention.Actor.URI,
+			"name": "@" + address(mention.Actor),
+		})
+		// mentioned actors are recipients of the status.
+		if status.Visibility == "direct" {
+			to = append(to, mention.Actor.URI)
+		} else {
+			cc = append(cc, mention.Actor.URI)
+		}
+	}
+	for _, tag := range status.Tags {
+		tags = append(tags, map[string]any{
+			"type": "Hashtag",
+			"href": actor.URLs().Tag(tag.Tag.Name),
+			"name": "#" + tag.Tag.Name,
+		})
+	}
+	note := map[string]any{
+		"id":           status.URI,
+		"type":         "Note",
+		"summary":      nilIfEmpty(status.SpoilerText),
+		"inReplyTo":    nilIfEmpty(parents[ptrOrZero(status.InReplyToID)]),
+		"published":    published,
+		"url":          status.URI,
+		"attributedTo": actor.URI,
+		"to":           to,
+		"cc":           cc,
+		"sensitive":    status.Sensitive,
+		"content":      status.Note,
+		"attachment":   attachments,
+		"tag":          tags,
+	}
+	if status.Language != "" {
+		note["contentMap"] = map[string]any{status.Language: status.Note}
+	}
+	return map[string]any{
+		"id":        status.URI + "/activity",
+		"type":      "Create",
+		"actor":     actor.URI,
+		"published": published,
+		"to":        to,
+		"cc":        cc,
+		"object":    note,
+	}, nil
+}
+
+// exportLikes writes the URIs of the statuses favourited by the actor to likes.json.
+func (e *Exporter) exportLikes() error {
+	return e.exportReactions("likes.json", "favourited = true")
+}
+
+// exportBookmarks writes the URIs of the statuses bookmarked by the actor to bookmarks.json.
+func (e *Exporter) exportBookmarks() error {
+	return e.exportReactions("bookmarks.json", "bookmarked = true")
+}
+
+func (e *Exporter) exportReactions(name, cond string) error {
+	var reactions []*models.Reaction
+	if err := e.db.Joins("Status").Where("reactions.actor_id = ?", e.account.ActorID).Where(cond).Find(&reactions).Error; err != nil {
+		return err
+	}
+	items := algorithms.Map(reactions, func(r *models.Reaction) any {
+		return r.Status.URI
+	})
+	return e.writeJSON(name, orderedCollection(name, items))
+}
+
+// exportFollowing writes the accounts followed by the actor to following_accounts.csv.
+func (e *Exporter) exportFollowing() error {
+	targets, err := e.relationshipTargets("following = true")
+	if err != nil {
+		return err
+	}
+	records := [][]string{{"Account address", "Show boosts", "Notify on new posts", "Languages"}}
+	for _, target := range targets {
+		records = append(records, []string{address(target), "true", "false", ""})
+	}
+	return e.writeCSV("following_accounts.csv", records)
+}
+
+// exportLists writes the members of the account's lists to lists.csv.
+func (e *Exporter) exportLists() error {
+	var lists []*models.AccountList
+	if err := e.db.Preload("Members").Preload("Members.Member").Where("account_id = ?", e.account.ID).Find(&lists).Error; err != nil {
+		return err
+	}
+	var records [][]string
+	for _, list := range lists {
+		for _, member := range list.Members {
+			records = append(records, []string{list.Title, address(member.Member)})
+		}
+	}
+	return e.writeCSV("lists.csv", records)
+}
+
+// exportBlocks writes the accounts blocked by the actor to blocked_accounts.csv.
+func (e *Exporter) exportBlocks() error {
+	targets, err := e.relationshipTargets("blocking = true")
+	if err != nil {
+		return err
+	}
+	return e.writeCSV("blocked_accounts.csv", algorithms.Map(targets, func(a *models.Actor) []string {
+		return []string{address(a)}
+	}))
+}
+
+// exportMutes writes the accounts muted by the actor to muted_accounts.csv.
+func (e *Exporter) exportMutes() error {
+	targets, err := e.relationshipTargets("muting = true")
+	if err != nil {
+		return err
+	}
+	records := [][]string{{"Account address", "Hide notifications"}}
+	for _, target := range targets {
+		records = append(records, []string{address(target), "true"})
+	}
+	return e.writeCSV("muted_accounts.csv", records)
+}
+
+// exportDomainBlocks writes blocked_domains.csv. Domains are blocked per
+// instance, not per account, so the file is always empty.
+func (e *Exporter) exportDomainBlocks() error {
+	return e.writeCSV("blocked_domains.csv", nil)
+}
+
+func (e *Exporter) relationshipTargets(cond string) ([]*models.Actor, error) {
+	var relationships []*models.Relationship
+	if err := e.db.Joins("Target").Where("relationships.actor_id = ?", e.account.ActorID).Where(cond).Find(&relationships).Error; err != nil {
+		return nil, err
+	}
+	return algorithms.Map(relationships, func(r *models.Relationship) *models.Actor {
+		return r.Target
+	}), nil
+}
+
+// exportMedia writes the uploaded file at url to the archive and returns its
+// path in the archive. If url is not an uploaded file, or the file is missing
+// from the store, it is returned unchanged.
+func (e *Exporter) exportMedia(url string) (string, error) {
+	name, ok := media.UploadName(url)
+	if !ok {
+		return url, nil
+	}
+	path := "media_attachments/files/" + name
+	if e.files[name] {
+		return "/" + path, nil
+	}
+	f, err := e.store.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("Exporter.exportMedia: skipping missing upload:", url)
+		return url, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if err := e.tw.WriteHeader(&tar.Header{
+		Name:     path,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     fi.Size(),
+		ModTime:  fi.ModTime(),
+	}); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(e.tw, f); err != nil {
+		return "", err
+	}
+	e.files[name] = true
+	return "/" + path, nil
+}
+
+func (e *Exporter) writeJSON(name string, obj map[string]any) error {
+	b, err := marshalIndent(obj)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(name, b)
+}
+
+func (e *Exporter) writeCSV(name string, records [][]string) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+	return e.writeFile(name, buf.Bytes())
+}
+
+func (e *Exporter) writeFile(name string, b []byte) error {
+	if err := e.tw.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+		Size:     int64(len(b)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
+	}
+	_, err := e.tw.Write(b)
+	return err
+}
+
+func orderedCollection(id string, items []any) map[string]any {
+	return map[string]any{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           id,
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	}
+}
+
+// addressing returns the to and cc addresses of a status with the given visibility.
+func addressing(actor *models.Actor, visibility string) (to, cc []any) {
+	const public = "https://www.w3.org/ns/activitystreams#Public"
+	followers := actor.URI + "/followers"
+	switch visibility {
+	case "public":
+		return []any{public}, []any{followers}
+	case "unlisted":
+		return []any{followers}, []any{public}
+	case "private":
+		return []any{followers}, []any{}
+	default:
+		return []any{}, []any{}
+	}
+}
+
+// address returns the account address, user@domain, of the actor.
+func address(a *models.Actor) string {
+	return fmt.Sprintf("%s@%s", a.Name, a.Domain)
+}
+
+func nilIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func ptrOrZero[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
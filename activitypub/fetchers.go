@@ -15,14 +15,16 @@ import (
 
 type RemoteActorFetcher struct {
 	// signAs is the account that will be used to sign the request
-	signAs *models.Account
-	db     *gorm.DB
+	signAs  *models.Account
+	db      *gorm.DB
+	timeout time.Duration
 }
 
-func NewRemoteActorFetcher(signAs *models.Account, db *gorm.DB) *RemoteActorFetcher {
+func NewRemoteActorFetcher(signAs *models.Account, db *gorm.DB, timeout time.Duration) *RemoteActorFetcher {
 	return &RemoteActorFetcher{
-		signAs: signAs,
-		db:     db,
+		signAs:  signAs,
+		db:      db,
+		timeout: timeout,
 	}
 }
 
@@ -31,6 +33,9 @@ func (f *RemoteActorFetcher) Fetch(uri string) (*models.Actor, error) {
 	if err != nil {
 		return nil, err
 	}
+	if _, err := domainPolicy(f.db, f.signAs, uri); err != nil {
+		return nil, err
+	}
 
 	obj, err := f.fetch(uri)
 	if err != nil {
@@ -44,36 +49,54 @@ func (f *RemoteActorFetcher) Fetch(uri string) (*models.Actor, error) {
 
 	actor := models.Actor{
 		ID:           snowflake.TimeToID(published),
-		Type:         stringFromAny(obj["type"]),
-		Name:         stringFromAny(obj["preferredUsername"]),
 		Domain:       u.Host,
 		URI:          stringFromAny(obj["id"]),
-		DisplayName:  stringFromAny(obj["name"]),
-		Locked:       boolFromAny(obj["manuallyApprovesFollowers"]),
-		Note:         stringFromAny(obj["summary"]),
-		Avatar:       stringFromAny(mapFromAny(obj["icon"])["url"]),
-		Header:       stringFromAny(mapFromAny(obj["image"])["url"]),
 		LastStatusAt: time.Now(),
-		// AttachmentsJSON: anyToSlice(obj["attachment"]),
-		PublicKey: []byte(stringFromAny(mapFromAny(obj["publicKey"])["publicKeyPem"])),
 	}
+	updateActor(&actor, obj)
+	return &actor, nil
+}
+
+// updateActor sets the fields of actor, and its attributes, from the
+// ActivityPub object obj.
+func updateActor(actor *models.Actor, obj map[string]any) {
+	actor.Type = stringFromAny(obj["type"])
+	actor.Name = stringFromAny(obj["preferredUsername"])
+	actor.DisplayName = stringFromAny(obj["name"])
+	actor.Locked = boolFromAny(obj["manuallyApprovesFollowers"])
+	actor.Discoverable = boolFromAny(obj["discoverable"])
+	actor.Note = stringFromAny(obj["summary"])
+	actor.Avatar = stringFromAny(mapFromAny(obj["icon"])["url"])
+	actor.Header = stringFromAny(mapFromAny(obj["image"])["url"])
+	actor.PublicKey = []byte(stringFromAny(mapFromAny(obj["publicKey"])["publicKeyPem"]))
+	actor.AlsoKnownAs = stringsFromAny(obj["alsoKnownAs"])
+	actor.Attributes = nil
 	for _, att := range anyToSlice(obj["attachment"]) {
 		t := mapFromAny(att)
-		fmt.Println("RemoteActorFetcher.Fetch: attachment:", t)
 		switch t["type"] {
 		case "PropertyValue":
 			actor.Attributes = append(actor.Attributes, &models.ActorAttribute{
-				Name:  stringFromAny(t["name"]),
-				Value: stringFromAny(t["value"]),
+				ActorID: actor.ID,
+				Name:    stringFromAny(t["name"]),
+				Value:   stringFromAny(t["value"]),
 			})
 		}
 	}
-	return &actor, nil
+}
+
+// saveActor saves a remote actor, replacing its attributes.
+func saveActor(db *gorm.DB, actor *models.Actor) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("actor_id = ?", actor.ID).Delete(&models.ActorAttribute{}).Error; err != nil {
+			return err
+		}
+		return tx.Save(actor).Error
+	})
 }
 
 func (f *RemoteActorFetcher) fetch(uri string) (map[string]any, error) {
 	fmt.Println("RemoteActorFetcher.fetch:", uri)
-	c, err := activitypub.NewClient(f.db.Statement.Context, f.signAs)
+	c, err := activitypub.NewClient(f.db.Statement.Context, f.signAs, f.timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -81,18 +104,23 @@ func (f *RemoteActorFetcher) fetch(uri string) (map[string]any, error) {
 }
 
 type RemoteStatusFetcher struct {
-	signAs *models.Account
-	db     *gorm.DB
+	signAs  *models.Account
+	db      *gorm.DB
+	timeout time.Duration
 }
 
-func NewRemoteStatusFetcher(signAs *models.Account, db *gorm.DB) *RemoteStatusFetcher {
+func NewRemoteStatusFetcher(signAs *models.Account, db *gorm.DB, timeout time.Duration) *RemoteStatusFetcher {
 	return &RemoteStatusFetcher{
-		signAs: signAs,
-		db:     db,
+		signAs:  signAs,
+		db:      db,
+		timeout: timeout,
 	}
 }
 
 func (f *RemoteStatusFetcher) Fetch(uri string) (*models.Status, error) {
+	if _, err := domainPolicy(f.db, f.signAs, uri); err != nil {
+		return nil, err
+	}
 	obj, err := f.fetch(uri)
 	if err != nil {
 		return nil, err
@@ -152,7 +180,7 @@ func (f *RemoteStatusFetcher) Fetch(uri string) (*models.Status, error) {
 		}
 		conversationID = conv.ID
 	}
-	fetcher := NewRemoteActorFetcher(f.signAs, f.db)
+	fetcher := NewRemoteActorFetcher(f.signAs, f.db, f.timeout)
 	actor, err := models.NewActors(f.db).FindOrCreate(stringFromAny(obj["attributedTo"]), fetcher.Fetch)
 	if err != nil {
 		return nil, err
@@ -172,7 +200,13 @@ func (f *RemoteStatusFetcher) Fetch(uri string) (*models.Status, error) {
 		Language:         stringFromAny(obj["language"]),
 		URI:              uri,
 		Note:             stringFromAny(obj["content"]),
-		Attachments:      algorithms.Map(algorithms.Map(anyToSlice(obj["attachment"]), mapFromAny), objToStatusAttachment),
+	}
+	rejectMedia, err := models.NewDomainBlocks(f.db).RejectsMedia(actor.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if !rejectMedia {
+		st.Attachments = algorithms.Map(algorithms.Map(anyToSlice(obj["attachment"]), mapFromAny), objToStatusAttachment)
 	}
 
 	for _, tag := range anyToSlice(obj["tag"]) {
@@ -199,6 +233,28 @@ func (f *RemoteStatusFetcher) Fetch(uri string) (*models.Status, error) {
 	return st, nil
 }
 
+// domainPolicy returns the policy of the instance of account for the host of
+// uri. If the instance does not federate with the host the error wraps
+// models.ErrDomainSuspended.
+func domainPolicy(db *gorm.DB, account *models.Account, uri string) (*models.DomainPolicy, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	var instance models.Instance
+	if err := db.Take(&instance, account.InstanceID).Error; err != nil {
+		return nil, err
+	}
+	policy, err := models.NewDomainBlocks(db).Policy(&instance, u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Suspend {
+		return nil, fmt.Errorf("%s: %w", u.Host, models.ErrDomainSuspended)
+	}
+	return policy, nil
+}
+
 // // noteToStatus converts an ActivityPub note to a Status.
 // func noteToStatus(note map[string]interface{}) (*Status, error) {
 // 	createdAt := timeFromAny(note["published"])
@@ -211,7 +267,7 @@ func (f *RemoteStatusFetcher) Fetch(uri string) (*models.Status, error) {
 
 func (f *RemoteStatusFetcher) fetch(uri string) (map[string]interface{}, error) {
 	fmt.Println("RemoteStatusFetcher.fetch:", uri)
-	c, err := activitypub.NewClient(f.db.Statement.Context, f.signAs)
+	c, err := activitypub.NewClient(f.db.Statement.Context, f.signAs, f.timeout)
 	if err != nil {
 		return nil, err
 	}
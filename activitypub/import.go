@@ -0,0 +1,493 @@
+package activitypub
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davecheney/pub/internal/algorithms"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/webfinger"
+	"github.com/davecheney/pub/media"
+	"github.com/go-json-experiment/json"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ImportStats counts the items processed by an Importer.
+type ImportStats struct {
+	Statuses  int
+	Reblogs   int
+	Likes     int
+	Bookmarks int
+	Follows   int
+	Blocks    int
+	Mutes     int
+	// Skipped counts items which were already imported, or are not supported.
+	Skipped int
+	// Failed counts items which could not be imported.
+	Failed int
+}
+
+// An Importer imports a Mastodon account archive into a local account.
+type Importer struct {
+	db      *gorm.DB
+	account *models.Account
+	store   *media.Store
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
+
+	// Follow, if true, follows the accounts listed in following_accounts.csv.
+	Follow bool
+
+	// Stats counts the items processed by Import.
+	Stats ImportStats
+
+	// statuses are the imported statuses, by their URI in the archive.
+	statuses map[string]*models.Status
+}
+
+// NewImporter returns an Importer which imports into account. Media files in
+// the archive are stored in store. timeout is the time allowed for each
+// request to a remote server.
+func NewImporter(db *gorm.DB, account *models.Account, store *media.Store, timeout time.Duration) *Importer {
+	return &Importer{
+		db:       db,
+		account:  account,
+		store:    store,
+		timeout:  timeout,
+		statuses: make(map[string]*models.Status),
+	}
+}
+
+// Import imports the contents of archive. Files missing from the archive are
+// skipped. Individual items which cannot be imported are counted in Stats.Failed.
+func (imp *Importer) Import(archive fs.FS) error {
+	for _, fn := range []func(fs.FS) error{
+		imp.importActor,
+		imp.importOutbox,
+		imp.importLikes,
+		imp.importBookmarks,
+		imp.importFollowing,
+		imp.importBlocks,
+		imp.importMutes,
+	} {
+		if err := fn(archive); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// importActor imports the profile in actor.json.
+func (imp *Importer) importActor(archive fs.FS) error {
+	obj, err := readJSON(archive, "actor.json")
+	if err != nil {
+		return err
+	}
+	actor := imp.account.Actor
+	actor.DisplayName = stringFromAny(obj["name"])
+	actor.Note = stringFromAny(obj["summary"])
+	actor.Locked = boolFromAny(obj["manuallyApprovesFollowers"])
+	actor.Discoverable = boolFromAny(obj["discoverable"])
+	if avatar, err := imp.importMedia(archive, stringFromAny(mapFromAny(obj["icon"])["url"])); err != nil {
+		return err
+	} else if avatar != "" {
+		actor.Avatar = avatar
+	}
+	if header, err := imp.importMedia(archive, stringFromAny(mapFromAny(obj["image"])["url"])); err != nil {
+		return err
+	} else if header != "" {
+		actor.Header = header
+	}
+	actor.Attributes = nil
+	for _, att := range anyToSlice(obj["attachment"]) {
+		t := mapFromAny(att)
+		if t["type"] != "PropertyValue" {
+			continue
+		}
+		actor.Attributes = append(actor.Attributes, &models.ActorAttribute{
+			ActorID: actor.ID,
+			Name:    stringFromAny(t["name"]),
+			Value:   stringFromAny(t["value"]),
+		})
+	}
+	return imp.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("actor_id = ?", actor.ID).Delete(&models.ActorAttribute{}).Error; err != nil {
+			return err
+		}
+		if len(actor.Attributes) > 0 {
+			if err := tx.Create(actor.Attributes).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Omit(clause.Associations).Save(actor).Error; err != nil {
+			return err
+		}
+		return models.NewActors(tx).QueueUpdate(actor)
+	})
+}
+
+// importOutbox imports the Create and Announce activities in outbox.json,
+// oldest first so that replies can be threaded to their parents.
+func (imp *Importer) importOutbox(archive fs.FS) error {
+	obj, err := readJSON(archive, "outbox.json")
+	if err != nil {
+		return err
+	}
+	activities := algorithms.Map(anyToSlice(obj["orderedItems"]), mapFromAny)
+	sort.SliceStable(activities, func(i, j int) bool {
+		return timeFromAnyOrZero(activities[i]["published"]).Before(timeFromAnyOrZero(activities[j]["published"]))
+	})
+	for _, activity := range activities {
+		var err error
+		switch stringFromAny(activity["type"]) {
+		case "Create":
+			err = imp.importNote(archive, mapFromAny(activity["object"]))
+		case "Announce":
+			err = imp.importAnnounce(activity)
+		default:
+			imp.Stats.Skipped++
+		}
+		if err != nil {
+			fmt.Println("Importer.importOutbox:", stringFromAny(activity["id"]), "error:", err)
+			imp.Stats.Failed++
+		}
+	}
+	return nil
+}
+
+// importNote imports a Note as a status of the account. The status ID is
+// derived from the note's published time, preserving the original ordering.
+func (imp *Importer) importNote(archive fs.FS, note map[string]any) error {
+	if stringFromAny(note["type"]) != "Note" {
+		imp.Stats.Skipped++
+		return nil
+	}
+	published, err := timeFromAny(note["published"])
+	if err != nil {
+		return err
+	}
+	existing, err := imp.findImported(snowflake.TimeToID(published))
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		imp.statuses[stringFromAny(note["id"])] = existing
+		imp.Stats.Skipped++
+		return nil
+	}
+
+	parent := imp.statuses[stringFromAny(note["inReplyTo"])]
+	if parent == nil && stringFromAny(note["inReplyTo"]) != "" {
+		parent, err = models.NewStatuses(imp.db).FindByURI(stringFromAny(note["inReplyTo"]))
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+	}
+	vis := visiblity(note)
+	var conversationID uint32
+	if parent != nil {
+		conversationID = parent.ConversationID
+	} else {
+		conv, err := models.NewConversations(imp.db).New(vis)
+		if err != nil {
+			return err
+		}
+		conversationID = conv.ID
+	}
+
+	actor := imp.account.Actor
+	id := snowflake.TimeToID(published)
+	status := &models.Status{
+		ID:               id,
+		ActorID:          actor.ID,
+		ConversationID:   conversationID,
+		URI:              actor.URLs().Status(actor.Name, id),
+		InReplyToID:      inReplyToID(parent),
+		InReplyToActorID: inReplyToActorID(parent),
+		Sensitive:        boolFromAny(note["sensitive"]),
+		SpoilerText:      stringFromAny(note["summary"]),
+		Visibility:       vis,
+		Language:         language(note),
+		Note:             stringFromAny(note["content"]),
+	}
+	for _, att := range algorithms.Map(anyToSlice(note["attachment"]), mapFromAny) {
+		attachment := objToStatusAttachment(att)
+		url, err := imp.importMedia(archive, attachment.URL)
+		if err != nil {
+			return err
+		}
+		if url == "" {
+			// the file is missing, or cannot be stored, import the status without it.
+			continue
+		}
+		attachment.URL = url
+		status.Attachments = append(status.Attachments, attachment)
+	}
+	for _, tag := range algorithms.Map(anyToSlice(note["tag"]), mapFromAny) {
+		switch tag["type"] {
+		case "Mention":
+			mention, err := imp.findOrFetchActor(stringFromAny(tag["href"]))
+			if err != nil {
+				// the status is imported without the mention.
+				fmt.Println("Importer.importNote:", stringFromAny(note["id"]), "mention:", stringFromAny(tag["href"]), "error:", err)
+				continue
+			}
+			status.Mentions = append(status.Mentions, models.StatusMention{
+				StatusID: status.ID,
+				ActorID:  mention.ID,
+			})
+		case "Hashtag":
+			status.Tags = append(status.Tags, models.StatusTag{
+				StatusID: status.ID,
+				Tag: &models.Tag{
+					Name: strings.TrimLeft(stringFromAny(tag["name"]), "#"),
+				},
+			})
+		}
+	}
+	if err := imp.db.Create(status).Error; err != nil {
+		return err
+	}
+	imp.statuses[stringFromAny(note["id"])] = status
+	imp.Stats.Statuses++
+	return nil
+}
+
+// importAnnounce imports a reblog, fetching the reblogged status if it is not already known.
+func (imp *Importer) importAnnounce(announce map[string]any) error {
+	published, err := timeFromAny(announce["published"])
+	if err != nil {
+		return err
+	}
+	existing, err := imp.findImported(snowflake.TimeToID(published))
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		imp.Stats.Skipped++
+		return nil
+	}
+	original, err := imp.findOrFetchStatus(stringFromAny(announce["object"]))
+	if err != nil {
+		return err
+	}
+	conv, err := models.NewConversations(imp.db).New("public")
+	if err != nil {
+		return err
+	}
+	actor := imp.account.Actor
+	id := snowflake.TimeToID(published)
+	if err := imp.db.Create(&models.Status{
+		ID:             id,
+		ActorID:        actor.ID,
+		ConversationID: conv.ID,
+		URI:            actor.URLs().Status(actor.Name, id),
+		Visibility:     "public",
+		ReblogID:       &original.ID,
+	}).Error; err != nil {
+		return err
+	}
+	imp.Stats.Reblogs++
+	return nil
+}
+
+// findImported returns the status of the account created in the same
+// millisecond as id, or nil if there is none. Statuses are imported with IDs
+// derived from their published time, so this finds statuses imported by a
+// previous run.
+func (imp *Importer) findImported(id snowflake.ID) (*models.Status, error) {
+	var statuses []*models.Status
+	ms := id >> 16
+	if err := imp.db.Where("actor_id = ? and id >= ? and id < ?", imp.account.ActorID, ms<<16, (ms+1)<<16).Limit(1).Find(&statuses).Error; err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	return statuses[0], nil
+}
+
+// importLikes favourites the statuses listed in likes.json.
+func (imp *Importer) importLikes(archive fs.FS) error {
+	return imp.importReactions(archive, "likes.json", &imp.Stats.Likes, func(status *models.Status) error {
+		// the like was delivered when it was made, do not send it again.
+		return models.NewReactions(imp.db).ImportFavourite(status, imp.account.Actor)
+	})
+}
+
+// importBookmarks bookmarks the statuses listed in bookmarks.json.
+func (imp *Importer) importBookmarks(archive fs.FS) error {
+	return imp.importReactions(archive, "bookmarks.json", &imp.Stats.Bookmarks, func(status *models.Status) error {
+		return models.NewReactions(imp.db).Bookmark(status, imp.account.Actor)
+	})
+}
+
+// importReactions calls fn for each status in the collection in the named file,
+// incrementing count for each success.
+func (imp *Importer) importReactions(archive fs.FS, name string, count *int, fn func(*models.Status) error) error {
+	obj, err := readJSON(archive, name)
+	if err != nil {
+		return err
+	}
+	for _, item := range anyToSlice(obj["orderedItems"]) {
+		uri := stringFromAny(item)
+		status, err := imp.findOrFetchStatus(uri)
+		if err == nil {
+			err = fn(status)
+		}
+		if err != nil {
+			fmt.Println("Importer.importReactions:", name, uri, "error:", err)
+			imp.Stats.Failed++
+			continue
+		}
+		*count++
+	}
+	return nil
+}
+
+// importFollowing follows the accounts listed in following_accounts.csv, if
+// Follow is set. The follow requests are sent in the background.
+func (imp *Importer) importFollowing(archive fs.FS) error {
+	if !imp.Follow {
+		return nil
+	}
+	return imp.importAccounts(archive, "following_accounts.csv", &imp.Stats.Follows, func(target *models.Actor) error {
+		_, err := models.NewRelationships(imp.db).Follow(imp.account.Actor, target)
+		return err
+	})
+}
+
+// importBlocks blocks the accounts listed in blocked_accounts.csv.
+func (imp *Importer) importBlocks(archive fs.FS) error {
+	return imp.importAccounts(archive, "blocked_accounts.csv", &imp.Stats.Blocks, func(target *models.Actor) error {
+		_, err := models.NewRelationships(imp.db).Block(imp.account.Actor, target)
+		return err
+	})
+}
+
+// importMutes mutes the accounts listed in muted_accounts.csv.
+func (imp *Importer) importMutes(archive fs.FS) error {
+	return imp.importAccounts(archive, "muted_accounts.csv", &imp.Stats.Mutes, func(target *models.Actor) error {
+		_, err := models.NewRelationships(imp.db).Mute(imp.account.Actor, target)
+		return err
+	})
+}
+
+// importAccounts calls fn for each account address in the first column of the
+// named csv file, incrementing count for each success.
+func (imp *Importer) importAccounts(archive fs.FS, name string, count *int, fn func(*models.Actor) error) error {
+	f, err := archive.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, record := range records {
+		addr := strings.TrimPrefix(strings.TrimSpace(record[0]), "@")
+		if addr == "" || addr == "Account address" {
+			// skip the header
+			continue
+		}
+		actor, err := imp.resolveAccount(addr)
+		if err == nil {
+			err = fn(actor)
+		}
+		if err != nil {
+			fmt.Println("Importer.importAccounts:", name, addr, "error:", err)
+			imp.Stats.Failed++
+			continue
+		}
+		*count++
+	}
+	return nil
+}
+
+// resolveAccount finds the actor for the account address, user@domain, using webfinger.
+func (imp *Importer) resolveAccount(addr string) (*models.Actor, error) {
+	acct, err := webfinger.Parse("acct:" + addr)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := acct.Fetch(imp.db.Statement.Context)
+	if err != nil {
+		return nil, err
+	}
+	uri, err := wf.ActivityPub()
+	if err != nil {
+		return nil, err
+	}
+	return imp.findOrFetchActor(uri)
+}
+
+func (imp *Importer) findOrFetchActor(uri string) (*models.Actor, error) {
+	fetcher := NewRemoteActorFetcher(imp.account, imp.db, imp.timeout)
+	return models.NewActors(imp.db).FindOrCreate(uri, fetcher.Fetch)
+}
+
+func (imp *Importer) findOrFetchStatus(uri string) (*models.Status, error) {
+	fetcher := NewRemoteStatusFetcher(imp.account, imp.db, imp.timeout)
+	return models.NewStatuses(imp.db).FindOrCreate(uri, fetcher.Fetch)
+}
+
+// importMedia stores the file referenced by ref in the media store and returns
+// its URL. If ref is not a path within the archive, such as a URL, it is
+// returned unchanged. If the file is missing from the archive, or is not a
+// type, or size, the store accepts, "" is returned.
+func (imp *Importer) importMedia(archive fs.FS, ref string) (string, error) {
+	name := strings.TrimPrefix(ref, "/")
+	if ref == "" || !fs.ValidPath(name) {
+		return ref, nil
+	}
+	f, err := archive.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stored, err := imp.store.Put(f)
+	if errors.Is(err, media.ErrTooLarge) || errors.Is(err, media.ErrUnsupportedType) {
+		fmt.Println("Importer.importMedia:", name, "skipped:", err)
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return imp.account.Actor.URLs().Upload(stored), nil
+}
+
+func readJSON(archive fs.FS, name string) (map[string]any, error) {
+	f, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var obj map[string]any
+	if err := json.UnmarshalFull(f, &obj); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return obj, nil
+}
+
+// language returns the language of the note's content, if known.
+func language(note map[string]any) string {
+	for lang := range mapFromAny(note["contentMap"]) {
+		if len(lang) >= 2 {
+			return lang[:2]
+		}
+	}
+	return ""
+}
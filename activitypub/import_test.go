@@ -0,0 +1,108 @@
+package activitypub
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/davecheney/pub/media"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImporterImport(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &models.Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	alice, err := models.NewAccounts(db).Create(instance, models.NewAccount{Name: "alice", Email: "alice@example.com", Password: "sssh", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	require.NoError(t, db.Model(alice.Actor).Update("avatar", "https://example.com/avatar.png").Error)
+	bob := &models.Actor{ID: snowflake.Now(), Name: "bob", Domain: "remote.example", URI: "https://remote.example/users/bob", PublicKey: []byte("public key")}
+	require.NoError(t, db.Create(bob).Error)
+	conv, err := models.NewConversations(db).New("public")
+	require.NoError(t, err)
+	liked := &models.Status{ID: snowflake.Now(), ActorID: bob.ID, ConversationID: conv.ID, URI: "https://remote.example/users/bob/statuses/1", Visibility: "public"}
+	require.NoError(t, db.Create(liked).Error)
+
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	archive := fstest.MapFS{
+		"actor.json": {Data: []byte(`{
+			"type": "Person",
+			"name": "Alice",
+			"icon": {"type": "Image", "url": "avatar.png"},
+			"image": {"type": "Image", "url": "header.png"}
+		}`)},
+		"header.png": {Data: img.Bytes()},
+		"outbox.json": {Data: []byte(`{"orderedItems": [
+			{"type": "Create", "published": "2022-01-02T00:00:00Z", "object": {
+				"id": "https://old.example/users/alice/statuses/2",
+				"type": "Note",
+				"attributedTo": "https://old.example/users/alice",
+				"inReplyTo": "https://old.example/users/alice/statuses/1",
+				"published": "2022-01-02T00:00:00Z",
+				"to": ["https://www.w3.org/ns/activitystreams#Public"],
+				"content": "a reply",
+				"attachment": [
+					{"type": "Document", "mediaType": "image/png", "url": "/media/image.png"},
+					{"type": "Document", "mediaType": "video/mp4", "url": "/media/video.mp4"},
+					{"type": "Document", "mediaType": "image/png", "url": "/media/missing.png"}
+				]
+			}},
+			{"type": "Create", "published": "2022-01-01T00:00:00Z", "object": {
+				"id": "https://old.example/users/alice/statuses/1",
+				"type": "Note",
+				"attributedTo": "https://old.example/users/alice",
+				"published": "2022-01-01T00:00:00Z",
+				"to": ["https://www.w3.org/ns/activitystreams#Public"],
+				"content": "hello"
+			}}
+		]}`)},
+		"media/image.png": {Data: img.Bytes()},
+		"media/video.mp4": {Data: append([]byte("\x00\x00\x00\x18ftypmp42"), make([]byte, 32)...)},
+		"likes.json":      {Data: []byte(`{"orderedItems": ["https://remote.example/users/bob/statuses/1"]}`)},
+	}
+
+	imp := NewImporter(db, alice, media.NewStore(t.TempDir()), time.Second)
+	require.NoError(t, imp.Import(archive))
+	require.Equal(t, ImportStats{Statuses: 2, Likes: 1}, imp.Stats)
+
+	var actor models.Actor
+	require.NoError(t, db.Take(&actor, alice.ActorID).Error)
+	require.Equal(t, "Alice", actor.DisplayName)
+	// the avatar is missing from the archive, so it is unchanged.
+	require.Equal(t, "https://example.com/avatar.png", actor.Avatar)
+	require.Contains(t, actor.Header, "/media/uploads/")
+
+	var statuses []*models.Status
+	require.NoError(t, db.Preload("Attachments").Where("actor_id = ?", alice.ActorID).Order("id").Find(&statuses).Error)
+	require.Len(t, statuses, 2)
+	require.Equal(t, "hello", statuses[0].Note)
+	require.Equal(t, &statuses[0].ID, statuses[1].InReplyToID)
+	// the video cannot be stored, and the missing image cannot be found,
+	// but the reply is imported with the image that can.
+	require.Len(t, statuses[1].Attachments, 1)
+	require.Contains(t, statuses[1].Attachments[0].URL, "/media/uploads/")
+
+	// the like is recorded, but not sent again.
+	var reaction models.Reaction
+	require.NoError(t, db.Take(&reaction, "actor_id = ? AND status_id = ?", alice.ActorID, liked.ID).Error)
+	require.True(t, reaction.Favourited)
+	require.NoError(t, db.Take(liked, liked.ID).Error)
+	require.EqualValues(t, 1, liked.FavouritesCount)
+	var count int64
+	require.NoError(t, db.Model(&models.ReactionRequest{}).Count(&count).Error)
+	require.Zero(t, count)
+
+	// importing again skips what was imported.
+	imp = NewImporter(db, alice, media.NewStore(t.TempDir()), time.Second)
+	require.NoError(t, imp.Import(archive))
+	require.Equal(t, ImportStats{Skipped: 2, Likes: 1}, imp.Stats)
+	require.NoError(t, db.Model(&models.ReactionRequest{}).Count(&count).Error)
+	require.Zero(t, count)
+}
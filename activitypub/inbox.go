@@ -1,10 +1,12 @@
 package activitypub
 
 import (
+	"crypto"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/httpx"
@@ -17,32 +19,60 @@ import (
 
 func InboxCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
 	// find the instance that this request is for.
-	var instance models.Instance
-	if err := env.DB.Joins("Admin").Preload("Admin.Actor").Take(&instance, "domain = ?", r.Host).Error; err != nil {
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return httpx.Error(http.StatusNotFound, err)
 		}
 		return err
 	}
 
-	if err := validateSignature(env, r); err != nil {
+	signer, err := validateSignature(env, r)
+	if err != nil {
+		if errors.Is(err, models.ErrDomainSuspended) {
+			return httpx.Error(http.StatusForbidden, err)
+		}
 		return httpx.Error(http.StatusUnauthorized, err)
 	}
+	policy, err := models.NewDomainBlocks(env.DB).Policy(instance, signer.Domain)
+	if err != nil {
+		return err
+	}
+	moderation, err := models.NewActorModerations(env.DB).Find(instance.ID, signer)
+	if err != nil {
+		return err
+	}
+	if moderation.IsSuspended() || policy.Suspend {
+		// accept, and drop, activities from suspended actors and domains.
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
 
 	var body map[string]any
 	if err := json.UnmarshalFull(r.Body, &body); err != nil {
 		return httpx.Error(http.StatusBadRequest, err)
 	}
+	// the signer must be the actor of the activity, otherwise any actor
+	// could act on behalf of another.
+	if actor := idFromAny(body["actor"]); actor != signer.URI {
+		return httpx.Error(http.StatusUnauthorized, fmt.Errorf("activity actor %q is not the signer %q", actor, signer.URI))
+	}
 
 	// if we need to make an activity pub request, we need to sign it with the
-	// instance's admin account.
+	// instance's service account.
 	processor := &inboxProcessor{
-		db:     env.DB,
-		signAs: instance.Admin,
+		db:      env.DB,
+		signAs:  instance.ServiceAccount,
+		signer:  signer,
+		timeout: env.ClientTimeout,
 	}
 
-	err := processor.processActivity(body)
-	if err != nil {
+	if err := processor.processActivity(body); err != nil {
+		if errors.Is(err, models.ErrDomainSuspended) {
+			// the activity refers to a suspended domain, drop it.
+			w.WriteHeader(http.StatusAccepted)
+			return nil
+		}
 		return fmt.Errorf("processActivity failed: %s: %w ", stringFromAny(body["id"]), err)
 	}
 	w.WriteHeader(http.StatusAccepted)
@@ -52,6 +82,10 @@ func InboxCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
 type inboxProcessor struct {
 	db     *gorm.DB
 	signAs *models.Account
+	// signer is the actor whose key signed the activity.
+	signer *models.Actor
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
 }
 
 // processActivity processes an activity. If the activity can be handled without
@@ -83,6 +117,10 @@ func (i *inboxProcessor) processActivity(body map[string]any) error {
 		return i.processAdd(body)
 	case "Remove":
 		return i.processRemove(body)
+	case "Move":
+		return i.processMove(body)
+	case "Flag":
+		return i.processFlag(body)
 	default:
 		return errors.New("unknown activity type " + typ)
 	}
@@ -130,14 +168,14 @@ func (i *inboxProcessor) processUndoFollow(body map[string]any) error {
 
 func (i *inboxProcessor) processAnnounce(obj map[string]any) error {
 	target := stringFromAny(obj["object"])
-	statusFetcher := NewRemoteStatusFetcher(i.signAs, i.db)
+	statusFetcher := NewRemoteStatusFetcher(i.signAs, i.db, i.timeout)
 	statuses := models.NewStatuses(i.db)
 	original, err := statuses.FindOrCreate(target, statusFetcher.Fetch)
 	if err != nil {
 		return err
 	}
 
-	actorFetcher := NewRemoteActorFetcher(i.signAs, i.db)
+	actorFetcher := NewRemoteActorFetcher(i.signAs, i.db, i.timeout)
 	actors := models.NewActors(i.db)
 	actor, err := actors.FindOrCreate(stringFromAny(obj["actor"]), actorFetcher.Fetch)
 	if err != nil {
@@ -240,7 +278,10 @@ func (i *inboxProcessor) processCreateNote(create map[string]any) error {
 	}
 
 	_, err := models.NewStatuses(i.db).FindOrCreate(uri, func(string) (*models.Status, error) {
-		fetcher := NewRemoteActorFetcher(i.signAs, i.db)
+		if _, err := domainPolicy(i.db, i.signAs, uri); err != nil {
+			return nil, err
+		}
+		fetcher := NewRemoteActorFetcher(i.signAs, i.db, i.timeout)
 		actor, err := models.NewActors(i.db).FindOrCreate(stringFromAny(create["attributedTo"]), fetcher.Fetch)
 		if err != nil {
 			return nil, err
@@ -253,7 +294,7 @@ func (i *inboxProcessor) processCreateNote(create map[string]any) error {
 
 		var inReplyTo *models.Status
 		if inReplyToAtomUri, ok := create["inReplyTo"].(string); ok {
-			remoteStatusFetcher := NewRemoteStatusFetcher(i.signAs, i.db)
+			remoteStatusFetcher := NewRemoteStatusFetcher(i.signAs, i.db, i.timeout)
 			inReplyTo, err = models.NewStatuses(i.db).FindOrCreate(inReplyToAtomUri, remoteStatusFetcher.Fetch)
 			if err != nil {
 				fmt.Println("inReplyToAtomUri:", inReplyToAtomUri, "err:", err)
@@ -284,7 +325,13 @@ func (i *inboxProcessor) processCreateNote(create map[string]any) error {
 			Visibility:       vis,
 			Language:         "en",
 			Note:             stringFromAny(create["content"]),
-			Attachments:      algorithms.Map(algorithms.Map(anyToSlice(create["attachment"]), mapFromAny), objToStatusAttachment),
+		}
+		rejectMedia, err := models.NewDomainBlocks(i.db).RejectsMedia(actor.Domain)
+		if err != nil {
+			return nil, err
+		}
+		if !rejectMedia {
+			st.Attachments = algorithms.Map(algorithms.Map(anyToSlice(create["attachment"]), mapFromAny), objToStatusAttachment)
 		}
 		// and here
 		for _, tag := range anyToSlice(create["tag"]) {
@@ -391,7 +438,7 @@ func (i *inboxProcessor) processUpdate(update map[string]any) error {
 
 func (i *inboxProcessor) processUpdateStatus(update map[string]any) error {
 	id := stringFromAny(update["id"])
-	statusFetcher := NewRemoteStatusFetcher(i.signAs, i.db)
+	statusFetcher := NewRemoteStatusFetcher(i.signAs, i.db, i.timeout)
 	status, err := models.NewStatuses(i.db).FindOrCreate(id, statusFetcher.Fetch)
 	if err != nil {
 		return err
@@ -400,31 +447,140 @@ func (i *inboxProcessor) processUpdateStatus(update map[string]any) error {
 	if err != nil {
 		return err
 	}
-	status.UpdatedAt = updated
-	status.Note = stringFromAny(update["content"])
-
 	// TODO handle polls and attachments
-	return i.db.Save(&status).Error
+	return models.NewStatuses(i.db).Edit(status, stringFromAny(update["content"]), updated)
 }
 
 func (i *inboxProcessor) processUpdateActor(update map[string]any) error {
 	id := stringFromAny(update["id"])
-	actorFetcher := NewRemoteActorFetcher(i.signAs, i.db)
+	actorFetcher := NewRemoteActorFetcher(i.signAs, i.db, i.timeout)
 	actor, err := models.NewActors(i.db).FindOrCreate(id, actorFetcher.Fetch)
 	if err != nil {
 		return err
 	}
-	actor.Name = stringFromAny(update["preferredUsername"])
-	actor.DisplayName = stringFromAny(update["name"])
-	actor.Locked = boolFromAny(update["manuallyApprovesFollowers"])
-	actor.Note = stringFromAny(update["summary"])
-	actor.Avatar = stringFromAny(mapFromAny(update["icon"])["url"])
-	actor.Header = stringFromAny(mapFromAny(update["image"])["url"])
-	actor.PublicKey = []byte(stringFromAny(mapFromAny(update["publicKey"])["publicKeyPem"]))
+	if actor.IsLocal() {
+		return fmt.Errorf("processUpdateActor: cannot update local actor %q", id)
+	}
+	updateActor(actor, update)
+	return saveActor(i.db, actor)
+}
 
-	// todo update attributes
+// processMove handles an actor moving to a new account. The move is only
+// accepted if the target declares the actor as an alias. Local followers of
+// the actor are moved to the target.
+func (i *inboxProcessor) processMove(body map[string]any) error {
+	origin := stringFromAny(body["object"])
+	if origin == "" || origin != stringFromAny(body["actor"]) {
+		return fmt.Errorf("processMove: actor %q cannot move %q", stringFromAny(body["actor"]), origin)
+	}
+	actors := models.NewActors(i.db)
+	actor, err := actors.FindByURI(origin)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// we don't know this actor, so nobody here follows them.
+			return nil
+		}
+		return err
+	}
+	if actor.IsLocal() {
+		return fmt.Errorf("processMove: cannot move local actor %q", origin)
+	}
 
-	return i.db.Save(&actor).Error
+	target, err := moveTarget(i.db, i.signAs, actor, stringFromAny(body["target"]), i.timeout)
+	if err != nil {
+		return err
+	}
+	return i.db.Transaction(func(tx *gorm.DB) error {
+		if err := models.NewActors(tx).Move(actor, target); err != nil {
+			return err
+		}
+		var followers []*models.Relationship
+		if err := tx.Joins("Actor").Where("target_id = ? and following = true", actor.ID).Find(&followers).Error; err != nil {
+			return err
+		}
+		relationships := models.NewRelationships(tx)
+		for _, follower := range followers {
+			if !follower.Actor.IsLocal() {
+				continue
+			}
+			if _, err := relationships.Unfollow(follower.Actor, actor); err != nil {
+				return err
+			}
+			if _, err := relationships.Follow(follower.Actor, target); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// processFlag adds a report from a remote server to the report queue of the
+// instance. The objects of the Flag are the reported actor, which must be
+// local to the instance, and optionally some of their statuses. The reporter
+// is the signer of the Flag, usually the remote server's instance actor.
+func (i *inboxProcessor) processFlag(body map[string]any) error {
+	uri := stringFromAny(body["id"])
+	if uri == "" {
+		return httpx.Error(http.StatusBadRequest, errors.New("processFlag: flag has no id"))
+	}
+	var count int64
+	if err := i.db.Model(&models.Report{}).Where("uri = ? AND instance_id = ?", uri, i.signAs.InstanceID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		// already received.
+		return nil
+	}
+
+	var instance models.Instance
+	if err := i.db.Take(&instance, i.signAs.InstanceID).Error; err != nil {
+		return err
+	}
+	actors := models.NewActors(i.db)
+	var target *models.Actor
+	flagged := anyToSlice(body["object"])
+	if flagged == nil {
+		// a single object.
+		flagged = []any{body["object"]}
+	}
+	var objects []string
+	for _, obj := range flagged {
+		object := idFromAny(obj)
+		actor, err := actors.FindByURI(object)
+		switch {
+		case err == nil && actor.Domain == instance.Domain:
+			target = actor
+		case err == nil:
+			// not one of ours.
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			objects = append(objects, object)
+		default:
+			return err
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("processFlag: %s: no local actor reported", uri)
+	}
+	var statusIDs []snowflake.ID
+	for _, object := range objects {
+		status, err := models.NewStatuses(i.db).FindByURI(object)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue
+			}
+			return err
+		}
+		if status.ActorID == target.ID {
+			statusIDs = append(statusIDs, status.ID)
+		}
+	}
+
+	_, err := models.NewReports(i.db).Create(&instance, i.signer, target, models.NewReport{
+		Comment:   stringFromAny(body["content"]),
+		StatusIDs: statusIDs,
+		URI:       uri,
+	})
+	return err
 }
 
 func (i *inboxProcessor) processDelete(body map[string]any) error {
@@ -467,19 +623,45 @@ func (i *inboxProcessor) processDeleteActor(uri string) error {
 	return i.db.Delete(&actor).Error
 }
 
-func validateSignature(env *Env, r *http.Request) error {
+// validateSignature validates the HTTP signature on the request and returns
+// the actor that signed it. If the signature does not match the cached key
+// of a remote actor, the actor is refetched in case they have rotated their key.
+func validateSignature(env *Env, r *http.Request) (*models.Actor, error) {
 	verifier, err := httpsig.NewVerifier(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	pubKey, err := env.GetKey(verifier.KeyId())
+	actor, err := env.keyOwner(r.Host, verifier.KeyId())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err := verifier.Verify(pubKey, httpsig.RSA_SHA256); err != nil {
-		return err
+	err = verifySignature(verifier, actor)
+	if err == nil {
+		return actor, nil
 	}
-	return nil
+	if err := env.refreshPublicKey(r.Host, actor); err != nil {
+		return nil, err
+	}
+	if err := verifySignature(verifier, actor); err != nil {
+		return nil, err
+	}
+	return actor, nil
+}
+
+// verifySignature verifies the signature against each of the actor's valid public keys.
+func verifySignature(verifier httpsig.Verifier, actor *models.Actor) error {
+	err := errors.New("verifySignature: no public key")
+	for _, key := range actor.PublicKeys(time.Now()) {
+		var pubKey crypto.PublicKey
+		pubKey, err = pemToPublicKey(key)
+		if err != nil {
+			continue
+		}
+		if err = verifier.Verify(pubKey, httpsig.RSA_SHA256); err == nil {
+			return nil
+		}
+	}
+	return err
 }
 
 func visiblity(obj map[string]any) string {
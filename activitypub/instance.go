@@ -0,0 +1,45 @@
+package activitypub
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/to"
+	"gorm.io/gorm"
+)
+
+// InstanceActorShow serves the instance's Application actor. The instance actor
+// signs server to server requests on behalf of the instance, so it is always
+// served unsigned, even in secure mode.
+func InstanceActorShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	actor := instance.ServiceAccount.Actor
+	return to.JSON(w, map[string]any{
+		"@context": []any{
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+		},
+		"id":                        actor.URI,
+		"type":                      actor.Type,
+		"preferredUsername":         actor.Name,
+		"inbox":                     actor.URI + "/inbox",
+		"url":                       env.URLs.URL("/about/more?instance_actor=true"),
+		"manuallyApprovesFollowers": true,
+		"endpoints": map[string]any{
+			"sharedInbox": env.URLs.SharedInbox(),
+		},
+		"publicKey": map[string]any{
+			"id":           actor.PublicKeyID(),
+			"owner":        actor.URI,
+			"publicKeyPem": string(actor.PublicKey),
+		},
+	})
+}
@@ -0,0 +1,45 @@
+package activitypub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// MoveAccount moves the account's actor to the actor at uri. The target actor
+// must already list the account's actor in its alsoKnownAs. The followers of
+// the account's actor are notified with a Move activity in the background.
+// timeout is the time allowed to fetch the target actor.
+func MoveAccount(db *gorm.DB, account *models.Account, uri string, timeout time.Duration) (*models.Actor, error) {
+	target, err := moveTarget(db, account, account.Actor, uri, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if target.ID == account.Actor.ID {
+		return nil, fmt.Errorf("MoveAccount: cannot move %q to itself", uri)
+	}
+	return target, models.NewActors(db).Move(account.Actor, target)
+}
+
+// moveTarget fetches the target of a move from actor and verifies that it
+// declares actor as an alias. The target is always fetched, as a cached copy
+// may predate the alias.
+func moveTarget(db *gorm.DB, signAs *models.Account, actor *models.Actor, uri string, timeout time.Duration) (*models.Actor, error) {
+	fetched, err := NewRemoteActorFetcher(signAs, db, timeout).Fetch(uri)
+	if err != nil {
+		return nil, err
+	}
+	if !fetched.IsAlias(actor.URI) {
+		return nil, fmt.Errorf("moveTarget: %q is not an alias of %q", fetched.URI, actor.URI)
+	}
+	actors := models.NewActors(db)
+	target, err := actors.FindOrCreate(fetched.URI, func(string) (*models.Actor, error) {
+		return fetched, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return target, actors.SetAliases(target, fetched.AlsoKnownAs)
+}
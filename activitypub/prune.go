@@ -0,0 +1,43 @@
+package activitypub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// Pruner periodically deletes remote statuses, and the actors and
+// conversations they leave behind, which no local actor has interacted with.
+type Pruner struct {
+	db *gorm.DB
+	// age is the age after which remote statuses are deleted.
+	age time.Duration
+}
+
+func NewPruner(db *gorm.DB, age time.Duration) *Pruner {
+	return &Pruner{
+		db:  db,
+		age: age,
+	}
+}
+
+func (p *Pruner) Run(stop <-chan struct{}) error {
+	fmt.Println("Pruner.Run started")
+	defer fmt.Println("Pruner.Run stopped")
+
+	for {
+		stats, err := models.Prune(p.db, time.Now().Add(-p.age), 1000)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Pruner.Run: pruned %d statuses, %d attachments, %d actors, %d conversations\n", stats.Statuses, stats.Attachments, stats.Actors, stats.Conversations)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(24 * time.Hour):
+			// continue
+		}
+	}
+}
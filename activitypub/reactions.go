@@ -11,12 +11,17 @@ import (
 
 // ReactionRequestProcessor handles delivery of relationship requests.
 type ReactionRequestProcessor struct {
-	db *gorm.DB
+	db      *gorm.DB
+	workers int
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
 }
 
-func NewReactionRequestProcessor(db *gorm.DB) *ReactionRequestProcessor {
+func NewReactionRequestProcessor(db *gorm.DB, workers int, timeout time.Duration) *ReactionRequestProcessor {
 	return &ReactionRequestProcessor{
-		db: db,
+		db:      db,
+		workers: workers,
+		timeout: timeout,
 	}
 }
 
@@ -45,7 +50,7 @@ func (rrp *ReactionRequestProcessor) process() error {
 		return err
 	}
 
-	for _, request := range requests {
+	return forEach(rrp.workers, requests, func(request *models.ReactionRequest) error {
 		if err := rrp.processRequest(request); err != nil {
 			request.LastAttempt = time.Now()
 			request.Attempts++
@@ -57,9 +62,8 @@ func (rrp *ReactionRequestProcessor) process() error {
 		if err := rrp.db.Delete(request).Error; err != nil {
 			return err
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 func (rrp *ReactionRequestProcessor) processRequest(request *models.ReactionRequest) error {
@@ -82,7 +86,10 @@ func (rrp *ReactionRequestProcessor) processRequest(request *models.ReactionRequ
 }
 
 func (rrp *ReactionRequestProcessor) processLikeRequest(account *models.Account, target *models.Status) error {
-	client, err := activitypub.NewClient(rrp.db.Statement.Context, account)
+	if _, err := domainPolicy(rrp.db, account, target.URI); err != nil {
+		return err
+	}
+	client, err := activitypub.NewClient(rrp.db.Statement.Context, account, rrp.timeout)
 	if err != nil {
 		return err
 	}
@@ -90,7 +97,10 @@ func (rrp *ReactionRequestProcessor) processLikeRequest(account *models.Account,
 }
 
 func (rrp *ReactionRequestProcessor) processUnlikeRequest(account *models.Account, target *models.Status) error {
-	client, err := activitypub.NewClient(rrp.db.Statement.Context, account)
+	if _, err := domainPolicy(rrp.db, account, target.URI); err != nil {
+		return err
+	}
+	client, err := activitypub.NewClient(rrp.db.Statement.Context, account, rrp.timeout)
 	if err != nil {
 		return err
 	}
@@ -11,12 +11,17 @@ import (
 
 // RelationshipRequestProcessor handles delivery of relationship requests.
 type RelationshipRequestProcessor struct {
-	db *gorm.DB
+	db      *gorm.DB
+	workers int
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
 }
 
-func NewRelationshipRequestProcessor(db *gorm.DB) *RelationshipRequestProcessor {
+func NewRelationshipRequestProcessor(db *gorm.DB, workers int, timeout time.Duration) *RelationshipRequestProcessor {
 	return &RelationshipRequestProcessor{
-		db: db,
+		db:      db,
+		workers: workers,
+		timeout: timeout,
 	}
 }
 
@@ -45,7 +50,7 @@ func (rrp *RelationshipRequestProcessor) process() error {
 		return err
 	}
 
-	for _, request := range requests {
+	return forEach(rrp.workers, requests, func(request *models.RelationshipRequest) error {
 		if err := rrp.processRequest(request); err != nil {
 			request.LastAttempt = time.Now()
 			request.Attempts++
@@ -57,9 +62,8 @@ func (rrp *RelationshipRequestProcessor) process() error {
 		if err := rrp.db.Delete(request).Error; err != nil {
 			return err
 		}
-	}
-
-	return nil
+		return nil
+	})
 }
 
 func (rrp *RelationshipRequestProcessor) processRequest(request *models.RelationshipRequest) error {
@@ -82,7 +86,10 @@ func (rrp *RelationshipRequestProcessor) processRequest(request *models.Relation
 }
 
 func (rrp *RelationshipRequestProcessor) processFollowRequest(account *models.Account, target *models.Actor) error {
-	client, err := activitypub.NewClient(rrp.db.Statement.Context, account)
+	if _, err := domainPolicy(rrp.db, account, target.URI); err != nil {
+		return err
+	}
+	client, err := activitypub.NewClient(rrp.db.Statement.Context, account, rrp.timeout)
 	if err != nil {
 		return err
 	}
@@ -90,7 +97,10 @@ func (rrp *RelationshipRequestProcessor) processFollowRequest(account *models.Ac
 }
 
 func (rrp *RelationshipRequestProcessor) processUnfollowRequest(account *models.Account, target *models.Actor) error {
-	client, err := activitypub.NewClient(rrp.db.Statement.Context, account)
+	if _, err := domainPolicy(rrp.db, account, target.URI); err != nil {
+		return err
+	}
+	client, err := activitypub.NewClient(rrp.db.Statement.Context, account, rrp.timeout)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,94 @@
+package activitypub
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/netx"
+	"github.com/davecheney/pub/internal/relme"
+	"gorm.io/gorm"
+)
+
+// relMeTimeout is the time allowed to fetch a linked page.
+const relMeTimeout = 10 * time.Second
+
+// RelMeVerifier verifies the links in the profile fields of local actors.
+// A field is verified if the page it links to links back to the actor with
+// rel="me".
+type RelMeVerifier struct {
+	db      *gorm.DB
+	workers int
+	// client fetches the linked pages; the links are supplied by users so
+	// it must not be able to reach the network of the server.
+	client *http.Client
+}
+
+func NewRelMeVerifier(db *gorm.DB, workers int) *RelMeVerifier {
+	return &RelMeVerifier{
+		db:      db,
+		workers: workers,
+		client:  netx.NewClient(relMeTimeout),
+	}
+}
+
+func (rmv *RelMeVerifier) Run(stop <-chan struct{}) error {
+	fmt.Println("RelMeVerifier.Run started")
+	defer fmt.Println("RelMeVerifier.Run stopped")
+
+	for {
+		if err := rmv.process(); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(30 * time.Second):
+			// continue
+		}
+	}
+}
+
+// process makes one pass through the attributes of local actors, verifying
+// those which have not been checked in the last day.
+func (rmv *RelMeVerifier) process() error {
+	var actors []*models.Actor
+	cutoff := time.Now().Add(-24 * time.Hour)
+	if err := rmv.db.Preload("Attributes", "checked_at is null or checked_at < ?", cutoff).Where("type = ?", "LocalPerson").Find(&actors).Error; err != nil {
+		return err
+	}
+
+	return forEach(rmv.workers, actors, func(actor *models.Actor) error {
+		for _, attr := range actor.Attributes {
+			if err := rmv.verify(actor, attr); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// verify checks the link in attr and records the result.
+func (rmv *RelMeVerifier) verify(actor *models.Actor, attr *models.ActorAttribute) error {
+	now := time.Now()
+	verifiedAt := attr.VerifiedAt
+	if link := relme.Link(attr.Value); link == "" {
+		verifiedAt = nil
+	} else {
+		ok, err := relme.Verify(rmv.db.Statement.Context, rmv.client, link, actor.URL(), actor.URI)
+		if err != nil {
+			fmt.Println("RelMeVerifier.verify: actor:", actor.URI, "link:", link, "error:", err)
+		}
+		switch {
+		case !ok:
+			verifiedAt = nil
+		case verifiedAt == nil:
+			verifiedAt = &now
+		}
+	}
+	return rmv.db.Model(attr).Updates(map[string]any{
+		"checked_at":  now,
+		"verified_at": verifiedAt,
+	}).Error
+}
@@ -0,0 +1,110 @@
+package activitypub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/activitypub"
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// maxReportAttempts is the number of times forwarding a report is attempted.
+const maxReportAttempts = 5
+
+// ReportRequestProcessor forwards reports to the servers of the reported
+// actors.
+type ReportRequestProcessor struct {
+	db      *gorm.DB
+	workers int
+	// timeout is the time allowed for each request to a remote server.
+	timeout time.Duration
+}
+
+func NewReportRequestProcessor(db *gorm.DB, workers int, timeout time.Duration) *ReportRequestProcessor {
+	return &ReportRequestProcessor{
+		db:      db,
+		workers: workers,
+		timeout: timeout,
+	}
+}
+
+func (rrp *ReportRequestProcessor) Run(stop <-chan struct{}) error {
+	fmt.Println("ReportRequestProcessor.Run started")
+	defer fmt.Println("ReportRequestProcessor.Run stopped")
+
+	for {
+		if err := rrp.process(); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(30 * time.Second):
+			// continue
+		}
+	}
+}
+
+// process make one pass through the ReportRequest table, processing
+// any pending requests.
+func (rrp *ReportRequestProcessor) process() error {
+	var requests []*models.ReportRequest
+	if err := rrp.db.Preload("Report.Target").Preload("Report.Instance.ServiceAccount.Actor").Where("attempts < ?", maxReportAttempts).Find(&requests).Error; err != nil {
+		return err
+	}
+
+	return forEach(rrp.workers, requests, func(request *models.ReportRequest) error {
+		if err := rrp.processRequest(request); err != nil {
+			request.LastAttempt = time.Now()
+			request.Attempts++
+			request.LastResult = err.Error()
+			return rrp.db.Save(request).Error
+		}
+		return rrp.db.Delete(request).Error
+	})
+}
+
+// processRequest sends an anonymous Flag activity, signed by the instance
+// actor, to the inbox of the reported actor.
+func (rrp *ReportRequestProcessor) processRequest(request *models.ReportRequest) error {
+	report := request.Report
+	fmt.Println("ReportRequestProcessor.processRequest: report:", report.ID, "target:", report.Target.URI)
+
+	if report.Instance == nil || report.Instance.ServiceAccount == nil {
+		return fmt.Errorf("instance %d has no service account", report.InstanceID)
+	}
+	signAs := report.Instance.ServiceAccount
+	if _, err := domainPolicy(rrp.db, signAs, report.Target.URI); err != nil {
+		return err
+	}
+	var statuses []string
+	if len(report.StatusIDs) > 0 {
+		if err := rrp.db.Model(&models.Status{}).Where("id IN (?) AND actor_id = ?", report.StatusIDs, report.TargetID).Pluck("uri", &statuses).Error; err != nil {
+			return err
+		}
+	}
+	client, err := activitypub.NewClient(rrp.db.Statement.Context, signAs, rrp.timeout)
+	if err != nil {
+		return err
+	}
+	inbox, err := client.Inbox(report.Target.URI)
+	if err != nil {
+		return err
+	}
+	uri := signAs.Actor.URLs().Report(report.ID)
+	if err := client.Post(inbox, map[string]any{
+		"@context": "https://www.w3.org/ns/activitystreams",
+		"id":       uri,
+		"type":     "Flag",
+		"actor":    signAs.Actor.URI,
+		"content":  report.Comment,
+		"object":   append([]string{report.Target.URI}, statuses...),
+	}); err != nil {
+		return err
+	}
+	return rrp.db.Model(report).Updates(map[string]any{
+		"forwarded": true,
+		"uri":       uri,
+	}).Error
+}
@@ -0,0 +1,156 @@
+package activitypub
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/to"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-fed/httpsig"
+	"gorm.io/gorm"
+)
+
+// AuthorizedFetch returns middleware which enforces secure mode, also known as
+// authorized fetch, for ActivityPub GET requests.
+//
+// When the instance serving the request has secure mode enabled, GET requests
+// must carry a valid HTTP signature from an actor which is not blocked by the
+// instance, or by any of its accounts. Unsigned requests for an actor document
+// are answered with a minimal document containing the actor's public key so
+// remote servers can verify our signatures on first contact.
+func AuthorizedFetch(envFn func(*http.Request) *Env) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return httpx.HandlerFunc(envFn, func(env *Env, w http.ResponseWriter, r *http.Request) error {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return nil
+			}
+
+			var instance models.Instance
+			if err := env.DB.Take(&instance, "domain = ?", r.Host).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return httpx.Error(http.StatusNotFound, err)
+				}
+				return err
+			}
+			if !instance.SecureMode {
+				next.ServeHTTP(w, r)
+				return nil
+			}
+
+			if r.Header.Get("Signature") == "" {
+				if isActorDocument(r) {
+					return usersShowPublicKey(env, w, r)
+				}
+				return httpx.Error(http.StatusUnauthorized, errors.New("signature required"))
+			}
+
+			// refuse blocked domains before fetching the owner of the key.
+			domain, err := keyDomain(r)
+			if err != nil {
+				return httpx.Error(http.StatusUnauthorized, err)
+			}
+			blocked, err := models.NewDomainBlocks(env.DB).IsBlocked(&instance, domain)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				return httpx.Error(http.StatusForbidden, errors.New("forbidden"))
+			}
+
+			signer, err := validateSignature(env, r)
+			if err != nil {
+				return httpx.Error(http.StatusUnauthorized, err)
+			}
+			blocked, err = isBlocked(env.DB, &instance, signer)
+			if err != nil {
+				return err
+			}
+			if blocked {
+				return httpx.Error(http.StatusForbidden, errors.New("forbidden"))
+			}
+			next.ServeHTTP(w, r)
+			return nil
+		})
+	}
+}
+
+// keyDomain returns the domain of the key which signed the request.
+func keyDomain(r *http.Request) (string, error) {
+	verifier, err := httpsig.NewVerifier(r)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(verifier.KeyId())
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("keyDomain: %q is not a URL", verifier.KeyId())
+	}
+	return u.Host, nil
+}
+
+// isActorDocument returns true if the request is for the actor document itself,
+// rather than one of the actor's collections.
+func isActorDocument(r *http.Request) bool {
+	return strings.TrimSuffix(r.URL.Path, "/") == "/u/"+chi.URLParam(r, "username")
+}
+
+// isBlocked returns true if the actor is suspended by the instance, or the
+// actor, or the actor's domain, is blocked by the instance or by any account
+// on the instance.
+func isBlocked(db *gorm.DB, instance *models.Instance, actor *models.Actor) (bool, error) {
+	moderation, err := models.NewActorModerations(db).Find(instance.ID, actor)
+	if err != nil {
+		return false, err
+	}
+	if moderation.IsSuspended() {
+		return true, nil
+	}
+	blocked, err := models.NewDomainBlocks(db).IsBlocked(instance, actor.Domain)
+	if err != nil || blocked {
+		return blocked, err
+	}
+	var count int64
+	accounts := db.Select("actor_id").Where("instance_id = ?", instance.ID).Table("accounts")
+	if err := db.Model(&models.Relationship{}).Where("actor_id IN (?) AND target_id = ? AND blocking = true", accounts, actor.ID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// usersShowPublicKey writes a minimal actor document, which contains only
+// the information required to verify the actor's signatures.
+func usersShowPublicKey(env *Env, w http.ResponseWriter, r *http.Request) error {
+	var actor models.Actor
+	if err := env.DB.First(&actor, "name = ? and domain = ?", chi.URLParam(r, "username"), r.Host).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	return to.JSON(w, map[string]any{
+		"@context": []any{
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+		},
+		"id":                actor.URI,
+		"type":              actorType(&actor),
+		"preferredUsername": actor.Name,
+		"inbox":             actor.URI + "/inbox",
+		"endpoints": map[string]any{
+			"sharedInbox": env.URLs.SharedInbox(),
+		},
+		"publicKey": map[string]any{
+			"id":           actor.PublicKeyID(),
+			"owner":        actor.URI,
+			"publicKeyPem": string(actor.PublicKey),
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package activitypub
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// TrendsUpdater periodically scores the tags, statuses, and links used by
+// recent public statuses.
+type TrendsUpdater struct {
+	db       *gorm.DB
+	interval time.Duration
+	// window is the age of the oldest statuses counted.
+	window time.Duration
+	// halfLife is the age at which a use counts half.
+	halfLife time.Duration
+}
+
+func NewTrendsUpdater(db *gorm.DB, interval, window, halfLife time.Duration) *TrendsUpdater {
+	return &TrendsUpdater{
+		db:       db,
+		interval: interval,
+		window:   window,
+		halfLife: halfLife,
+	}
+}
+
+func (t *TrendsUpdater) Run(stop <-chan struct{}) error {
+	fmt.Println("TrendsUpdater.Run started")
+	defer fmt.Println("TrendsUpdater.Run stopped")
+
+	for {
+		if err := models.UpdateTrends(t.db, time.Now(), t.window, t.halfLife); err != nil {
+			return err
+		}
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(t.interval):
+			// continue
+		}
+	}
+}
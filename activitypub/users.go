@@ -3,6 +3,7 @@ package activitypub
 import (
 	"net/http"
 
+	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/httpx"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/to"
@@ -12,81 +13,83 @@ import (
 
 func UsersShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 	var actor models.Actor
-	if err := env.DB.First(&actor, "name = ? and domain = ?", chi.URLParam(r, "username"), r.Host).Error; err != nil {
+	if err := env.DB.Preload("Attributes").Preload("MovedTo").Scopes(models.NotPending).First(&actor, "name = ? and domain = ?", chi.URLParam(r, "username"), r.Host).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return httpx.Error(http.StatusNotFound, err)
 		}
 		return err
 	}
-	return to.JSON(w, map[string]any{
-		"@context": []any{
-			"https://www.w3.org/ns/activitystreams",
-			"https://w3id.org/security/v1",
-			map[string]any{
-				"manuallyApprovesFollowers": "as:manuallyApprovesFollowers",
-				"toot":                      "http://joinmastodon.org/ns#",
-				"featured": map[string]any{
-					"@id":   "toot:featured",
-					"@type": "@id",
-				},
-				"featuredTags": map[string]any{
-					"@id":   "toot:featuredTags",
-					"@type": "@id",
-				},
-				"alsoKnownAs": map[string]any{
-					"@id":   "as:alsoKnownAs",
-					"@type": "@id",
-				},
-				"movedTo": map[string]any{
-					"@id":   "as:movedTo",
-					"@type": "@id",
-				},
-				"schema":           "http://schema.org#",
-				"PropertyValue":    "schema:PropertyValue",
-				"value":            "schema:value",
-				"discoverable":     "toot:discoverable",
-				"Device":           "toot:Device",
-				"Ed25519Signature": "toot:Ed25519Signature",
-				"Ed25519Key":       "toot:Ed25519Key",
-				"Curve25519Key":    "toot:Curve25519Key",
-				"EncryptedMessage": "toot:EncryptedMessage",
-				"publicKeyBase64":  "toot:publicKeyBase64",
-				"deviceId":         "toot:deviceId",
-				"claim": map[string]any{
-					"@type": "@id",
-					"@id":   "toot:claim",
-				},
-				"fingerprintKey": map[string]any{
-					"@type": "@id",
-					"@id":   "toot:fingerprintKey",
-				},
-				"identityKey": map[string]any{
-					"@type": "@id",
-					"@id":   "toot:identityKey",
-				},
-				"devices": map[string]any{
-					"@type": "@id",
-					"@id":   "toot:devices",
-				},
-				"messageFranking": "toot:messageFranking",
-				"messageType":     "toot:messageType",
-				"cipherText":      "toot:cipherText",
-				"suspended":       "toot:suspended",
-				"focalPoint": map[string]any{
-					"@container": "@list",
-					"@id":        "toot:focalPoint",
-				},
-			},
+	obj := serialiseActor(&actor)
+	obj["@context"] = actorContext
+	return to.JSON(w, obj)
+}
+
+// actorContext is the JSON-LD context of an actor document.
+var actorContext = []any{
+	"https://www.w3.org/ns/activitystreams",
+	"https://w3id.org/security/v1",
+	map[string]any{
+		"manuallyApprovesFollowers": "as:manuallyApprovesFollowers",
+		"toot":                      "http://joinmastodon.org/ns#",
+		"featured": map[string]any{
+			"@id":   "toot:featured",
+			"@type": "@id",
 		},
-		"id": actor.URI,
-		"type": func(a *models.Actor) string {
-			switch a.Type {
-			case "LocalPerson":
-				return "Person"
-			default:
-				return a.Type
-			}
-		}(&actor),
+		"featuredTags": map[string]any{
+			"@id":   "toot:featuredTags",
+			"@type": "@id",
+		},
+		"alsoKnownAs": map[string]any{
+			"@id":   "as:alsoKnownAs",
+			"@type": "@id",
+		},
+		"movedTo": map[string]any{
+			"@id":   "as:movedTo",
+			"@type": "@id",
+		},
+		"schema":           "http://schema.org#",
+		"PropertyValue":    "schema:PropertyValue",
+		"value":            "schema:value",
+		"discoverable":     "toot:discoverable",
+		"Device":           "toot:Device",
+		"Ed25519Signature": "toot:Ed25519Signature",
+		"Ed25519Key":       "toot:Ed25519Key",
+		"Curve25519Key":    "toot:Curve25519Key",
+		"EncryptedMessage": "toot:EncryptedMessage",
+		"publicKeyBase64":  "toot:publicKeyBase64",
+		"deviceId":         "toot:deviceId",
+		"claim": map[string]any{
+			"@type": "@id",
+			"@id":   "toot:claim",
+		},
+		"fingerprintKey": map[string]any{
+			"@type": "@id",
+			"@id":   "toot:fingerprintKey",
+		},
+		"identityKey": map[string]any{
+			"@type": "@id",
+			"@id":   "toot:identityKey",
+		},
+		"devices": map[string]any{
+			"@type": "@id",
+			"@id":   "toot:devices",
+		},
+		"messageFranking": "toot:messageFranking",
+		"messageType":     "toot:messageType",
+		"cipherText":      "toot:cipherText",
+		"suspended":       "toot:suspended",
+		"focalPoint": map[string]any{
+			"@container": "@list",
+			"@id":        "toot:focalPoint",
+		},
+	},
+}
+
+// serialiseActor returns the ActivityPub representation of a local actor.
+func serialiseActor(actor *models.Actor) map[string]any {
+	obj := map[string]any{
+		"id":                        actor.URI,
+		"type":                      actorType(actor),
 		"following":                 actor.URI + "/following",
 		"followers":                 actor.URI + "/followers",
 		"inbox":                     actor.URI + "/inbox",
@@ -98,7 +101,7 @@ func UsersShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 		"summary":                   actor.Note,
 		"url":                       actor.URL(),
 		"manuallyApprovesFollowers": actor.Locked,
-		"discoverable":              false,                                            // mastodon sets this to false
+		"discoverable":              actor.Discoverable,
 		"published":                 actor.ID.ToTime().Format("2006-01-02T00:00:00Z"), // spec says round created_at to nearest day
 		"devices":                   actor.URI + "/collections/devices",
 		"publicKey": map[string]any{
@@ -106,15 +109,41 @@ func UsersShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 			"owner":        actor.URI,
 			"publicKeyPem": string(actor.PublicKey),
 		},
-		"tag":        []any{},
-		"attachment": []any{},
+		"tag": []any{},
+		"attachment": algorithms.Map(actor.Attributes, func(a *models.ActorAttribute) any {
+			return map[string]any{
+				"type":  "PropertyValue",
+				"name":  a.Name,
+				"value": a.Value,
+			}
+		}),
 		"endpoints": map[string]any{
-			"sharedInbox": "https://" + r.Host + "/inbox",
+			"sharedInbox": actor.URLs().SharedInbox(),
 		},
 		"icon": map[string]any{
 			"type":      "Image",
 			"mediaType": "image/jpeg",
 			"url":       actor.Avatar,
 		},
-	})
+		"image": map[string]any{
+			"type":      "Image",
+			"mediaType": "image/jpeg",
+			"url":       actor.Header,
+		},
+		"alsoKnownAs": actor.AlsoKnownAs,
+	}
+	if actor.MovedTo != nil {
+		obj["movedTo"] = actor.MovedTo.URI
+	}
+	return obj
+}
+
+// actorType returns the ActivityPub type of the actor.
+func actorType(a *models.Actor) string {
+	switch a.Type {
+	case "LocalPerson":
+		return "Person"
+	default:
+		return a.Type
+	}
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type AliasCmd struct {
+	Name   string `required:"" help:"name of the account"`
+	Domain string `required:"" help:"domain of the account"`
+	Alias  string `required:"" help:"URI of the actor which is also this account"`
+	Remove bool   `help:"remove the alias"`
+}
+
+func (a *AliasCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", a.Name, a.Domain).Error; err != nil {
+		return err
+	}
+	actors := models.NewActors(db)
+	if a.Remove {
+		return actors.RemoveAlias(account.Actor, a.Alias)
+	}
+	return actors.AddAlias(account.Actor, a.Alias)
+}
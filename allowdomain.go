@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type AllowDomainCmd struct {
+	Instance string `required:"" help:"domain name of the instance"`
+	Domain   string `required:"" help:"domain to allow"`
+	Disallow bool   `help:"remove the domain from the allowlist"`
+}
+
+func (a *AllowDomainCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, a.Instance)
+	if err != nil {
+		return err
+	}
+	var allows []models.DomainAllow
+	if err := db.Where("instance_id = ? AND domain = ?", admin.InstanceID, a.Domain).Find(&allows).Error; err != nil {
+		return err
+	}
+	actions := models.NewAdminActions(db)
+	if a.Disallow {
+		for i := range allows {
+			if err := actions.DisallowDomain(admin, &allows[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(allows) > 0 {
+		// already allowed.
+		return nil
+	}
+	_, err = actions.AllowDomain(admin, a.Domain)
+	return err
+}
+
+type AllowlistModeCmd struct {
+	Domain  string `required:"" help:"domain name of the instance"`
+	Disable bool   `help:"disable allowlist mode"`
+}
+
+func (a *AllowlistModeCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, a.Domain)
+	if err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).SetAllowlistMode(admin, !a.Disable)
+}
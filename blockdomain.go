@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type BlockDomainCmd struct {
+	Instance    string `required:"" help:"domain name of the instance"`
+	Domain      string `required:"" help:"domain to block"`
+	Severity    string `help:"severity of the block, one of suspend, silence, or noop" enum:"suspend,silence,noop" default:"suspend"`
+	RejectMedia bool   `help:"do not store attachments from the domain"`
+	Comment     string `help:"public comment on the block"`
+	Unblock     bool   `help:"remove the block"`
+}
+
+func (b *BlockDomainCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, b.Instance)
+	if err != nil {
+		return err
+	}
+	var blocks []models.DomainBlock
+	if err := db.Where("instance_id = ? AND domain = ?", admin.InstanceID, b.Domain).Find(&blocks).Error; err != nil {
+		return err
+	}
+	actions := models.NewAdminActions(db)
+	if b.Unblock {
+		for i := range blocks {
+			if err := actions.UnblockDomain(admin, &blocks[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(blocks) > 0 {
+		// already blocked, update the block.
+		block := &blocks[0]
+		block.Severity = b.Severity
+		block.RejectMedia = b.RejectMedia
+		block.PublicComment = b.Comment
+		return actions.UpdateDomainBlock(admin, block)
+	}
+	return actions.BlockDomain(admin, &models.DomainBlock{
+		Domain:        b.Domain,
+		Severity:      b.Severity,
+		RejectMedia:   b.RejectMedia,
+		PublicComment: b.Comment,
+	})
+}
@@ -0,0 +1,23 @@
+package main
+
+import "fmt"
+
+type ConfigCmd struct {
+	Check ConfigCheckCmd `cmd:"" help:"Validate the configuration and print the effective settings."`
+}
+
+type ConfigCheckCmd struct{}
+
+func (c *ConfigCheckCmd) Run(ctx *Context) error {
+	fmt.Print(ctx.Settings)
+	return ctx.Settings.Validate()
+}
+
+// stringOrDefault returns s, or def if s is empty. It is used to apply flags
+// which override a setting only when they are given.
+func stringOrDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
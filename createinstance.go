@@ -1,10 +1,10 @@
 package main
 
 import (
-	"fmt"
-
+	"github.com/davecheney/pub/internal/keypair"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -14,6 +14,7 @@ type CreateInstanceCmd struct {
 	Title       string `required:"" help:"title of the instance to create"`
 	Description string `required:"" help:"description of the instance to create"`
 	AdminEmail  string `required:"" help:"email address of the admin account to create"`
+	SecureMode  bool   `help:"require signed requests for ActivityPub GET endpoints"`
 }
 
 func (c *CreateInstanceCmd) Run(ctx *Context) error {
@@ -22,30 +23,31 @@ func (c *CreateInstanceCmd) Run(ctx *Context) error {
 		return err
 	}
 
-	kp, err := generateRSAKeypair()
+	kp, err := keypair.Generate()
 	if err != nil {
 		return err
 	}
 
-	passwd, err := bcrypt.GenerateFromPassword(kp.privateKey, bcrypt.DefaultCost)
+	passwd, err := bcrypt.GenerateFromPassword(kp.PrivateKey, bcrypt.DefaultCost)
 	if err != nil {
 		return err
 	}
 
+	u := urls.New(ctx.Settings.BaseURL(c.Domain))
 	return withTransaction(db, func(tx *gorm.DB) error {
 
 		admin := models.Actor{
 			ID:          snowflake.Now(),
 			Type:        "Service",
-			URI:         fmt.Sprintf("https://%s/u/%s", c.Domain, "admin"),
+			URI:         u.Actor("admin"),
 			Name:        "admin",
 			Domain:      c.Domain,
 			DisplayName: "admin",
 			Locked:      false,
 			Note:        "The admin account for " + c.Domain,
-			Avatar:      "https://avatars.githubusercontent.com/u/1024?v=4",
-			Header:      "https://avatars.githubusercontent.com/u/1024?v=4",
-			PublicKey:   kp.publicKey,
+			Avatar:      ctx.Settings.Media.DefaultAvatar,
+			Header:      ctx.Settings.Media.DefaultHeader,
+			PublicKey:   kp.PublicKey,
 		}
 		if err := tx.Create(&admin).Error; err != nil {
 			return err
@@ -58,7 +60,8 @@ func (c *CreateInstanceCmd) Run(ctx *Context) error {
 			Title:            c.Title,
 			ShortDescription: c.Description,
 			Description:      c.Description,
-			Thumbnail:        "https://avatars.githubusercontent.com/u/1024?v=4",
+			Thumbnail:        ctx.Settings.Media.DefaultAvatar,
+			SecureMode:       c.SecureMode,
 			Rules: []models.InstanceRule{{
 				Text: "No loafing",
 			}},
@@ -83,17 +86,60 @@ func (c *CreateInstanceCmd) Run(ctx *Context) error {
 			ActorID:           admin.ID,
 			Email:             c.AdminEmail,
 			EncryptedPassword: passwd,
-			PrivateKey:        kp.privateKey,
-			RoleID:            adminRole.ID,
+			PrivateKey:        kp.PrivateKey,
+			RoleID:            &adminRole.ID,
 		}
 		if err := tx.Create(&adminAccount).Error; err != nil {
 			return err
 		}
 
-		return tx.Model(&instance).Update("admin_id", adminAccount.ID).Error
+		if err := tx.Model(&instance).Update("admin_id", adminAccount.ID).Error; err != nil {
+			return err
+		}
+
+		return createServiceAccount(tx, &instance, u)
 	})
 }
 
+// createServiceAccount creates the Application actor, and its account, which
+// signs server to server requests on behalf of the instance. u builds the URLs
+// of the instance.
+func createServiceAccount(tx *gorm.DB, instance *models.Instance, u *urls.Builder) error {
+	kp, err := keypair.Generate()
+	if err != nil {
+		return err
+	}
+
+	actor := models.Actor{
+		ID:          snowflake.Now(),
+		Type:        "Application",
+		URI:         u.InstanceActor(),
+		Name:        instance.Domain,
+		Domain:      instance.Domain,
+		DisplayName: instance.Domain,
+		Locked:      true,
+		Note:        "The instance actor for " + instance.Domain,
+		PublicKey:   kp.PublicKey,
+	}
+	if err := tx.Create(&actor).Error; err != nil {
+		return err
+	}
+
+	// the service account has no password, it cannot log in.
+	account := models.Account{
+		ID:                snowflake.Now(),
+		InstanceID:        instance.ID,
+		ActorID:           actor.ID,
+		EncryptedPassword: []byte{},
+		PrivateKey:        kp.PrivateKey,
+	}
+	if err := tx.Create(&account).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(instance).Update("service_account_id", account.ID).Error
+}
+
 func withTransaction(db *gorm.DB, fn func(*gorm.DB) error) error {
 	tx := db.Begin()
 	if err := fn(tx); err != nil {
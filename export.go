@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+
+	"github.com/davecheney/pub/activitypub"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/media"
+	"gorm.io/gorm"
+)
+
+type ExportCmd struct {
+	Name     string `required:"" help:"name of the account to export"`
+	Domain   string `required:"" help:"domain of the account to export"`
+	Output   string `required:"" short:"o" help:"path of the .tar.gz archive to write"`
+	MediaDir string `help:"directory of uploaded media, overrides media.dir"`
+}
+
+func (c *ExportCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", c.Name, c.Domain).Error; err != nil {
+		return err
+	}
+
+	f, err := os.Create(c.Output)
+	if err != nil {
+		return err
+	}
+	exporter := activitypub.NewExporter(db, &account, media.NewStore(stringOrDefault(c.MediaDir, ctx.Settings.Media.Dir)))
+	if err := exporter.Export(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
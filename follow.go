@@ -6,6 +6,7 @@ import (
 	"github.com/davecheney/pub/internal/activitypub"
 	"github.com/davecheney/pub/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FollowCmd struct {
@@ -20,11 +21,11 @@ func (f *FollowCmd) Run(ctx *Context) error {
 	}
 
 	var account models.Account
-	if err := db.Joins("Actor", &models.Actor{URI: f.Actor}).Take(&account).Error; err != nil {
+	if err := db.Joins("Actor").Where(clause.Eq{Column: clause.Column{Table: "Actor", Name: "uri"}, Value: f.Actor}).Take(&account).Error; err != nil {
 		return err
 	}
 
-	client, err := activitypub.NewClient(context.Background(), &account)
+	client, err := activitypub.NewClient(context.Background(), &account, ctx.Settings.HTTP.ClientTimeout)
 	if err != nil {
 		return err
 	}
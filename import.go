@@ -1,9 +1,21 @@
 package main
 
-import "gorm.io/gorm"
+import (
+	"fmt"
+
+	"github.com/davecheney/pub/activitypub"
+	"github.com/davecheney/pub/internal/archive"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/media"
+	"gorm.io/gorm"
+)
 
 type ImportCmd struct {
-	URI string `short:"u" long:"uri" description:"URI to import"`
+	Name     string `required:"" help:"name of the account to import into"`
+	Domain   string `required:"" help:"domain of the account to import into"`
+	Archive  string `arg:"" help:"path to the Mastodon archive; a directory, .zip, or .tar.gz file"`
+	Follow   bool   `help:"follow the accounts in following_accounts.csv"`
+	MediaDir string `help:"directory to store imported media, overrides media.dir"`
 }
 
 func (c *ImportCmd) Run(ctx *Context) error {
@@ -11,6 +23,23 @@ func (c *ImportCmd) Run(ctx *Context) error {
 	if err != nil {
 		return err
 	}
-	_ = db
+
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", c.Name, c.Domain).Error; err != nil {
+		return err
+	}
+
+	fsys, closer, err := archive.Open(c.Archive)
+	if err != nil {
+		return err
+	}
+	defer closer()
+
+	importer := activitypub.NewImporter(db, &account, media.NewStore(stringOrDefault(c.MediaDir, ctx.Settings.Media.Dir)), ctx.Settings.HTTP.ClientTimeout)
+	importer.Follow = c.Follow
+	if err := importer.Import(fsys); err != nil {
+		return err
+	}
+	fmt.Printf("%+v\n", importer.Stats)
 	return nil
 }
@@ -25,10 +25,13 @@ import (
 type Client struct {
 	keyID      string
 	privateKey crypto.PrivateKey
+	// timeout is the time allowed for each request.
+	timeout time.Duration
 }
 
-// NewClient returns a new ActivityPub client.
-func NewClient(ctx context.Context, signAs *models.Account) (*Client, error) {
+// NewClient returns a new ActivityPub client which signs its requests as
+// signAs, and allows timeout for each request.
+func NewClient(ctx context.Context, signAs *models.Account, timeout time.Duration) (*Client, error) {
 	privPem, _ := pem.Decode(signAs.PrivateKey)
 	if privPem == nil || privPem.Type != "RSA PRIVATE KEY" {
 		return nil, errors.New("expected RSA PRIVATE KEY")
@@ -50,6 +53,7 @@ func NewClient(ctx context.Context, signAs *models.Account) (*Client, error) {
 	return &Client{
 		keyID:      signAs.Actor.PublicKeyID(),
 		privateKey: privateKey,
+		timeout:    timeout,
 	}, nil
 }
 
@@ -78,17 +82,10 @@ func (e *Error) Error() string {
 
 // Follow sends a follow request to the given URL.
 func (c *Client) Follow(follower, target string) error {
-	actor, err := c.Get(target)
+	inbox, err := c.Inbox(target)
 	if err != nil {
 		return err
 	}
-	inbox := stringFromAny(actor["sharedInbox"])
-	if inbox == "" {
-		inbox = stringFromAny(actor["inbox"])
-		if inbox == "" {
-			return fmt.Errorf("no inbox found for %s", target)
-		}
-	}
 
 	return c.Post(inbox, map[string]any{
 		"@context": "https://www.w3.org/ns/activitystreams",
@@ -101,17 +98,10 @@ func (c *Client) Follow(follower, target string) error {
 
 // Unfollow sends an unfollow request to the given URL.
 func (c *Client) Unfollow(follower, target string) error {
-	actor, err := c.Get(target)
+	inbox, err := c.Inbox(target)
 	if err != nil {
 		return err
 	}
-	inbox := stringFromAny(actor["sharedInbox"])
-	if inbox == "" {
-		inbox = stringFromAny(actor["inbox"])
-		if inbox == "" {
-			return fmt.Errorf("no inbox found for %s", target)
-		}
-	}
 
 	return c.Post(inbox, map[string]any{
 		"@context": "https://www.w3.org/ns/activitystreams",
@@ -128,17 +118,10 @@ func (c *Client) Unfollow(follower, target string) error {
 
 // Like sends a like request to the given URL.
 func (c *Client) Like(liking, target string) error {
-	actor, err := c.Get(target)
+	inbox, err := c.Inbox(target)
 	if err != nil {
 		return err
 	}
-	inbox := stringFromAny(actor["sharedInbox"])
-	if inbox == "" {
-		inbox = stringFromAny(actor["inbox"])
-		if inbox == "" {
-			return fmt.Errorf("no inbox found for %s", target)
-		}
-	}
 
 	return c.Post(inbox, map[string]any{
 		"@context": "https://www.w3.org/ns/activitystreams",
@@ -151,17 +134,10 @@ func (c *Client) Like(liking, target string) error {
 
 // Unlike sends an undo like request to the given URL.
 func (c *Client) Unlike(liking, target string) error {
-	actor, err := c.Get(target)
+	inbox, err := c.Inbox(target)
 	if err != nil {
 		return err
 	}
-	inbox := stringFromAny(actor["sharedInbox"])
-	if inbox == "" {
-		inbox = stringFromAny(actor["inbox"])
-		if inbox == "" {
-			return fmt.Errorf("no inbox found for %s", target)
-		}
-	}
 
 	return c.Post(inbox, map[string]any{
 		"@context": "https://www.w3.org/ns/activitystreams",
@@ -176,6 +152,75 @@ func (c *Client) Unlike(liking, target string) error {
 	})
 }
 
+// Inbox returns the inbox to deliver activities for the actor at the given URL,
+// preferring the shared inbox of the actor's server if it has one.
+func (c *Client) Inbox(uri string) (string, error) {
+	actor, err := c.Get(uri)
+	if err != nil {
+		return "", err
+	}
+	inbox := stringFromAny(mapFromAny(actor["endpoints"])["sharedInbox"])
+	if inbox == "" {
+		inbox = stringFromAny(actor["sharedInbox"])
+	}
+	if inbox == "" {
+		inbox = stringFromAny(actor["inbox"])
+	}
+	if inbox == "" {
+		return "", fmt.Errorf("no inbox found for %s", uri)
+	}
+	return inbox, nil
+}
+
+// Walk calls fn with the URL and items of each page of the collection at uri.
+// uri may be a collection, in which case walking starts at its first page, or
+// a page of a collection, in which case walking starts at that page. Pages
+// embedded in their collection, or in the previous page, are walked without
+// fetching them again. Walk stops when the last page is reached, or fn
+// returns an error.
+func (c *Client) Walk(uri string, fn func(page string, items []any) error) error {
+	obj, err := c.Get(uri)
+	if err != nil {
+		return err
+	}
+	seen := map[string]bool{uri: true}
+	for {
+		items, ok := obj["orderedItems"].([]any)
+		if !ok {
+			items, _ = obj["items"].([]any)
+		}
+		if len(items) > 0 {
+			if err := fn(uri, items); err != nil {
+				return err
+			}
+		}
+		var next any
+		switch stringFromAny(obj["type"]) {
+		case "OrderedCollection", "Collection":
+			next = obj["first"]
+		default:
+			next = obj["next"]
+		}
+		if page, ok := next.(map[string]any); ok && (page["orderedItems"] != nil || page["items"] != nil) {
+			// the page is embedded, there is no need to fetch it.
+			if id := idFromAny(page); id != "" {
+				uri = id
+			}
+			obj = page
+			seen[uri] = true
+			continue
+		}
+		uri = idFromAny(next)
+		if uri == "" || seen[uri] {
+			return nil
+		}
+		seen[uri] = true
+		if obj, err = c.Get(uri); err != nil {
+			return err
+		}
+	}
+}
+
 // Get fetches the ActivityPub resource at the given URL.
 func (c *Client) Get(uri string) (map[string]any, error) {
 	req, err := http.NewRequest("GET", uri, nil)
@@ -187,7 +232,7 @@ func (c *Client) Get(uri string) (map[string]any, error) {
 		return nil, fmt.Errorf("failed to sign request: %w", err)
 	}
 	ctx := context.Background()
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 	req = req.WithContext(ctx)
 	resp, err := http.DefaultClient.Do(req)
@@ -212,7 +257,9 @@ func (c *Client) Post(url string, obj map[string]any) error {
 	if err := httpsig.Sign(req, c.keyID, c.privateKey, body); err != nil {
 		return fmt.Errorf("failed to sign request: %w", err)
 	}
-	resp, err := http.DefaultClient.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -252,3 +299,20 @@ func stringFromAny(v any) string {
 	s, _ := v.(string)
 	return s
 }
+
+func mapFromAny(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+// idFromAny returns the id of v, which may be a URI or an object.
+func idFromAny(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any:
+		return stringFromAny(v["id"])
+	default:
+		return ""
+	}
+}
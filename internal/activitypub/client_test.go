@@ -0,0 +1,76 @@
+package activitypub
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/keypair"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientWalk(t *testing.T) {
+	var url string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replies", func(w http.ResponseWriter, r *http.Request) {
+		// the first page is embedded in the collection, as Mastodon does.
+		fmt.Fprintf(w, `{"id":"%[1]s/replies","type":"Collection","first":{"type":"CollectionPage","next":"%[1]s/replies?page=2","items":["%[1]s/1"]}}`, url)
+	})
+	mux.HandleFunc("/replies2", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"id":"%[1]s/replies2","type":"OrderedCollection","first":"%[1]s/replies2?page=1"}`, url)
+	})
+	mux.HandleFunc("/other", func(w http.ResponseWriter, r *http.Request) {
+		// a page which links back to itself.
+		fmt.Fprintf(w, `{"id":"%[1]s/other","type":"OrderedCollectionPage","next":"%[1]s/other","orderedItems":[{"id":"%[1]s/4"}]}`, url)
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotEmpty(t, r.Header.Get("Signature"))
+		switch r.URL.RawQuery {
+		case "page=2":
+			fmt.Fprintf(w, `{"id":"%[1]s/replies?page=2","type":"CollectionPage","items":["%[1]s/2","%[1]s/3"]}`, url)
+		case "page=1":
+			fmt.Fprintf(w, `{"type":"OrderedCollectionPage","orderedItems":[]}`)
+		default:
+			mux.ServeHTTP(w, r)
+		}
+	}))
+	defer srv.Close()
+	url = srv.URL
+
+	kp, err := keypair.Generate()
+	require.NoError(t, err)
+	c, err := NewClient(context.Background(), &models.Account{
+		PrivateKey: kp.PrivateKey,
+		Actor:      &models.Actor{URI: "https://example.com/users/dave"},
+	}, 5*time.Second)
+	require.NoError(t, err)
+
+	walk := func(uri string) ([]string, []string) {
+		var pages, items []string
+		err := c.Walk(uri, func(page string, is []any) error {
+			pages = append(pages, page)
+			for _, item := range is {
+				items = append(items, idFromAny(item))
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		return pages, items
+	}
+
+	pages, items := walk(url + "/replies")
+	require.Equal(t, []string{url + "/replies", url + "/replies?page=2"}, pages)
+	require.Equal(t, []string{url + "/1", url + "/2", url + "/3"}, items)
+
+	pages, items = walk(url + "/replies2")
+	require.Empty(t, pages)
+	require.Empty(t, items)
+
+	pages, items = walk(url + "/other")
+	require.Equal(t, []string{url + "/other"}, pages)
+	require.Equal(t, []string{url + "/4"}, items)
+}
@@ -0,0 +1,96 @@
+// Package archive opens account archives, such as those exported by Mastodon.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Open opens the archive at path, which may be a directory, a zip file,
+// or a gzipped tar file. The returned function releases any resources
+// held by the archive.
+func Open(path string) (fs.FS, func() error, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case fi.IsDir():
+		return os.DirFS(path), func() error { return nil }, nil
+	case strings.HasSuffix(path, ".zip"):
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		dir, err := os.MkdirTemp("", "pub-archive-")
+		if err != nil {
+			return nil, nil, err
+		}
+		cleanup := func() error { return os.RemoveAll(dir) }
+		if err := extract(path, dir); err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		return os.DirFS(dir), cleanup, nil
+	default:
+		return nil, nil, fmt.Errorf("archive.Open: %s: unsupported archive format", path)
+	}
+}
+
+// extract extracts the regular files in the gzipped tar file at path into dir.
+func extract(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("archive.extract: %s: invalid path %q", path, hdr.Name)
+		}
+		if err := extractFile(tr, filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+}
+
+func extractFile(r io.Reader, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
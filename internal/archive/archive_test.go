@@ -0,0 +1,97 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var files = map[string]string{
+	"actor.json":                            `{"type":"Person"}`,
+	"media_attachments/files/001/large.png": "png",
+}
+
+func TestOpenDir(t *testing.T) {
+	dir := t.TempDir()
+	for name, body := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+	}
+	testOpen(t, dir)
+}
+
+func TestOpenZip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for name, body := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+	testOpen(t, path)
+}
+
+func TestOpenTarGz(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, body := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     "./" + name,
+			Typeflag: tar.TypeReg,
+			Mode:     0o644,
+			Size:     int64(len(body)),
+		}))
+		_, err := tw.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+	testOpen(t, path)
+}
+
+func TestOpenTarGzInvalidPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.tgz")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     "../escape",
+		Typeflag: tar.TypeReg,
+		Mode:     0o644,
+	}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	require.NoError(t, f.Close())
+
+	_, _, err = Open(path)
+	require.Error(t, err)
+}
+
+func testOpen(t *testing.T, path string) {
+	t.Helper()
+	fsys, closer, err := Open(path)
+	require.NoError(t, err)
+	defer closer()
+	for name, body := range files {
+		got, err := fs.ReadFile(fsys, name)
+		require.NoError(t, err)
+		require.Equal(t, body, string(got))
+	}
+}
@@ -0,0 +1,363 @@
+// Package config loads the settings for pub from a YAML file and the
+// environment.
+//
+// Settings are read from the file, then overridden by environment variables
+// named PUB_ followed by the upper cased path of the setting joined with
+// underscores, eg. PUB_DATABASE_DSN overrides database.dsn.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of pub.
+type Config struct {
+	// Listen is the address the HTTP server listens on.
+	Listen     string              `yaml:"listen"`
+	Database   Database            `yaml:"database"`
+	HTTP       HTTP                `yaml:"http"`
+	Instances  map[string]Instance `yaml:"instances,omitempty"`
+	Media      Media               `yaml:"media"`
+	Workers    Workers             `yaml:"workers"`
+	Federation Federation          `yaml:"federation"`
+	Trends     Trends              `yaml:"trends"`
+	Logging    Logging             `yaml:"logging"`
+}
+
+type Database struct {
+	// Driver is one of mysql, sqlite or postgres.
+	Driver string `yaml:"driver"`
+	// DSN is the data source name. If empty, the default for the driver is
+	// used, see DataSource.
+	DSN             string        `yaml:"dsn"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+}
+
+type HTTP struct {
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// ClientTimeout is the timeout for requests to other servers.
+	ClientTimeout time.Duration `yaml:"client_timeout"`
+}
+
+// Instance holds the settings for an instance, keyed by its domain.
+type Instance struct {
+	// BaseURL is the URL the instance is served from, by default
+	// https:// followed by its domain. Requests are matched to instances by
+	// their host, so the host of BaseURL must be the domain.
+	BaseURL string `yaml:"base_url"`
+}
+
+type Media struct {
+	// Dir is the directory uploaded media is stored in.
+	Dir string `yaml:"dir"`
+	// DefaultAvatar and DefaultHeader are the URLs of the images used for
+	// actors without an avatar or header.
+	DefaultAvatar string `yaml:"default_avatar"`
+	DefaultHeader string `yaml:"default_header"`
+	// CacheDir is the directory remote avatars, headers, and attachments
+	// are cached in.
+	CacheDir string `yaml:"cache_dir"`
+	// CacheSize is the size in bytes the cache is limited to, the least
+	// recently used files are removed when it is exceeded. Zero is unlimited.
+	CacheSize int64 `yaml:"cache_size"`
+}
+
+// Workers holds the number of requests each background processor handles
+// at once. Zero disables the processor.
+type Workers struct {
+	Relationships int `yaml:"relationships"`
+	Reactions     int `yaml:"reactions"`
+	Actors        int `yaml:"actors"`
+	RelMe         int `yaml:"relme"`
+	Backfill      int `yaml:"backfill"`
+	Reports       int `yaml:"reports"`
+}
+
+type Federation struct {
+	// VapidKey is the public key sent to applications for web push.
+	VapidKey string `yaml:"vapid_key"`
+	// StaleActorAge is the age after which remote actors are refetched.
+	StaleActorAge time.Duration `yaml:"stale_actor_age"`
+	// BackfillOutboxLimit is the maximum number of statuses fetched from a
+	// remote actor's outbox.
+	BackfillOutboxLimit int `yaml:"backfill_outbox_limit"`
+	// BackfillRepliesLimit is the maximum number of statuses fetched for a
+	// remote thread.
+	BackfillRepliesLimit int `yaml:"backfill_replies_limit"`
+	// PruneAfter is the age after which remote statuses which no local
+	// account has interacted with are deleted. Zero disables pruning.
+	PruneAfter time.Duration `yaml:"prune_after"`
+}
+
+// Trends holds the settings for the trending tags, statuses, and links.
+type Trends struct {
+	// Interval is how often trends are updated. Zero disables trends.
+	Interval time.Duration `yaml:"interval"`
+	// Window is the age of the oldest statuses counted towards trends.
+	Window time.Duration `yaml:"window"`
+	// HalfLife is the age at which a use counts half as much as a new one.
+	HalfLife time.Duration `yaml:"half_life"`
+}
+
+type Logging struct {
+	// HTTP logs HTTP requests.
+	HTTP bool `yaml:"http"`
+	// SQL logs SQL queries.
+	SQL bool `yaml:"sql"`
+}
+
+// Default returns the default configuration.
+func Default() *Config {
+	return &Config{
+		Listen: "127.0.0.1:9999",
+		Database: Database{
+			Driver:          "mysql",
+			MaxIdleConns:    10,
+			MaxOpenConns:    100,
+			ConnMaxLifetime: time.Hour,
+		},
+		HTTP: HTTP{
+			ReadTimeout:   15 * time.Second,
+			WriteTimeout:  15 * time.Second,
+			ClientTimeout: 5 * time.Second,
+		},
+		Media: Media{
+			Dir:           "uploads",
+			DefaultAvatar: "https://avatars.githubusercontent.com/u/1024?v=4",
+			DefaultHeader: "https://static.ma-cdn.net/headers/original/missing.png",
+			CacheDir:      "cache",
+			CacheSize:     1 << 30,
+		},
+		Workers: Workers{
+			Relationships: 1,
+			Reactions:     1,
+			Actors:        1,
+			RelMe:         1,
+			Backfill:      1,
+			Reports:       1,
+		},
+		Federation: Federation{
+			VapidKey:             "BCk-QqERU0q-CfYZjcuB6lnyyOYfJ2AifKqfeGIm7Z-HiTU5T9eTG5GxVA0_OH5mMlI4UkkDTpaZwozy0TzdZ2M=",
+			StaleActorAge:        24 * time.Hour,
+			BackfillOutboxLimit:  40,
+			BackfillRepliesLimit: 100,
+		},
+		Trends: Trends{
+			Interval: 15 * time.Minute,
+			Window:   48 * time.Hour,
+			HalfLife: 6 * time.Hour,
+		},
+	}
+}
+
+// defaultDSNs are the data source names used for each driver when none is
+// configured.
+var defaultDSNs = map[string]string{
+	"mysql":    "pub:pub@tcp(localhost:3306)/pub",
+	"sqlite":   "pub.db",
+	"postgres": "host=localhost user=pub password=pub dbname=pub",
+}
+
+// DataSource returns the data source name of the database; DSN if it is
+// set, otherwise the default for the driver.
+func (d *Database) DataSource() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+	return defaultDSNs[d.Driver]
+}
+
+// Load returns the default configuration, overridden by the file at path, if
+// path is not empty, and then by the environment.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		// an empty file is an io.EOF, leaving the defaults unchanged.
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(cfg).Elem(), "PUB", os.LookupEnv); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// BaseURL returns the URL the instance for domain is served from.
+func (c *Config) BaseURL(domain string) string {
+	if i, ok := c.Instances[domain]; ok && i.BaseURL != "" {
+		return strings.TrimSuffix(i.BaseURL, "/")
+	}
+	return "https://" + domain
+}
+
+// Validate returns an error describing an invalid setting, if any.
+func (c *Config) Validate() error {
+	if _, ok := defaultDSNs[c.Database.Driver]; !ok {
+		return fmt.Errorf("database.driver: unknown driver %q", c.Database.Driver)
+	}
+	if c.Listen == "" {
+		return errors.New("listen: must not be empty")
+	}
+	for name, d := range map[string]time.Duration{
+		"http.read_timeout":          c.HTTP.ReadTimeout,
+		"http.write_timeout":         c.HTTP.WriteTimeout,
+		"http.client_timeout":        c.HTTP.ClientTimeout,
+		"database.conn_max_lifetime": c.Database.ConnMaxLifetime,
+		"federation.stale_actor_age": c.Federation.StaleActorAge,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("%s: must be positive", name)
+		}
+	}
+	for name, n := range map[string]int{
+		"database.max_idle_conns":           c.Database.MaxIdleConns,
+		"database.max_open_conns":           c.Database.MaxOpenConns,
+		"workers.relationships":             c.Workers.Relationships,
+		"workers.reactions":                 c.Workers.Reactions,
+		"workers.actors":                    c.Workers.Actors,
+		"workers.relme":                     c.Workers.RelMe,
+		"workers.backfill":                  c.Workers.Backfill,
+		"workers.reports":                   c.Workers.Reports,
+		"federation.backfill_outbox_limit":  c.Federation.BackfillOutboxLimit,
+		"federation.backfill_replies_limit": c.Federation.BackfillRepliesLimit,
+	} {
+		if n < 0 {
+			return fmt.Errorf("%s: must not be negative", name)
+		}
+	}
+	if c.Media.CacheDir == "" {
+		return errors.New("media.cache_dir: must not be empty")
+	}
+	if c.Media.CacheSize < 0 {
+		return errors.New("media.cache_size: must not be negative")
+	}
+	if c.Federation.PruneAfter < 0 {
+		return errors.New("federation.prune_after: must not be negative")
+	}
+	if c.Trends.Interval < 0 {
+		return errors.New("trends.interval: must not be negative")
+	}
+	if c.Trends.Interval > 0 {
+		for name, d := range map[string]time.Duration{
+			"trends.window":    c.Trends.Window,
+			"trends.half_life": c.Trends.HalfLife,
+		} {
+			if d <= 0 {
+				return fmt.Errorf("%s: must be positive", name)
+			}
+		}
+	}
+	for domain, i := range c.Instances {
+		if i.BaseURL == "" {
+			continue
+		}
+		if err := validateURL(i.BaseURL); err != nil {
+			return fmt.Errorf("instances.%s.base_url: %w", domain, err)
+		}
+		u, _ := url.Parse(i.BaseURL)
+		if u.Host != domain {
+			return fmt.Errorf("instances.%s.base_url: host %q is not the domain of the instance", domain, u.Host)
+		}
+		if strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("instances.%s.base_url: %q must not have a path", domain, i.BaseURL)
+		}
+	}
+	for name, u := range map[string]string{
+		"media.default_avatar": c.Media.DefaultAvatar,
+		"media.default_header": c.Media.DefaultHeader,
+	} {
+		if err := validateURL(u); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// String returns the configuration as YAML.
+func (c *Config) String() string {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func validateURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%q is not an http or https URL", s)
+	}
+	return nil
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// applyEnv overrides the fields of v with the environment variables named
+// prefix followed by the field's yaml name.
+func applyEnv(v reflect.Value, prefix string, lookup func(string) (string, bool)) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + "_" + strings.ToUpper(strings.Split(f.Tag.Get("yaml"), ",")[0])
+		fv := v.Field(i)
+		if f.Type.Kind() == reflect.Struct {
+			if err := applyEnv(fv, name, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		switch {
+		case f.Type == durationType:
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fv.SetInt(int64(d))
+		case f.Type.Kind() == reflect.String:
+			fv.SetString(s)
+		case f.Type.Kind() == reflect.Int, f.Type.Kind() == reflect.Int64:
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fv.SetInt(int64(n))
+		case f.Type.Kind() == reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			fv.SetBool(b)
+		default:
+			// maps, eg. instances, can only be set in the file.
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultIsValid(t *testing.T) {
+	require.NoError(t, Default().Validate())
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pub.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+listen: 0.0.0.0:8080
+database:
+  driver: sqlite
+  dsn: pub.db
+instances:
+  localhost:8080:
+    base_url: http://localhost:8080/
+federation:
+  stale_actor_age: 72h
+`), 0o644))
+	t.Setenv("PUB_DATABASE_DSN", "/var/lib/pub/pub.db")
+	t.Setenv("PUB_WORKERS_BACKFILL", "0")
+	t.Setenv("PUB_LOGGING_HTTP", "true")
+	t.Setenv("PUB_MEDIA_CACHE_SIZE", "1048576")
+
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, "0.0.0.0:8080", cfg.Listen)
+	require.Equal(t, "sqlite", cfg.Database.Driver)
+	require.Equal(t, "/var/lib/pub/pub.db", cfg.Database.DSN)
+	require.Equal(t, 72*time.Hour, cfg.Federation.StaleActorAge)
+	require.Equal(t, 0, cfg.Workers.Backfill)
+	require.True(t, cfg.Logging.HTTP)
+	require.Equal(t, int64(1<<20), cfg.Media.CacheSize)
+	// unset values keep their defaults.
+	require.Equal(t, 100, cfg.Database.MaxOpenConns)
+
+	require.Equal(t, "http://localhost:8080", cfg.BaseURL("localhost:8080"))
+	require.Equal(t, "https://other.example", cfg.BaseURL("other.example"))
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := t.TempDir()
+	tc := map[string]string{
+		"unknown field": "lisen: 127.0.0.1:9999\n",
+		"bad duration":  "http:\n  read_timeout: soon\n",
+	}
+	for name, contents := range tc {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name+".yaml")
+			require.NoError(t, os.WriteFile(path, []byte(contents), 0o644))
+			_, err := Load(path)
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("bad environment", func(t *testing.T) {
+		t.Setenv("PUB_DATABASE_MAX_OPEN_CONNS", "lots")
+		_, err := Load("")
+		require.Error(t, err)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	tc := map[string]func(*Config){
+		"driver":   func(c *Config) { c.Database.Driver = "oracle" },
+		"timeout":  func(c *Config) { c.HTTP.ReadTimeout = 0 },
+		"workers":  func(c *Config) { c.Workers.Actors = -1 },
+		"base url": func(c *Config) { c.Instances = map[string]Instance{"example.com": {BaseURL: "example.com"}} },
+		"base url host": func(c *Config) {
+			c.Instances = map[string]Instance{"example.com": {BaseURL: "https://pub.example.com"}}
+		},
+		"base url path": func(c *Config) {
+			c.Instances = map[string]Instance{"example.com": {BaseURL: "https://example.com/pub"}}
+		},
+		"default avatar": func(c *Config) { c.Media.DefaultAvatar = "" },
+		"trends window":  func(c *Config) { c.Trends.Window = 0 },
+	}
+	for name, fn := range tc {
+		t.Run(name, func(t *testing.T) {
+			cfg := Default()
+			fn(cfg)
+			require.Error(t, cfg.Validate())
+		})
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pub.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(Default().String()), 0o644))
+	cfg, err := Load(path)
+	require.NoError(t, err)
+	require.Equal(t, Default(), cfg)
+}
+
+func TestDataSource(t *testing.T) {
+	for driver, expect := range map[string]string{
+		"mysql":    "pub:pub@tcp(localhost:3306)/pub",
+		"sqlite":   "pub.db",
+		"postgres": "host=localhost user=pub password=pub dbname=pub",
+	} {
+		db := Database{Driver: driver}
+		require.Equal(t, expect, db.DataSource())
+		db.DSN = "custom"
+		require.Equal(t, "custom", db.DataSource())
+	}
+}
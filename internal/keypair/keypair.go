@@ -0,0 +1,45 @@
+// Package keypair generates the RSA keypairs actors use to sign ActivityPub requests.
+package keypair
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+)
+
+// Keypair is a PEM encoded RSA keypair.
+type Keypair struct {
+	// PublicKey is the PEM encoded PKIX public key.
+	PublicKey []byte
+	// PrivateKey is the PEM encoded PKCS1 private key.
+	PrivateKey []byte
+}
+
+// Generate returns a new 2048 bit RSA keypair.
+func Generate() (*Keypair, error) {
+	privatekey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	publickey := &privatekey.PublicKey
+	privateKeyBytes := x509.MarshalPKCS1PrivateKey(privatekey)
+	privateKeyBlock := &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: privateKeyBytes,
+	}
+	privateKeyPem := pem.EncodeToMemory(privateKeyBlock)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publickey)
+	if err != nil {
+		return nil, err
+	}
+	publicKeyBlock := &pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: publicKeyBytes,
+	}
+	publicKeyPem := pem.EncodeToMemory(publicKeyBlock)
+	return &Keypair{
+		PublicKey:  publicKeyPem,
+		PrivateKey: privateKeyPem,
+	}, nil
+}
@@ -0,0 +1,343 @@
+// Package migrations applies versioned changes to the database schema.
+//
+// Each Migration is applied once, in order of its version, and recorded in
+// the schema_migrations table. The first migration creates the schema from a
+// snapshot of the models, in v1.go, which must not change. Changes to the
+// models after that need a new migration. Migrations should check for a
+// change before making it, or use tx.AutoMigrate on the models they change,
+// as a database created by models.AutoMigrate before versioned migrations
+// were introduced may already have the change.
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+// A Migration is a versioned change to the database schema.
+type Migration struct {
+	Version uint32
+	Name    string
+	// Up applies the migration.
+	Up func(tx *gorm.DB) error
+	// Down reverts the migration.
+	Down func(tx *gorm.DB) error
+}
+
+// SchemaMigration records a Migration which has been applied to the database.
+type SchemaMigration struct {
+	Version   uint32    `gorm:"primarykey;autoIncrement:false"`
+	Name      string    `gorm:"size:64;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// migrations are the migrations known to this version of pub, in version order.
+var migrations = []Migration{{
+	Version: 1,
+	Name:    "initial schema",
+	Up:      v1Up,
+	Down:    v1Down,
+}, {
+	Version: 2,
+	Name:    "registrations and invites",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.Instance{}, &models.Account{}, &models.Invite{}); err != nil {
+			return err
+		}
+		return mergeLegacyRoles(tx)
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropTable(&models.Invite{}); err != nil {
+			return err
+		}
+		for _, col := range []string{"Pending", "Reason", "InviteID"} {
+			if err := m.DropColumn(&models.Account{}, col); err != nil {
+				return err
+			}
+		}
+		return m.DropColumn(&models.Instance{}, "RegistrationsMode")
+	},
+}, {
+	Version: 3,
+	Name:    "moderation",
+	Up: func(tx *gorm.DB) error {
+		// this migration also added actors.suspended_at and silenced_at,
+		// which migration 10 replaced with actor_moderations.
+		return tx.AutoMigrate(&models.AdminAction{}, &models.Report{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&models.AdminAction{}, &models.Report{})
+	},
+}, {
+	Version: 4,
+	Name:    "domain policy",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&models.Instance{}, &models.DomainBlock{}, &models.DomainAllow{})
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropTable(&models.DomainAllow{}); err != nil {
+			return err
+		}
+		for _, col := range []string{"Severity", "RejectMedia", "PublicComment", "PrivateComment"} {
+			if err := m.DropColumn(&models.DomainBlock{}, col); err != nil {
+				return err
+			}
+		}
+		return m.DropColumn(&models.Instance{}, "AllowlistMode")
+	},
+}, {
+	Version: 5,
+	Name:    "report forwarding",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&models.Report{}, &models.ReportRequest{})
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropTable(&models.ReportRequest{}); err != nil {
+			return err
+		}
+		return m.DropColumn(&models.Report{}, "URI")
+	},
+}, {
+	Version: 6,
+	Name:    "status search",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.StatusTerm{}); err != nil {
+			return err
+		}
+		return models.IndexStatuses(tx)
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&models.StatusTerm{})
+	},
+}, {
+	Version: 7,
+	Name:    "followed and featured tags",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&models.FollowedTag{}, &models.FeaturedTag{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropTable(&models.FollowedTag{}, &models.FeaturedTag{})
+	},
+}, {
+	Version: 8,
+	Name:    "trends",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&models.Instance{}, &models.Trend{}, &models.TrendReview{})
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropTable(&models.TrendReview{}, &models.Trend{}); err != nil {
+			return err
+		}
+		return m.DropColumn(&models.Instance{}, "TrendsRequireApproval")
+	},
+}, {
+	Version: 9,
+	Name:    "drop old reaction request index",
+	Up: func(tx *gorm.DB) error {
+		// databases created before the index was renamed, on MySQL, still
+		// have the old index as well as the new one.
+		m := tx.Migrator()
+		if !m.HasIndex(&models.ReactionRequest{}, "idx_actor_id_target_id") {
+			return nil
+		}
+		return m.DropIndex(&models.ReactionRequest{}, "idx_actor_id_target_id")
+	},
+	Down: func(tx *gorm.DB) error {
+		// the old index duplicated the new one, there is nothing to restore.
+		return nil
+	},
+}, {
+	Version: 10,
+	Name:    "moderation per instance",
+	Up: func(tx *gorm.DB) error {
+		if err := tx.AutoMigrate(&models.ActorModeration{}); err != nil {
+			return err
+		}
+		m := tx.Migrator()
+		if !m.HasColumn(&models.Actor{}, "suspended_at") {
+			return nil
+		}
+		// suspensions and silences applied to every instance, keep them.
+		if err := tx.Exec("INSERT INTO actor_moderations (instance_id, actor_id, suspended_at, silenced_at) " +
+			"SELECT instances.id, actors.id, actors.suspended_at, actors.silenced_at FROM instances, actors " +
+			"WHERE actors.suspended_at IS NOT NULL OR actors.silenced_at IS NOT NULL").Error; err != nil {
+			return err
+		}
+		if tx.Dialector.Name() == "sqlite" {
+			// SQLite drops a column by recreating the table, which, with
+			// foreign keys enforced, cascades to everything which refers
+			// to the actors. The unused columns are left in place.
+			return nil
+		}
+		for _, col := range []string{"suspended_at", "silenced_at"} {
+			if err := m.DropColumn(&models.Actor{}, col); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	Down: func(tx *gorm.DB) error {
+		// the suspensions and silences of each instance cannot be merged
+		// back into the actor, they are dropped.
+		return tx.Migrator().DropTable(&models.ActorModeration{})
+	},
+}, {
+	Version: 11,
+	Name:    "actor request delivery",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&models.ActorRequest{})
+	},
+	Down: func(tx *gorm.DB) error {
+		return tx.Migrator().DropColumn(&models.ActorRequest{}, "Delivered")
+	},
+}, {
+	Version: 12,
+	Name:    "actor refresh instance",
+	Up: func(tx *gorm.DB) error {
+		return tx.AutoMigrate(&models.ActorRequest{})
+	},
+	Down: func(tx *gorm.DB) error {
+		m := tx.Migrator()
+		if err := m.DropConstraint(&models.ActorRequest{}, "Instance"); err != nil {
+			return err
+		}
+		return m.DropColumn(&models.ActorRequest{}, "InstanceID")
+	},
+}}
+
+// A Status is a migration and the time it was applied, if it has been.
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Statuses returns the status of each migration, in version order.
+func Statuses(db *gorm.DB) ([]Status, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var statuses []Status
+	for _, m := range migrations {
+		s := Status{Migration: m}
+		if sm, ok := applied[m.Version]; ok {
+			s.AppliedAt = &sm.AppliedAt
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations which have not been applied to the database.
+func Pending(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations, in version order, and returns the
+// migrations applied.
+func Up(db *gorm.DB) ([]Migration, error) {
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range pending {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		}); err != nil {
+			return pending[:i], fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// Down reverts the last steps applied migrations, in reverse version order,
+// and returns the migrations reverted.
+func Down(db *gorm.DB, steps int) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var reverted []Migration
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{Version: m.Version}).Error
+		}); err != nil {
+			return reverted, fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+		reverted = append(reverted, m)
+	}
+	return reverted, nil
+}
+
+// appliedMigrations returns the migrations recorded in the schema_migrations
+// table, creating the table if it does not exist.
+func appliedMigrations(db *gorm.DB) (map[uint32]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	var sms []SchemaMigration
+	if err := db.Find(&sms).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[uint32]SchemaMigration)
+	for _, sm := range sms {
+		applied[sm.Version] = sm
+	}
+	return applied, nil
+}
+
+// mergeLegacyRoles merges the duplicate roles created by earlier versions of
+// create-account into the first role of each name, and removes the
+// permissions, including administrator, they gave the user role.
+func mergeLegacyRoles(tx *gorm.DB) error {
+	var roles []models.AccountRole
+	if err := tx.Order("id").Find(&roles).Error; err != nil {
+		return err
+	}
+	first := make(map[string]uint32)
+	for _, role := range roles {
+		id, ok := first[role.Name]
+		if !ok {
+			first[role.Name] = role.ID
+			continue
+		}
+		if err := tx.Model(&models.Account{}).Where("role_id = ?", role.ID).Update("role_id", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.AccountRole{}, role.ID).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Model(&models.AccountRole{}).Where("name = ?", "user").Update("permissions", 0).Error
+}
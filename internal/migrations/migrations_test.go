@@ -0,0 +1,212 @@
+package migrations
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	return db
+}
+
+func TestUpDown(t *testing.T) {
+	db := setupTestDB(t)
+
+	pending, err := Pending(db)
+	require.NoError(t, err)
+	require.Len(t, pending, len(migrations))
+
+	applied, err := Up(db)
+	require.NoError(t, err)
+	require.Equal(t, len(migrations), len(applied))
+	require.True(t, db.Migrator().HasTable(&models.Actor{}))
+
+	pending, err = Pending(db)
+	require.NoError(t, err)
+	require.Empty(t, pending)
+
+	statuses, err := Statuses(db)
+	require.NoError(t, err)
+	for _, s := range statuses {
+		require.NotNil(t, s.AppliedAt, "migration %d", s.Version)
+	}
+
+	// applying the migrations again is a no-op.
+	applied, err = Up(db)
+	require.NoError(t, err)
+	require.Empty(t, applied)
+
+	reverted, err := Down(db, len(migrations))
+	require.NoError(t, err)
+	require.Equal(t, len(migrations), len(reverted))
+	require.Equal(t, migrations[0].Version, reverted[len(reverted)-1].Version)
+	require.False(t, db.Migrator().HasTable(&models.Actor{}))
+	// reverting every migration leaves only the schema_migrations table.
+	require.Equal(t, map[string][]string{"indexes": nil}, sqliteSchema(t, db))
+
+	pending, err = Pending(db)
+	require.NoError(t, err)
+	require.Len(t, pending, len(migrations))
+}
+
+func TestMigrationsOrdered(t *testing.T) {
+	for i := 1; i < len(migrations); i++ {
+		require.Greater(t, migrations[i].Version, migrations[i-1].Version)
+	}
+}
+
+func TestUpAdoptsAutoMigratedDatabase(t *testing.T) {
+	db := setupTestDB(t)
+	// databases created by auto-migrate have the tables, but no schema_migrations.
+	require.NoError(t, models.AutoMigrate(db))
+	_, err := Up(db)
+	require.NoError(t, err)
+}
+
+func TestUpDropsOldReactionRequestIndex(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := Up(db)
+	require.NoError(t, err)
+	// revert to before migration 9.
+	_, err = Down(db, len(migrations)-8)
+	require.NoError(t, err)
+
+	// recreate the index as it was before it was renamed. SQLite index names
+	// are global, so the relationship request index must make way for it.
+	m := db.Migrator()
+	require.NoError(t, m.DropIndex(&models.RelationshipRequest{}, "idx_actor_id_target_id"))
+	require.NoError(t, db.Exec("CREATE UNIQUE INDEX idx_actor_id_target_id ON reaction_requests (actor_id, target_id)").Error)
+	require.True(t, m.HasIndex(&models.ReactionRequest{}, "idx_actor_id_target_id"))
+
+	_, err = Up(db)
+	require.NoError(t, err)
+	require.False(t, m.HasIndex(&models.ReactionRequest{}, "idx_actor_id_target_id"))
+	require.True(t, m.HasIndex(&models.ReactionRequest{}, "idx_reaction_requests_actor_id_target_id"))
+}
+
+func TestUpMatchesModels(t *testing.T) {
+	// the migrations must create the same tables, columns, and indexes as
+	// auto-migrating the current models.
+	want := setupTestDB(t)
+	require.NoError(t, models.AutoMigrate(want))
+	got := setupTestDB(t)
+	_, err := Up(got)
+	require.NoError(t, err)
+
+	require.Equal(t, sqliteSchema(t, want), sqliteSchema(t, got))
+}
+
+// sqliteSchema returns the columns of each table, and the indexes, of db,
+// excluding the schema_migrations table.
+func sqliteSchema(t *testing.T, db *gorm.DB) map[string][]string {
+	t.Helper()
+	var tables []string
+	require.NoError(t, db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name != 'schema_migrations' ORDER BY name").Scan(&tables).Error)
+	schema := make(map[string][]string)
+	for _, table := range tables {
+		var columns []string
+		require.NoError(t, db.Raw("SELECT name FROM pragma_table_info(?) ORDER BY name", table).Scan(&columns).Error)
+		schema[table] = columns
+	}
+	var indexes []string
+	require.NoError(t, db.Raw("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name != 'schema_migrations' ORDER BY name").Scan(&indexes).Error)
+	schema["indexes"] = indexes
+	return schema
+}
+
+func TestUpMergesLegacyRoles(t *testing.T) {
+	db := setupTestDB(t)
+	// apply only the first migration.
+	_, err := appliedMigrations(db)
+	require.NoError(t, err)
+	require.NoError(t, migrations[0].Up(db))
+	require.NoError(t, db.Create(&SchemaMigration{Version: migrations[0].Version, Name: migrations[0].Name, AppliedAt: time.Now()}).Error)
+
+	// earlier versions of create-account created a user role, with every
+	// permission, for each account.
+	admin := v1AccountRole{Name: "admin", Position: 1, Permissions: 0xFFFFFFFF}
+	require.NoError(t, db.Create(&admin).Error)
+	var users []v1AccountRole
+	for i := 0; i < 2; i++ {
+		user := v1AccountRole{Name: "user", Position: 10, Permissions: 65535}
+		require.NoError(t, db.Create(&user).Error)
+		users = append(users, user)
+	}
+	instance := v1Instance{ID: 1, Domain: "example.com"}
+	require.NoError(t, db.Create(&instance).Error)
+	var accounts []v1Account
+	for i, role := range []v1AccountRole{admin, users[0], users[1]} {
+		actor := v1Actor{ID: snowflake.ID(i + 1), URI: fmt.Sprintf("https://example.com/u/%d", i), Name: fmt.Sprint(i), Domain: "example.com", PublicKey: []byte("key")}
+		require.NoError(t, db.Create(&actor).Error)
+		account := v1Account{ID: snowflake.ID(i + 1), InstanceID: instance.ID, ActorID: actor.ID, EncryptedPassword: []byte("password"), PrivateKey: []byte("key"), RoleID: &role.ID}
+		require.NoError(t, db.Create(&account).Error)
+		accounts = append(accounts, account)
+	}
+
+	_, err = Up(db)
+	require.NoError(t, err)
+
+	var roles []models.AccountRole
+	require.NoError(t, db.Order("id").Find(&roles).Error)
+	require.Len(t, roles, 2)
+	require.Equal(t, "admin", roles[0].Name)
+	require.True(t, roles[0].Can(models.PermissionAdministrator))
+	require.Equal(t, "user", roles[1].Name)
+	require.Equal(t, uint32(0), roles[1].Permissions)
+	require.False(t, roles[1].Can(models.PermissionManageUsers))
+
+	for i, want := range []uint32{roles[0].ID, roles[1].ID, roles[1].ID} {
+		var account models.Account
+		require.NoError(t, db.Take(&account, accounts[i].ID).Error)
+		require.Equal(t, want, *account.RoleID)
+	}
+}
+
+func TestUpMovesActorModeration(t *testing.T) {
+	db := setupTestDB(t)
+	_, err := Up(db)
+	require.NoError(t, err)
+	// revert to before migration 10.
+	_, err = Down(db, len(migrations)-9)
+	require.NoError(t, err)
+
+	// before migration 10, suspensions and silences were columns of actors.
+	require.NoError(t, db.Exec("ALTER TABLE `actors` ADD `suspended_at` datetime").Error)
+	require.NoError(t, db.Exec("ALTER TABLE `actors` ADD `silenced_at` datetime").Error)
+	var instances []*models.Instance
+	for _, domain := range []string{"a.example", "b.example"} {
+		instance := &models.Instance{ID: snowflake.Now(), Domain: domain}
+		require.NoError(t, db.Create(instance).Error)
+		instances = append(instances, instance)
+	}
+	actor := &models.Actor{ID: snowflake.Now(), URI: "https://remote.example/u/bob", Name: "bob", Domain: "remote.example", PublicKey: []byte("key")}
+	require.NoError(t, db.Create(actor).Error)
+	require.NoError(t, db.Exec("UPDATE actors SET suspended_at = ? WHERE id = ?", time.Now(), actor.ID).Error)
+
+	_, err = Up(db)
+	require.NoError(t, err)
+	require.NoError(t, db.Take(&models.Actor{}, actor.ID).Error)
+	for _, instance := range instances {
+		moderation, err := models.NewActorModerations(db).Find(instance.ID, actor)
+		require.NoError(t, err)
+		require.True(t, moderation.IsSuspended(), instance.Domain)
+		require.False(t, moderation.IsSilenced(), instance.Domain)
+	}
+}
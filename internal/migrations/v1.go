@@ -0,0 +1,389 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+)
+
+// The types in this file are a snapshot of the models when versioned
+// migrations were introduced, and define the schema created by the first
+// migration. They must not change; later changes to the models are made by
+// later migrations.
+
+type v1Account struct {
+	snowflake.ID      `gorm:"primarykey;autoIncrement:false"`
+	UpdatedAt         time.Time
+	InstanceID        snowflake.ID
+	Instance          *v1Instance `gorm:"<-:false;"`
+	ActorID           snowflake.ID
+	Actor             *v1Actor          `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Lists             []v1AccountList   `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE;"`
+	Markers           []v1AccountMarker `gorm:"foreignKey:AccountID;constraint:OnDelete:CASCADE;"`
+	Email             string            `gorm:"size:64;not null"`
+	EncryptedPassword []byte            `gorm:"size:60;not null"`
+	PrivateKey        []byte            `gorm:"not null"`
+	RoleID            *uint32
+	Role              *v1AccountRole
+	DefaultPrivacy    string `gorm:"size:16;default:'public';not null"`
+	DefaultSensitive  bool   `gorm:"default:false;not null"`
+	DefaultLanguage   string `gorm:"size:8;default:'en';not null"`
+}
+
+func (v1Account) TableName() string { return "accounts" }
+
+type v1AccountRole struct {
+	ID          uint32 `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Name        string `gorm:"size:16;not null"`
+	Color       string `gorm:"size:8;not null,default:''"`
+	Position    int32
+	Permissions uint32
+	Highlighted bool
+}
+
+func (v1AccountRole) TableName() string { return "account_roles" }
+
+type v1AccountList struct {
+	snowflake.ID  `gorm:"primarykey;autoIncrement:false"`
+	AccountID     snowflake.ID          `gorm:"not null;"`
+	Title         string                `gorm:"size:64"`
+	RepliesPolicy string                `gorm:"size:16;not null;default:'public'"`
+	Members       []v1AccountListMember `gorm:"foreignKey:AccountListID;constraint:OnDelete:CASCADE;"`
+}
+
+func (v1AccountList) TableName() string { return "account_lists" }
+
+type v1AccountListMember struct {
+	AccountListID snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	MemberID      snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Member        *v1Actor     `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+func (v1AccountListMember) TableName() string { return "account_list_members" }
+
+type v1AccountMarker struct {
+	ID         uint32 `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	AccountID  snowflake.ID `gorm:"not null;"`
+	Name       string       `gorm:"size:16;not null;"`
+	Version    int32        `gorm:"not null;"`
+	LastReadID snowflake.ID `gorm:"not null;"`
+}
+
+func (v1AccountMarker) TableName() string { return "account_markers" }
+
+type v1Actor struct {
+	snowflake.ID               `gorm:"primarykey;autoIncrement:false"`
+	UpdatedAt                  time.Time
+	Type                       string `gorm:"size:16;default:'Person';not null"`
+	URI                        string `gorm:"uniqueIndex;size:128;not null"`
+	Name                       string `gorm:"size:64;uniqueIndex:idx_actor_name_domain;not null"`
+	Domain                     string `gorm:"size:64;uniqueIndex:idx_actor_name_domain;not null"`
+	DisplayName                string `gorm:"size:128;not null"`
+	Locked                     bool   `gorm:"default:false;not null"`
+	Bot                        bool   `gorm:"default:false;not null"`
+	Discoverable               bool   `gorm:"default:false;not null"`
+	Note                       string `gorm:"type:text"`
+	FollowersCount             int32  `gorm:"default:0;not null"`
+	FollowingCount             int32  `gorm:"default:0;not null"`
+	StatusesCount              int32  `gorm:"default:0;not null"`
+	LastStatusAt               time.Time
+	Avatar                     string              `gorm:"size:255"`
+	Header                     string              `gorm:"size:255"`
+	PublicKey                  []byte              `gorm:"not null"`
+	Attributes                 []*v1ActorAttribute `gorm:"foreignKey:ActorID;constraint:OnDelete:CASCADE;"`
+	PreviousPublicKey          []byte
+	PreviousPublicKeyExpiresAt *time.Time
+	MovedToID                  *snowflake.ID
+	MovedTo                    *v1Actor `gorm:"constraint:OnDelete:SET NULL;<-:false;"`
+	AlsoKnownAs                []string `gorm:"serializer:json"`
+}
+
+func (v1Actor) TableName() string { return "actors" }
+
+type v1ActorAttribute struct {
+	ID         uint32 `gorm:"primarykey"`
+	ActorID    snowflake.ID
+	Name       string `gorm:"size:255;not null"`
+	Value      string `gorm:"type:text;not null"`
+	VerifiedAt *time.Time
+	CheckedAt  *time.Time
+}
+
+func (v1ActorAttribute) TableName() string { return "actor_attributes" }
+
+type v1ActorRequest struct {
+	ID          uint32 `gorm:"primarykey;"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ActorID     snowflake.ID `gorm:"uniqueIndex:idx_actor_id_action;not null;"`
+	Actor       *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Action      string       `gorm:"size:16;uniqueIndex:idx_actor_id_action;not null"`
+	Attempts    uint32       `gorm:"not null;default:0"`
+	LastAttempt time.Time
+	LastResult  string `gorm:"size:255;not null;default:''"`
+}
+
+func (v1ActorRequest) TableName() string { return "actor_requests" }
+
+type v1Application struct {
+	snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	InstanceID   snowflake.ID
+	Instance     *v1Instance `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Name         string      `gorm:"size:64;not null"`
+	Website      *string     `gorm:"size:64"`
+	RedirectURI  string      `gorm:"size:128;not null"`
+	ClientID     string      `gorm:"size:64;not null"`
+	ClientSecret string      `gorm:"size:64;not null"`
+	VapidKey     string      `gorm:"size:128;not null"`
+}
+
+func (v1Application) TableName() string { return "applications" }
+
+type v1BackfillRequest struct {
+	ID          uint32 `gorm:"primarykey;"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	InstanceID  snowflake.ID `gorm:"not null;"`
+	Instance    *v1Instance  `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Kind        string       `gorm:"size:16;uniqueIndex:idx_kind_uri;not null"`
+	URI         string       `gorm:"size:255;uniqueIndex:idx_kind_uri;not null"`
+	CompletedAt *time.Time
+	Attempts    uint32 `gorm:"not null;default:0"`
+	LastAttempt time.Time
+	LastResult  string `gorm:"size:255;not null;default:''"`
+}
+
+func (v1BackfillRequest) TableName() string { return "backfill_requests" }
+
+type v1Conversation struct {
+	ID         uint32 `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Visibility string `gorm:"size:16;not null"`
+}
+
+func (v1Conversation) TableName() string { return "conversations" }
+
+type v1DomainBlock struct {
+	ID         uint32 `gorm:"primarykey"`
+	CreatedAt  time.Time
+	InstanceID snowflake.ID `gorm:"uniqueIndex:idx_instance_id_domain;not null"`
+	Domain     string       `gorm:"size:64;uniqueIndex:idx_instance_id_domain;not null"`
+}
+
+func (v1DomainBlock) TableName() string { return "domain_blocks" }
+
+type v1Instance struct {
+	snowflake.ID     `gorm:"primarykey;autoIncrement:false"`
+	UpdatedAt        time.Time
+	Domain           string `gorm:"size:64;uniqueIndex"`
+	AdminID          *snowflake.ID
+	Admin            *v1Account `gorm:"<-:false;"`
+	ServiceAccountID *snowflake.ID
+	ServiceAccount   *v1Account `gorm:"<-:false;"`
+	SourceURL        string
+	Title            string `gorm:"size:64"`
+	ShortDescription string
+	Description      string
+	Thumbnail        string           `gorm:"size:64"`
+	AccountsCount    int              `gorm:"default:0;not null"`
+	StatusesCount    int              `gorm:"default:0;not null"`
+	SecureMode       bool             `gorm:"default:false;not null"`
+	Rules            []v1InstanceRule `gorm:"foreignKey:InstanceID"`
+}
+
+func (v1Instance) TableName() string { return "instances" }
+
+type v1InstanceRule struct {
+	ID         uint32 `gorm:"primarykey"`
+	InstanceID uint64
+	Text       string
+}
+
+func (v1InstanceRule) TableName() string { return "instance_rules" }
+
+type v1Reaction struct {
+	StatusID   snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Status     *v1Status    `gorm:"constraint:OnDelete:CASCADE;<-:false"`
+	ActorID    snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Actor      *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false"`
+	Favourited bool         `gorm:"not null;default:false"`
+	Reblogged  bool         `gorm:"not null;default:false"`
+	Muted      bool         `gorm:"not null;default:false"`
+	Bookmarked bool         `gorm:"not null;default:false"`
+	Pinned     bool         `gorm:"not null;default:false"`
+}
+
+func (v1Reaction) TableName() string { return "reactions" }
+
+type v1ReactionRequest struct {
+	ID          uint32 `gorm:"primarykey;"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ActorID     snowflake.ID `gorm:"uniqueIndex:idx_reaction_requests_actor_id_target_id;not null;"`
+	Actor       *v1Actor     `gorm:"constraint:OnDelete:CASCADE;"`
+	TargetID    snowflake.ID `gorm:"uniqueIndex:idx_reaction_requests_actor_id_target_id;not null;"`
+	Target      *v1Status    `gorm:"constraint:OnDelete:CASCADE;"`
+	Action      string       `gorm:"size:16;not null"`
+	Attempts    uint32       `gorm:"not null;default:0"`
+	LastAttempt time.Time
+	LastResult  string `gorm:"size:255;not null;default:''"`
+}
+
+func (v1ReactionRequest) TableName() string { return "reaction_requests" }
+
+type v1Relationship struct {
+	ActorID    snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Actor      *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TargetID   snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Target     *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Muting     bool         `gorm:"not null;default:false"`
+	Blocking   bool         `gorm:"not null;default:false"`
+	BlockedBy  bool         `gorm:"not null;default:false"`
+	Following  bool         `gorm:"not null;default:false"`
+	FollowedBy bool         `gorm:"not null;default:false"`
+}
+
+func (v1Relationship) TableName() string { return "relationships" }
+
+type v1RelationshipRequest struct {
+	ID          uint32 `gorm:"primarykey;"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ActorID     snowflake.ID `gorm:"uniqueIndex:idx_actor_id_target_id;not null;"`
+	Actor       *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TargetID    snowflake.ID `gorm:"uniqueIndex:idx_actor_id_target_id;not null;"`
+	Target      *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Action      string       `gorm:"size:16;not null"`
+	Attempts    uint32       `gorm:"not null;default:0"`
+	LastAttempt time.Time
+	LastResult  string `gorm:"size:255;not null;default:''"`
+}
+
+func (v1RelationshipRequest) TableName() string { return "relationship_requests" }
+
+type v1Status struct {
+	snowflake.ID     `gorm:"primarykey;autoIncrement:false"`
+	UpdatedAt        time.Time
+	ActorID          snowflake.ID
+	Actor            *v1Actor `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	ConversationID   uint32
+	Conversation     *v1Conversation `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	InReplyToID      *snowflake.ID
+	InReplyToActorID *snowflake.ID
+	Sensitive        bool
+	SpoilerText      string `gorm:"size:128"`
+	Visibility       string `gorm:"size:16"`
+	Language         string `gorm:"size:2"`
+	Note             string
+	URI              string `gorm:"uniqueIndex;size:128"`
+	RepliesCount     int    `gorm:"not null;default:0"`
+	ReblogsCount     int    `gorm:"not null;default:0"`
+	FavouritesCount  int    `gorm:"not null;default:0"`
+	ReblogID         *snowflake.ID
+	Reblog           *v1Status            `gorm:"<-:false;"`
+	Reaction         *v1Reaction          `gorm:"foreignKey:StatusID;<-:false;"`
+	Attachments      []v1StatusAttachment `gorm:"foreignKey:StatusID;constraint:OnDelete:CASCADE;"`
+	Mentions         []v1StatusMention    `gorm:"foreignKey:StatusID;constraint:OnDelete:CASCADE;"`
+	Tags             []v1StatusTag        `gorm:"foreignKey:StatusID;constraint:OnDelete:CASCADE;"`
+}
+
+func (v1Status) TableName() string { return "statuses" }
+
+type v1StatusPoll struct {
+	ID         uint64 `gorm:"primarykey"`
+	ExpiresAt  time.Time
+	Multiple   bool
+	VotesCount int                  `gorm:"not null;default:0"`
+	Options    []v1StatusPollOption `gorm:"serializer:json"`
+}
+
+type v1StatusPollOption struct {
+	Title string `json:"title"`
+	Count int    `json:"count"`
+}
+
+func (v1StatusPoll) TableName() string { return "status_polls" }
+
+type v1StatusAttachment struct {
+	snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	MediaType    string       `gorm:"size:64;not null"`
+	URL          string       `gorm:"size:255;not null"`
+	Name         string       `gorm:"not null"`
+	Blurhash     string       `gorm:"size:36;not null"`
+	Width        int          `gorm:"not null"`
+	Height       int          `gorm:"not null"`
+	StatusID     snowflake.ID `gorm:"not null"`
+}
+
+func (v1StatusAttachment) TableName() string { return "status_attachments" }
+
+type v1StatusMention struct {
+	StatusID snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	ActorID  snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Actor    *v1Actor     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+}
+
+func (v1StatusMention) TableName() string { return "status_mentions" }
+
+type v1StatusTag struct {
+	StatusID snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	TagID    uint32       `gorm:"primarykey;autoIncrement:false"`
+	Tag      *v1Tag
+}
+
+func (v1StatusTag) TableName() string { return "status_tags" }
+
+type v1Tag struct {
+	ID   uint32 `gorm:"primaryKey"`
+	Name string `gorm:"size:64;uniqueIndex"`
+}
+
+func (v1Tag) TableName() string { return "tags" }
+
+type v1Token struct {
+	AccessToken       string `gorm:"size:64;primaryKey;autoIncrement:false"`
+	CreatedAt         time.Time
+	AccountID         snowflake.ID
+	Account           *v1Account `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	ApplicationID     snowflake.ID
+	Application       *v1Application `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TokenType         string         `gorm:"size:16;not null"`
+	Scope             string         `gorm:"size:64;not null"`
+	AuthorizationCode string         `gorm:"size:64;not null"`
+}
+
+func (v1Token) TableName() string { return "tokens" }
+
+// v1Models returns a value of each model in the first migration.
+func v1Models() []any {
+	return []any{
+		&v1Actor{}, &v1ActorAttribute{}, &v1ActorRequest{},
+		&v1Account{}, &v1AccountList{}, &v1AccountListMember{}, &v1AccountRole{}, &v1AccountMarker{},
+		&v1Application{},
+		&v1BackfillRequest{},
+		&v1Conversation{},
+		&v1DomainBlock{},
+		&v1Instance{}, &v1InstanceRule{},
+		&v1Reaction{}, &v1ReactionRequest{},
+		&v1Relationship{}, &v1RelationshipRequest{},
+		&v1Status{}, &v1StatusPoll{}, &v1StatusAttachment{}, &v1StatusMention{}, &v1StatusTag{},
+		&v1Tag{},
+		&v1Token{},
+	}
+}
+
+// v1Up creates the tables of the first migration.
+func v1Up(tx *gorm.DB) error {
+	return tx.AutoMigrate(v1Models()...)
+}
+
+// v1Down drops the tables of the first migration.
+func v1Down(tx *gorm.DB) error {
+	return tx.Migrator().DropTable(v1Models()...)
+}
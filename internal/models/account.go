@@ -1,9 +1,13 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/davecheney/pub/internal/keypair"
 	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -22,8 +26,19 @@ type Account struct {
 	Email             string          `gorm:"size:64;not null"`
 	EncryptedPassword []byte          `gorm:"size:60;not null"`
 	PrivateKey        []byte          `gorm:"not null"`
-	RoleID            uint32
+	RoleID            *uint32
 	Role              *AccountRole
+	// DefaultPrivacy, DefaultSensitive, and DefaultLanguage are the defaults for new statuses.
+	DefaultPrivacy   string `gorm:"size:16;default:'public';not null"`
+	DefaultSensitive bool   `gorm:"default:false;not null"`
+	DefaultLanguage  string `gorm:"size:8;default:'en';not null"`
+	// Pending is true if the account signed up and is waiting to be
+	// approved by an admin. Pending accounts cannot log in.
+	Pending bool `gorm:"default:false;not null"`
+	// Reason is the reason given when signing up.
+	Reason string `gorm:"size:255;not null;default:''"`
+	// InviteID is the invite used to sign up, if any.
+	InviteID *uint32
 }
 
 func (a *Account) Name() string {
@@ -45,11 +60,35 @@ type AccountRole struct {
 	Highlighted bool
 }
 
+// Permissions of an AccountRole, the values match Mastodon's.
+const (
+	PermissionAdministrator    uint32 = 1 << 0
+	PermissionViewAuditLog     uint32 = 1 << 2
+	PermissionManageReports    uint32 = 1 << 4
+	PermissionManageFederation uint32 = 1 << 5
+	PermissionManageTaxonomies uint32 = 1 << 8
+	PermissionManageUsers      uint32 = 1 << 10
+	PermissionManageInvites    uint32 = 1 << 11
+)
+
+// defaultRoles are the roles created by Accounts.Create, by name, if they
+// do not exist.
+var defaultRoles = map[string]AccountRole{
+	"admin": {Name: "admin", Position: 1, Permissions: 0xFFFFFFFF, Highlighted: true},
+	"user":  {Name: "user", Position: 10},
+}
+
+// Can returns true if the role has permission p. Administrators have every
+// permission.
+func (r *AccountRole) Can(p uint32) bool {
+	return r.Permissions&PermissionAdministrator != 0 || r.Permissions&p == p
+}
+
 type AccountList struct {
 	snowflake.ID  `gorm:"primarykey;autoIncrement:false"`
 	AccountID     snowflake.ID        `gorm:"not null;"`
 	Title         string              `gorm:"size:64"`
-	RepliesPolicy string              `gorm:"enum('public','followers','none');not null;default:'public'"`
+	RepliesPolicy string              `gorm:"size:16;not null;default:'public'"`
 	Members       []AccountListMember `gorm:"constraint:OnDelete:CASCADE;"`
 }
 
@@ -64,7 +103,7 @@ type AccountMarker struct {
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
 	AccountID  snowflake.ID `gorm:"not null;"`
-	Name       string       `gorm:"enum('home','notifications');not null;"`
+	Name       string       `gorm:"size:16;not null;"`
 	Version    int32        `gorm:"not null;"`
 	LastReadID snowflake.ID `gorm:"not null;"`
 }
@@ -84,3 +123,132 @@ func (a *Accounts) AccountForActor(actor *Actor) (*Account, error) {
 	}
 	return &account, nil
 }
+
+// NewAccount holds the details of an account to create.
+type NewAccount struct {
+	Name     string
+	Email    string
+	Password string
+	// Avatar and Header are the URLs of the actor's avatar and header.
+	Avatar string
+	Header string
+	// Role is the name of the account's role, admin or user. If empty, the
+	// account has the user role.
+	Role string
+	// Pending, Reason, and InviteID are set for accounts which signed up.
+	Pending  bool
+	Reason   string
+	InviteID *uint32
+	// URLs builds the URLs of the instance, and so the URI of the actor.
+	URLs *urls.Builder
+}
+
+// Create creates a local actor, and its account, on instance.
+func (a *Accounts) Create(instance *Instance, params NewAccount) (*Account, error) {
+	name := params.Role
+	if name == "" {
+		name = "user"
+	}
+	role, ok := defaultRoles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown role %q", params.Role)
+	}
+	kp, err := keypair.Generate()
+	if err != nil {
+		return nil, err
+	}
+	passwd, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	actor := &Actor{
+		ID:          snowflake.Now(),
+		Name:        params.Name,
+		Domain:      instance.Domain,
+		URI:         params.URLs.Actor(params.Name),
+		Type:        "LocalPerson",
+		DisplayName: params.Name,
+		Avatar:      params.Avatar,
+		Header:      params.Header,
+		PublicKey:   kp.PublicKey,
+	}
+	account := &Account{
+		ID:                snowflake.Now(),
+		InstanceID:        instance.ID,
+		ActorID:           actor.ID,
+		Actor:             actor,
+		Email:             params.Email,
+		EncryptedPassword: passwd,
+		PrivateKey:        kp.PrivateKey,
+		Pending:           params.Pending,
+		Reason:            params.Reason,
+		InviteID:          params.InviteID,
+	}
+	err = a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(actor).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("name = ?", role.Name).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
+		account.RoleID = &role.ID
+		return tx.Create(account).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// NotPending is a scope which excludes the actors of accounts which are
+// pending approval.
+func NotPending(db *gorm.DB) *gorm.DB {
+	pending := db.Session(&gorm.Session{NewDB: true}).Model(&Account{}).Select("actor_id").Where("pending = ?", true)
+	return db.Where("actors.id NOT IN (?)", pending)
+}
+
+// Approve approves the pending account.
+func (a *Accounts) Approve(account *Account) error {
+	if !account.Pending {
+		return fmt.Errorf("account %s is not pending approval", account.Actor.Acct())
+	}
+	return a.db.Model(account).Update("pending", false).Error
+}
+
+// Reject deletes the pending account, and its actor.
+func (a *Accounts) Reject(account *Account) error {
+	if !account.Pending {
+		return fmt.Errorf("account %s is not pending approval", account.Actor.Acct())
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&Actor{ID: account.ActorID}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Account{ID: account.ID}).Error
+	})
+}
+
+// RotateKeys replaces the keypair of the account's actor. The previous public key
+// remains valid for verifying signatures for gracePeriod.
+func (a *Accounts) RotateKeys(account *Account, gracePeriod time.Duration) error {
+	kp, err := keypair.Generate()
+	if err != nil {
+		return err
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(account).Update("private_key", kp.PrivateKey).Error; err != nil {
+			return err
+		}
+		expiresAt := time.Now().Add(gracePeriod)
+		if err := tx.Model(account.Actor).Updates(map[string]any{
+			"public_key":                     kp.PublicKey,
+			"previous_public_key":            account.Actor.PublicKey,
+			"previous_public_key_expires_at": expiresAt,
+		}).Error; err != nil {
+			return err
+		}
+		// federate the new key to the actor's followers.
+		return NewActors(tx).QueueUpdate(account.Actor)
+	})
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountsCreate(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+
+	accounts := NewAccounts(db)
+	alice, err := accounts.Create(instance, NewAccount{Name: "alice", Email: "alice@example.com", Password: "sssh", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/u/alice", alice.Actor.URI)
+	require.False(t, alice.Pending)
+
+	var role AccountRole
+	require.NoError(t, db.Take(&role, alice.RoleID).Error)
+	require.Equal(t, "user", role.Name)
+	require.False(t, role.Can(PermissionManageUsers))
+
+	admin, err := accounts.Create(instance, NewAccount{Name: "admin", Email: "admin@example.com", Password: "sssh", Role: "admin", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	var adminRole AccountRole
+	require.NoError(t, db.Take(&adminRole, admin.RoleID).Error)
+	require.True(t, adminRole.Can(PermissionManageUsers))
+
+	bob, err := accounts.Create(instance, NewAccount{Name: "bob", Email: "bob@example.com", Password: "sssh", Pending: true, URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	require.Error(t, accounts.Approve(alice))
+	require.NoError(t, accounts.Approve(bob))
+	var approved Account
+	require.NoError(t, db.Take(&approved, bob.ID).Error)
+	require.False(t, approved.Pending)
+
+	carol, err := accounts.Create(instance, NewAccount{Name: "carol", Email: "carol@example.com", Password: "sssh", Pending: true, URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	require.NoError(t, accounts.Reject(carol))
+	require.Error(t, db.Take(&Account{}, carol.ID).Error)
+	require.Error(t, db.Take(&Actor{}, carol.ActorID).Error)
+}
+
+func TestAccountsRotateKeys(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+
+	accounts := NewAccounts(db)
+	alice, err := accounts.Create(instance, NewAccount{Name: "alice", Email: "alice@example.com", Password: "sssh", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	previous := alice.Actor.PublicKey
+
+	require.NoError(t, accounts.RotateKeys(alice, time.Hour))
+	var actor Actor
+	require.NoError(t, db.Take(&actor, alice.ActorID).Error)
+	require.NotEqual(t, previous, actor.PublicKey)
+	require.Equal(t, previous, actor.PreviousPublicKey)
+	require.Len(t, actor.PublicKeys(time.Now()), 2)
+
+	// the new key is federated to the actor's followers.
+	var request ActorRequest
+	require.NoError(t, db.Take(&request, "actor_id = ? and action = ?", alice.ActorID, "update").Error)
+}
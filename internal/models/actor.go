@@ -3,21 +3,26 @@ package models
 import (
 	"errors"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Actor struct {
 	snowflake.ID   `gorm:"primarykey;autoIncrement:false"`
 	UpdatedAt      time.Time
-	Type           string `gorm:"type:enum('Person', 'Application', 'Service', 'Group', 'Organization', 'LocalPerson');default:'Person';not null"`
+	Type           string `gorm:"size:16;default:'Person';not null"`
 	URI            string `gorm:"uniqueIndex;size:128;not null"`
 	Name           string `gorm:"size:64;uniqueIndex:idx_actor_name_domain;not null"`
 	Domain         string `gorm:"size:64;uniqueIndex:idx_actor_name_domain;not null"`
 	DisplayName    string `gorm:"size:128;not null"`
 	Locked         bool   `gorm:"default:false;not null"`
+	Bot            bool   `gorm:"default:false;not null"` // set by a local actor to identify as automated
+	Discoverable   bool   `gorm:"default:false;not null"`
 	Note           string `gorm:"type:text"` // max 2^16
 	FollowersCount int32  `gorm:"default:0;not null"`
 	FollowingCount int32  `gorm:"default:0;not null"`
@@ -25,8 +30,18 @@ type Actor struct {
 	LastStatusAt   time.Time
 	Avatar         string            `gorm:"size:255"`
 	Header         string            `gorm:"size:255"`
-	PublicKey      []byte            `gorm:"type:blob;not null"`
+	PublicKey      []byte            `gorm:"not null"`
 	Attributes     []*ActorAttribute `gorm:"constraint:OnDelete:CASCADE;"`
+	// PreviousPublicKey is the public key in use before the last key rotation.
+	// It remains valid for verification until PreviousPublicKeyExpiresAt.
+	PreviousPublicKey          []byte
+	PreviousPublicKeyExpiresAt *time.Time
+	// MovedToID is the actor this actor has moved to, if any.
+	MovedToID *snowflake.ID
+	MovedTo   *Actor `gorm:"constraint:OnDelete:SET NULL;<-:false;"`
+	// AlsoKnownAs are the URIs of other actors which the owner of this actor
+	// has declared to be the same person.
+	AlsoKnownAs []string `gorm:"serializer:json"`
 }
 
 func (a *Actor) Acct() string {
@@ -37,7 +52,7 @@ func (a *Actor) Acct() string {
 }
 
 func (a *Actor) IsBot() bool {
-	return !a.IsPerson()
+	return a.Bot || !a.IsPerson()
 }
 
 func (a *Actor) IsPerson() bool {
@@ -48,38 +63,127 @@ func (a *Actor) IsLocal() bool {
 	return a.Type == "LocalPerson"
 }
 
+// IsService returns true if the actor is the Application actor which signs
+// requests on behalf of an instance.
+func (a *Actor) IsService() bool {
+	return a.Type == "Application"
+}
+
 func (a *Actor) IsGroup() bool {
 	return a.Type == "Group"
 }
 
+// IsAlias reports whether the actor has declared that it is also known as uri.
+func (a *Actor) IsAlias(uri string) bool {
+	for _, aka := range a.AlsoKnownAs {
+		if aka == uri {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *Actor) PublicKeyID() string {
 	return fmt.Sprintf("%s#main-key", a.URI)
 }
 
+// PublicKeys returns the public keys which are valid for the actor at time t;
+// the current key, and the previous key if its grace period has not expired.
+func (a *Actor) PublicKeys(t time.Time) [][]byte {
+	keys := [][]byte{a.PublicKey}
+	if len(a.PreviousPublicKey) > 0 && a.PreviousPublicKeyExpiresAt != nil && t.Before(*a.PreviousPublicKeyExpiresAt) {
+		keys = append(keys, a.PreviousPublicKey)
+	}
+	return keys
+}
+
+// URL returns the URL of the actor's profile page.
 func (a *Actor) URL() string {
+	if a.IsLocal() {
+		return a.URLs().Profile(a.Name)
+	}
 	return fmt.Sprintf("https://%s/@%s", a.Domain, a.Name)
 }
 
+// URLs returns the Builder for the instance of the local actor a. The URI of
+// a local actor is on the base URL its instance was served from when the
+// actor was created.
+func (a *Actor) URLs() *urls.Builder {
+	u, err := url.Parse(a.URI)
+	if err != nil || u.Host == "" {
+		return urls.New("https://" + a.Domain)
+	}
+	return urls.New(u.Scheme + "://" + u.Host)
+}
+
 type ActorAttribute struct {
 	ID      uint32 `gorm:"primarykey"`
 	ActorID snowflake.ID
 	Name    string `gorm:"size:255;not null"`
 	Value   string `gorm:"type:text;not null"`
+	// VerifiedAt is the time the page linked by Value was last found to link
+	// back to the actor with rel="me", or nil if it has not been verified.
+	VerifiedAt *time.Time
+	// CheckedAt is the time the link was last checked.
+	CheckedAt *time.Time
+}
+
+// An ActorRequest records a request to notify the followers of a local actor
+// that the actor has changed, or to refresh the cached copy of a remote actor.
+// ActorRequests are created by Actors, and are processed by the ActorRequestProcessor in the background.
+type ActorRequest struct {
+	ID uint32 `gorm:"primarykey;"`
+	// CreatedAt is the time the request was created.
+	CreatedAt time.Time
+	// UpdatedAt is the time the request was last updated.
+	UpdatedAt time.Time
+	ActorID   snowflake.ID `gorm:"uniqueIndex:idx_actor_id_action;not null;"`
+	// Actor is the actor that has changed.
+	Actor *Actor `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// InstanceID is the instance whose service account signs, and whose
+	// domain policy applies to, the refresh of a remote actor.
+	InstanceID *snowflake.ID
+	Instance   *Instance `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// Action is the action to perform.
+	Action string `gorm:"size:16;uniqueIndex:idx_actor_id_action;not null"`
+	// Attempts is the number of times the request has been attempted.
+	Attempts uint32 `gorm:"not null;default:0"`
+	// LastAttempt is the time the request was last attempted.
+	LastAttempt time.Time
+	// LastResult is the result of the last attempt if it failed.
+	LastResult string `gorm:"size:255;not null;default:''"`
+	// Delivered are the inboxes the activity for the request has been
+	// delivered to, they are skipped when the request is retried.
+	Delivered []string `gorm:"serializer:json"`
 }
 
 type Actors struct {
 	db *gorm.DB
+	// staleAge is the age after which the cached copy of a remote actor is
+	// refreshed when it is found by FindOrCreate. If zero, it is not.
+	staleAge time.Duration
+	// instance is the instance which refreshes the stale actors.
+	instance *Instance
 }
 
+// NewActors returns Actors which does not refresh the remote actors it
+// finds. Use Env.Actors to refresh stale actors.
 func NewActors(db *gorm.DB) *Actors {
 	return &Actors{db: db}
 }
 
 // FindOrCreate finds an account by its URI, or creates it if it doesn't exist.
+// If the actor is a remote actor which has not been updated for the stale age
+// of a, a refresh is queued and the cached actor is returned.
 func (a *Actors) FindOrCreate(uri string, createFn func(string) (*Actor, error)) (*Actor, error) {
 	actor, err := a.FindByURI(uri)
 	if err == nil {
 		// found cached key
+		if a.staleAge > 0 && !actor.IsLocal() && time.Since(actor.UpdatedAt) > a.staleAge {
+			if err := a.Refresh(a.instance, actor); err != nil {
+				return nil, err
+			}
+		}
 		return actor, nil
 	}
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -94,6 +198,18 @@ func (a *Actors) FindOrCreate(uri string, createFn func(string) (*Actor, error))
 	return acc, err
 }
 
+// Refresh queues a request to refetch the remote actor, signed by the service
+// account of the instance.
+func (a *Actors) Refresh(instance *Instance, actor *Actor) error {
+	return a.db.Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(&ActorRequest{
+		ActorID:    actor.ID,
+		Action:     "refresh",
+		InstanceID: &instance.ID,
+	}).Error
+}
+
 // FindByURI returns an account by its URI if it exists locally.
 func (a *Actors) FindByURI(uri string) (*Actor, error) {
 	// use find to avoid record not found error in case of empty result
@@ -106,3 +222,69 @@ func (a *Actors) FindByURI(uri string) (*Actor, error) {
 	}
 	return &actors[0], nil
 }
+
+// QueueUpdate queues an Update activity to the followers of a local actor,
+// after its profile or keys have changed. Remote actors are ignored.
+func (a *Actors) QueueUpdate(actor *Actor) error {
+	if !actor.IsLocal() {
+		return nil
+	}
+	return a.queue(actor, "update")
+}
+
+// queue creates, or resets, the request to perform action for actor.
+func (a *Actors) queue(actor *Actor, action string) error {
+	return a.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "actor_id"}, {Name: "action"}},
+		UpdateAll: true,
+	}).Create(&ActorRequest{
+		ActorID: actor.ID,
+		Action:  action,
+	}).Error
+}
+
+// SetAliases replaces the alsoKnownAs URIs of actor.
+func (a *Actors) SetAliases(actor *Actor, aliases []string) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		actor.AlsoKnownAs = aliases
+		if err := tx.Model(actor).Select("also_known_as").Updates(actor).Error; err != nil {
+			return err
+		}
+		return NewActors(tx).QueueUpdate(actor)
+	})
+}
+
+// AddAlias adds uri to the alsoKnownAs URIs of actor.
+func (a *Actors) AddAlias(actor *Actor, uri string) error {
+	if actor.IsAlias(uri) {
+		return nil
+	}
+	return a.SetAliases(actor, append(actor.AlsoKnownAs, uri))
+}
+
+// RemoveAlias removes uri from the alsoKnownAs URIs of actor.
+func (a *Actors) RemoveAlias(actor *Actor, uri string) error {
+	var aliases []string
+	for _, aka := range actor.AlsoKnownAs {
+		if aka != uri {
+			aliases = append(aliases, aka)
+		}
+	}
+	return a.SetAliases(actor, aliases)
+}
+
+// Move records that actor has moved to target. If actor is local, a Move
+// activity is queued for delivery to its followers.
+func (a *Actors) Move(actor, target *Actor) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		actor.MovedToID = &target.ID
+		actor.MovedTo = target
+		if err := tx.Model(actor).Update("moved_to_id", target.ID).Error; err != nil {
+			return err
+		}
+		if !actor.IsLocal() {
+			return nil
+		}
+		return NewActors(tx).queue(actor, "move")
+	})
+}
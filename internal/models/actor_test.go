@@ -0,0 +1,117 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActorURLs(t *testing.T) {
+	alice := &Actor{Type: "LocalPerson", Name: "alice", Domain: "localhost:8080", URI: "http://localhost:8080/u/alice"}
+	require.Equal(t, "http://localhost:8080/inbox", alice.URLs().SharedInbox())
+	require.Equal(t, "http://localhost:8080/@alice", alice.URL())
+	service := &Actor{Type: "Application", Name: "example.com", Domain: "example.com", URI: "https://example.com/actor"}
+	require.Equal(t, "https://example.com/reports/42", service.URLs().Report(42))
+}
+
+func TestActorsFindOrCreate(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	env := &Env{DB: db, StaleActorAge: time.Hour}
+	actors := env.Actors(instance)
+
+	alice := &Actor{
+		ID:        1 << 16,
+		Name:      "alice",
+		Domain:    "remote.example",
+		URI:       "https://remote.example/users/alice",
+		PublicKey: []byte("public key"),
+	}
+	fetches := 0
+	fetch := func(uri string) (*Actor, error) {
+		fetches++
+		return alice, nil
+	}
+	actor, err := actors.FindOrCreate(alice.URI, fetch)
+	require.NoError(t, err)
+	require.Equal(t, alice.ID, actor.ID)
+	require.Equal(t, "Person", actor.Type)
+
+	// the second lookup is served from the database.
+	_, err = actors.FindOrCreate(alice.URI, fetch)
+	require.NoError(t, err)
+	require.Equal(t, 1, fetches)
+
+	// a stale actor is queued for refresh, unless refreshing is disabled.
+	require.NoError(t, db.Model(alice).UpdateColumn("updated_at", time.Now().Add(-2*time.Hour)).Error)
+	_, err = NewActors(db).FindOrCreate(alice.URI, fetch)
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, db.Model(&ActorRequest{}).Count(&count).Error)
+	require.EqualValues(t, 0, count)
+	_, err = actors.FindOrCreate(alice.URI, fetch)
+	require.NoError(t, err)
+	require.Equal(t, 1, fetches)
+	var request ActorRequest
+	require.NoError(t, db.First(&request, "actor_id = ?", alice.ID).Error)
+	require.Equal(t, "refresh", request.Action)
+	require.Equal(t, instance.ID, *request.InstanceID)
+
+	// queueing twice is not an error.
+	require.NoError(t, actors.Refresh(instance, alice))
+}
+
+func TestActorsQueueUpdate(t *testing.T) {
+	db := setupTestDB(t)
+	actors := NewActors(db)
+	dave := createActor(t, db, "dave", "example.com", true)
+	alice := createActor(t, db, "alice", "remote.example", false)
+
+	// updating an actor, or its counters, does not federate anything.
+	require.NoError(t, db.Model(dave).Update("display_name", "Dave").Error)
+	require.NoError(t, db.Model(&Actor{ID: dave.ID}).Update("followers_count", 1).Error)
+	var count int64
+	require.NoError(t, db.Model(&ActorRequest{}).Count(&count).Error)
+	require.EqualValues(t, 0, count)
+
+	require.NoError(t, actors.QueueUpdate(alice))
+	require.NoError(t, actors.QueueUpdate(dave))
+	// queueing twice is not an error.
+	require.NoError(t, actors.QueueUpdate(dave))
+
+	var requests []ActorRequest
+	require.NoError(t, db.Find(&requests).Error)
+	require.Len(t, requests, 1)
+	require.Equal(t, dave.ID, requests[0].ActorID)
+	require.Equal(t, "update", requests[0].Action)
+}
+
+func TestActorsAliasesAndMove(t *testing.T) {
+	db := setupTestDB(t)
+	actors := NewActors(db)
+	dave := createActor(t, db, "dave", "example.com", true)
+	other := createActor(t, db, "dave", "other.example", false)
+
+	require.NoError(t, actors.AddAlias(dave, other.URI))
+	require.NoError(t, actors.AddAlias(dave, other.URI))
+	var actor Actor
+	require.NoError(t, db.First(&actor, dave.ID).Error)
+	require.Equal(t, []string{other.URI}, actor.AlsoKnownAs)
+	require.True(t, actor.IsAlias(other.URI))
+
+	require.NoError(t, actors.RemoveAlias(dave, other.URI))
+	require.NoError(t, db.First(&actor, dave.ID).Error)
+	require.Empty(t, actor.AlsoKnownAs)
+	// changing the aliases federates the actor.
+	var request ActorRequest
+	require.NoError(t, db.First(&request, "actor_id = ? and action = ?", dave.ID, "update").Error)
+
+	require.NoError(t, actors.Move(dave, other))
+	require.NoError(t, db.Preload("MovedTo").First(&actor, dave.ID).Error)
+	require.Equal(t, other.URI, actor.MovedTo.URI)
+	var move ActorRequest
+	require.NoError(t, db.First(&move, "actor_id = ? and action = ?", dave.ID, "move").Error)
+}
@@ -0,0 +1,270 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+)
+
+// An AdminAction records a moderation action taken by an admin of an
+// Instance, for the audit log.
+// An AdminAction belongs to an Instance.
+// An AdminAction belongs to the Account which took the action.
+type AdminAction struct {
+	snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	InstanceID   snowflake.ID  `gorm:"not null;index"`
+	Instance     *Instance     `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	AccountID    *snowflake.ID // nil if the account has since been deleted.
+	Account      *Account      `gorm:"constraint:OnDelete:SET NULL;<-:false;"`
+	// Action is what was done, eg. approve, suspend, or block_domain.
+	Action string `gorm:"size:32;not null"`
+	// TargetType and TargetID identify the target of the action; an account,
+	// domain_block, domain_allow, instance, report, or trend.
+	TargetType string `gorm:"size:16;not null"`
+	TargetID   uint64 `gorm:"not null"`
+	// Target describes the target at the time of the action, as the target
+	// may since have been deleted.
+	Target string `gorm:"size:255;not null"`
+	Text   string `gorm:"size:1000;not null;default:''"`
+}
+
+// AdminActions takes moderation actions, and records them in the audit log.
+type AdminActions struct {
+	db *gorm.DB
+}
+
+func NewAdminActions(db *gorm.DB) *AdminActions {
+	return &AdminActions{db: db}
+}
+
+// record records admin taking action on the target.
+func record(tx *gorm.DB, admin *Account, action, targetType string, targetID uint64, target, text string) error {
+	return tx.Create(&AdminAction{
+		ID:         snowflake.Now(),
+		InstanceID: admin.InstanceID,
+		AccountID:  &admin.ID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Target:     target,
+		Text:       text,
+	}).Error
+}
+
+// Approve approves the pending account.
+func (a *AdminActions) Approve(admin, account *Account) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := NewAccounts(tx).Approve(account); err != nil {
+			return err
+		}
+		return record(tx, admin, "approve", "account", uint64(account.ActorID), account.Actor.Acct(), "")
+	})
+}
+
+// Reject rejects, and deletes, the pending account.
+func (a *AdminActions) Reject(admin, account *Account) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := NewAccounts(tx).Reject(account); err != nil {
+			return err
+		}
+		return record(tx, admin, "reject", "account", uint64(account.ActorID), account.Actor.Acct(), "")
+	})
+}
+
+// Suspend suspends the actor on the admin's instance.
+func (a *AdminActions) Suspend(admin *Account, actor *Actor, text string) error {
+	return a.setActor(admin, actor, "suspend", "suspended_at", time.Now(), text)
+}
+
+// Unsuspend lifts the suspension of the actor on the admin's instance.
+func (a *AdminActions) Unsuspend(admin *Account, actor *Actor) error {
+	return a.setActor(admin, actor, "unsuspend", "suspended_at", nil, "")
+}
+
+// Silence silences the actor on the admin's instance.
+func (a *AdminActions) Silence(admin *Account, actor *Actor, text string) error {
+	return a.setActor(admin, actor, "silence", "silenced_at", time.Now(), text)
+}
+
+// Unsilence lifts the silencing of the actor on the admin's instance.
+func (a *AdminActions) Unsilence(admin *Account, actor *Actor) error {
+	return a.setActor(admin, actor, "unsilence", "silenced_at", nil, "")
+}
+
+// Warn records a warning to the actor, without taking any other action.
+func (a *AdminActions) Warn(admin *Account, actor *Actor, text string) error {
+	return record(a.db, admin, "warn", "account", uint64(actor.ID), actor.Acct(), text)
+}
+
+// setActor sets column of the admin's instance's moderation of the actor to
+// value, and records the action.
+func (a *AdminActions) setActor(admin *Account, actor *Actor, action, column string, value any, text string) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := NewActorModerations(tx).set(admin.InstanceID, actor, column, value); err != nil {
+			return err
+		}
+		return record(tx, admin, action, "account", uint64(actor.ID), actor.Acct(), text)
+	})
+}
+
+// BlockDomain creates the block of a domain for the admin's instance. If the
+// block suspends the domain, its content is purged.
+func (a *AdminActions) BlockDomain(admin *Account, block *DomainBlock) error {
+	block.InstanceID = admin.InstanceID
+	if block.Severity == "" {
+		block.Severity = SeveritySuspend
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&DomainBlock{}).Where("instance_id = ? AND domain = ?", admin.InstanceID, block.Domain).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("domain %s is already blocked", block.Domain)
+		}
+		if err := tx.Create(block).Error; err != nil {
+			return err
+		}
+		if err := record(tx, admin, "block_domain", "domain_block", uint64(block.ID), block.Domain, block.Severity); err != nil {
+			return err
+		}
+		if block.Severity == SeveritySuspend {
+			return purgeDomain(tx, block.Domain)
+		}
+		return nil
+	})
+}
+
+// UpdateDomainBlock saves the changes to the domain block. If the block now
+// suspends the domain, its content is purged.
+func (a *AdminActions) UpdateDomainBlock(admin *Account, block *DomainBlock) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Select("severity", "reject_media", "public_comment", "private_comment").Save(block).Error; err != nil {
+			return err
+		}
+		if err := record(tx, admin, "update_domain_block", "domain_block", uint64(block.ID), block.Domain, block.Severity); err != nil {
+			return err
+		}
+		if block.Severity == SeveritySuspend {
+			return purgeDomain(tx, block.Domain)
+		}
+		return nil
+	})
+}
+
+// UnblockDomain removes the domain block.
+func (a *AdminActions) UnblockDomain(admin *Account, block *DomainBlock) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(block).Error; err != nil {
+			return err
+		}
+		return record(tx, admin, "unblock_domain", "domain_block", uint64(block.ID), block.Domain, "")
+	})
+}
+
+// AllowDomain allows domain to federate with the admin's instance in
+// allowlist mode.
+func (a *AdminActions) AllowDomain(admin *Account, domain string) (*DomainAllow, error) {
+	allow := &DomainAllow{
+		InstanceID: admin.InstanceID,
+		Domain:     domain,
+	}
+	err := a.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&DomainAllow{}).Where("instance_id = ? AND domain = ?", admin.InstanceID, domain).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("domain %s is already allowed", domain)
+		}
+		if err := tx.Create(allow).Error; err != nil {
+			return err
+		}
+		return record(tx, admin, "allow_domain", "domain_allow", uint64(allow.ID), domain, "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return allow, nil
+}
+
+// DisallowDomain removes the domain allow.
+func (a *AdminActions) DisallowDomain(admin *Account, allow *DomainAllow) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(allow).Error; err != nil {
+			return err
+		}
+		return record(tx, admin, "disallow_domain", "domain_allow", uint64(allow.ID), allow.Domain, "")
+	})
+}
+
+// SetAllowlistMode enables, or disables, allowlist mode for the admin's
+// instance.
+func (a *AdminActions) SetAllowlistMode(admin *Account, enabled bool) error {
+	action := "disable_allowlist_mode"
+	if enabled {
+		action = "enable_allowlist_mode"
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Instance{}).Where("id = ?", admin.InstanceID).Update("allowlist_mode", enabled).Error; err != nil {
+			return err
+		}
+		return record(tx, admin, action, "instance", uint64(admin.InstanceID), admin.Domain(), "")
+	})
+}
+
+// ResolveReport marks the report as resolved.
+func (a *AdminActions) ResolveReport(admin *Account, report *Report) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := NewReports(tx).Resolve(report, admin); err != nil {
+			return err
+		}
+		return record(tx, admin, "resolve_report", "report", uint64(report.ID), fmt.Sprint(report.ID), "")
+	})
+}
+
+// ReopenReport marks the resolved report as open.
+func (a *AdminActions) ReopenReport(admin *Account, report *Report) error {
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := NewReports(tx).Reopen(report); err != nil {
+			return err
+		}
+		return record(tx, admin, "reopen_report", "report", uint64(report.ID), fmt.Sprint(report.ID), "")
+	})
+}
+
+// ReviewTrend approves, or rejects, the trend for the admin's instance.
+func (a *AdminActions) ReviewTrend(admin *Account, trend *Trend, approved bool) error {
+	action := "reject_trend"
+	if approved {
+		action = "approve_trend"
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&TrendReview{
+			InstanceID: admin.InstanceID,
+			TrendID:    trend.ID,
+			Approved:   approved,
+			ReviewedAt: time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		return record(tx, admin, action, "trend", uint64(trend.ID), trend.Describe(), "")
+	})
+}
+
+// SetTrendsRequireApproval sets whether the admin's instance only shows the
+// trends which have been approved.
+func (a *AdminActions) SetTrendsRequireApproval(admin *Account, required bool) error {
+	action := "disable_trends_approval"
+	if required {
+		action = "enable_trends_approval"
+	}
+	return a.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Instance{}).Where("id = ?", admin.InstanceID).Update("trends_require_approval", required).Error; err != nil {
+			return err
+		}
+		return record(tx, admin, action, "instance", uint64(admin.InstanceID), admin.Domain(), "")
+	})
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdminActions(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	admin, err := NewAccounts(db).Create(instance, NewAccount{Name: "admin", Email: "admin@example.com", Password: "sssh", Role: "admin", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	remote := createActor(t, db, "bob", "remote.example", false)
+
+	other := &Instance{ID: snowflake.Now(), Domain: "other.example"}
+	require.NoError(t, db.Create(other).Error)
+
+	actions := NewAdminActions(db)
+	moderations := NewActorModerations(db)
+	moderation := func(instance *Instance) *ActorModeration {
+		t.Helper()
+		m, err := moderations.Find(instance.ID, remote)
+		require.NoError(t, err)
+		return m
+	}
+	require.NoError(t, actions.Suspend(admin, remote, "spam"))
+	require.True(t, moderation(instance).IsSuspended())
+	// moderation is per instance.
+	require.False(t, moderation(other).IsSuspended())
+	require.NoError(t, actions.Unsuspend(admin, remote))
+	require.False(t, moderation(instance).IsSuspended())
+	require.NoError(t, actions.Silence(admin, remote, ""))
+	require.True(t, moderation(instance).IsSilenced())
+	require.False(t, moderation(other).IsSilenced())
+
+	block := &DomainBlock{Domain: "bad.example", Severity: SeveritySilence}
+	require.NoError(t, actions.BlockDomain(admin, block))
+	require.Error(t, actions.BlockDomain(admin, &DomainBlock{Domain: "bad.example"}))
+	require.NoError(t, actions.UnblockDomain(admin, block))
+
+	report := &Report{ID: snowflake.Now(), InstanceID: instance.ID, ActorID: admin.ActorID, TargetID: remote.ID}
+	require.NoError(t, db.Create(report).Error)
+	require.NoError(t, actions.ResolveReport(admin, report))
+	require.True(t, report.IsResolved())
+	require.NoError(t, actions.ReopenReport(admin, report))
+	require.False(t, report.IsResolved())
+
+	var logged []string
+	require.NoError(t, db.Model(&AdminAction{}).Where("instance_id = ?", instance.ID).Pluck("action", &logged).Error)
+	require.ElementsMatch(t, []string{"suspend", "unsuspend", "silence", "block_domain", "unblock_domain", "resolve_report", "reopen_report"}, logged)
+
+	var suspend AdminAction
+	require.NoError(t, db.Take(&suspend, "action = ?", "suspend").Error)
+	require.Equal(t, "bob@remote.example", suspend.Target)
+	require.Equal(t, "spam", suspend.Text)
+	require.Equal(t, uint64(remote.ID), suspend.TargetID)
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BackfillInterval is the minimum time between backfills of the same object.
+const BackfillInterval = 24 * time.Hour
+
+// A BackfillRequest records a request to fetch the history of a remote object
+// which did not arrive in our inbox; the statuses in an actor's outbox, or the
+// replies to, and the conversation of, a status. BackfillRequests are processed
+// by the BackfillProcessor in the background.
+type BackfillRequest struct {
+	ID uint32 `gorm:"primarykey;"`
+	// CreatedAt is the time the request was created.
+	CreatedAt time.Time
+	// UpdatedAt is the time the request was last updated.
+	UpdatedAt time.Time
+	// InstanceID is the instance whose service account signs the requests
+	// to the remote server.
+	InstanceID snowflake.ID `gorm:"not null;"`
+	Instance   *Instance    `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// Kind is the collection to walk.
+	Kind string `gorm:"size:16;uniqueIndex:idx_kind_uri;not null"`
+	// URI is the URI of the actor, for outbox requests, or the status.
+	URI string `gorm:"size:255;uniqueIndex:idx_kind_uri;not null"`
+	// CompletedAt is the time the request was last completed, or nil if
+	// the request is pending.
+	CompletedAt *time.Time
+	// Attempts is the number of times the request has been attempted.
+	Attempts uint32 `gorm:"not null;default:0"`
+	// LastAttempt is the time the request was last attempted.
+	LastAttempt time.Time
+	// LastResult is the result of the last attempt if it failed.
+	LastResult string `gorm:"size:255;not null;default:''"`
+}
+
+type Backfills struct {
+	db *gorm.DB
+}
+
+func NewBackfills(db *gorm.DB) *Backfills {
+	return &Backfills{db: db}
+}
+
+// Request queues a backfill of kind for the object at uri, signed by the
+// service account of instance. If the object was backfilled less than
+// BackfillInterval ago, or a backfill is already pending, Request does nothing.
+// Requests which were abandoned after repeated failures are retried once
+// BackfillInterval has passed.
+func (b *Backfills) Request(instance *Instance, kind, uri string) error {
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{
+			DoNothing: true,
+		}).Create(&BackfillRequest{
+			InstanceID: instance.ID,
+			Kind:       kind,
+			URI:        uri,
+		}).Error; err != nil {
+			return err
+		}
+		// requeue the request if it completed, or was abandoned, long enough ago.
+		cutoff := time.Now().Add(-BackfillInterval)
+		return tx.Model(&BackfillRequest{}).
+			Where("kind = ? AND uri = ?", kind, uri).
+			Where("completed_at < ? OR (completed_at IS NULL AND last_attempt < ?)", cutoff, cutoff).
+			Updates(map[string]any{"completed_at": nil, "attempts": 0}).Error
+	})
+}
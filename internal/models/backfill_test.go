@@ -0,0 +1,37 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfillsRequest(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+
+	backfills := NewBackfills(db)
+	const uri = "https://remote.example/users/alice"
+	require.NoError(t, backfills.Request(instance, "outbox", uri))
+	require.NoError(t, backfills.Request(instance, "outbox", uri))
+	var requests []BackfillRequest
+	require.NoError(t, db.Find(&requests).Error)
+	require.Len(t, requests, 1)
+
+	// a recently completed backfill is not requeued.
+	completed := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Model(&requests[0]).Update("completed_at", completed).Error)
+	require.NoError(t, backfills.Request(instance, "outbox", uri))
+	require.NoError(t, db.First(&requests[0]).Error)
+	require.NotNil(t, requests[0].CompletedAt)
+
+	// but one completed more than BackfillInterval ago is.
+	completed = time.Now().Add(-2 * BackfillInterval)
+	require.NoError(t, db.Model(&requests[0]).Update("completed_at", completed).Error)
+	require.NoError(t, backfills.Request(instance, "outbox", uri))
+	require.NoError(t, db.First(&requests[0]).Error)
+	require.Nil(t, requests[0].CompletedAt)
+}
@@ -14,7 +14,7 @@ type Conversation struct {
 	ID         uint32 `gorm:"primarykey"`
 	CreatedAt  time.Time
 	UpdatedAt  time.Time
-	Visibility string `gorm:"type:enum('public', 'unlisted', 'private', 'direct', 'limited');not null"`
+	Visibility string `gorm:"size:16;not null"`
 }
 
 type Conversations struct {
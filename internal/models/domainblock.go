@@ -0,0 +1,248 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// A DomainBlock limits how an Instance federates with a remote domain.
+// A DomainBlock also applies to all subdomains of Domain.
+// A DomainBlock belongs to an Instance.
+type DomainBlock struct {
+	ID         uint32 `gorm:"primarykey"`
+	CreatedAt  time.Time
+	InstanceID snowflake.ID `gorm:"uniqueIndex:idx_instance_id_domain;not null"`
+	Domain     string       `gorm:"size:64;uniqueIndex:idx_instance_id_domain;not null"`
+	// Severity is one of suspend, silence, or noop.
+	Severity string `gorm:"size:16;not null;default:'suspend'"`
+	// RejectMedia is true if attachments from the domain are not shown.
+	RejectMedia bool `gorm:"not null;default:false"`
+	// PublicComment is published with the block, PrivateComment is only
+	// shown to admins.
+	PublicComment  string `gorm:"size:255;not null;default:''"`
+	PrivateComment string `gorm:"size:255;not null;default:''"`
+}
+
+// The severities of a DomainBlock, the values match Mastodon's.
+const (
+	// SeveritySuspend drops all activities from the domain, and purges its
+	// content.
+	SeveritySuspend = "suspend"
+	// SeveritySilence hides the domain's content from the public timelines.
+	SeveritySilence = "silence"
+	// SeverityNoop only applies the block's RejectMedia.
+	SeverityNoop = "noop"
+)
+
+// A DomainAllow permits an Instance in allowlist mode to federate with a
+// remote domain, and its subdomains.
+// A DomainAllow belongs to an Instance.
+type DomainAllow struct {
+	ID         uint32 `gorm:"primarykey"`
+	CreatedAt  time.Time
+	InstanceID snowflake.ID `gorm:"uniqueIndex:idx_domain_allows_instance_id_domain;not null"`
+	Domain     string       `gorm:"size:64;uniqueIndex:idx_domain_allows_instance_id_domain;not null"`
+}
+
+// ErrDomainSuspended is returned when an instance does not federate with a
+// domain, because the domain is suspended or, in allowlist mode, not allowed.
+var ErrDomainSuspended = errors.New("domain is suspended")
+
+// A DomainPolicy is how an Instance federates with a remote domain.
+type DomainPolicy struct {
+	// Suspend is true if the instance does not federate with the domain.
+	Suspend bool
+	// Silence is true if the domain's content is hidden from the public
+	// timelines.
+	Silence bool
+	// RejectMedia is true if attachments from the domain are not shown.
+	RejectMedia bool
+}
+
+type DomainBlocks struct {
+	db *gorm.DB
+}
+
+func NewDomainBlocks(db *gorm.DB) *DomainBlocks {
+	return &DomainBlocks{db: db}
+}
+
+// IsBlocked returns true if the instance does not federate with domain.
+func (d *DomainBlocks) IsBlocked(instance *Instance, domain string) (bool, error) {
+	policy, err := d.Policy(instance, domain)
+	if err != nil {
+		return false, err
+	}
+	return policy.Suspend, nil
+}
+
+// Policy returns the policy of the instance for domain, combining the blocks
+// of domain and its parent domains. In allowlist mode, domains which are not
+// allowed, and are not served by this server, are suspended.
+func (d *DomainBlocks) Policy(instance *Instance, domain string) (*DomainPolicy, error) {
+	var policy DomainPolicy
+	if domain == instance.Domain {
+		return &policy, nil
+	}
+	if instance.AllowlistMode {
+		allowed, err := d.isAllowed(instance, domain)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			policy.Suspend = true
+			return &policy, nil
+		}
+	}
+	var blocks []DomainBlock
+	if err := d.db.Where("instance_id = ?", instance.ID).Find(&blocks).Error; err != nil {
+		return nil, err
+	}
+	for _, block := range blocks {
+		if !matchesDomain(domain, block.Domain) {
+			continue
+		}
+		switch block.Severity {
+		case SeveritySuspend:
+			policy.Suspend = true
+		case SeveritySilence:
+			policy.Silence = true
+		}
+		policy.RejectMedia = policy.RejectMedia || block.RejectMedia
+	}
+	return &policy, nil
+}
+
+// RejectsMedia returns true if no instance on this server stores the
+// attachments of domain. Attachments are shared by the instances on this
+// server, so they are stored while any instance accepts them; each instance
+// hides them when serialising if it rejects them.
+func (d *DomainBlocks) RejectsMedia(domain string) (bool, error) {
+	var instances []*Instance
+	if err := d.db.Find(&instances).Error; err != nil {
+		return false, err
+	}
+	for _, instance := range instances {
+		policy, err := d.Policy(instance, domain)
+		if err != nil {
+			return false, err
+		}
+		if !policy.Suspend && !policy.RejectMedia {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isAllowed returns true if domain, or any of its parent domains, is allowed
+// by the instance, or is the domain of an instance on this server.
+func (d *DomainBlocks) isAllowed(instance *Instance, domain string) (bool, error) {
+	var count int64
+	if err := d.db.Model(&Instance{}).Where("domain = ?", domain).Count(&count).Error; err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+	var allows []DomainAllow
+	if err := d.db.Where("instance_id = ?", instance.ID).Find(&allows).Error; err != nil {
+		return false, err
+	}
+	for _, allow := range allows {
+		if matchesDomain(domain, allow.Domain) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Hidden returns a scope which hides the rows whose column holds a domain the
+// instance does not federate with. If silenced is true, domains the instance
+// silences are also hidden. column is quoted for the database, so it may name
+// a joined association, such as Actor.
+func (d *DomainBlocks) Hidden(instance *Instance, column clause.Column, silenced bool) (func(*gorm.DB) *gorm.DB, error) {
+	severities := []string{SeveritySuspend}
+	if silenced {
+		severities = append(severities, SeveritySilence)
+	}
+	var hidden []string
+	if err := d.db.Model(&DomainBlock{}).Where("instance_id = ? AND severity IN (?)", instance.ID, severities).Pluck("domain", &hidden).Error; err != nil {
+		return nil, err
+	}
+	var allowed []string
+	if instance.AllowlistMode {
+		if err := d.db.Model(&DomainAllow{}).Where("instance_id = ?", instance.ID).Pluck("domain", &allowed).Error; err != nil {
+			return nil, err
+		}
+	}
+	return func(tx *gorm.DB) *gorm.DB {
+		for _, domain := range hidden {
+			query, args := domainCondition(column, domain)
+			tx = tx.Where("NOT ("+query+")", args...)
+		}
+		if instance.AllowlistMode {
+			query := "? IN (?)"
+			args := []any{column, d.db.Model(&Instance{}).Select("domain")}
+			for _, domain := range allowed {
+				q, a := domainCondition(column, domain)
+				query += " OR " + q
+				args = append(args, a...)
+			}
+			tx = tx.Where("("+query+")", args...)
+		}
+		return tx
+	}, nil
+}
+
+// domainCondition returns a condition, and its arguments, matching the rows
+// whose column is domain, or a subdomain of domain.
+func domainCondition(column clause.Column, domain string) (string, []any) {
+	return "? = ? OR ? LIKE ? ESCAPE '!'", []any{column, domain, column, "%." + EscapeLike(domain)}
+}
+
+// matchesDomain returns true if domain is parent, or a subdomain of parent.
+func matchesDomain(domain, parent string) bool {
+	return domain == parent || strings.HasSuffix(domain, "."+parent)
+}
+
+// EscapeLike escapes the wildcards in s for use in a LIKE pattern with
+// ESCAPE '!'. Backslash is not used as it is not the default escape character
+// in every database.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`).Replace(s)
+}
+
+// purgeDomain deletes the remote actors of domain, and its subdomains, and
+// with them their statuses, if no instance on this server federates with the
+// domain. Remote actors are shared by the instances on this server, so they
+// are kept while any instance still federates with the domain. Actors which
+// have reported, or been reported, are kept so the reports survive.
+func purgeDomain(tx *gorm.DB, domain string) error {
+	var instances []*Instance
+	if err := tx.Find(&instances).Error; err != nil {
+		return err
+	}
+	blocks := NewDomainBlocks(tx)
+	for _, instance := range instances {
+		if instance.Domain == domain {
+			return nil
+		}
+		blocked, err := blocks.IsBlocked(instance, domain)
+		if err != nil {
+			return err
+		}
+		if !blocked {
+			return nil
+		}
+	}
+	query, args := domainCondition(clause.Column{Name: "domain"}, domain)
+	return tx.Where("("+query+")", args...).
+		Where("domain NOT IN (?)", tx.Model(&Instance{}).Select("domain")).
+		Where("NOT EXISTS (?)", tx.Model(&Report{}).Select("1").Where("reports.actor_id = actors.id OR reports.target_id = actors.id")).
+		Delete(&Actor{}).Error
+}
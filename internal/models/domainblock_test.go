@@ -0,0 +1,172 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func TestDomainBlocksPolicy(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: instance.ID, Domain: "bad.example", Severity: SeveritySuspend}).Error)
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: instance.ID, Domain: "loud.example", Severity: SeveritySilence, RejectMedia: true}).Error)
+
+	blocks := NewDomainBlocks(db)
+	for domain, want := range map[string]DomainPolicy{
+		"bad.example":        {Suspend: true},
+		"social.bad.example": {Suspend: true},
+		"notbad.example":     {},
+		"loud.example":       {Silence: true, RejectMedia: true},
+		"example.com":        {},
+	} {
+		policy, err := blocks.Policy(instance, domain)
+		require.NoError(t, err)
+		require.Equal(t, want, *policy, domain)
+	}
+
+	// in allowlist mode only allowed domains, and the instances on this
+	// server, are federated with.
+	createActor(t, db, "alice", "local.example", true)
+	instance.AllowlistMode = true
+	require.NoError(t, db.Create(&DomainAllow{InstanceID: instance.ID, Domain: "friendly.example"}).Error)
+	for domain, want := range map[string]bool{
+		"friendly.example":     false,
+		"a.friendly.example":   false,
+		"local.example":        false,
+		"stranger.example":     true,
+		"bad.friendly.example": false,
+	} {
+		blocked, err := blocks.IsBlocked(instance, domain)
+		require.NoError(t, err)
+		require.Equal(t, want, blocked, domain)
+	}
+}
+
+func TestDomainBlocksHidden(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: instance.ID, Domain: "bad.example", Severity: SeveritySuspend}).Error)
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: instance.ID, Domain: "loud.example", Severity: SeveritySilence}).Error)
+	for _, domain := range []string{"bad.example", "social.bad.example", "loud.example", "friendly.example", "stranger.example"} {
+		createActor(t, db, "bob", domain, false)
+	}
+	createActor(t, db, "alice", "example.com", false)
+
+	visible := func(silenced bool) []string {
+		scope, err := NewDomainBlocks(db).Hidden(instance, clause.Column{Name: "domain"}, silenced)
+		require.NoError(t, err)
+		var domains []string
+		require.NoError(t, db.Model(&Actor{}).Scopes(scope).Pluck("domain", &domains).Error)
+		return domains
+	}
+	require.ElementsMatch(t, []string{"loud.example", "friendly.example", "stranger.example", "example.com"}, visible(false))
+	require.ElementsMatch(t, []string{"friendly.example", "stranger.example", "example.com"}, visible(true))
+
+	instance.AllowlistMode = true
+	require.NoError(t, db.Create(&DomainAllow{InstanceID: instance.ID, Domain: "friendly.example"}).Error)
+	require.ElementsMatch(t, []string{"friendly.example", "example.com"}, visible(true))
+}
+
+func TestAdminActionsBlockDomainPurges(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	admin, err := NewAccounts(db).Create(instance, NewAccount{Name: "admin", Email: "admin@example.com", Password: "sssh", Role: "admin", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	bad := createActor(t, db, "bob", "social.bad.example", false)
+	createStatus(t, db, bad, time.Now())
+	good := createActor(t, db, "carol", "good.example", false)
+
+	actions := NewAdminActions(db)
+	require.NoError(t, actions.BlockDomain(admin, &DomainBlock{Domain: "bad.example", Severity: SeveritySilence}))
+	require.NoError(t, db.Take(&Actor{}, bad.ID).Error)
+
+	var block DomainBlock
+	require.NoError(t, db.Take(&block, "domain = ?", "bad.example").Error)
+	block.Severity = SeveritySuspend
+	require.NoError(t, actions.UpdateDomainBlock(admin, &block))
+	require.Error(t, db.Take(&Actor{}, bad.ID).Error)
+	var count int64
+	require.NoError(t, db.Model(&Status{}).Where("actor_id = ?", bad.ID).Count(&count).Error)
+	require.Zero(t, count)
+	require.NoError(t, db.Take(&Actor{}, good.ID).Error)
+
+	// remote actors are kept while another instance federates with the domain.
+	other := &Instance{ID: snowflake.Now(), Domain: "other.example"}
+	require.NoError(t, db.Create(other).Error)
+	good2 := createActor(t, db, "dave", "good.example", false)
+	require.NoError(t, actions.BlockDomain(admin, &DomainBlock{Domain: "good.example"}))
+	require.NoError(t, db.Take(&Actor{}, good2.ID).Error)
+}
+
+func TestAdminActionsBlockDomainKeepsReports(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	admin, err := NewAccounts(db).Create(instance, NewAccount{Name: "admin", Email: "admin@example.com", Password: "sssh", Role: "admin", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	alice := createActor(t, db, "alice", "example.com", true)
+	bob := createActor(t, db, "bob", "bad.example", false)
+	reported := createStatus(t, db, bob, time.Now())
+	carol := createActor(t, db, "carol", "bad.example", false)
+	dave := createActor(t, db, "dave", "bad.example", false)
+	// alice reported bob, and carol, a moderator of bad.example, reported alice.
+	require.NoError(t, db.Create(&Report{ID: snowflake.Now(), InstanceID: instance.ID, ActorID: alice.ID, TargetID: bob.ID, StatusIDs: []snowflake.ID{reported.ID}}).Error)
+	require.NoError(t, db.Create(&Report{ID: snowflake.Now(), InstanceID: instance.ID, ActorID: carol.ID, TargetID: alice.ID}).Error)
+
+	require.NoError(t, NewAdminActions(db).BlockDomain(admin, &DomainBlock{Domain: "bad.example", Severity: SeveritySuspend}))
+	require.Error(t, db.Take(&Actor{}, dave.ID).Error)
+	require.NoError(t, db.Take(&Actor{}, bob.ID).Error)
+	require.NoError(t, db.Take(&Actor{}, carol.ID).Error)
+	require.NoError(t, db.Take(&Status{}, reported.ID).Error)
+	var count int64
+	require.NoError(t, db.Model(&Report{}).Count(&count).Error)
+	require.EqualValues(t, 2, count)
+}
+
+func TestDomainBlocksHiddenQuotesColumn(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: instance.ID, Domain: "bad.example", Severity: SeveritySuspend}).Error)
+	scope, err := NewDomainBlocks(db).Hidden(instance, clause.Column{Table: "Actor", Name: "domain"}, false)
+	require.NoError(t, err)
+
+	// PostgreSQL folds unquoted identifiers to lower case, so the joined
+	// Actor must be quoted to match the alias gorm gives it.
+	pg, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	stmt := pg.Scopes(scope).Joins("Actor").Where(clause.Eq{Column: clause.Column{Table: "Actor", Name: "domain"}, Value: "example.com"}).Find(&[]*Status{}).Statement
+	sql := stmt.SQL.String()
+	require.Contains(t, sql, `LEFT JOIN "actors" "Actor"`)
+	require.Contains(t, sql, `WHERE "Actor"."domain" = $1 AND (NOT ("Actor"."domain" = $2 OR "Actor"."domain" LIKE $3 ESCAPE '!'))`)
+}
+
+func TestDomainBlocksRejectsMedia(t *testing.T) {
+	db := setupTestDB(t)
+	a := &Instance{ID: snowflake.Now(), Domain: "a.example"}
+	require.NoError(t, db.Create(a).Error)
+	b := &Instance{ID: snowflake.Now(), Domain: "b.example"}
+	require.NoError(t, db.Create(b).Error)
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: a.ID, Domain: "loud.example", Severity: SeverityNoop, RejectMedia: true}).Error)
+
+	// attachments are stored while any instance accepts them.
+	blocks := NewDomainBlocks(db)
+	rejected, err := blocks.RejectsMedia("loud.example")
+	require.NoError(t, err)
+	require.False(t, rejected)
+
+	require.NoError(t, db.Create(&DomainBlock{InstanceID: b.ID, Domain: "loud.example", Severity: SeveritySuspend}).Error)
+	rejected, err = blocks.RejectsMedia("social.loud.example")
+	require.NoError(t, err)
+	require.True(t, rejected)
+}
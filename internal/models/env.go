@@ -1,12 +1,29 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Env struct {
 	// DB is the database connection.
 	DB *gorm.DB
+	// StaleActorAge is the age after which the cached copy of a remote
+	// actor is refreshed when it is next used. If zero, it is not.
+	StaleActorAge time.Duration
+	// ClientTimeout is the time allowed for each request to a remote server.
+	ClientTimeout time.Duration
+}
+
+// Actors returns Actors which refreshes the stale remote actors it finds, as
+// the instance.
+func (e *Env) Actors(instance *Instance) *Actors {
+	return &Actors{
+		db:       e.DB,
+		staleAge: e.StaleActorAge,
+		instance: instance,
+	}
 }
 
 func (e *Env) Statuses() *Statuses {
@@ -1,20 +1,25 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
 )
 
 // An Instance is an ActivityPub domain managed by this server.
 // An Instance has many InstanceRules.
 // An Instance has one Admin Account.
+// An Instance has one ServiceAccount, which signs server to server requests.
 type Instance struct {
 	snowflake.ID     `gorm:"primarykey;autoIncrement:false"`
 	UpdatedAt        time.Time
 	Domain           string `gorm:"size:64;uniqueIndex"`
 	AdminID          *snowflake.ID
-	Admin            *Account `gorm:"<-:false;"`
+	Admin            *Account `gorm:"foreignKey:AdminID;<-:false;"`
+	ServiceAccountID *snowflake.ID
+	ServiceAccount   *Account `gorm:"foreignKey:ServiceAccountID;<-:false;"`
 	SourceURL        string
 	Title            string `gorm:"size:64"`
 	ShortDescription string
@@ -22,14 +27,62 @@ type Instance struct {
 	Thumbnail        string `gorm:"size:64"`
 	AccountsCount    int    `gorm:"default:0;not null"`
 	StatusesCount    int    `gorm:"default:0;not null"`
+	SecureMode       bool   `gorm:"default:false;not null"` // require signed ActivityPub GET requests
+	AllowlistMode    bool   `gorm:"default:false;not null"` // only federate with the domains in DomainAllows
+	// TrendsRequireApproval is true if only the trends approved by an admin
+	// are shown, otherwise trends are shown unless they are rejected.
+	TrendsRequireApproval bool `gorm:"default:false;not null"`
+	// RegistrationsMode controls who may sign up with the API, one of
+	// closed, invite, approval, or open.
+	RegistrationsMode string `gorm:"size:16;default:'closed';not null"`
 
 	DomainsCount int64 `gorm:"-"`
 
 	Rules []InstanceRule
 }
 
+// The registrations modes of an Instance.
+const (
+	// RegistrationsClosed allows no sign ups.
+	RegistrationsClosed = "closed"
+	// RegistrationsInvite allows sign ups with an invite.
+	RegistrationsInvite = "invite"
+	// RegistrationsApproval allows anyone to sign up, their account is
+	// pending until it is approved, unless they have an invite.
+	RegistrationsApproval = "approval"
+	// RegistrationsOpen allows anyone to sign up.
+	RegistrationsOpen = "open"
+)
+
+// RegistrationsEnabled returns true if anyone may sign up, with or without
+// approval.
+func (i *Instance) RegistrationsEnabled() bool {
+	return i.RegistrationsMode == RegistrationsOpen || i.RegistrationsMode == RegistrationsApproval
+}
+
 type InstanceRule struct {
 	ID         uint32 `gorm:"primarykey"`
 	InstanceID uint64
 	Text       string
 }
+
+type Instances struct {
+	db *gorm.DB
+}
+
+func NewInstances(db *gorm.DB) *Instances {
+	return &Instances{db: db}
+}
+
+// FindByDomain returns the instance for the given domain, with its
+// ServiceAccount and the ServiceAccount's Actor preloaded.
+func (i *Instances) FindByDomain(domain string) (*Instance, error) {
+	var instance Instance
+	if err := i.db.Joins("ServiceAccount").Preload("ServiceAccount.Actor").Take(&instance, "domain = ?", domain).Error; err != nil {
+		return nil, err
+	}
+	if instance.ServiceAccountID == nil {
+		return nil, errors.New("instance " + domain + " has no service account")
+	}
+	return &instance, nil
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+)
+
+// An Invite allows someone to sign up to an Instance whose registrations
+// are not open.
+// An Invite belongs to an Instance.
+// An Invite belongs to the Account which created it.
+type Invite struct {
+	ID          uint32 `gorm:"primarykey"`
+	CreatedAt   time.Time
+	InstanceID  snowflake.ID `gorm:"not null"`
+	Instance    *Instance    `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	CreatedByID snowflake.ID `gorm:"not null"`
+	CreatedBy   *Account     `gorm:"foreignKey:CreatedByID;constraint:OnDelete:CASCADE;<-:false;"`
+	Code        string       `gorm:"size:16;not null;uniqueIndex"`
+	// ExpiresAt is the time the invite expires, nil if it does not.
+	ExpiresAt *time.Time
+	// MaxUses is the number of times the invite may be used, zero if it is
+	// unlimited.
+	MaxUses int32 `gorm:"not null;default:0"`
+	Uses    int32 `gorm:"not null;default:0"`
+}
+
+// Expired returns true if the invite has expired.
+func (i *Invite) Expired() bool {
+	return i.ExpiresAt != nil && time.Now().After(*i.ExpiresAt)
+}
+
+// ErrInvalidInvite is returned when an invite does not exist, has expired,
+// or has been used up.
+var ErrInvalidInvite = errors.New("invalid invite code")
+
+type Invites struct {
+	db *gorm.DB
+}
+
+func NewInvites(db *gorm.DB) *Invites {
+	return &Invites{db: db}
+}
+
+// Create creates an invite to instance, created by account, which may be used
+// maxUses times, or without limit if maxUses is zero, and expires after
+// expiresIn, or never if expiresIn is zero.
+func (i *Invites) Create(instance *Instance, account *Account, maxUses int32, expiresIn time.Duration) (*Invite, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	invite := &Invite{
+		InstanceID:  instance.ID,
+		CreatedByID: account.ID,
+		Code:        base64.RawURLEncoding.EncodeToString(buf),
+		MaxUses:     maxUses,
+	}
+	if expiresIn > 0 {
+		expiresAt := time.Now().Add(expiresIn)
+		invite.ExpiresAt = &expiresAt
+	}
+	if err := i.db.Create(invite).Error; err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// Use records a use of the invite to instance with code, and returns the
+// invite. If the invite does not exist, has expired, or has been used up,
+// ErrInvalidInvite is returned.
+func (i *Invites) Use(instance *Instance, code string) (*Invite, error) {
+	var invite Invite
+	if err := i.db.Where("instance_id = ? AND code = ?", instance.ID, code).First(&invite).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidInvite
+		}
+		return nil, err
+	}
+	if invite.Expired() {
+		return nil, ErrInvalidInvite
+	}
+	// check the limit in the update so concurrent sign ups cannot exceed it.
+	res := i.db.Model(&invite).
+		Where("max_uses = 0 OR uses < max_uses").
+		Update("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrInvalidInvite
+	}
+	invite.Uses++
+	return &invite, nil
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInvitesUse(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	admin, err := NewAccounts(db).Create(instance, NewAccount{Name: "admin", Email: "admin@example.com", Password: "sssh", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+
+	invites := NewInvites(db)
+	t.Run("max uses", func(t *testing.T) {
+		invite, err := invites.Create(instance, admin, 2, 0)
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err := invites.Use(instance, invite.Code)
+			require.NoError(t, err)
+		}
+		_, err = invites.Use(instance, invite.Code)
+		require.ErrorIs(t, err, ErrInvalidInvite)
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		invite, err := invites.Create(instance, admin, 0, 0)
+		require.NoError(t, err)
+		for i := 0; i < 3; i++ {
+			used, err := invites.Use(instance, invite.Code)
+			require.NoError(t, err)
+			require.EqualValues(t, i+1, used.Uses)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		invite, err := invites.Create(instance, admin, 0, time.Hour)
+		require.NoError(t, err)
+		_, err = invites.Use(instance, invite.Code)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(invite).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+		_, err = invites.Use(instance, invite.Code)
+		require.ErrorIs(t, err, ErrInvalidInvite)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		_, err := invites.Use(instance, "nope")
+		require.ErrorIs(t, err, ErrInvalidInvite)
+	})
+}
@@ -13,3 +13,33 @@ func forEach(tx *gorm.DB, fns ...func(tx *gorm.DB) error) error {
 	}
 	return nil
 }
+
+// AutoMigrate creates or updates the tables for all models.
+func AutoMigrate(db *gorm.DB) error {
+	return db.AutoMigrate(all()...)
+}
+
+// all returns a value of each model.
+func all() []any {
+	return []any{
+		&Actor{}, &ActorAttribute{}, &ActorModeration{}, &ActorRequest{},
+		&Account{}, &AccountList{}, &AccountListMember{}, &AccountRole{}, &AccountMarker{},
+		&AdminAction{},
+		&Application{},
+		&BackfillRequest{},
+		&Conversation{},
+		&DomainBlock{},
+		&DomainAllow{},
+		&Instance{}, &InstanceRule{},
+		&Invite{},
+		&Reaction{}, &ReactionRequest{},
+		&Relationship{}, &RelationshipRequest{},
+		&Report{},
+		&ReportRequest{},
+		// &Notification{},
+		&Status{}, &StatusPoll{}, &StatusAttachment{}, &StatusMention{}, &StatusTag{}, &StatusTerm{},
+		&Tag{}, &FollowedTag{}, &FeaturedTag{},
+		&Token{},
+		&Trend{}, &TrendReview{},
+	}
+}
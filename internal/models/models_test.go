@@ -0,0 +1,72 @@
+package models
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB returns an empty, migrated, in memory SQLite database.
+func setupTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:?_pragma=foreign_keys(1)"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// each connection to :memory: is a new database.
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	require.NoError(t, AutoMigrate(db))
+	return db
+}
+
+func TestAutoMigrate(t *testing.T) {
+	db := setupTestDB(t)
+	// migrating an up to date database is a no-op.
+	require.NoError(t, AutoMigrate(db))
+}
+
+// createActor creates an actor called name on domain. If local is true, the
+// actor is a LocalPerson, and domain is created as an instance.
+func createActor(t *testing.T, db *gorm.DB, name, domain string, local bool) *Actor {
+	t.Helper()
+	actor := &Actor{
+		ID:        snowflake.Now(),
+		Type:      "Person",
+		Name:      name,
+		Domain:    domain,
+		URI:       "https://" + domain + "/users/" + name,
+		PublicKey: []byte("public key"),
+	}
+	if local {
+		actor.Type = "LocalPerson"
+		require.NoError(t, db.Where(&Instance{Domain: domain}).Attrs(&Instance{ID: snowflake.Now()}).FirstOrCreate(&Instance{}).Error)
+	}
+	require.NoError(t, db.Create(actor).Error)
+	return actor
+}
+
+// createStatus creates a public status by actor published at t.
+func createStatus(t *testing.T, db *gorm.DB, actor *Actor, published time.Time) *Status {
+	t.Helper()
+	conv, err := NewConversations(db).New("public")
+	require.NoError(t, err)
+	st := &Status{
+		ID:             snowflake.TimeToID(published),
+		ActorID:        actor.ID,
+		ConversationID: conv.ID,
+		Visibility:     "public",
+		URI:            fmt.Sprintf("%s/statuses/%d", actor.URI, published.UnixNano()),
+		Note:           "hello",
+	}
+	require.NoError(t, db.Create(st).Error)
+	return st
+}
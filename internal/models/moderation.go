@@ -0,0 +1,101 @@
+package models
+
+import (
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// An ActorModeration records that an Instance has suspended, or silenced, an
+// Actor. Remote actors are shared by the instances on this server, so each
+// instance moderates them separately.
+// An ActorModeration belongs to an Instance.
+// An ActorModeration belongs to an Actor.
+type ActorModeration struct {
+	InstanceID snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Instance   *Instance    `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	ActorID    snowflake.ID `gorm:"primarykey;autoIncrement:false;index"`
+	Actor      *Actor       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// SuspendedAt is the time the instance suspended the actor, nil if it
+	// has not. Suspended local actors cannot log in, activities from
+	// suspended remote actors are dropped.
+	SuspendedAt *time.Time
+	// SilencedAt is the time the instance silenced the actor, nil if it has
+	// not. The statuses of silenced actors are hidden from the instance's
+	// public timelines and directory.
+	SilencedAt *time.Time
+}
+
+// IsSuspended returns true if the instance has suspended the actor.
+func (m *ActorModeration) IsSuspended() bool {
+	return m.SuspendedAt != nil
+}
+
+// IsSilenced returns true if the instance has silenced the actor.
+func (m *ActorModeration) IsSilenced() bool {
+	return m.SilencedAt != nil
+}
+
+type ActorModerations struct {
+	db *gorm.DB
+}
+
+func NewActorModerations(db *gorm.DB) *ActorModerations {
+	return &ActorModerations{db: db}
+}
+
+// Find returns the moderation of actor by the instance. If the instance has
+// not moderated the actor, the moderation is empty.
+func (m *ActorModerations) Find(instanceID snowflake.ID, actor *Actor) (*ActorModeration, error) {
+	var moderations []ActorModeration
+	if err := m.db.Where("instance_id = ? AND actor_id = ?", instanceID, actor.ID).Find(&moderations).Error; err != nil {
+		return nil, err
+	}
+	if len(moderations) == 0 {
+		return &ActorModeration{InstanceID: instanceID, ActorID: actor.ID}, nil
+	}
+	return &moderations[0], nil
+}
+
+// FindAll returns the moderations of the actors with ids by the instance, by
+// actor ID. Actors the instance has not moderated are omitted.
+func (m *ActorModerations) FindAll(instanceID snowflake.ID, ids []snowflake.ID) (map[snowflake.ID]*ActorModeration, error) {
+	byActor := make(map[snowflake.ID]*ActorModeration)
+	if len(ids) == 0 {
+		return byActor, nil
+	}
+	var moderations []*ActorModeration
+	if err := m.db.Where("instance_id = ? AND actor_id IN (?)", instanceID, ids).Find(&moderations).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range moderations {
+		byActor[m.ActorID] = m
+	}
+	return byActor, nil
+}
+
+// Hidden returns a scope which hides the rows whose column holds the ID of an
+// actor the instance has suspended, or, if silenced is true, silenced.
+func (m *ActorModerations) Hidden(instanceID snowflake.ID, column string, silenced bool) func(*gorm.DB) *gorm.DB {
+	moderated := m.db.Model(&ActorModeration{}).Select("actor_id").Where("instance_id = ?", instanceID)
+	if silenced {
+		moderated = moderated.Where("suspended_at IS NOT NULL OR silenced_at IS NOT NULL")
+	} else {
+		moderated = moderated.Where("suspended_at IS NOT NULL")
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(column+" NOT IN (?)", moderated)
+	}
+}
+
+// set sets column of the instance's moderation of the actor to value.
+func (m *ActorModerations) set(instanceID snowflake.ID, actor *Actor, column string, value any) error {
+	if err := m.db.Clauses(clause.OnConflict{
+		DoNothing: true,
+	}).Create(&ActorModeration{InstanceID: instanceID, ActorID: actor.ID}).Error; err != nil {
+		return err
+	}
+	return m.db.Model(&ActorModeration{}).Where("instance_id = ? AND actor_id = ?", instanceID, actor.ID).Update(column, value).Error
+}
@@ -121,3 +121,66 @@ func PaginateStatuses(r *http.Request) func(db *gorm.DB) *gorm.DB {
 		return db.Order("statuses.id desc")
 	}
 }
+
+// PaginateByID paginates a table by its snowflake ID column, newest first.
+func PaginateByID(r *http.Request, column string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		q := r.URL.Query()
+
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		switch {
+		case limit > 80:
+			limit = 80
+		case limit <= 0:
+			limit = 40
+		}
+		db = db.Limit(limit)
+
+		sinceID, _ := strconv.ParseUint(q.Get("since_id"), 10, 64)
+		if sinceID > 0 {
+			db = db.Where(column+" > ?", sinceID)
+		}
+		minID, _ := strconv.ParseUint(q.Get("min_id"), 10, 64)
+		if minID > 0 {
+			db = db.Where(column+" > ?", minID)
+		}
+		maxID, _ := strconv.ParseUint(q.Get("max_id"), 10, 64)
+		if maxID > 0 {
+			db = db.Where(column+" < ?", maxID)
+		}
+		return db.Order(column + " desc")
+	}
+}
+
+// PaginateSearch paginates search results by limit and offset. If column is
+// not empty, the min_id and max_id parameters are also applied to it.
+func PaginateSearch(r *http.Request, column string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		q := r.URL.Query()
+
+		limit, _ := strconv.Atoi(q.Get("limit"))
+		switch {
+		case limit > 40:
+			limit = 40
+		case limit <= 0:
+			limit = 20
+		}
+		db = db.Limit(limit)
+
+		offset, _ := strconv.Atoi(q.Get("offset"))
+		db = db.Offset(offset)
+
+		if column == "" {
+			return db
+		}
+		minID, _ := strconv.ParseUint(q.Get("min_id"), 10, 64)
+		if minID > 0 {
+			db = db.Where(column+" > ?", minID)
+		}
+		maxID, _ := strconv.ParseUint(q.Get("max_id"), 10, 64)
+		if maxID > 0 {
+			db = db.Where(column+" < ?", maxID)
+		}
+		return db
+	}
+}
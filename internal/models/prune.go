@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+)
+
+// PruneStats counts the rows deleted by Prune.
+type PruneStats struct {
+	Statuses      int64
+	Attachments   int64
+	Actors        int64
+	Conversations int64
+}
+
+// Prune deletes remote statuses published before t which no local actor has
+// interacted with; statuses which are not favourited, reblogged, bookmarked,
+// pinned, replied to, mentioning a local actor, or reported. Remote actors and
+// conversations which are no longer referenced are then deleted. Rows are
+// deleted in batches of batchSize.
+func Prune(db *gorm.DB, t time.Time, batchSize int) (*PruneStats, error) {
+	var stats PruneStats
+	reported, err := reportedStatuses(db)
+	if err != nil {
+		return &stats, err
+	}
+	stats.Statuses, err = deleteInBatches(db, &Status{}, batchSize, func() *gorm.DB {
+		return prunableStatuses(db, t, reported)
+	}, func(ids []uint64) error {
+		var count int64
+		if err := db.Model(&StatusAttachment{}).Where("status_id IN (?)", ids).Count(&count).Error; err != nil {
+			return err
+		}
+		stats.Attachments += count
+		return nil
+	})
+	if err != nil {
+		return &stats, err
+	}
+	stats.Actors, err = deleteInBatches(db, &Actor{}, batchSize, func() *gorm.DB {
+		return orphanedActors(db, t)
+	}, nil)
+	if err != nil {
+		return &stats, err
+	}
+	stats.Conversations, err = deleteInBatches(db, &Conversation{}, batchSize, func() *gorm.DB {
+		return db.Model(&Conversation{}).Select("conversations.id").
+			Where("conversations.created_at < ?", t).
+			Where("NOT EXISTS (?)", db.Model(&Status{}).Select("1").Where("statuses.conversation_id = conversations.id"))
+	}, nil)
+	if err != nil {
+		return &stats, err
+	}
+	return &stats, nil
+}
+
+// localActors returns a query selecting the IDs of the actors of the instances
+// on this server.
+func localActors(db *gorm.DB) *gorm.DB {
+	return db.Model(&Actor{}).Select("id").Where("domain IN (?)", db.Model(&Instance{}).Select("domain"))
+}
+
+// reportedStatuses returns the IDs of the statuses attached to reports, which
+// are kept as evidence for moderators.
+func reportedStatuses(db *gorm.DB) ([]snowflake.ID, error) {
+	var reports []*Report
+	if err := db.Select("status_ids").Find(&reports).Error; err != nil {
+		return nil, err
+	}
+	var ids []snowflake.ID
+	for _, report := range reports {
+		ids = append(ids, report.StatusIDs...)
+	}
+	return ids, nil
+}
+
+// prunableStatuses returns a query selecting the IDs of the remote statuses
+// published before t which no local actor has interacted with, and which are
+// not among the reported statuses.
+func prunableStatuses(db *gorm.DB, t time.Time, reported []snowflake.ID) *gorm.DB {
+	// the lower 16 bits of a snowflake ID are random, clear them so the
+	// cutoff is the first possible ID at t.
+	cutoff := snowflake.TimeToID(t) &^ 0xffff
+	query := db.Model(&Status{}).Select("statuses.id")
+	if len(reported) > 0 {
+		query = query.Where("statuses.id NOT IN (?)", reported)
+	}
+	return query.
+		Where("statuses.id < ?", cutoff).
+		Where("statuses.actor_id NOT IN (?)", localActors(db)).
+		Where("NOT EXISTS (?)", db.Model(&Reaction{}).Select("1").Where("reactions.status_id = statuses.id AND reactions.actor_id IN (?)", localActors(db))).
+		Where("NOT EXISTS (?)", db.Table("statuses AS replies").Select("1").Where("replies.in_reply_to_id = statuses.id AND replies.actor_id IN (?)", localActors(db))).
+		Where("NOT EXISTS (?)", db.Table("statuses AS reblogs").Select("1").Where("reblogs.reblog_id = statuses.id")).
+		Where("NOT EXISTS (?)", db.Model(&StatusMention{}).Select("1").Where("status_mentions.status_id = statuses.id AND status_mentions.actor_id IN (?)", localActors(db)))
+}
+
+// orphanedActors returns a query selecting the IDs of the remote actors, last
+// updated before t, which are not referenced by any status, relationship,
+// reaction, mention, list, report, or other actor, and which no instance has
+// suspended or silenced, so their moderation is not lost if they return.
+func orphanedActors(db *gorm.DB, t time.Time) *gorm.DB {
+	return db.Model(&Actor{}).Select("actors.id").
+		Where("actors.updated_at < ?", t).
+		Where("actors.domain NOT IN (?)", db.Model(&Instance{}).Select("domain")).
+		Where("NOT EXISTS (?)", db.Model(&Status{}).Select("1").Where("statuses.actor_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&Relationship{}).Select("1").Where("relationships.actor_id = actors.id OR relationships.target_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&Reaction{}).Select("1").Where("reactions.actor_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&StatusMention{}).Select("1").Where("status_mentions.actor_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&AccountListMember{}).Select("1").Where("account_list_members.member_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&Account{}).Select("1").Where("accounts.actor_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&Report{}).Select("1").Where("reports.actor_id = actors.id OR reports.target_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Table("actors AS movers").Select("1").Where("movers.moved_to_id = actors.id")).
+		Where("NOT EXISTS (?)", db.Model(&ActorModeration{}).Select("1").Where("actor_moderations.actor_id = actors.id AND (actor_moderations.suspended_at IS NOT NULL OR actor_moderations.silenced_at IS NOT NULL)"))
+}
+
+// deleteInBatches deletes the rows of model whose IDs are selected by query,
+// batchSize rows at a time, and returns the number of rows deleted. If
+// before is not nil, it is called with the IDs of each batch before they
+// are deleted.
+func deleteInBatches(db *gorm.DB, model any, batchSize int, query func() *gorm.DB, before func([]uint64) error) (int64, error) {
+	var deleted int64
+	for {
+		var ids []uint64
+		if err := query().Limit(batchSize).Pluck("id", &ids).Error; err != nil {
+			return deleted, err
+		}
+		if len(ids) == 0 {
+			return deleted, nil
+		}
+		if before != nil {
+			if err := before(ids); err != nil {
+				return deleted, err
+			}
+		}
+		res := db.Delete(model, ids)
+		if res.Error != nil {
+			return deleted, res.Error
+		}
+		deleted += res.RowsAffected
+	}
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrune(t *testing.T) {
+	db := setupTestDB(t)
+	dave := createActor(t, db, "dave", "example.com", true)
+	alice := createActor(t, db, "alice", "remote.example", false)
+	bob := createActor(t, db, "bob", "remote.example", false)
+	carol := createActor(t, db, "carol", "remote.example", false)
+	erin := createActor(t, db, "erin", "remote.example", false)
+
+	old := time.Now().AddDate(0, 0, -100)
+	stale := createStatus(t, db, alice, old)
+	recent := createStatus(t, db, alice, time.Now())
+	favourited := createStatus(t, db, alice, old.Add(time.Second))
+	local := createStatus(t, db, dave, old.Add(2*time.Second))
+	orphan := createStatus(t, db, bob, old.Add(3*time.Second))
+
+	_, err := NewReactions(db).Favourite(favourited, dave)
+	require.NoError(t, err)
+	_, err = NewRelationships(db).Follow(dave, carol)
+	require.NoError(t, err)
+	var instance Instance
+	require.NoError(t, db.Take(&instance, "domain = ?", "example.com").Error)
+	require.NoError(t, NewActorModerations(db).set(instance.ID, erin, "suspended_at", time.Now()))
+
+	// make the remote actors, and the conversations, old enough to be pruned.
+	require.NoError(t, db.Model(&Actor{}).Where("type = ?", "Person").UpdateColumn("updated_at", old).Error)
+	require.NoError(t, db.Model(&Conversation{}).Where("id > 0").UpdateColumn("created_at", old).Error)
+
+	stats, err := Prune(db, time.Now().AddDate(0, 0, -90), 2)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, stats.Statuses)
+	require.EqualValues(t, 1, stats.Actors)
+	require.EqualValues(t, 2, stats.Conversations)
+
+	var ids []uint64
+	require.NoError(t, db.Model(&Status{}).Order("id").Pluck("id", &ids).Error)
+	require.ElementsMatch(t, []uint64{uint64(recent.ID), uint64(favourited.ID), uint64(local.ID)}, ids)
+	require.Error(t, db.First(&Status{}, stale.ID).Error)
+	require.Error(t, db.First(&Status{}, orphan.ID).Error)
+
+	// bob has no statuses left, carol is followed by dave, erin is suspended.
+	require.Error(t, db.First(&Actor{}, bob.ID).Error)
+	require.NoError(t, db.First(&Actor{}, carol.ID).Error)
+	require.NoError(t, db.First(&Actor{}, erin.ID).Error)
+	require.NoError(t, db.First(&Actor{}, alice.ID).Error)
+}
+
+func TestPruneInteractions(t *testing.T) {
+	db := setupTestDB(t)
+	dave := createActor(t, db, "dave", "example.com", true)
+	alice := createActor(t, db, "alice", "remote.example", false)
+
+	old := time.Now().AddDate(0, 0, -100)
+	statuses := make(map[string]*Status)
+	for i, name := range []string{"replied", "reblogged", "mentioning", "bookmarked", "attached"} {
+		statuses[name] = createStatus(t, db, alice, old.Add(time.Duration(i)*time.Second))
+	}
+	reply := createStatus(t, db, dave, time.Now())
+	require.NoError(t, db.Model(reply).Update("in_reply_to_id", statuses["replied"].ID).Error)
+	reblog := createStatus(t, db, dave, time.Now().Add(time.Second))
+	require.NoError(t, db.Model(reblog).Update("reblog_id", statuses["reblogged"].ID).Error)
+	require.NoError(t, db.Create(&StatusMention{StatusID: statuses["mentioning"].ID, ActorID: dave.ID}).Error)
+	require.NoError(t, NewReactions(db).Bookmark(statuses["bookmarked"], dave))
+	require.NoError(t, db.Create(&StatusAttachment{
+		Attachment: Attachment{ID: snowflake.Now(), MediaType: "image/png", URL: "https://remote.example/a.png"},
+		StatusID:   statuses["attached"].ID,
+	}).Error)
+
+	stats, err := Prune(db, time.Now().AddDate(0, 0, -90), 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, stats.Statuses)
+	require.EqualValues(t, 1, stats.Attachments)
+	require.Error(t, db.First(&Status{}, statuses["attached"].ID).Error)
+	for _, name := range []string{"replied", "reblogged", "mentioning", "bookmarked"} {
+		require.NoError(t, db.First(&Status{}, statuses[name].ID).Error, name)
+	}
+}
+
+func TestPruneReports(t *testing.T) {
+	db := setupTestDB(t)
+	dave := createActor(t, db, "dave", "example.com", true)
+	frank := createActor(t, db, "frank", "remote.example", false)
+	grace := createActor(t, db, "grace", "other.example", false)
+
+	old := time.Now().AddDate(0, 0, -100)
+	reported := createStatus(t, db, frank, old)
+	unreported := createStatus(t, db, frank, old.Add(time.Second))
+	var instance Instance
+	require.NoError(t, db.Take(&instance, "domain = ?", dave.Domain).Error)
+	// grace, who has no statuses, reported frank to example.com.
+	require.NoError(t, db.Create(&Report{ID: snowflake.Now(), InstanceID: instance.ID, ActorID: grace.ID, TargetID: frank.ID, StatusIDs: []snowflake.ID{reported.ID}}).Error)
+	require.NoError(t, db.Model(&Actor{}).Where("type = ?", "Person").UpdateColumn("updated_at", old).Error)
+
+	stats, err := Prune(db, time.Now().AddDate(0, 0, -90), 10)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, stats.Statuses)
+	require.EqualValues(t, 0, stats.Actors)
+	require.NoError(t, db.First(&Status{}, reported.ID).Error)
+	require.Error(t, db.First(&Status{}, unreported.ID).Error)
+	require.NoError(t, db.First(&Actor{}, grace.ID).Error)
+}
@@ -33,6 +33,7 @@ func (r *Reaction) BeforeUpdate(tx *gorm.DB) error {
 	// if there is a conflict; eg. a follow then an unfollow before the follow is processed
 	// update the existing row to reflect the new action.
 	tx = tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "actor_id"}, {Name: "target_id"}},
 		UpdateAll: true,
 	})
 
@@ -81,14 +82,14 @@ type ReactionRequest struct {
 	CreatedAt time.Time
 	// UpdatedAt is the time the request was last updated.
 	UpdatedAt time.Time
-	ActorID   snowflake.ID `gorm:"uniqueIndex:idx_actor_id_target_id;not null;"`
+	ActorID   snowflake.ID `gorm:"uniqueIndex:idx_reaction_requests_actor_id_target_id;not null;"`
 	// Actor is the actor that is requesting the reaction change.
 	Actor    *Actor       `gorm:"constraint:OnDelete:CASCADE;"`
-	TargetID snowflake.ID `gorm:"uniqueIndex:idx_actor_id_target_id;not null;"`
+	TargetID snowflake.ID `gorm:"uniqueIndex:idx_reaction_requests_actor_id_target_id;not null;"`
 	// Target is the status that is being reacted to.
 	Target *Status `gorm:"constraint:OnDelete:CASCADE;"`
-	// Action is the action to perform, either follow or unfollow.
-	Action string `gorm:"type:enum('like', 'unlike');not null"`
+	// Action is the action to perform, either like or unlike.
+	Action string `gorm:"size:16;not null"`
 	// Attempts is the number of times the request has been attempted.
 	Attempts uint32 `gorm:"not null;default:0"`
 	// LastAttempt is the time the request was last attempted.
@@ -123,6 +124,15 @@ func (r *Reactions) Unpin(status *Status, actor *Actor) error {
 	return r.db.Model(reaction).Update("pinned", false).Error
 }
 
+func (r *Reactions) Bookmark(status *Status, actor *Actor) error {
+	reaction, err := r.findOrCreate(status, actor)
+	if err != nil {
+		return err
+	}
+	reaction.Bookmarked = true
+	return r.db.Model(reaction).Update("bookmarked", true).Error
+}
+
 func (r *Reactions) Favourite(status *Status, actor *Actor) (*Reaction, error) {
 	reaction, err := r.findOrCreate(status, actor)
 	if err != nil {
@@ -135,6 +145,24 @@ func (r *Reactions) Favourite(status *Status, actor *Actor) (*Reaction, error) {
 	return reaction, nil
 }
 
+// ImportFavourite marks status as favourited by actor without requesting the
+// like be sent to the status's server, for likes which have already been
+// delivered, such as those imported from an archive.
+func (r *Reactions) ImportFavourite(status *Status, actor *Actor) error {
+	reaction, err := r.findOrCreate(status, actor)
+	if err != nil {
+		return err
+	}
+	reaction.Favourited = true
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		// UpdateColumn skips the hooks which would queue a ReactionRequest.
+		if err := tx.Model(reaction).UpdateColumn("favourited", true).Error; err != nil {
+			return err
+		}
+		return reaction.updateStatusCount(tx)
+	})
+}
+
 func (r *Reactions) Unfavourite(status *Status, actor *Actor) (*Reaction, error) {
 	reaction, err := r.findOrCreate(status, actor)
 	if err != nil {
@@ -32,6 +32,7 @@ func (r *Relationship) BeforeUpdate(tx *gorm.DB) error {
 	// if there is a conflict; eg. a follow then an unfollow before the follow is processed
 	// update the existing row to reflect the new action.
 	tx = tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "actor_id"}, {Name: "target_id"}},
 		UpdateAll: true,
 	})
 
@@ -95,7 +96,7 @@ type RelationshipRequest struct {
 	// Target is the actor that is being followed or unfollowed.
 	Target *Actor `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
 	// Action is the action to perform, either follow or unfollow.
-	Action string `gorm:"type:enum('follow', 'unfollow');not null"`
+	Action string `gorm:"size:16;not null"`
 	// Attempts is the number of times the request has been attempted.
 	Attempts uint32 `gorm:"not null;default:0"`
 	// LastAttempt is the time the request was last attempted.
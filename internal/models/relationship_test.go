@@ -0,0 +1,39 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRelationshipsFollow(t *testing.T) {
+	db := setupTestDB(t)
+	dave := createActor(t, db, "dave", "example.com", true)
+	alice := createActor(t, db, "alice", "remote.example", false)
+
+	relationships := NewRelationships(db)
+	rel, err := relationships.Follow(dave, alice)
+	require.NoError(t, err)
+	require.True(t, rel.Following)
+
+	var inverse Relationship
+	require.NoError(t, db.First(&inverse, "actor_id = ? and target_id = ?", alice.ID, dave.ID).Error)
+	require.True(t, inverse.FollowedBy)
+
+	var request RelationshipRequest
+	require.NoError(t, db.First(&request, "actor_id = ? and target_id = ?", dave.ID, alice.ID).Error)
+	require.Equal(t, "follow", request.Action)
+
+	require.NoError(t, db.First(dave, dave.ID).Error)
+	require.NoError(t, db.First(alice, alice.ID).Error)
+	require.EqualValues(t, 1, dave.FollowingCount)
+	require.EqualValues(t, 1, alice.FollowersCount)
+
+	// unfollowing before the follow is delivered replaces the pending request.
+	_, err = relationships.Unfollow(dave, alice)
+	require.NoError(t, err)
+	var requests []RelationshipRequest
+	require.NoError(t, db.Find(&requests, "actor_id = ? and target_id = ?", dave.ID, alice.ID).Error)
+	require.Len(t, requests, 1)
+	require.Equal(t, "unfollow", requests[0].Action)
+}
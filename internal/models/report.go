@@ -0,0 +1,143 @@
+package models
+
+import (
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+)
+
+// A Report is a report of an actor, and optionally some of their statuses,
+// to the moderators of an Instance.
+// A Report belongs to an Instance.
+// A Report belongs to the Actor who made it, and the Actor it reports.
+type Report struct {
+	snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	UpdatedAt    time.Time
+	InstanceID   snowflake.ID `gorm:"not null;index"`
+	Instance     *Instance    `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	ActorID      snowflake.ID `gorm:"not null"`
+	Actor        *Actor       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TargetID     snowflake.ID `gorm:"not null"`
+	Target       *Actor       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// Category is one of spam, legal, violation, or other.
+	Category string `gorm:"size:16;not null;default:'other'"`
+	Comment  string `gorm:"size:1000;not null;default:''"`
+	// StatusIDs are the IDs of the reported statuses of Target.
+	StatusIDs []snowflake.ID `gorm:"serializer:json"`
+	// Forwarded is true if the report was sent to the Target's server.
+	Forwarded bool `gorm:"not null;default:false"`
+	// URI is the id of the Flag activity which forwarded the report, either
+	// received from a remote server, or sent to the Target's server.
+	URI string `gorm:"size:255;not null;default:'';index"`
+	// ActionTakenAt is the time the report was resolved, nil if it is open.
+	ActionTakenAt   *time.Time
+	ActionTakenByID *snowflake.ID
+	ActionTakenBy   *Account `gorm:"constraint:OnDelete:SET NULL;<-:false;"`
+}
+
+// IsResolved returns true if the report has been resolved.
+func (r *Report) IsResolved() bool {
+	return r.ActionTakenAt != nil
+}
+
+type Reports struct {
+	db *gorm.DB
+}
+
+func NewReports(db *gorm.DB) *Reports {
+	return &Reports{db: db}
+}
+
+// NewReport holds the details of a report to create.
+type NewReport struct {
+	Category  string
+	Comment   string
+	StatusIDs []snowflake.ID
+	// Forward is true if the report should be forwarded to the server of
+	// a remote Target.
+	Forward bool
+	// URI is the id of the Flag activity of a report received from a
+	// remote server.
+	URI string
+}
+
+// Create creates a report, made by actor, of target to the moderators of
+// instance. If params.Forward is true and target is remote, the report is
+// queued to be forwarded to the target's server.
+func (r *Reports) Create(instance *Instance, actor, target *Actor, params NewReport) (*Report, error) {
+	report := &Report{
+		ID:         snowflake.Now(),
+		InstanceID: instance.ID,
+		ActorID:    actor.ID,
+		Actor:      actor,
+		TargetID:   target.ID,
+		Target:     target,
+		Category:   params.Category,
+		Comment:    params.Comment,
+		StatusIDs:  params.StatusIDs,
+		URI:        params.URI,
+	}
+	if report.Category == "" {
+		report.Category = "other"
+	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(report).Error; err != nil {
+			return err
+		}
+		if !params.Forward || target.IsLocal() {
+			return nil
+		}
+		return tx.Create(&ReportRequest{ReportID: report.ID}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Resolve marks the report as resolved by account.
+func (r *Reports) Resolve(report *Report, account *Account) error {
+	now := time.Now()
+	if err := r.db.Model(report).Updates(map[string]any{
+		"action_taken_at":    now,
+		"action_taken_by_id": account.ID,
+	}).Error; err != nil {
+		return err
+	}
+	report.ActionTakenAt = &now
+	report.ActionTakenByID = &account.ID
+	return nil
+}
+
+// Reopen marks the resolved report as open.
+func (r *Reports) Reopen(report *Report) error {
+	if err := r.db.Model(report).Updates(map[string]any{
+		"action_taken_at":    nil,
+		"action_taken_by_id": nil,
+	}).Error; err != nil {
+		return err
+	}
+	report.ActionTakenAt = nil
+	report.ActionTakenByID = nil
+	return nil
+}
+
+// A ReportRequest is a request to forward a Report to the server of its
+// Target.
+type ReportRequest struct {
+	ID uint32 `gorm:"primarykey;"`
+	// CreatedAt is the time the request was created.
+	CreatedAt time.Time
+	// UpdatedAt is the time the request was last updated.
+	UpdatedAt time.Time
+	ReportID  snowflake.ID `gorm:"uniqueIndex;not null;"`
+	// Report is the report to forward.
+	Report *Report `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// Attempts is the number of times the request has been attempted.
+	Attempts uint32 `gorm:"not null;default:0"`
+	// LastAttempt is the time the request was last attempted.
+	LastAttempt time.Time
+	// LastResult is the result of the last attempt if it failed.
+	LastResult string `gorm:"size:255;not null;default:''"`
+}
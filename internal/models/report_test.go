@@ -0,0 +1,41 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportsCreate(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	var instance Instance
+	require.NoError(t, db.Take(&instance, "domain = ?", "example.com").Error)
+	carol := createActor(t, db, "carol", "example.com", true)
+	bob := createActor(t, db, "bob", "remote.example", false)
+	status := createStatus(t, db, bob, time.Now())
+
+	reports := NewReports(db)
+	report, err := reports.Create(&instance, alice, bob, NewReport{
+		Comment:   "spam",
+		StatusIDs: []snowflake.ID{status.ID},
+		Forward:   true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "other", report.Category)
+
+	var got Report
+	require.NoError(t, db.Take(&got, report.ID).Error)
+	require.Equal(t, []snowflake.ID{status.ID}, got.StatusIDs)
+	var request ReportRequest
+	require.NoError(t, db.Take(&request, "report_id = ?", report.ID).Error)
+
+	// reports of local actors are never forwarded.
+	report, err = reports.Create(&instance, alice, carol, NewReport{Category: "spam", Forward: true})
+	require.NoError(t, err)
+	var count int64
+	require.NoError(t, db.Model(&ReportRequest{}).Where("report_id = ?", report.ID).Count(&count).Error)
+	require.Zero(t, count)
+}
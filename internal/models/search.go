@@ -0,0 +1,170 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxTermLength is the length, in bytes, of the longest indexed term.
+// Longer words, usually URLs or base64, are not indexed.
+const maxTermLength = 64
+
+// A StatusTerm is a word in the Note of a Status. The terms of a Status are
+// maintained by its hooks, and are used to search statuses without an
+// external search service.
+type StatusTerm struct {
+	StatusID snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Status   *Status      `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Term     string       `gorm:"primarykey;size:64;index"`
+}
+
+// updateTerms replaces the indexed terms of the status with the words of its
+// Note.
+func (st *Status) updateTerms(tx *gorm.DB) error {
+	if err := tx.Where("status_id = ?", st.ID).Delete(&StatusTerm{}).Error; err != nil {
+		return err
+	}
+	terms := Terms(st.Note)
+	if len(terms) == 0 {
+		return nil
+	}
+	rows := make([]StatusTerm, 0, len(terms))
+	for _, term := range terms {
+		rows = append(rows, StatusTerm{StatusID: st.ID, Term: term})
+	}
+	return tx.Create(&rows).Error
+}
+
+// Terms returns the distinct, lower cased, words of s, with any HTML removed.
+func Terms(s string) []string {
+	var terms []string
+	seen := make(map[string]bool)
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return terms
+		case html.TextToken:
+			words := strings.FieldsFunc(strings.ToLower(string(z.Text())), func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+			})
+			for _, word := range words {
+				if utf8.RuneCountInString(word) < 2 || len(word) > maxTermLength || seen[word] {
+					continue
+				}
+				seen[word] = true
+				terms = append(terms, word)
+			}
+		}
+	}
+}
+
+// SearchStatuses returns a scope which matches the statuses containing every
+// word of q.
+func SearchStatuses(q string) func(*gorm.DB) *gorm.DB {
+	terms := Terms(q)
+	return func(db *gorm.DB) *gorm.DB {
+		if len(terms) == 0 {
+			return db.Where("1 = 0")
+		}
+		matches := db.Session(&gorm.Session{NewDB: true}).Model(&StatusTerm{}).
+			Select("status_id").
+			Where("term IN (?)", terms).
+			Group("status_id").
+			Having("COUNT(*) = ?", len(terms))
+		return db.Where("statuses.id IN (?)", matches)
+	}
+}
+
+// SearchActors returns a scope which matches the actors whose name, display
+// name, or domain starts with q. If q is of the form name@domain, the name and
+// domain of the actor must both start with their part of q.
+func SearchActors(q string) func(*gorm.DB) *gorm.DB {
+	q = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(q), "@"))
+	name, domain, acct := strings.Cut(q, "@")
+	return func(db *gorm.DB) *gorm.DB {
+		if acct {
+			return db.Where("LOWER(actors.name) LIKE ? ESCAPE '!' AND LOWER(actors.domain) LIKE ? ESCAPE '!'", EscapeLike(name)+"%", EscapeLike(domain)+"%")
+		}
+		prefix := EscapeLike(q) + "%"
+		return db.Where("LOWER(actors.name) LIKE ? ESCAPE '!' OR LOWER(actors.display_name) LIKE ? ESCAPE '!' OR LOWER(actors.domain) LIKE ? ESCAPE '!'", prefix, prefix, prefix)
+	}
+}
+
+// RankActors returns a scope which orders actors for autocompletion; the
+// actors followed by viewer first, then the actors of domain, then by their
+// number of followers. If viewer is nil, only domain and followers are ranked.
+func RankActors(viewer *Actor, domain string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		var viewerID snowflake.ID
+		if viewer != nil {
+			viewerID = viewer.ID
+		}
+		following := db.Session(&gorm.Session{NewDB: true}).Model(&Relationship{}).Select("target_id").Where("actor_id = ? AND following = true", viewerID)
+		return db.Clauses(clause.OrderBy{
+			Expression: clause.Expr{
+				SQL:                "CASE WHEN actors.id IN (?) THEN 0 ELSE 1 END, CASE WHEN actors.domain = ? THEN 0 ELSE 1 END, actors.followers_count DESC, actors.id",
+				Vars:               []any{following, domain},
+				WithoutParentheses: true,
+			},
+		})
+	}
+}
+
+// Blocked returns a query selecting the IDs of the actors who block, or are
+// blocked by, actor. If muted is true, the actors muted by actor are also
+// selected.
+func Blocked(db *gorm.DB, actor *Actor, muted bool) *gorm.DB {
+	if muted {
+		return db.Model(&Relationship{}).Select("target_id").Where("actor_id = ? AND (blocking = true OR blocked_by = true OR muting = true)", actor.ID)
+	}
+	return db.Model(&Relationship{}).Select("target_id").Where("actor_id = ? AND (blocking = true OR blocked_by = true)", actor.ID)
+}
+
+// SearchTags returns a scope which matches the tags whose name starts with q.
+func SearchTags(q string) func(*gorm.DB) *gorm.DB {
+	q = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(q), "#"))
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("LOWER(tags.name) LIKE ? ESCAPE '!'", EscapeLike(q)+"%")
+	}
+}
+
+// StatusesVisibleTo returns a scope which matches the statuses actor may see;
+// public and unlisted statuses, the actor's own statuses, followers only
+// statuses of the actors they follow, and statuses which mention them.
+// Statuses of actors who block, or are blocked by, actor are excluded.
+// If actor is nil, only public and unlisted statuses are matched.
+func StatusesVisibleTo(actor *Actor) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if actor == nil {
+			return db.Where("statuses.visibility IN (?)", []string{"public", "unlisted"})
+		}
+		subquery := db.Session(&gorm.Session{NewDB: true})
+		following := subquery.Model(&Relationship{}).Select("target_id").Where("actor_id = ? AND following = true", actor.ID)
+		blocks := Blocked(subquery, actor, false)
+		mentions := subquery.Model(&StatusMention{}).Select("status_id").Where("actor_id = ?", actor.ID)
+		return db.Where("statuses.visibility IN (?) OR statuses.actor_id = ? OR (statuses.visibility = ? AND statuses.actor_id IN (?)) OR statuses.id IN (?)",
+			[]string{"public", "unlisted"}, actor.ID, "private", following, mentions).
+			Where("statuses.actor_id NOT IN (?)", blocks)
+	}
+}
+
+// IndexStatuses indexes the terms of every status, for statuses created before
+// the terms were maintained by their hooks.
+func IndexStatuses(tx *gorm.DB) error {
+	var statuses []*Status
+	return tx.Select("id", "note").FindInBatches(&statuses, 100, func(tx *gorm.DB, batch int) error {
+		for _, st := range statuses {
+			if err := st.updateTerms(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerms(t *testing.T) {
+	require.Equal(t, []string{"hello", "wörld", "42", "pub"},
+		Terms(`<p>Hello, <a href="https://example.com/tags/pub">WÖRLD</a> a 42 hello</p><p>#pub</p>`))
+	require.Empty(t, Terms(""))
+}
+
+func TestSearchStatuses(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	bob := createActor(t, db, "bob", "remote.example", false)
+
+	first := createStatus(t, db, alice, time.Now().Add(-time.Minute))
+	second := createStatus(t, db, bob, time.Now())
+	require.NoError(t, db.Model(first).Update("visibility", "private").Error)
+	// a partial update does not change the terms.
+	require.NoError(t, db.Model(&Status{ID: first.ID}).Update("replies_count", 1).Error)
+
+	search := func(viewer *Actor, q string) []snowflake.ID {
+		var ids []snowflake.ID
+		require.NoError(t, db.Model(&Status{}).Scopes(SearchStatuses(q), StatusesVisibleTo(viewer)).Order("statuses.id").Pluck("statuses.id", &ids).Error)
+		return ids
+	}
+	require.Equal(t, []snowflake.ID{first.ID, second.ID}, search(alice, "Hello"))
+	require.Equal(t, []snowflake.ID{second.ID}, search(bob, "hello"))
+	require.Equal(t, []snowflake.ID{second.ID}, search(nil, "hello"))
+	require.Empty(t, search(alice, "hello world"))
+	require.Empty(t, search(alice, ""))
+
+	// editing the note reindexes the status.
+	require.NoError(t, NewStatuses(db).Edit(second, "<p>hello world</p>", time.Now()))
+	require.Equal(t, []snowflake.ID{second.ID}, search(alice, "world hello"))
+
+	// bob can see alice's followers only status once they follow alice.
+	require.NoError(t, db.Create(&Relationship{ActorID: bob.ID, TargetID: alice.ID, Following: true}).Error)
+	require.Equal(t, []snowflake.ID{first.ID, second.ID}, search(bob, "hello"))
+
+	// blocked actors' statuses are hidden.
+	require.NoError(t, db.Create(&Relationship{ActorID: alice.ID, TargetID: bob.ID, Blocking: true}).Error)
+	require.Equal(t, []snowflake.ID{first.ID}, search(alice, "hello"))
+
+	// deleting a status removes its terms.
+	require.NoError(t, db.Delete(second).Error)
+	var count int64
+	require.NoError(t, db.Model(&StatusTerm{}).Where("status_id = ?", second.ID).Count(&count).Error)
+	require.Zero(t, count)
+}
+
+func TestSearchActors(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	bob := createActor(t, db, "bob", "remote.example", false)
+	require.NoError(t, db.Model(bob).Update("display_name", "Robert").Error)
+
+	search := func(q string) []snowflake.ID {
+		var ids []snowflake.ID
+		require.NoError(t, db.Model(&Actor{}).Scopes(SearchActors(q)).Order("id").Pluck("id", &ids).Error)
+		return ids
+	}
+	require.Equal(t, []snowflake.ID{alice.ID}, search("Ali"))
+	require.Equal(t, []snowflake.ID{bob.ID}, search("rob"))
+	require.Equal(t, []snowflake.ID{bob.ID}, search("remote"))
+	require.Equal(t, []snowflake.ID{alice.ID}, search("@alice@example"))
+	require.Empty(t, search("alice@remote"))
+	require.Empty(t, search("a%"))
+}
+
+func TestRankActors(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	popular := createActor(t, db, "ann", "remote.example", false)
+	require.NoError(t, db.Model(popular).Update("followers_count", 100).Error)
+	followed := createActor(t, db, "anna", "other.example", false)
+	local := createActor(t, db, "annie", "example.com", true)
+	require.NoError(t, db.Create(&Relationship{ActorID: alice.ID, TargetID: followed.ID, Following: true}).Error)
+
+	rank := func(viewer *Actor) []snowflake.ID {
+		var ids []snowflake.ID
+		require.NoError(t, db.Model(&Actor{}).Scopes(SearchActors("ann"), RankActors(viewer, "example.com")).Pluck("id", &ids).Error)
+		return ids
+	}
+	require.Equal(t, []snowflake.ID{followed.ID, local.ID, popular.ID}, rank(alice))
+	require.Equal(t, []snowflake.ID{local.ID, popular.ID, followed.ID}, rank(nil))
+}
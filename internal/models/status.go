@@ -23,7 +23,7 @@ type Status struct {
 	InReplyToActorID *snowflake.ID
 	Sensitive        bool
 	SpoilerText      string `gorm:"size:128"`
-	Visibility       string `gorm:"type:enum('public', 'unlisted', 'private', 'direct', 'limited')"`
+	Visibility       string `gorm:"size:16"`
 	Language         string `gorm:"size:2"`
 	Note             string
 	URI              string `gorm:"uniqueIndex;size:128"`
@@ -39,7 +39,7 @@ type Status struct {
 }
 
 func (st *Status) AfterCreate(tx *gorm.DB) error {
-	return forEach(tx, st.updateStatusCount, st.updateRepliesCount)
+	return forEach(tx, st.updateStatusCount, st.updateRepliesCount, st.updateTerms)
 }
 
 // updateRepliesCount updates the replies_count field on the status.
@@ -118,6 +118,22 @@ func (s *Statuses) FindOrCreate(uri string, createFn func(string) (*Status, erro
 	return status, nil
 }
 
+// Edit replaces the note of the status, as edited at updatedAt, and reindexes
+// its terms.
+func (s *Statuses) Edit(status *Status, note string, updatedAt time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(status).UpdateColumns(map[string]any{
+			"note":       note,
+			"updated_at": updatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		status.Note = note
+		status.UpdatedAt = updatedAt
+		return status.updateTerms(tx)
+	})
+}
+
 func (s *Statuses) FindByURI(uri string) (*Status, error) {
 	// use find to avoid the not found error on empty result
 	var status []Status
@@ -1,6 +1,245 @@
 package models
 
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"gorm.io/gorm"
+)
+
 type Tag struct {
 	ID   uint32 `gorm:"primaryKey"`
 	Name string `gorm:"size:64;uniqueIndex"`
 }
+
+// BeforeCreate finds, or creates, the tag of the status tag by its name, as
+// the tags of a status are only known by their names.
+func (st *StatusTag) BeforeCreate(tx *gorm.DB) error {
+	if st.TagID != 0 || st.Tag == nil {
+		return nil
+	}
+	tag, err := NewTags(tx).FindOrCreate(st.Tag.Name)
+	if err != nil {
+		return err
+	}
+	st.Tag = tag
+	st.TagID = tag.ID
+	return nil
+}
+
+// A FollowedTag is a Tag followed by an Actor, the statuses carrying the tag
+// are shown on the actor's home timeline.
+type FollowedTag struct {
+	ID      snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	ActorID snowflake.ID `gorm:"uniqueIndex:idx_followed_tags_actor_id_tag_id;not null"`
+	Actor   *Actor       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TagID   uint32       `gorm:"uniqueIndex:idx_followed_tags_actor_id_tag_id;not null"`
+	Tag     *Tag         `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+}
+
+// MaxFeaturedTags is the number of tags an actor may feature on their profile.
+const MaxFeaturedTags = 10
+
+// A FeaturedTag is a Tag featured on the profile of an Actor.
+type FeaturedTag struct {
+	ID      snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	ActorID snowflake.ID `gorm:"uniqueIndex:idx_featured_tags_actor_id_tag_id;not null"`
+	Actor   *Actor       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TagID   uint32       `gorm:"uniqueIndex:idx_featured_tags_actor_id_tag_id;not null"`
+	Tag     *Tag         `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// StatusesCount and LastStatusAt are the number of statuses of the actor
+	// carrying the tag, and the time of the latest. They are not stored, see
+	// Tags.FeaturedStats.
+	StatusesCount int        `gorm:"-"`
+	LastStatusAt  *time.Time `gorm:"-"`
+}
+
+// ErrTooManyFeaturedTags is returned when an actor features more than
+// MaxFeaturedTags tags.
+var ErrTooManyFeaturedTags = errors.New("too many featured tags")
+
+// A TagUse is the use of a Tag on one day.
+type TagUse struct {
+	// Day is the start of the day, in UTC.
+	Day time.Time
+	// Uses is the number of statuses carrying the tag.
+	Uses int
+	// Accounts is the number of actors who used the tag.
+	Accounts int
+}
+
+type Tags struct {
+	db *gorm.DB
+}
+
+func NewTags(db *gorm.DB) *Tags {
+	return &Tags{db: db}
+}
+
+// FindByName returns the tag called name, ignoring case.
+func (t *Tags) FindByName(name string) (*Tag, error) {
+	var tag Tag
+	if err := t.db.Where("LOWER(name) = LOWER(?)", strings.TrimPrefix(name, "#")).Take(&tag).Error; err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// FindOrCreate returns the tag called name, ignoring case, creating it if it
+// does not exist.
+func (t *Tags) FindOrCreate(name string) (*Tag, error) {
+	tag, err := t.FindByName(name)
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return tag, err
+	}
+	tag = &Tag{Name: strings.TrimPrefix(name, "#")}
+	if err := t.db.Create(tag).Error; err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+// History returns the daily use of the tag for the last days, starting with
+// today.
+func (t *Tags) History(tag *Tag, days int) ([]TagUse, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	since := today.AddDate(0, 0, 1-days)
+	var rows []struct {
+		StatusID snowflake.ID
+		ActorID  snowflake.ID
+	}
+	if err := t.db.Model(&StatusTag{}).Select("status_tags.status_id, statuses.actor_id").
+		Joins("JOIN statuses ON statuses.id = status_tags.status_id").
+		Where("status_tags.tag_id = ? AND status_tags.status_id >= ?", tag.ID, snowflake.TimeToID(since)).
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	history := make([]TagUse, days)
+	accounts := make([]map[snowflake.ID]bool, days)
+	for i := range history {
+		history[i].Day = today.AddDate(0, 0, -i)
+		accounts[i] = make(map[snowflake.ID]bool)
+	}
+	for _, row := range rows {
+		i := int(today.Sub(row.StatusID.ToTime().UTC().Truncate(24*time.Hour)) / (24 * time.Hour))
+		if i < 0 || i >= days {
+			continue
+		}
+		history[i].Uses++
+		accounts[i][row.ActorID] = true
+	}
+	for i := range history {
+		history[i].Accounts = len(accounts[i])
+	}
+	return history, nil
+}
+
+// IsFollowing returns true if actor follows tag.
+func (t *Tags) IsFollowing(actor *Actor, tag *Tag) (bool, error) {
+	var count int64
+	if err := t.db.Model(&FollowedTag{}).Where("actor_id = ? AND tag_id = ?", actor.ID, tag.ID).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Follow adds the statuses carrying tag to the home timeline of actor.
+func (t *Tags) Follow(actor *Actor, tag *Tag) error {
+	following, err := t.IsFollowing(actor, tag)
+	if err != nil || following {
+		return err
+	}
+	return t.db.Create(&FollowedTag{
+		ID:      snowflake.Now(),
+		ActorID: actor.ID,
+		TagID:   tag.ID,
+	}).Error
+}
+
+// Unfollow removes the statuses carrying tag from the home timeline of actor.
+func (t *Tags) Unfollow(actor *Actor, tag *Tag) error {
+	return t.db.Where("actor_id = ? AND tag_id = ?", actor.ID, tag.ID).Delete(&FollowedTag{}).Error
+}
+
+// Feature features tag on the profile of actor. If the tag is already
+// featured, the existing FeaturedTag is returned.
+func (t *Tags) Feature(actor *Actor, tag *Tag) (*FeaturedTag, error) {
+	var featured FeaturedTag
+	err := t.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Preload("Tag").Where("actor_id = ? AND tag_id = ?", actor.ID, tag.ID).Take(&featured).Error
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&FeaturedTag{}).Where("actor_id = ?", actor.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count >= MaxFeaturedTags {
+			return ErrTooManyFeaturedTags
+		}
+		featured = FeaturedTag{
+			ID:      snowflake.Now(),
+			ActorID: actor.ID,
+			TagID:   tag.ID,
+			Tag:     tag,
+		}
+		return tx.Create(&featured).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &featured, t.FeaturedStats(&featured)
+}
+
+// Featured returns the tags featured by actor, with their stats.
+func (t *Tags) Featured(actor *Actor) ([]*FeaturedTag, error) {
+	var featured []*FeaturedTag
+	if err := t.db.Preload("Tag").Where("actor_id = ?", actor.ID).Order("id").Find(&featured).Error; err != nil {
+		return nil, err
+	}
+	for _, f := range featured {
+		if err := t.FeaturedStats(f); err != nil {
+			return nil, err
+		}
+	}
+	return featured, nil
+}
+
+// FeaturedStats sets the StatusesCount and LastStatusAt of featured from the
+// statuses of its actor carrying its tag.
+func (t *Tags) FeaturedStats(featured *FeaturedTag) error {
+	var stats struct {
+		Count  int
+		LastID *snowflake.ID
+	}
+	if err := t.db.Model(&StatusTag{}).Select("COUNT(*) AS count, MAX(status_tags.status_id) AS last_id").
+		Joins("JOIN statuses ON statuses.id = status_tags.status_id").
+		Where("status_tags.tag_id = ? AND statuses.actor_id = ?", featured.TagID, featured.ActorID).
+		Scan(&stats).Error; err != nil {
+		return err
+	}
+	featured.StatusesCount = stats.Count
+	featured.LastStatusAt = nil
+	if stats.LastID != nil {
+		last := stats.LastID.ToTime()
+		featured.LastStatusAt = &last
+	}
+	return nil
+}
+
+// Suggested returns the tags most used by actor which are not featured.
+func (t *Tags) Suggested(actor *Actor, limit int) ([]*Tag, error) {
+	var tags []*Tag
+	err := t.db.Model(&Tag{}).
+		Joins("JOIN status_tags ON status_tags.tag_id = tags.id").
+		Joins("JOIN statuses ON statuses.id = status_tags.status_id").
+		Where("statuses.actor_id = ?", actor.ID).
+		Where("tags.id NOT IN (?)", t.db.Model(&FeaturedTag{}).Select("tag_id").Where("actor_id = ?", actor.ID)).
+		Group("tags.id, tags.name").
+		Order("COUNT(*) DESC").Order("tags.name").
+		Limit(limit).
+		Find(&tags).Error
+	return tags, err
+}
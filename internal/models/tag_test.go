@@ -0,0 +1,92 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusTagsFindOrCreateTag(t *testing.T) {
+	db := setupTestDB(t)
+	bob := createActor(t, db, "bob", "remote.example", false)
+	first := createStatus(t, db, bob, time.Now().Add(-time.Minute))
+	second := createStatus(t, db, bob, time.Now())
+	// tags arrive by name, the second status reuses the tag of the first.
+	require.NoError(t, db.Create(&StatusTag{StatusID: first.ID, Tag: &Tag{Name: "golang"}}).Error)
+	require.NoError(t, db.Create(&StatusTag{StatusID: second.ID, Tag: &Tag{Name: "GoLang"}}).Error)
+
+	var tags []Tag
+	require.NoError(t, db.Find(&tags).Error)
+	require.Len(t, tags, 1)
+	var count int64
+	require.NoError(t, db.Model(&StatusTag{}).Where("tag_id = ?", tags[0].ID).Count(&count).Error)
+	require.EqualValues(t, 2, count)
+}
+
+func TestTagsHistory(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	bob := createActor(t, db, "bob", "remote.example", false)
+	tags := NewTags(db)
+	tag, err := tags.FindOrCreate("#golang")
+	require.NoError(t, err)
+	require.Equal(t, "golang", tag.Name)
+
+	now := time.Now()
+	for _, st := range []*Status{
+		createStatus(t, db, alice, now),
+		createStatus(t, db, bob, now.Add(-time.Second)),
+		createStatus(t, db, bob, now.Add(-2*time.Second)),
+		createStatus(t, db, bob, now.AddDate(0, 0, -2)),
+		createStatus(t, db, bob, now.AddDate(0, 0, -10)),
+	} {
+		require.NoError(t, db.Create(&StatusTag{StatusID: st.ID, TagID: tag.ID}).Error)
+	}
+	history, err := tags.History(tag, 7)
+	require.NoError(t, err)
+	require.Len(t, history, 7)
+	require.Equal(t, now.UTC().Truncate(24*time.Hour), history[0].Day)
+	require.Equal(t, 3, history[0].Uses+history[1].Uses)
+	require.Equal(t, 1, history[2].Uses+history[3].Uses)
+	for _, use := range history[4:] {
+		require.Zero(t, use.Uses)
+	}
+}
+
+func TestTagsFeature(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	tags := NewTags(db)
+	tag, err := tags.FindOrCreate("golang")
+	require.NoError(t, err)
+	st := createStatus(t, db, alice, time.Now())
+	require.NoError(t, db.Create(&StatusTag{StatusID: st.ID, TagID: tag.ID}).Error)
+
+	suggested, err := tags.Suggested(alice, MaxFeaturedTags)
+	require.NoError(t, err)
+	require.Len(t, suggested, 1)
+
+	featured, err := tags.Feature(alice, tag)
+	require.NoError(t, err)
+	require.Equal(t, 1, featured.StatusesCount)
+	require.NotNil(t, featured.LastStatusAt)
+	again, err := tags.Feature(alice, tag)
+	require.NoError(t, err)
+	require.Equal(t, featured.ID, again.ID)
+
+	suggested, err = tags.Suggested(alice, MaxFeaturedTags)
+	require.NoError(t, err)
+	require.Empty(t, suggested)
+
+	for i := 1; i < MaxFeaturedTags; i++ {
+		tag, err := tags.FindOrCreate(string(rune('a'+i)) + "tag")
+		require.NoError(t, err)
+		_, err = tags.Feature(alice, tag)
+		require.NoError(t, err)
+	}
+	tag, err = tags.FindOrCreate("onetoomany")
+	require.NoError(t, err)
+	_, err = tags.Feature(alice, tag)
+	require.ErrorIs(t, err, ErrTooManyFeaturedTags)
+}
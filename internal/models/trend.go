@@ -0,0 +1,342 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"golang.org/x/net/html"
+	"gorm.io/gorm"
+)
+
+// The kinds of Trend.
+const (
+	TrendTag    = "tag"
+	TrendStatus = "status"
+	TrendLink   = "link"
+)
+
+// MinTrendAccounts is the number of distinct actors who must use a tag or
+// link, or react to a status, for it to trend.
+const MinTrendAccounts = 2
+
+// A Trend is a tag, status, or link whose recent use is rising. Trends are
+// computed for the whole server by UpdateTrends, and each Instance decides
+// which it shows with a TrendReview.
+type Trend struct {
+	ID        uint32 `gorm:"primarykey"`
+	UpdatedAt time.Time
+	Kind      string        `gorm:"size:16;not null;index"`
+	TagID     *uint32       `gorm:"index"`
+	Tag       *Tag          `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	StatusID  *snowflake.ID `gorm:"index"`
+	Status    *Status       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	// URL is the URL of a link.
+	URL string `gorm:"size:255;not null;default:''"`
+	// Score is the use of the trend over the window, each use decaying with
+	// its age. A score of zero is no longer trending.
+	Score float64 `gorm:"not null;default:0"`
+	// Uses is the number of statuses which used the tag or link, or the
+	// number of favourites and reblogs of the status, in the window.
+	Uses int `gorm:"not null;default:0"`
+	// Accounts is the number of distinct actors who made those uses.
+	Accounts int `gorm:"not null;default:0"`
+}
+
+// A TrendReview records whether the admins of an Instance approved, or
+// rejected, a Trend.
+type TrendReview struct {
+	InstanceID snowflake.ID `gorm:"primarykey;autoIncrement:false"`
+	Instance   *Instance    `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	TrendID    uint32       `gorm:"primarykey;autoIncrement:false"`
+	Trend      *Trend       `gorm:"constraint:OnDelete:CASCADE;<-:false;"`
+	Approved   bool         `gorm:"not null"`
+	ReviewedAt time.Time    `gorm:"not null"`
+}
+
+// Describe returns the tag, status URI, or URL of the trend.
+func (t *Trend) Describe() string {
+	switch {
+	case t.Tag != nil:
+		return "#" + t.Tag.Name
+	case t.Status != nil:
+		return t.Status.URI
+	case t.StatusID != nil:
+		return fmt.Sprint(*t.StatusID)
+	default:
+		return t.URL
+	}
+}
+
+// trendKey identifies the item of a trend.
+type trendKey struct {
+	kind     string
+	tagID    uint32
+	statusID snowflake.ID
+	url      string
+}
+
+func (t *Trend) key() trendKey {
+	k := trendKey{kind: t.Kind, url: t.URL}
+	if t.TagID != nil {
+		k.tagID = *t.TagID
+	}
+	if t.StatusID != nil {
+		k.statusID = *t.StatusID
+	}
+	return k
+}
+
+// trendUse accumulates the decayed uses of a trend.
+type trendUse struct {
+	uses     int
+	accounts map[snowflake.ID]float64
+}
+
+func (u *trendUse) add(actorID snowflake.ID, weight float64) {
+	u.uses++
+	// each actor counts once, for their most recent use.
+	u.accounts[actorID] = math.Max(u.accounts[actorID], weight)
+}
+
+func (u *trendUse) score() float64 {
+	var score float64
+	for _, weight := range u.accounts {
+		score += weight
+	}
+	return score
+}
+
+// UpdateTrends scores the tags, statuses, and links used by public statuses
+// created in the window before now. Each use decays by half every halfLife.
+// Trends which are no longer used have their score set to zero, and are
+// deleted unless an instance has reviewed them.
+func UpdateTrends(db *gorm.DB, now time.Time, window, halfLife time.Duration) error {
+	since := snowflake.TimeToID(now.Add(-window))
+	decay := func(id snowflake.ID) float64 {
+		return math.Exp2(-now.Sub(id.ToTime()).Hours() / halfLife.Hours())
+	}
+	uses := make(map[trendKey]*trendUse)
+	use := func(k trendKey, actorID snowflake.ID, weight float64) {
+		u, ok := uses[k]
+		if !ok {
+			u = &trendUse{accounts: make(map[snowflake.ID]float64)}
+			uses[k] = u
+		}
+		u.add(actorID, weight)
+	}
+	// trending statuses are public, not replies or reblogs, by actors who
+	// are not silenced or suspended. Trends are shared by the instances on
+	// this server, so an actor moderated by any instance is excluded.
+	moderated := db.Model(&ActorModeration{}).Select("actor_id").Where("suspended_at IS NOT NULL OR silenced_at IS NOT NULL")
+	public := func(db *gorm.DB) *gorm.DB {
+		return db.Joins("JOIN actors ON actors.id = statuses.actor_id").
+			Where("statuses.id >= ? AND statuses.visibility = ? AND statuses.reblog_id IS NULL AND statuses.in_reply_to_id IS NULL", since, "public").
+			Where("actors.id NOT IN (?)", moderated)
+	}
+
+	var tags []struct {
+		TagID    uint32
+		StatusID snowflake.ID
+		ActorID  snowflake.ID
+	}
+	if err := db.Model(&StatusTag{}).Select("status_tags.tag_id, statuses.id AS status_id, statuses.actor_id").
+		Joins("JOIN statuses ON statuses.id = status_tags.status_id").
+		Scopes(public).Scan(&tags).Error; err != nil {
+		return err
+	}
+	for _, t := range tags {
+		use(trendKey{kind: TrendTag, tagID: t.TagID}, t.ActorID, decay(t.StatusID))
+	}
+
+	var reactions []struct {
+		StatusID snowflake.ID
+		ActorID  snowflake.ID
+	}
+	if err := db.Model(&Reaction{}).Select("reactions.status_id, reactions.actor_id").
+		Joins("JOIN statuses ON statuses.id = reactions.status_id").
+		Where("reactions.favourited = ? OR reactions.reblogged = ?", true, true).
+		Scopes(public).Scan(&reactions).Error; err != nil {
+		return err
+	}
+	for _, r := range reactions {
+		use(trendKey{kind: TrendStatus, statusID: r.StatusID}, r.ActorID, decay(r.StatusID))
+	}
+
+	var notes []struct {
+		ID      snowflake.ID
+		ActorID snowflake.ID
+		Note    string
+	}
+	if err := db.Model(&Status{}).Select("statuses.id, statuses.actor_id, statuses.note").
+		Scopes(public).Scan(&notes).Error; err != nil {
+		return err
+	}
+	for _, n := range notes {
+		for _, link := range Links(n.Note) {
+			use(trendKey{kind: TrendLink, url: link}, n.ActorID, decay(n.ID))
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var trends []*Trend
+		if err := tx.Find(&trends).Error; err != nil {
+			return err
+		}
+		existing := make(map[trendKey]*Trend, len(trends))
+		for _, t := range trends {
+			existing[t.key()] = t
+		}
+		for k, u := range uses {
+			if len(u.accounts) < MinTrendAccounts {
+				continue
+			}
+			t, ok := existing[k]
+			if !ok {
+				t = &Trend{Kind: k.kind, URL: k.url}
+				if k.tagID != 0 {
+					t.TagID = &k.tagID
+				}
+				if k.statusID != 0 {
+					t.StatusID = &k.statusID
+				}
+			}
+			delete(existing, k)
+			t.Score = u.score()
+			t.Uses = u.uses
+			t.Accounts = len(u.accounts)
+			if err := tx.Save(t).Error; err != nil {
+				return err
+			}
+		}
+		for _, t := range existing {
+			if err := tx.Model(t).Updates(map[string]any{"score": 0, "uses": 0, "accounts": 0}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Where("score = 0 AND id NOT IN (?)", tx.Model(&TrendReview{}).Select("trend_id")).Delete(&Trend{}).Error
+	})
+}
+
+// Links returns the distinct URLs linked by s, the note of a status, ignoring
+// mentions and hashtags. URLs in the text of s are also links, as the notes
+// of local statuses are not HTML.
+func Links(s string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if len(url) > 255 || seen[url] || !(strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) {
+			return
+		}
+		seen[url] = true
+		links = append(links, url)
+	}
+	inAnchor := false
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return links
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "a" {
+				inAnchor = false
+			}
+		case html.TextToken:
+			if inAnchor {
+				continue
+			}
+			for _, word := range strings.Fields(string(z.Text())) {
+				add(strings.TrimRight(word, ".,;:!?)"))
+			}
+		case html.StartTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "a" {
+				continue
+			}
+			inAnchor = true
+			var href string
+			skip := false
+			for hasAttr {
+				var key, val []byte
+				key, val, hasAttr = z.TagAttr()
+				switch string(key) {
+				case "href":
+					href = string(val)
+				case "class", "rel":
+					for _, f := range strings.Fields(string(val)) {
+						if f == "mention" || f == "hashtag" || f == "tag" {
+							skip = true
+						}
+					}
+				}
+			}
+			if !skip {
+				add(href)
+			}
+		}
+	}
+}
+
+type Trends struct {
+	db *gorm.DB
+}
+
+func NewTrends(db *gorm.DB) *Trends {
+	return &Trends{db: db}
+}
+
+// Shown returns a scope which matches the trends of kind which instance shows;
+// those it has approved and, unless it requires approval, those it has not
+// rejected.
+func (t *Trends) Shown(instance *Instance, kind string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		db = db.Where("trends.kind = ? AND trends.score > 0", kind)
+		subquery := db.Session(&gorm.Session{NewDB: true})
+		if instance.TrendsRequireApproval {
+			approved := subquery.Model(&TrendReview{}).Select("trend_id").Where("instance_id = ? AND approved = ?", instance.ID, true)
+			return db.Where("trends.id IN (?)", approved)
+		}
+		rejected := subquery.Model(&TrendReview{}).Select("trend_id").Where("instance_id = ? AND approved = ?", instance.ID, false)
+		return db.Where("trends.id NOT IN (?)", rejected)
+	}
+}
+
+// Find returns the trend of kind for the tag, status, or link trend, id.
+func (t *Trends) Find(kind string, id uint64) (*Trend, error) {
+	var trend Trend
+	query := t.db.Preload("Tag").Where("kind = ?", kind)
+	switch kind {
+	case TrendTag:
+		query = query.Where("tag_id = ?", id)
+	case TrendStatus:
+		query = query.Where("status_id = ?", id)
+	default:
+		query = query.Where("id = ?", id)
+	}
+	if err := query.Take(&trend).Error; err != nil {
+		return nil, err
+	}
+	return &trend, nil
+}
+
+// Reviews returns the reviews by instance of trends, by trend ID.
+func (t *Trends) Reviews(instance *Instance, trends []*Trend) (map[uint32]*TrendReview, error) {
+	ids := make([]uint32, 0, len(trends))
+	for _, trend := range trends {
+		ids = append(ids, trend.ID)
+	}
+	reviews := make(map[uint32]*TrendReview)
+	if len(ids) == 0 {
+		return reviews, nil
+	}
+	var found []*TrendReview
+	if err := t.db.Where("instance_id = ? AND trend_id IN (?)", instance.ID, ids).Find(&found).Error; err != nil {
+		return nil, err
+	}
+	for _, r := range found {
+		reviews[r.TrendID] = r
+	}
+	return reviews, nil
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinks(t *testing.T) {
+	note := `<p><span class="h-card"><a href="https://example.com/@bob" class="u-url mention">@bob</a></span> read ` +
+		`<a href="https://go.dev/blog/" rel="nofollow">https://go.dev/blog/</a> ` +
+		`<a href="https://example.com/tags/golang" class="mention hashtag" rel="tag">#golang</a></p>`
+	require.Equal(t, []string{"https://go.dev/blog/"}, Links(note))
+	require.Equal(t, []string{"https://go.dev/blog/", "http://example.org/"}, Links("see https://go.dev/blog/, and http://example.org/. https://go.dev/blog/"))
+	require.Empty(t, Links("no links, ftp://example.com"))
+}
+
+func TestUpdateTrends(t *testing.T) {
+	db := setupTestDB(t)
+	alice := createActor(t, db, "alice", "example.com", true)
+	bob := createActor(t, db, "bob", "remote.example", false)
+	carol := createActor(t, db, "carol", "remote.example", false)
+	tags := NewTags(db)
+	golang, err := tags.FindOrCreate("golang")
+	require.NoError(t, err)
+	rust, err := tags.FindOrCreate("rust")
+	require.NoError(t, err)
+
+	now := time.Now()
+	tag := func(st *Status, tag *Tag) {
+		require.NoError(t, db.Create(&StatusTag{StatusID: st.ID, TagID: tag.ID}).Error)
+	}
+	// golang is used by two actors, rust by only one.
+	tag(createStatus(t, db, alice, now.Add(-time.Hour)), golang)
+	tag(createStatus(t, db, bob, now.Add(-7*time.Hour)), golang)
+	tag(createStatus(t, db, bob, now.Add(-time.Hour)), rust)
+	// statuses older than the window do not count.
+	tag(createStatus(t, db, carol, now.Add(-72*time.Hour)), golang)
+
+	popular := createStatus(t, db, carol, now.Add(-time.Hour))
+	require.NoError(t, db.Model(popular).Update("note", `<p>read <a href="https://go.dev/blog/">go.dev/blog</a></p>`).Error)
+	require.NoError(t, db.Create(&Reaction{StatusID: popular.ID, ActorID: alice.ID, Favourited: true}).Error)
+	require.NoError(t, db.Create(&Reaction{StatusID: popular.ID, ActorID: bob.ID, Reblogged: true}).Error)
+	linked := createStatus(t, db, bob, now.Add(-2*time.Hour))
+	require.NoError(t, db.Model(linked).Update("note", "https://go.dev/blog/").Error)
+
+	require.NoError(t, UpdateTrends(db, now, 48*time.Hour, 6*time.Hour))
+
+	var trends []*Trend
+	require.NoError(t, db.Order("kind").Find(&trends).Error)
+	require.Len(t, trends, 3)
+	link, status, tagged := trends[0], trends[1], trends[2]
+
+	require.Equal(t, TrendTag, tagged.Kind)
+	require.Equal(t, golang.ID, *tagged.TagID)
+	require.Equal(t, 2, tagged.Uses)
+	require.Equal(t, 2, tagged.Accounts)
+	// an hour old use counts more than one seven hours old, which counts less
+	// than half.
+	require.InDelta(t, 0.891+0.445, tagged.Score, 0.01)
+
+	require.Equal(t, TrendStatus, status.Kind)
+	require.Equal(t, popular.ID, *status.StatusID)
+	require.Equal(t, 2, status.Accounts)
+
+	require.Equal(t, TrendLink, link.Kind)
+	require.Equal(t, "https://go.dev/blog/", link.URL)
+	require.Equal(t, 2, link.Accounts)
+
+	// once the uses have aged out of the window, unreviewed trends are
+	// deleted and reviewed trends are kept with no score.
+	var instance Instance
+	require.NoError(t, db.Take(&instance, "domain = ?", "example.com").Error)
+	require.NoError(t, db.Create(&TrendReview{InstanceID: instance.ID, TrendID: tagged.ID, Approved: true, ReviewedAt: now}).Error)
+	require.NoError(t, UpdateTrends(db, now.Add(72*time.Hour), 48*time.Hour, 6*time.Hour))
+	trends = nil
+	require.NoError(t, db.Find(&trends).Error)
+	require.Len(t, trends, 1)
+	require.Equal(t, tagged.ID, trends[0].ID)
+	require.Zero(t, trends[0].Score)
+}
+
+func TestTrendsShown(t *testing.T) {
+	db := setupTestDB(t)
+	instance := &Instance{ID: snowflake.Now(), Domain: "example.com"}
+	require.NoError(t, db.Create(instance).Error)
+	admin, err := NewAccounts(db).Create(instance, NewAccount{Name: "admin", Email: "admin@example.com", Password: "sssh", Role: "admin", URLs: urls.New("https://" + instance.Domain)})
+	require.NoError(t, err)
+	approved := &Trend{Kind: TrendLink, URL: "https://approved.example/", Score: 1}
+	rejected := &Trend{Kind: TrendLink, URL: "https://rejected.example/", Score: 1}
+	pending := &Trend{Kind: TrendLink, URL: "https://pending.example/", Score: 1}
+	stale := &Trend{Kind: TrendLink, URL: "https://stale.example/"}
+	for _, trend := range []*Trend{approved, rejected, pending, stale} {
+		require.NoError(t, db.Create(trend).Error)
+	}
+	actions := NewAdminActions(db)
+	require.NoError(t, actions.ReviewTrend(admin, approved, true))
+	require.NoError(t, actions.ReviewTrend(admin, rejected, false))
+
+	trends := NewTrends(db)
+	shown := func() []string {
+		t.Helper()
+		require.NoError(t, db.Take(instance, instance.ID).Error)
+		var urls []string
+		require.NoError(t, db.Model(&Trend{}).Scopes(trends.Shown(instance, TrendLink)).Order("url").Pluck("url", &urls).Error)
+		return urls
+	}
+	require.Equal(t, []string{"https://approved.example/", "https://pending.example/"}, shown())
+
+	require.NoError(t, actions.SetTrendsRequireApproval(admin, true))
+	require.Equal(t, []string{"https://approved.example/"}, shown())
+
+	var count int64
+	require.NoError(t, db.Model(&AdminAction{}).Where("action IN (?)", []string{"approve_trend", "reject_trend", "enable_trends_approval"}).Count(&count).Error)
+	require.EqualValues(t, 3, count)
+}
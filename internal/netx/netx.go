@@ -0,0 +1,61 @@
+// Package netx provides an HTTP client for fetching resources from other
+// servers on the public internet.
+package netx
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// sharedAddressSpace is the carrier grade NAT range, RFC 6598.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublic reports whether addr is a public unicast address; that is it is
+// not a loopback, private, link local, multicast, or unspecified address.
+func IsPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	switch {
+	case !addr.IsValid(),
+		addr.IsUnspecified(),
+		addr.IsLoopback(),
+		addr.IsPrivate(),
+		addr.IsLinkLocalUnicast(),
+		addr.IsLinkLocalMulticast(),
+		addr.IsInterfaceLocalMulticast(),
+		addr.IsMulticast(),
+		sharedAddressSpace.Contains(addr):
+		return false
+	}
+	return true
+}
+
+// NewClient returns a http.Client whose requests time out after timeout, and
+// which refuses to connect to addresses which are not public. The address is
+// checked after it is resolved, so neither redirects nor DNS can be used to
+// reach the network of the server.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !IsPublic(ap.Addr()) {
+				return fmt.Errorf("netx: refusing to connect to %s", ap.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
@@ -0,0 +1,47 @@
+package netx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublic(t *testing.T) {
+	tc := []struct {
+		addr   string
+		expect bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.addr, func(t *testing.T) {
+			require.Equal(t, tt.expect, IsPublic(netip.MustParseAddr(tt.addr)))
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	// the test server listens on loopback.
+	_, err := NewClient(time.Second).Get(srv.URL)
+	require.ErrorContains(t, err, "refusing to connect")
+}
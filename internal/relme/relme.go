@@ -0,0 +1,130 @@
+// Package relme verifies links between web pages using rel="me".
+//
+// See https://microformats.org/wiki/rel-me
+package relme
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// maxPageSize is the maximum number of bytes of a page which are searched for links.
+const maxPageSize = 1 << 20
+
+// Link returns the URL of the web page that the profile field value links to,
+// or the empty string if value does not contain a http or https URL.
+func Link(value string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "<") {
+		// mastodon stores the field value as HTML, find the href of the first anchor.
+		doc, err := html.Parse(strings.NewReader(value))
+		if err != nil {
+			return ""
+		}
+		value = ""
+		walk(doc, func(n *html.Node) bool {
+			if n.Type == html.ElementNode && n.Data == "a" {
+				value = attr(n, "href")
+				return false
+			}
+			return true
+		})
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// Verify fetches the page at pageURL and reports whether it contains an <a> or <link>
+// element with rel="me" whose href matches one of profileURLs.
+func Verify(ctx context.Context, client *http.Client, pageURL string, profileURLs ...string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s: unexpected status code %d", pageURL, resp.StatusCode)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != "text/html" && mt != "application/xhtml+xml" {
+		return false, fmt.Errorf("GET %s: unexpected content type %q", pageURL, mt)
+	}
+	// only the start of the page is read, however large it is.
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxPageSize))
+	if err != nil {
+		return false, err
+	}
+
+	want := make(map[string]bool)
+	for _, u := range profileURLs {
+		want[normalise(u)] = true
+	}
+	found := false
+	walk(doc, func(n *html.Node) bool {
+		if n.Type != html.ElementNode || (n.Data != "a" && n.Data != "link") {
+			return true
+		}
+		if !hasRelMe(attr(n, "rel")) {
+			return true
+		}
+		found = want[normalise(attr(n, "href"))]
+		return !found
+	})
+	return found, nil
+}
+
+// walk calls fn for each node in the tree rooted at n, in depth first order,
+// until fn returns false.
+func walk(n *html.Node, fn func(*html.Node) bool) bool {
+	if !fn(n) {
+		return false
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if !walk(c, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// hasRelMe reports whether the space separated rel attribute contains me.
+func hasRelMe(rel string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, "me") {
+			return true
+		}
+	}
+	return false
+}
+
+// normalise returns u without its trailing slash, so that links to
+// https://example.com/@dave/ match https://example.com/@dave.
+func normalise(u string) string {
+	return strings.TrimSuffix(strings.TrimSpace(u), "/")
+}
@@ -0,0 +1,91 @@
+package relme
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLink(t *testing.T) {
+	tc := []struct {
+		in     string
+		expect string
+	}{
+		{"https://dave.cheney.net/", "https://dave.cheney.net/"},
+		{" http://example.com/about ", "http://example.com/about"},
+		{`<a href="https://example.com/" rel="me nofollow noopener noreferrer" target="_blank">example.com</a>`, "https://example.com/"},
+		{"mailto:dave@example.com", ""},
+		{"Sydney, Australia", ""},
+		{"", ""},
+	}
+	for _, tt := range tc {
+		t.Run(tt.in, func(t *testing.T) {
+			require.Equal(t, tt.expect, Link(tt.in))
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const profile = "https://example.com/@dave"
+	tc := []struct {
+		name   string
+		page   string
+		expect bool
+	}{
+		{"anchor", `<html><body><a rel="me" href="https://example.com/@dave">mastodon</a></body></html>`, true},
+		{"link", `<html><head><link rel="me" href="https://example.com/@dave/"></head></html>`, true},
+		{"multiple rel values", `<a rel="nofollow me" href="https://example.com/@dave">mastodon</a>`, true},
+		{"missing rel", `<a href="https://example.com/@dave">mastodon</a>`, false},
+		{"other profile", `<a rel="me" href="https://example.com/@alice">mastodon</a>`, false},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.page)
+			}))
+			defer svr.Close()
+
+			got, err := Verify(context.Background(), svr.Client(), svr.URL, profile)
+			require.NoError(t, err)
+			require.Equal(t, tt.expect, got)
+		})
+	}
+}
+
+func TestVerifyNotFound(t *testing.T) {
+	svr := httptest.NewServer(http.NotFoundHandler())
+	defer svr.Close()
+
+	_, err := Verify(context.Background(), svr.Client(), svr.URL, "https://example.com/@dave")
+	require.Error(t, err)
+}
+
+func TestVerifyLimits(t *testing.T) {
+	const profile = "https://example.com/@dave"
+	const link = `<a rel="me" href="https://example.com/@dave">mastodon</a>`
+	tc := []struct {
+		name        string
+		contentType string
+		page        string
+	}{
+		{"not html", "application/octet-stream", link},
+		{"beyond the limit", "text/html", "<html><body><p>" + strings.Repeat("x", maxPageSize) + "</p>" + link},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				fmt.Fprint(w, tt.page)
+			}))
+			defer svr.Close()
+
+			got, _ := Verify(context.Background(), svr.Client(), svr.URL, profile)
+			require.False(t, got)
+		})
+	}
+}
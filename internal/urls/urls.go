@@ -0,0 +1,110 @@
+// Package urls builds the URLs of the actors, statuses, collections, inboxes,
+// and media served by the local instances.
+package urls
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/davecheney/pub/internal/snowflake"
+)
+
+// A Builder builds the URLs of an instance.
+type Builder struct {
+	base string
+}
+
+// New returns the Builder for the instance served from base, for example
+// https://example.com.
+func New(base string) *Builder {
+	return &Builder{base: strings.TrimSuffix(base, "/")}
+}
+
+// Base returns the base URL of the instance.
+func (b *Builder) Base() string {
+	return b.base
+}
+
+// Host returns the host of the instance, for example example.com.
+func (b *Builder) Host() string {
+	_, host, _ := strings.Cut(b.base, "://")
+	return host
+}
+
+// URL returns the URL of path, which must start with a /, on the instance.
+func (b *Builder) URL(path string) string {
+	return b.base + path
+}
+
+// Actor returns the ActivityPub id of the local actor name.
+func (b *Builder) Actor(name string) string {
+	return b.URL("/u/" + name)
+}
+
+// Profile returns the URL of the profile page of the local actor name.
+func (b *Builder) Profile(name string) string {
+	return b.URL("/@" + name)
+}
+
+// Status returns the ActivityPub id of the status id of the local actor name.
+func (b *Builder) Status(name string, id snowflake.ID) string {
+	return fmt.Sprintf("%s/statuses/%d", b.Actor(name), id)
+}
+
+// Inbox returns the URL of the inbox of the local actor name.
+func (b *Builder) Inbox(name string) string {
+	return b.Actor(name) + "/inbox"
+}
+
+// Outbox returns the URL of the outbox of the local actor name.
+func (b *Builder) Outbox(name string) string {
+	return b.Actor(name) + "/outbox"
+}
+
+// Followers returns the URL of the followers collection of the local actor name.
+func (b *Builder) Followers(name string) string {
+	return b.Actor(name) + "/followers"
+}
+
+// Following returns the URL of the following collection of the local actor name.
+func (b *Builder) Following(name string) string {
+	return b.Actor(name) + "/following"
+}
+
+// Collection returns the URL of the named collection of the local actor name.
+func (b *Builder) Collection(name, collection string) string {
+	return b.Actor(name) + "/collections/" + collection
+}
+
+// Tag returns the URL of the page of the hashtag name.
+func (b *Builder) Tag(name string) string {
+	return b.URL("/tags/" + name)
+}
+
+// SharedInbox returns the URL of the shared inbox of the instance.
+func (b *Builder) SharedInbox() string {
+	return b.URL("/inbox")
+}
+
+// InstanceActor returns the ActivityPub id of the instance actor.
+func (b *Builder) InstanceActor() string {
+	return b.URL("/actor")
+}
+
+// Report returns the ActivityPub id of the Flag activity which forwards the
+// report id.
+func (b *Builder) Report(id snowflake.ID) string {
+	return fmt.Sprintf("%s/reports/%d", b.base, id)
+}
+
+// Upload returns the URL of the uploaded media file name.
+func (b *Builder) Upload(name string) string {
+	return b.URL("/media/uploads/" + name)
+}
+
+// Media returns the URL of the cached copy of the remote media of kind, one
+// of avatar, header, or attachment, for the object id. hash is the hash of
+// the remote URL, so the URL changes when the remote media does.
+func (b *Builder) Media(kind, hash string, id snowflake.ID) string {
+	return fmt.Sprintf("%s/media/%s/%s/%d", b.base, kind, hash, id)
+}
@@ -0,0 +1,31 @@
+package urls
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	b := New("https://example.com")
+	require.Equal(t, "https://example.com", b.Base())
+	require.Equal(t, "example.com", b.Host())
+	require.Equal(t, "https://example.com/u/dave", b.Actor("dave"))
+	require.Equal(t, "https://example.com/@dave", b.Profile("dave"))
+	require.Equal(t, "https://example.com/u/dave/statuses/42", b.Status("dave", 42))
+	require.Equal(t, "https://example.com/u/dave/inbox", b.Inbox("dave"))
+	require.Equal(t, "https://example.com/u/dave/outbox", b.Outbox("dave"))
+	require.Equal(t, "https://example.com/u/dave/followers", b.Followers("dave"))
+	require.Equal(t, "https://example.com/u/dave/following", b.Following("dave"))
+	require.Equal(t, "https://example.com/u/dave/collections/featured", b.Collection("dave", "featured"))
+	require.Equal(t, "https://example.com/tags/golang", b.Tag("golang"))
+	require.Equal(t, "https://example.com/inbox", b.SharedInbox())
+	require.Equal(t, "https://example.com/actor", b.InstanceActor())
+	require.Equal(t, "https://example.com/reports/42", b.Report(42))
+	require.Equal(t, "https://example.com/media/uploads/abc.png", b.Upload("abc.png"))
+	require.Equal(t, "https://example.com/media/avatar/abc/42", b.Media("avatar", "abc", 42))
+}
+
+func TestBuilderTrailingSlash(t *testing.T) {
+	require.Equal(t, "http://example.com:8080/u/dave", New("http://example.com:8080/").Actor("dave"))
+}
@@ -45,46 +45,6 @@ func (a *Acct) Webfinger() string {
 	return "https://" + a.Host + "/.well-known/webfinger?resource=" + url.QueryEscape(a.String())
 }
 
-// ID returns the URL for the ID resource for this Acct.
-func (a *Acct) ID() string {
-	return "https://" + a.Host + "/u/" + a.User
-}
-
-// Followers returns the URL for the followers collection for this Acct.
-func (a *Acct) Followers() string {
-	return a.ID() + "/followers"
-}
-
-// Following returns the URL for the following collection for this Acct.
-func (a *Acct) Following() string {
-	return a.ID() + "/following"
-}
-
-// Collections returns the URL prefix for the collections for this Acct.
-func (a *Acct) Collections() string {
-	return a.ID() + "/collections"
-}
-
-// Tags returns the URL for the tags collection for this Acct.
-func (a *Acct) Tags() string {
-	return a.Collections() + "/tags"
-}
-
-// Inbox returns the URL for the inbox collection for this Acct.
-func (a *Acct) Inbox() string {
-	return a.ID() + "/inbox"
-}
-
-// Outbox returns the URL for the outbox collection for this Acct.
-func (a *Acct) Outbox() string {
-	return a.ID() + "/outbox"
-}
-
-// SharedInbox returns the URL for the shared inbox collection for this Acct.
-func (a *Acct) SharedInbox() string {
-	return "https://" + a.Host + "/inbox"
-}
-
 func (a *Acct) Fetch(ctx context.Context) (*Webfinger, error) {
 	var webfinger Webfinger
 	err := requests.URL(a.Webfinger()).ToJSON(&webfinger).Fetch(ctx)
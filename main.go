@@ -1,8 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/alecthomas/kong"
+	"github.com/davecheney/pub/internal/config"
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
@@ -12,40 +18,98 @@ import (
 type Context struct {
 	Debug bool
 
+	// Settings is the configuration loaded from the --config file and the
+	// environment, with the global flags applied.
+	Settings *config.Config
+
 	gorm.Config
 	gorm.Dialector
 }
 
 var cli struct {
-	LogSQL bool   `help:"Log SQL queries."`
-	DSN    string `help:"data source name" default:"pub:pub@tcp(localhost:3306)/pub"`
+	ConfigFile string `name:"config" help:"path of the configuration file" type:"path" env:"PUB_CONFIG"`
+	LogSQL     bool   `help:"Log SQL queries, overrides logging.sql."`
+	DBDriver   string `help:"database driver, one of mysql, sqlite or postgres, overrides database.driver"`
+	DSN        string `help:"data source name, overrides database.dsn"`
 
-	AutoMigrate          AutoMigrateCmd          `cmd:"" help:"Automigrate the database."`
+	Alias                AliasCmd                `cmd:"" help:"Add or remove an alias of an account."`
+	AllowDomain          AllowDomainCmd          `cmd:"" help:"Allow a domain to federate with an instance in allowlist mode."`
+	AllowlistMode        AllowlistModeCmd        `cmd:"" help:"Enable or disable allowlist mode for an instance."`
+	AutoMigrate          AutoMigrateCmd          `cmd:"" help:"Apply pending migrations, use migrate up instead." hidden:""`
+	BlockDomain          BlockDomainCmd          `cmd:"" help:"Block a domain from federating with an instance."`
+	Config               ConfigCmd               `cmd:"" help:"Inspect the configuration."`
 	CreateAccount        CreateAccountCmd        `cmd:"" help:"Create a new account."`
 	CreateInstance       CreateInstanceCmd       `cmd:"" help:"Create a new instance."`
 	DeleteAccount        DeleteAccountCmd        `cmd:"" help:"Delete an account."`
+	Export               ExportCmd               `cmd:"" help:"Export an account to a Mastodon compatible archive."`
+	Migrate              MigrateCmd              `cmd:"" help:"Manage database migrations."`
+	Move                 MoveCmd                 `cmd:"" help:"Move an account to another server."`
+	Prune                PruneCmd                `cmd:"" help:"Delete old remote statuses which no local account has interacted with."`
+	Registrations        RegistrationsCmd        `cmd:"" help:"Manage sign ups and invites."`
+	Reports              ReportsCmd              `cmd:"" help:"Manage reports."`
+	RotateKeys           RotateKeysCmd           `cmd:"" help:"Rotate the keypair of an account."`
+	SecureMode           SecureModeCmd           `cmd:"" help:"Enable or disable secure mode for an instance."`
 	Serve                ServeCmd                `cmd:"" help:"Serve a local web server."`
-	SynchroniseFollowers SynchroniseFollowersCmd `cmd:"" help:"Synchronise followers."`
-	Imoport              ImportCmd               `cmd:"" help:"Import data from another instance."`
+	SynchroniseFollowers SynchroniseFollowersCmd `cmd:"" help:"Follow the accounts followed by another actor."`
+	Trends               TrendsCmd               `cmd:"" help:"Manage trending tags, statuses and links."`
+	Import               ImportCmd               `cmd:"" help:"Import a Mastodon account archive."`
 	Follow               FollowCmd               `cmd:"" help:"Follow an object."`
 }
 
 func main() {
 	ctx := kong.Parse(&cli)
-	err := ctx.Run(&Context{
-		Debug: cli.LogSQL,
+	cfg, err := config.Load(cli.ConfigFile)
+	ctx.FatalIfErrorf(err)
+	if cli.LogSQL {
+		cfg.Logging.SQL = true
+	}
+	if cli.DBDriver != "" {
+		cfg.Database.Driver = cli.DBDriver
+	}
+	if cli.DSN != "" {
+		cfg.Database.DSN = cli.DSN
+	}
+	dialector, err := newDialector(cfg.Database.Driver, cfg.Database.DataSource())
+	ctx.FatalIfErrorf(err)
+	err = ctx.Run(&Context{
+		Debug:    cfg.Logging.SQL,
+		Settings: cfg,
 		Config: gorm.Config{
 			Logger: logger.Default.LogMode(func() logger.LogLevel {
-				if cli.LogSQL {
+				if cfg.Logging.SQL {
 					return logger.Info
 				}
 				return logger.Warn
 			}()),
 		},
-		Dialector: mysql.New(mysql.Config{
-			DSN:                       cli.DSN + "?charset=utf8mb4&parseTime=True&loc=Local",
-			SkipInitializeWithVersion: false, // auto configure based on currently MySQL version
-		}),
+		Dialector: dialector,
 	})
 	ctx.FatalIfErrorf(err)
 }
+
+// newDialector returns the gorm dialector for driver connected to dsn.
+func newDialector(driver, dsn string) (gorm.Dialector, error) {
+	switch driver {
+	case "mysql":
+		return mysql.New(mysql.Config{
+			DSN:                       dsn + "?charset=utf8mb4&parseTime=True&loc=Local",
+			SkipInitializeWithVersion: false, // auto configure based on currently MySQL version
+		}), nil
+	case "sqlite":
+		// foreign keys are off by default in SQLite, the models rely on them
+		// to cascade deletes.
+		if !strings.Contains(dsn, "foreign_keys") {
+			if strings.Contains(dsn, "?") {
+				dsn += "&"
+			} else {
+				dsn += "?"
+			}
+			dsn += "_pragma=foreign_keys(1)"
+		}
+		return sqlite.Open(dsn), nil
+	case "postgres":
+		return postgres.Open(dsn), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
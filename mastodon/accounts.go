@@ -1,16 +1,28 @@
 package mastodon
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/davecheney/pub/activitypub"
 	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/mime"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/snowflake"
 	"github.com/davecheney/pub/internal/to"
+	"github.com/davecheney/pub/media"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-json-experiment/json"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 func AccountsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -19,10 +31,49 @@ func AccountsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 	var actor models.Actor
-	if err := env.DB.Preload("Attributes").Take(&actor, chi.URLParam(r, "id")).Error; err != nil {
+	if err := env.DB.Preload("Attributes").Preload("MovedTo").Take(&actor, chi.URLParam(r, "id")).Error; err != nil {
 		return httpx.Error(http.StatusNotFound, err)
 	}
-	return to.JSON(w, serialiseAccount(&actor))
+	return to.JSON(w, env.serialise().account(&actor))
+}
+
+// AccountsLookup returns the account for acct, the name of an actor on this
+// instance, or name@domain.
+func AccountsLookup(env *Env, w http.ResponseWriter, r *http.Request) error {
+	acct := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(r.URL.Query().Get("acct")), "acct:"), "@")
+	name, domain, ok := strings.Cut(acct, "@")
+	if !ok {
+		domain = r.Host
+	}
+	if name == "" {
+		return httpx.Error(http.StatusNotFound, errors.New("account not found"))
+	}
+	suspended, err := hiddenActors(env, r, "actors.id", false)
+	if err != nil {
+		return err
+	}
+	var actor models.Actor
+	if err := env.DB.Preload("Attributes").Preload("MovedTo").Scopes(models.NotPending, suspended).Where("LOWER(name) = LOWER(?) AND LOWER(domain) = LOWER(?)", name, domain).Take(&actor).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	return to.JSON(w, env.serialise().account(&actor))
+}
+
+// AccountsSearch returns the accounts whose names start with q, for
+// autocompleting mentions.
+func AccountsSearch(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	actors, err := findAccounts(env, r, user.Actor, strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(actors, env.serialise().account))
 }
 
 func AccountsVerifyCredentials(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -30,7 +81,10 @@ func AccountsVerifyCredentials(env *Env, w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return err
 	}
-	return to.JSON(w, serialiseCredentialAccount(user))
+	if err := env.DB.Where("actor_id = ?", user.Actor.ID).Find(&user.Actor.Attributes).Error; err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().credentialAccount(user))
 }
 
 func AccountsStatusesShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -57,7 +111,7 @@ func AccountsStatusesShow(env *Env, w http.ResponseWriter, r *http.Request) erro
 		return err
 	}
 
-	return to.JSON(w, algorithms.Map(statuses, serialiseStatus))
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
 }
 
 func AccountsFollowersShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -72,9 +126,9 @@ func AccountsFollowersShow(env *Env, w http.ResponseWriter, r *http.Request) err
 	}
 
 	if len(followers) > 0 {
-		w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/accounts/%s/followers?max_id=%d>; rel=\"next\", <https://%s/api/v1/accounts/%s/followers?min_id=%d>; rel=\"prev\"", r.Host, chi.URLParam(r, "id"), followers[len(followers)-1].TargetID, r.Host, chi.URLParam(r, "id"), followers[0].TargetID))
+		w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/accounts/%s/followers?max_id=%d>; rel=\"next\", <%s/api/v1/accounts/%s/followers?min_id=%d>; rel=\"prev\"", env.URLs.Base(), chi.URLParam(r, "id"), followers[len(followers)-1].TargetID, env.URLs.Base(), chi.URLParam(r, "id"), followers[0].TargetID))
 	}
-	return to.JSON(w, algorithms.Map(algorithms.Map(followers, relationshipTarget), serialiseAccount))
+	return to.JSON(w, algorithms.Map(algorithms.Map(followers, relationshipTarget), env.serialise().account))
 }
 
 func relationshipTarget(rel *models.Relationship) *models.Actor {
@@ -93,9 +147,9 @@ func AccountsFollowingShow(env *Env, w http.ResponseWriter, r *http.Request) err
 
 	if len(following) > 0 {
 		// TODO don't send if we're at the end of the list
-		w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/accounts/%s/following?max_id=%d>; rel=\"next\", <https://%s/api/v1/accounts/%s/following?min_id=%d>; rel=\"prev\"", r.Host, chi.URLParam(r, "id"), following[len(following)-1].TargetID, r.Host, chi.URLParam(r, "id"), following[0].TargetID))
+		w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/accounts/%s/following?max_id=%d>; rel=\"next\", <%s/api/v1/accounts/%s/following?min_id=%d>; rel=\"prev\"", env.URLs.Base(), chi.URLParam(r, "id"), following[len(following)-1].TargetID, env.URLs.Base(), chi.URLParam(r, "id"), following[0].TargetID))
 	}
-	return to.JSON(w, algorithms.Map(algorithms.Map(following, relationshipTarget), serialiseAccount))
+	return to.JSON(w, algorithms.Map(algorithms.Map(following, relationshipTarget), env.serialise().account))
 }
 
 func AccountsUpdateCredentials(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -104,21 +158,340 @@ func AccountsUpdateCredentials(env *Env, w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	if err := r.ParseForm(); err != nil {
-		return httpx.Error(http.StatusBadRequest, err)
+	var params struct {
+		DisplayName  *string `json:"display_name"`
+		Note         *string `json:"note"`
+		Locked       *bool   `json:"locked"`
+		Bot          *bool   `json:"bot"`
+		Discoverable *bool   `json:"discoverable"`
+		Source       struct {
+			Privacy   *string `json:"privacy"`
+			Sensitive *bool   `json:"sensitive"`
+			Language  *string `json:"language"`
+		} `json:"source"`
+		FieldsAttributes fieldsAttributes `json:"fields_attributes"`
+	}
+	var avatar, header string
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, 2*media.MaxUploadSize)
+		if err := r.ParseMultipartForm(media.MaxUploadSize); err != nil && err != http.ErrNotMultipart {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+		params.DisplayName = formString(r.PostForm, "display_name")
+		params.Note = formString(r.PostForm, "note")
+		params.Locked = formBool(r.PostForm, "locked")
+		params.Bot = formBool(r.PostForm, "bot")
+		params.Discoverable = formBool(r.PostForm, "discoverable")
+		params.Source.Privacy = formString(r.PostForm, "source[privacy]")
+		params.Source.Sensitive = formBool(r.PostForm, "source[sensitive]")
+		params.Source.Language = formString(r.PostForm, "source[language]")
+		params.FieldsAttributes = formFields(r.PostForm)
+		if avatar, err = storeUpload(env, r, "avatar"); err != nil {
+			return httpx.Error(http.StatusUnprocessableEntity, err)
+		}
+		if header, err = storeUpload(env, r, "header"); err != nil {
+			return httpx.Error(http.StatusUnprocessableEntity, err)
+		}
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
 	}
 
-	if r.Form.Get("display_name") != "" {
-		account.Actor.DisplayName = r.Form.Get("display_name")
+	// only the columns which were changed are updated, so that concurrent
+	// changes to the others, eg. the counters, are not overwritten.
+	actor := account.Actor
+	actorUpdates := make(map[string]any)
+	if params.DisplayName != nil {
+		actor.DisplayName = *params.DisplayName
+		actorUpdates["display_name"] = actor.DisplayName
+	}
+	if params.Note != nil {
+		actor.Note = *params.Note
+		actorUpdates["note"] = actor.Note
+	}
+	if params.Locked != nil {
+		actor.Locked = *params.Locked
+		actorUpdates["locked"] = actor.Locked
+	}
+	if params.Bot != nil {
+		actor.Bot = *params.Bot
+		actorUpdates["bot"] = actor.Bot
+	}
+	if params.Discoverable != nil {
+		actor.Discoverable = *params.Discoverable
+		actorUpdates["discoverable"] = actor.Discoverable
+	}
+	if avatar != "" {
+		actor.Avatar = env.URLs.Upload(avatar)
+		actorUpdates["avatar"] = actor.Avatar
 	}
-	if r.Form.Get("note") != "" {
-		account.Actor.Note = r.Form.Get("note")
+	if header != "" {
+		actor.Header = env.URLs.Upload(header)
+		actorUpdates["header"] = actor.Header
+	}
+	accountUpdates := make(map[string]any)
+	if params.Source.Privacy != nil {
+		switch *params.Source.Privacy {
+		case "public", "unlisted", "private", "direct":
+			account.DefaultPrivacy = *params.Source.Privacy
+			accountUpdates["default_privacy"] = account.DefaultPrivacy
+		default:
+			return httpx.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid privacy %q", *params.Source.Privacy))
+		}
+	}
+	if params.Source.Sensitive != nil {
+		account.DefaultSensitive = *params.Source.Sensitive
+		accountUpdates["default_sensitive"] = account.DefaultSensitive
+	}
+	if params.Source.Language != nil {
+		if !validLanguage.MatchString(*params.Source.Language) {
+			return httpx.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid language %q", *params.Source.Language))
+		}
+		account.DefaultLanguage = *params.Source.Language
+		accountUpdates["default_language"] = account.DefaultLanguage
 	}
 
-	if err := env.DB.Save(account).Error; err != nil {
+	if err := env.DB.Transaction(func(tx *gorm.DB) error {
+		if params.FieldsAttributes != nil {
+			if err := tx.Where("actor_id = ?", actor.ID).Delete(&models.ActorAttribute{}).Error; err != nil {
+				return err
+			}
+			actor.Attributes = nil
+			for _, field := range params.FieldsAttributes {
+				if field.Name == "" && field.Value == "" {
+					continue
+				}
+				actor.Attributes = append(actor.Attributes, &models.ActorAttribute{
+					ActorID: actor.ID,
+					Name:    field.Name,
+					Value:   field.Value,
+				})
+			}
+			if len(actor.Attributes) > 0 {
+				if err := tx.Create(actor.Attributes).Error; err != nil {
+					return err
+				}
+			}
+		} else if err := tx.Where("actor_id = ?", actor.ID).Find(&actor.Attributes).Error; err != nil {
+			return err
+		}
+		if len(accountUpdates) > 0 {
+			if err := tx.Model(account).Updates(accountUpdates).Error; err != nil {
+				return err
+			}
+		}
+		if len(actorUpdates) == 0 && params.FieldsAttributes == nil {
+			return nil
+		}
+		if len(actorUpdates) > 0 {
+			if err := tx.Model(actor).Updates(actorUpdates).Error; err != nil {
+				return err
+			}
+		}
+		return models.NewActors(tx).QueueUpdate(actor)
+	}); err != nil {
 		return err
 	}
-	return to.JSON(w, serialiseAccount(account.Actor))
+	return to.JSON(w, env.serialise().credentialAccount(account))
+}
+
+// storeUpload stores the uploaded file in the named form field, if present,
+// and returns its name in the media store.
+func storeUpload(env *Env, r *http.Request, field string) (string, error) {
+	if r.MultipartForm == nil || len(r.MultipartForm.File[field]) == 0 {
+		return "", nil
+	}
+	f, err := r.MultipartForm.File[field][0].Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return env.Media.Put(f)
+}
+
+// formString returns a pointer to the value of key, or nil if key is not present.
+func formString(form url.Values, key string) *string {
+	if !form.Has(key) {
+		return nil
+	}
+	return ptr(form.Get(key))
+}
+
+// formBool returns a pointer to the boolean value of key, or nil if key is not present.
+func formBool(form url.Values, key string) *bool {
+	if !form.Has(key) {
+		return nil
+	}
+	switch form.Get(key) {
+	case "1", "true", "on":
+		return ptr(true)
+	default:
+		return ptr(false)
+	}
+}
+
+// validLanguage matches an ISO 639 language code, with an optional region or
+// script, which fits the account's DefaultLanguage.
+var validLanguage = regexp.MustCompile(`^[a-z]{2,3}(-[A-Za-z0-9]{2,4})?$`)
+
+// fieldsAttributes are the fields_attributes of update_credentials. Clients
+// send them as an array, or, as in the form, as an object keyed by index.
+type fieldsAttributes []Field
+
+func (f *fieldsAttributes) UnmarshalJSON(b []byte) error {
+	var fields []Field
+	if err := json.Unmarshal(b, &fields); err == nil {
+		*f = fields
+		return nil
+	}
+	var indexed map[string]Field
+	if err := json.Unmarshal(b, &indexed); err != nil {
+		return err
+	}
+	indexes := make([]int, 0, len(indexed))
+	byIndex := make(map[int]Field, len(indexed))
+	for key, field := range indexed {
+		i, err := strconv.Atoi(key)
+		if err != nil {
+			return fmt.Errorf("fields_attributes: invalid index %q", key)
+		}
+		indexes = append(indexes, i)
+		byIndex[i] = field
+	}
+	sort.Ints(indexes)
+	fields = make([]Field, 0, len(indexes))
+	for _, i := range indexes {
+		fields = append(fields, byIndex[i])
+	}
+	*f = fields
+	return nil
+}
+
+// formFields returns the fields_attributes[n][name] and fields_attributes[n][value]
+// pairs from the form in index order, or nil if there are none.
+func formFields(form url.Values) []Field {
+	var fields []Field
+	for i := 0; ; i++ {
+		name := fmt.Sprintf("fields_attributes[%d][name]", i)
+		value := fmt.Sprintf("fields_attributes[%d][value]", i)
+		if !form.Has(name) && !form.Has(value) {
+			return fields
+		}
+		fields = append(fields, Field{
+			Name:  form.Get(name),
+			Value: form.Get(value),
+		})
+	}
+}
+
+// AccountsRotateKeys replaces the keypair of the authenticated account.
+// The previous key remains valid for signature verification for 24 hours.
+func AccountsRotateKeys(env *Env, w http.ResponseWriter, r *http.Request) error {
+	account, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	if err := models.NewAccounts(env.DB).RotateKeys(account, 24*time.Hour); err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().account(account.Actor))
+}
+
+// AccountsAliasesCreate adds an alias to the authenticated account's actor.
+func AccountsAliasesCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	account, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	alias, err := uriParam(r, "alias")
+	if err != nil {
+		return err
+	}
+	if err := models.NewActors(env.DB).AddAlias(account.Actor, alias); err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().credentialAccount(account))
+}
+
+// AccountsAliasesDestroy removes an alias from the authenticated account's actor.
+func AccountsAliasesDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
+	account, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	alias, err := uriParam(r, "alias")
+	if err != nil {
+		return err
+	}
+	if err := models.NewActors(env.DB).RemoveAlias(account.Actor, alias); err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().credentialAccount(account))
+}
+
+// AccountsMove moves the authenticated account to the target actor, which must
+// already have the account as an alias. As the move cannot be undone, the
+// password of the account must be confirmed.
+func AccountsMove(env *Env, w http.ResponseWriter, r *http.Request) error {
+	account, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	params, err := bodyParams(r, "target", "password")
+	if err != nil {
+		return err
+	}
+	if err := bcrypt.CompareHashAndPassword(account.EncryptedPassword, []byte(params["password"])); err != nil {
+		return httpx.Error(http.StatusForbidden, errors.New("invalid password"))
+	}
+	target, err := parseURI("target", params["target"])
+	if err != nil {
+		return err
+	}
+	if _, err := activitypub.MoveAccount(env.DB, account, target, env.ClientTimeout); err != nil {
+		return httpx.Error(http.StatusUnprocessableEntity, err)
+	}
+	return to.JSON(w, env.serialise().credentialAccount(account))
+}
+
+// uriParam returns the actor URI in the named parameter of the request body.
+func uriParam(r *http.Request, name string) (string, error) {
+	params, err := bodyParams(r, name)
+	if err != nil {
+		return "", err
+	}
+	return parseURI(name, params[name])
+}
+
+// bodyParams returns the named parameters of the request body.
+func bodyParams(r *http.Request, names ...string) (map[string]string, error) {
+	params := make(map[string]string)
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		for _, name := range names {
+			params[name] = r.PostFormValue(name)
+		}
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return nil, httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return nil, httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+	return params, nil
+}
+
+// parseURI returns value, the named parameter, if it is an https URI.
+func parseURI(name, value string) (string, error) {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return "", httpx.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid %s %q", name, value))
+	}
+	return u.String(), nil
 }
 
 func AccountsShowListMembership(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -0,0 +1,657 @@
+package mastodon
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davecheney/pub/internal/algorithms"
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/mime"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/to"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-json-experiment/json"
+	"gorm.io/gorm"
+)
+
+// AdminAccountsIndex lists and searches the accounts known to the instance;
+// its local accounts, and remote accounts.
+func AdminAccountsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	q := r.URL.Query()
+	tx := env.DB.Scopes(models.PaginateByID(r, "actors.id"), adminVisible(env, admin))
+	if q.Get("local") == "true" {
+		tx = tx.Where("actors.domain = ?", admin.Actor.Domain)
+	}
+	if q.Get("remote") == "true" {
+		tx = tx.Where("actors.domain <> ?", admin.Actor.Domain)
+	}
+	if domain := q.Get("by_domain"); domain != "" {
+		tx = tx.Where("actors.domain = ?", domain)
+	}
+	if q.Get("pending") == "true" {
+		tx = tx.Where("actors.id IN (?)", env.DB.Model(&models.Account{}).Select("actor_id").Where("pending = ?", true))
+	}
+	if q.Get("suspended") == "true" {
+		tx = tx.Where("actors.id IN (?)", env.DB.Model(&models.ActorModeration{}).Select("actor_id").Where("instance_id = ? AND suspended_at IS NOT NULL", admin.InstanceID))
+	}
+	if q.Get("silenced") == "true" {
+		tx = tx.Where("actors.id IN (?)", env.DB.Model(&models.ActorModeration{}).Select("actor_id").Where("instance_id = ? AND silenced_at IS NOT NULL", admin.InstanceID))
+	}
+	if username := q.Get("username"); username != "" {
+		tx = tx.Where("actors.name LIKE ? ESCAPE '!'", models.EscapeLike(username)+"%")
+	}
+	if displayName := q.Get("display_name"); displayName != "" {
+		tx = tx.Where("actors.display_name LIKE ? ESCAPE '!'", "%"+models.EscapeLike(displayName)+"%")
+	}
+	if email := q.Get("email"); email != "" {
+		tx = tx.Where("actors.id IN (?)", env.DB.Model(&models.Account{}).Select("actor_id").Where("email LIKE ? ESCAPE '!'", "%"+models.EscapeLike(email)+"%"))
+	}
+	var actors []*models.Actor
+	if err := tx.Find(&actors).Error; err != nil {
+		return err
+	}
+
+	ids := algorithms.Map(actors, func(a *models.Actor) snowflake.ID { return a.ID })
+	moderations, err := models.NewActorModerations(env.DB).FindAll(admin.InstanceID, ids)
+	if err != nil {
+		return err
+	}
+	byActor := make(map[uint64]*models.Account)
+	if len(actors) > 0 {
+		var accounts []*models.Account
+		if err := env.DB.Preload("Role").Where("actor_id IN (?)", algorithms.Map(actors, func(a *models.Actor) uint64 { return uint64(a.ID) })).Find(&accounts).Error; err != nil {
+			return err
+		}
+		for _, a := range accounts {
+			byActor[uint64(a.ActorID)] = a
+		}
+	}
+	serialise := env.serialise()
+	return to.JSON(w, algorithms.Map(actors, func(a *models.Actor) *AdminAccount {
+		return serialise.adminAccount(a, byActor[uint64(a.ID)], moderations[a.ID])
+	}))
+}
+
+// AdminAccountsShow shows the account.
+func AdminAccountsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	actor, account, err := adminAccount(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	return serialiseAdminAccount(env, w, admin, actor, account)
+}
+
+// AdminAccountsApprove approves the pending account.
+func AdminAccountsApprove(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	actor, account, err := pendingAccount(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).Approve(admin, account); err != nil {
+		return err
+	}
+	account.Pending = false
+	return serialiseAdminAccount(env, w, admin, actor, account)
+}
+
+// AdminAccountsReject rejects, and deletes, the pending account.
+func AdminAccountsReject(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	actor, account, err := pendingAccount(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).Reject(admin, account); err != nil {
+		return err
+	}
+	return serialiseAdminAccount(env, w, admin, actor, account)
+}
+
+// AdminAccountsAction takes action against the account; silence, suspend,
+// or none, which records a warning in the audit log.
+func AdminAccountsAction(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		params.Type = r.PostFormValue("type")
+		params.Text = r.PostFormValue("text")
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+	actor, _, err := adminAccount(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if actor.ID == admin.ActorID {
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("cannot take action against yourself"))
+	}
+	actions := models.NewAdminActions(env.DB)
+	switch params.Type {
+	case "suspend":
+		err = actions.Suspend(admin, actor, params.Text)
+	case "silence":
+		err = actions.Silence(admin, actor, params.Text)
+	case "none":
+		err = actions.Warn(admin, actor, params.Text)
+	default:
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("type must be one of none, silence, or suspend"))
+	}
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, map[string]any{})
+}
+
+// AdminAccountsUnsuspend lifts the suspension of the account.
+func AdminAccountsUnsuspend(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminAccountsUndo(env, w, r, (*models.AdminActions).Unsuspend)
+}
+
+// AdminAccountsUnsilence lifts the silencing of the account.
+func AdminAccountsUnsilence(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminAccountsUndo(env, w, r, (*models.AdminActions).Unsilence)
+}
+
+func adminAccountsUndo(env *Env, w http.ResponseWriter, r *http.Request, undo func(*models.AdminActions, *models.Account, *models.Actor) error) error {
+	admin, err := env.authorize(r, models.PermissionManageUsers)
+	if err != nil {
+		return err
+	}
+	actor, account, err := adminAccount(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if err := undo(models.NewAdminActions(env.DB), admin, actor); err != nil {
+		return err
+	}
+	return serialiseAdminAccount(env, w, admin, actor, account)
+}
+
+// serialiseAdminAccount writes the actor, and its account, with the admin's
+// instance's moderation of the actor.
+func serialiseAdminAccount(env *Env, w http.ResponseWriter, admin *models.Account, actor *models.Actor, account *models.Account) error {
+	moderation, err := models.NewActorModerations(env.DB).Find(admin.InstanceID, actor)
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().adminAccount(actor, account, moderation))
+}
+
+// adminVisible limits the actors to those the admin may manage; the local
+// actors of the admin's instance, and remote actors.
+func adminVisible(env *Env, admin *models.Account) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("actors.domain = ? OR actors.domain NOT IN (?)", admin.Actor.Domain, env.DB.Model(&models.Instance{}).Select("domain"))
+	}
+}
+
+// adminAccount returns the actor with id, and its account if it is local.
+func adminAccount(env *Env, admin *models.Account, id string) (*models.Actor, *models.Account, error) {
+	var actor models.Actor
+	if err := env.DB.Scopes(adminVisible(env, admin)).Take(&actor, "actors.id = ?", id).Error; err != nil {
+		return nil, nil, httpx.Error(http.StatusNotFound, err)
+	}
+	var account models.Account
+	if err := env.DB.Preload("Role").Take(&account, "actor_id = ?", actor.ID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &actor, nil, nil
+		}
+		return nil, nil, err
+	}
+	account.Actor = &actor
+	return &actor, &account, nil
+}
+
+// pendingAccount returns the local actor with id, and its account, which must
+// be pending approval.
+func pendingAccount(env *Env, admin *models.Account, id string) (*models.Actor, *models.Account, error) {
+	actor, account, err := adminAccount(env, admin, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account == nil || !account.Pending {
+		return nil, nil, httpx.Error(http.StatusUnprocessableEntity, errors.New("account is not pending approval"))
+	}
+	return actor, account, nil
+}
+
+// AdminDomainBlocksIndex lists the domains blocked by the instance.
+func AdminDomainBlocksIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	var blocks []*models.DomainBlock
+	if err := env.DB.Where("instance_id = ?", admin.InstanceID).Order("domain").Find(&blocks).Error; err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(blocks, serialiseDomainBlock))
+}
+
+// AdminDomainBlocksShow shows the domain block.
+func AdminDomainBlocksShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	block, err := adminDomainBlock(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, serialiseDomainBlock(block))
+}
+
+// AdminDomainBlocksCreate blocks a domain.
+func AdminDomainBlocksCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	block := models.DomainBlock{Severity: models.SeveritySuspend}
+	domain, err := domainBlockParams(r, &block)
+	if err != nil {
+		return err
+	}
+	block.Domain, err = validDomain(domain)
+	if err != nil {
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).BlockDomain(admin, &block); err != nil {
+		return httpx.Error(http.StatusUnprocessableEntity, err)
+	}
+	return to.JSON(w, serialiseDomainBlock(&block))
+}
+
+// AdminDomainBlocksUpdate changes the severity, or comments, of the domain
+// block. The domain cannot be changed.
+func AdminDomainBlocksUpdate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	block, err := adminDomainBlock(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if _, err := domainBlockParams(r, block); err != nil {
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).UpdateDomainBlock(admin, block); err != nil {
+		return err
+	}
+	return to.JSON(w, serialiseDomainBlock(block))
+}
+
+// domainBlockParams sets the fields of block present in the request, and
+// returns the domain, if present.
+func domainBlockParams(r *http.Request, block *models.DomainBlock) (string, error) {
+	var params struct {
+		Domain         string  `json:"domain"`
+		Severity       *string `json:"severity"`
+		RejectMedia    *bool   `json:"reject_media"`
+		PublicComment  *string `json:"public_comment"`
+		PrivateComment *string `json:"private_comment"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseForm(); err != nil {
+			return "", httpx.Error(http.StatusBadRequest, err)
+		}
+		params.Domain = r.PostForm.Get("domain")
+		if r.PostForm.Has("severity") {
+			params.Severity = ptr(r.PostForm.Get("severity"))
+		}
+		params.RejectMedia = formBool(r.PostForm, "reject_media")
+		if r.PostForm.Has("public_comment") {
+			params.PublicComment = ptr(r.PostForm.Get("public_comment"))
+		}
+		if r.PostForm.Has("private_comment") {
+			params.PrivateComment = ptr(r.PostForm.Get("private_comment"))
+		}
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return "", httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return "", httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+	if params.Severity != nil {
+		switch *params.Severity {
+		case models.SeveritySuspend, models.SeveritySilence, models.SeverityNoop:
+			block.Severity = *params.Severity
+		default:
+			return "", httpx.Error(http.StatusUnprocessableEntity, errors.New("severity must be one of suspend, silence, or noop"))
+		}
+	}
+	if params.RejectMedia != nil {
+		block.RejectMedia = *params.RejectMedia
+	}
+	if params.PublicComment != nil {
+		block.PublicComment = *params.PublicComment
+	}
+	if params.PrivateComment != nil {
+		block.PrivateComment = *params.PrivateComment
+	}
+	return params.Domain, nil
+}
+
+// validDomain returns the normalised domain, or an error if it is not a
+// valid domain.
+func validDomain(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" || strings.ContainsAny(domain, "/@ ") {
+		return "", httpx.Error(http.StatusUnprocessableEntity, errors.New("domain is invalid"))
+	}
+	return domain, nil
+}
+
+// AdminDomainBlocksDestroy removes the domain block.
+func AdminDomainBlocksDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	block, err := adminDomainBlock(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).UnblockDomain(admin, block); err != nil {
+		return err
+	}
+	return to.JSON(w, map[string]any{})
+}
+
+func adminDomainBlock(env *Env, admin *models.Account, id string) (*models.DomainBlock, error) {
+	var block models.DomainBlock
+	if err := env.DB.Take(&block, "id = ? AND instance_id = ?", id, admin.InstanceID).Error; err != nil {
+		return nil, httpx.Error(http.StatusNotFound, err)
+	}
+	return &block, nil
+}
+
+// AdminDomainAllowsIndex lists the domains the instance federates with in
+// allowlist mode.
+func AdminDomainAllowsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	var allows []*models.DomainAllow
+	if err := env.DB.Where("instance_id = ?", admin.InstanceID).Order("domain").Find(&allows).Error; err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(allows, serialiseDomainAllow))
+}
+
+// AdminDomainAllowsShow shows the domain allow.
+func AdminDomainAllowsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	allow, err := adminDomainAllow(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, serialiseDomainAllow(allow))
+}
+
+// AdminDomainAllowsCreate allows a domain.
+func AdminDomainAllowsCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Domain string `json:"domain"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		params.Domain = r.PostFormValue("domain")
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+	domain, err := validDomain(params.Domain)
+	if err != nil {
+		return err
+	}
+	allow, err := models.NewAdminActions(env.DB).AllowDomain(admin, domain)
+	if err != nil {
+		return httpx.Error(http.StatusUnprocessableEntity, err)
+	}
+	return to.JSON(w, serialiseDomainAllow(allow))
+}
+
+// AdminDomainAllowsDestroy removes the domain allow.
+func AdminDomainAllowsDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageFederation)
+	if err != nil {
+		return err
+	}
+	allow, err := adminDomainAllow(env, admin, chi.URLParam(r, "id"))
+	if err != nil {
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).DisallowDomain(admin, allow); err != nil {
+		return err
+	}
+	return to.JSON(w, map[string]any{})
+}
+
+func adminDomainAllow(env *Env, admin *models.Account, id string) (*models.DomainAllow, error) {
+	var allow models.DomainAllow
+	if err := env.DB.Take(&allow, "id = ? AND instance_id = ?", id, admin.InstanceID).Error; err != nil {
+		return nil, httpx.Error(http.StatusNotFound, err)
+	}
+	return &allow, nil
+}
+
+// AdminReportsIndex lists the instance's reports, the open reports unless
+// resolved=true.
+func AdminReportsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageReports)
+	if err != nil {
+		return err
+	}
+	tx := env.DB.Scopes(models.PaginateByID(r, "reports.id")).Preload("Actor").Preload("Target").Preload("ActionTakenBy.Actor").Where("instance_id = ?", admin.InstanceID)
+	if r.URL.Query().Get("resolved") == "true" {
+		tx = tx.Where("action_taken_at IS NOT NULL")
+	} else {
+		tx = tx.Where("action_taken_at IS NULL")
+	}
+	if id := r.URL.Query().Get("target_account_id"); id != "" {
+		tx = tx.Where("target_id = ?", id)
+	}
+	var reports []*models.Report
+	if err := tx.Find(&reports).Error; err != nil {
+		return err
+	}
+	serialised := make([]*AdminReport, 0, len(reports))
+	for _, report := range reports {
+		ar, err := serialiseAdminReport(env, report)
+		if err != nil {
+			return err
+		}
+		serialised = append(serialised, ar)
+	}
+	return to.JSON(w, serialised)
+}
+
+// AdminReportsShow shows the report.
+func AdminReportsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminReportsUpdate(env, w, r, nil)
+}
+
+// AdminReportsResolve marks the report as resolved.
+func AdminReportsResolve(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminReportsUpdate(env, w, r, (*models.AdminActions).ResolveReport)
+}
+
+// AdminReportsReopen marks the report as open.
+func AdminReportsReopen(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminReportsUpdate(env, w, r, (*models.AdminActions).ReopenReport)
+}
+
+// adminReportsUpdate applies update, if it is not nil, to the report and
+// returns it.
+func adminReportsUpdate(env *Env, w http.ResponseWriter, r *http.Request, update func(*models.AdminActions, *models.Account, *models.Report) error) error {
+	admin, err := env.authorize(r, models.PermissionManageReports)
+	if err != nil {
+		return err
+	}
+	var report models.Report
+	if err := env.DB.Preload("Actor").Preload("Target").Take(&report, "id = ? AND instance_id = ?", chi.URLParam(r, "id"), admin.InstanceID).Error; err != nil {
+		return httpx.Error(http.StatusNotFound, err)
+	}
+	if update != nil {
+		if err := update(models.NewAdminActions(env.DB), admin, &report); err != nil {
+			return err
+		}
+	}
+	report.ActionTakenBy = nil
+	if report.ActionTakenByID != nil {
+		var account models.Account
+		if err := env.DB.Joins("Actor").Take(&account, "accounts.id = ?", *report.ActionTakenByID).Error; err != nil {
+			return err
+		}
+		report.ActionTakenBy = &account
+	}
+	ar, err := serialiseAdminReport(env, &report)
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, ar)
+}
+
+// serialiseAdminReport serialises the report with its reported statuses.
+func serialiseAdminReport(env *Env, report *models.Report) (*AdminReport, error) {
+	var statuses []*models.Status
+	if len(report.StatusIDs) > 0 {
+		query := env.DB.Joins("Actor")
+		query = query.Preload("Reblog").Preload("Reblog.Actor")     // boosts
+		query = query.Preload("Attachments")                        // media
+		query = query.Preload("Mentions").Preload("Mentions.Actor") // mentions
+		query = query.Preload("Tags").Preload("Tags.Tag")           // tags
+		if err := query.Where("statuses.id IN (?)", report.StatusIDs).Find(&statuses).Error; err != nil {
+			return nil, err
+		}
+	}
+	ids := []snowflake.ID{report.ActorID, report.TargetID}
+	if report.ActionTakenBy != nil {
+		ids = append(ids, report.ActionTakenBy.ActorID)
+	}
+	moderations, err := models.NewActorModerations(env.DB).FindAll(report.InstanceID, ids)
+	if err != nil {
+		return nil, err
+	}
+	return env.serialise().adminReport(report, statuses, moderations), nil
+}
+
+// AdminActionLogsIndex lists the moderation actions taken by the admins of
+// the instance, newest first.
+func AdminActionLogsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionViewAuditLog)
+	if err != nil {
+		return err
+	}
+	tx := env.DB.Scopes(models.PaginateByID(r, "admin_actions.id")).Preload("Account.Actor").Where("admin_actions.instance_id = ?", admin.InstanceID)
+	if id := r.URL.Query().Get("account_id"); id != "" {
+		tx = tx.Where("admin_actions.account_id = ?", id)
+	}
+	if action := r.URL.Query().Get("action"); action != "" {
+		tx = tx.Where("admin_actions.action = ?", action)
+	}
+	var actions []*models.AdminAction
+	if err := tx.Find(&actions).Error; err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(actions, env.serialise().adminAction))
+}
+
+// AdminInvitesIndex lists the invites to the instance.
+func AdminInvitesIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageInvites)
+	if err != nil {
+		return err
+	}
+	var invites []*models.Invite
+	if err := env.DB.Where("instance_id = ?", admin.InstanceID).Order("id desc").Find(&invites).Error; err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(invites, env.serialise().invite))
+}
+
+// AdminInvitesCreate creates an invite to the instance. max_uses limits the
+// number of sign ups, expires_in is the number of seconds the invite is valid
+// for; if either is zero, or absent, the invite is unlimited.
+func AdminInvitesCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, err := env.authorize(r, models.PermissionManageInvites)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		MaxUses   int32 `json:"max_uses"`
+		ExpiresIn int64 `json:"expires_in"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		maxUses, _ := strconv.ParseInt(r.PostFormValue("max_uses"), 10, 32)
+		params.MaxUses = int32(maxUses)
+		params.ExpiresIn, _ = strconv.ParseInt(r.PostFormValue("expires_in"), 10, 64)
+	case "application/json", "application/octet-stream":
+		// an empty body creates an unlimited invite.
+		if r.ContentLength != 0 {
+			if err := json.UnmarshalFull(r.Body, &params); err != nil {
+				return httpx.Error(http.StatusBadRequest, err)
+			}
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+	if params.MaxUses < 0 || params.ExpiresIn < 0 {
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("max_uses and expires_in must not be negative"))
+	}
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return err
+	}
+	invite, err := models.NewInvites(env.DB).Create(instance, admin, params.MaxUses, time.Duration(params.ExpiresIn)*time.Second)
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().invite(invite))
+}
@@ -47,7 +47,7 @@ func AppsCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
 		ClientID:     uuid.New().String(),
 		ClientSecret: uuid.New().String(),
 		RedirectURI:  params.RedirectURIs,
-		VapidKey:     "BCk-QqERU0q-CfYZjcuB6lnyyOYfJ2AifKqfeGIm7Z-HiTU5T9eTG5GxVA0_OH5mMlI4UkkDTpaZwozy0TzdZ2M=",
+		VapidKey:     env.VapidKey,
 	}
 	if err := env.DB.Create(app).Error; err != nil {
 		return err
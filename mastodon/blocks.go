@@ -21,7 +21,7 @@ func BlocksIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return to.JSON(w, algorithms.Map(algorithms.Map(blocks, relationshipTarget), serialiseAccount))
+	return to.JSON(w, algorithms.Map(algorithms.Map(blocks, relationshipTarget), env.serialise().account))
 }
 
 func BlocksCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -7,6 +7,7 @@ import (
 	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/to"
+	"gorm.io/gorm/clause"
 )
 
 func ConversationsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -21,7 +22,7 @@ func ConversationsIndex(env *Env, w http.ResponseWriter, r *http.Request) error
 	case "":
 		scope = scope.Joins("Actor")
 	default:
-		scope = scope.Joins("Actor").Where("Actor.domain = ?", r.Host)
+		scope = scope.Joins("Actor").Where(clause.Eq{Column: clause.Column{Table: "Actor", Name: "domain"}, Value: r.Host})
 	}
 
 	if err := scope.Order("statuses.id desc").Find(&statuses).Error; err != nil {
@@ -29,7 +30,7 @@ func ConversationsIndex(env *Env, w http.ResponseWriter, r *http.Request) error
 	}
 
 	if len(statuses) > 0 {
-		w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/timelines/public?max_id=%d>; rel=\"next\", <https://%s/api/v1/timelines/public?min_id=%d>; rel=\"prev\"", r.Host, statuses[len(statuses)-1].ID, r.Host, statuses[0].ID))
+		w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/timelines/public?max_id=%d>; rel=\"next\", <%s/api/v1/timelines/public?min_id=%d>; rel=\"prev\"", env.URLs.Base(), statuses[len(statuses)-1].ID, env.URLs.Base(), statuses[0].ID))
 	}
-	return to.JSON(w, algorithms.Map(statuses, serialiseStatus))
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
 }
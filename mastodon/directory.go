@@ -7,16 +7,25 @@ import (
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/to"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func DirectoryIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	hidden, err := hiddenDomains(env, r, clause.Column{Name: "domain"}, true)
+	if err != nil {
+		return err
+	}
+	moderated, err := hiddenActors(env, r, "actors.id", true)
+	if err != nil {
+		return err
+	}
 	var actors []*models.Actor
-	query := env.DB.Scopes(models.PaginateActors(r), isLocal(r))
+	query := env.DB.Scopes(models.PaginateActors(r), isLocal(r), hidden, moderated, models.NotPending)
 	if err := query.Find(&actors).Error; err != nil {
 		return err
 	}
 
-	return to.JSON(w, algorithms.Map(actors, serialiseAccount))
+	return to.JSON(w, algorithms.Map(actors, env.serialise().account))
 }
 
 func isLocal(r *http.Request) func(db *gorm.DB) *gorm.DB {
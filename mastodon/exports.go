@@ -0,0 +1,21 @@
+package mastodon
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davecheney/pub/activitypub"
+)
+
+// ExportsShow streams an archive of the authenticated account.
+func ExportsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	account, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"archive-%s-%s.tar.gz\"", time.Now().Format("20060102150405"), account.Actor.Name))
+	// once the archive has started streaming errors cannot be reported to the client.
+	return activitypub.NewExporter(env.DB, account, env.Media).Export(w)
+}
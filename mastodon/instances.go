@@ -3,6 +3,7 @@ package mastodon
 import (
 	"net/http"
 
+	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/httpx"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/to"
@@ -10,11 +11,11 @@ import (
 )
 
 func InstancesIndexV1(env *Env, w http.ResponseWriter, r *http.Request) error {
-	return instancesIndex(env, w, r, serialiseInstanceV1)
+	return instancesIndex(env, w, r, env.serialise().instanceV1)
 }
 
 func InstancesIndexV2(env *Env, w http.ResponseWriter, r *http.Request) error {
-	return instancesIndex(env, w, r, serialiseInstanceV2)
+	return instancesIndex(env, w, r, env.serialise().instanceV2)
 }
 
 func instancesIndex(env *Env, w http.ResponseWriter, r *http.Request, seraliser func(*models.Instance) map[string]any) error {
@@ -43,6 +44,15 @@ func InstancesActivityShow(env *Env, w http.ResponseWriter, r *http.Request) err
 	return to.JSON(w, []map[string]interface{}{})
 }
 
+// InstancesDomainBlocksShow publishes the domains the instance blocks.
 func InstancesDomainBlocksShow(env *Env, w http.ResponseWriter, r *http.Request) error {
-	return to.JSON(w, []map[string]interface{}{})
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return httpx.Error(http.StatusNotFound, err)
+	}
+	var blocks []*models.DomainBlock
+	if err := env.DB.Where("instance_id = ?", instance.ID).Order("domain").Find(&blocks).Error; err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(blocks, serialisePublicDomainBlock))
 }
@@ -174,7 +174,7 @@ func ListsViewMembers(env *Env, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return to.JSON(w, algorithms.Map(algorithms.Map(members, listMember), serialiseAccount))
+	return to.JSON(w, algorithms.Map(algorithms.Map(members, listMember), env.serialise().account))
 }
 
 func listMember(list *models.AccountListMember) *models.Actor {
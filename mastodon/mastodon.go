@@ -3,16 +3,62 @@ package mastodon
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/davecheney/pub/internal/httpx"
 	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/davecheney/pub/media"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Env struct {
 	*models.Env
+	// Media stores files uploaded by accounts.
+	Media *media.Store
+	// VapidKey is the public key sent to applications for web push.
+	VapidKey string
+	// URLs builds the URLs of the instance the request was made to.
+	URLs *urls.Builder
+	// DefaultAvatar and DefaultHeader are the images given to new accounts.
+	DefaultAvatar string
+	DefaultHeader string
+}
+
+// serialise returns a serialiser for the instance the request was made to.
+func (e *Env) serialise() *serialiser {
+	return &serialiser{urls: e.URLs, rejectsMedia: e.rejectsMedia()}
+}
+
+// rejectsMedia returns a function which reports whether the request's instance
+// rejects the media of a domain. The policy of each domain is looked up once.
+// If the policy cannot be found the media is rejected.
+func (e *Env) rejectsMedia() func(string) bool {
+	var instance *models.Instance
+	policies := make(map[string]bool)
+	return func(domain string) bool {
+		if rejected, ok := policies[domain]; ok {
+			return rejected
+		}
+		if instance == nil {
+			instance = new(models.Instance)
+			if err := e.DB.Take(instance, "domain = ?", e.URLs.Host()).Error; err != nil {
+				fmt.Println("rejectsMedia:", e.URLs.Host(), err)
+				instance = nil
+				return true
+			}
+		}
+		policy, err := models.NewDomainBlocks(e.DB).Policy(instance, domain)
+		if err != nil {
+			fmt.Println("rejectsMedia:", domain, err)
+			return true
+		}
+		policies[domain] = policy.RejectMedia
+		return policy.RejectMedia
+	}
 }
 
 // authenticate authenticates the bearer token attached to the request and, if
@@ -29,9 +75,72 @@ func (e *Env) authenticate(r *http.Request) (*models.Account, error) {
 		}
 		return nil, err
 	}
+	if token.Account.Actor.Domain != r.Host {
+		// tokens are issued by an instance, and are not valid for the others
+		// served by the same process.
+		return nil, httpx.Error(http.StatusUnauthorized, errors.New("invalid bearer token"))
+	}
+	if token.Account.Actor.IsService() {
+		return nil, httpx.Error(http.StatusForbidden, errors.New("the instance actor cannot be used by applications"))
+	}
+	if token.Account.Pending {
+		return nil, httpx.Error(http.StatusForbidden, errors.New("account is pending approval"))
+	}
+	moderation, err := models.NewActorModerations(e.DB).Find(token.Account.InstanceID, token.Account.Actor)
+	if err != nil {
+		return nil, err
+	}
+	if moderation.IsSuspended() {
+		return nil, httpx.Error(http.StatusForbidden, errors.New("account is suspended"))
+	}
 	return token.Account, nil
 }
 
+// authorize authenticates the request, as authenticate, and checks the
+// account's role has permission p.
+func (e *Env) authorize(r *http.Request, p uint32) (*models.Account, error) {
+	account, err := e.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if account.Role == nil || !account.Role.Can(p) {
+		return nil, httpx.Error(http.StatusForbidden, errors.New("permission denied"))
+	}
+	return account, nil
+}
+
+// hiddenDomains returns a scope which hides the rows whose column holds a
+// domain the request's instance does not federate with, or, if silenced is
+// true, silences.
+func hiddenDomains(env *Env, r *http.Request, column clause.Column, silenced bool) (func(*gorm.DB) *gorm.DB, error) {
+	var instance models.Instance
+	if err := env.DB.Take(&instance, "domain = ?", r.Host).Error; err != nil {
+		return nil, httpx.Error(http.StatusNotFound, err)
+	}
+	return models.NewDomainBlocks(env.DB).Hidden(&instance, column, silenced)
+}
+
+// hiddenActors returns a scope which hides the rows whose column holds the ID
+// of an actor the request's instance has suspended, or, if silenced is true,
+// silenced.
+func hiddenActors(env *Env, r *http.Request, column string, silenced bool) (func(*gorm.DB) *gorm.DB, error) {
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return nil, httpx.Error(http.StatusNotFound, err)
+	}
+	return models.NewActorModerations(env.DB).Hidden(instance.ID, column, silenced), nil
+}
+
+// requestBackfill queues a backfill of kind for the remote object at uri, signed
+// by the service account of the request's instance.
+func requestBackfill(env *Env, r *http.Request, kind, uri string) error {
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return err
+	}
+	return models.NewBackfills(env.DB).Request(instance, kind, uri)
+}
+
 func stringOrDefault(s string, def string) string {
 	if s == "" {
 		return def
@@ -21,7 +21,7 @@ func MutesIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return to.JSON(w, algorithms.Map(algorithms.Map(mutes, relationshipTarget), serialiseAccount))
+	return to.JSON(w, algorithms.Map(algorithms.Map(mutes, relationshipTarget), env.serialise().account))
 }
 
 func MutesCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
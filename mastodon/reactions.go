@@ -29,7 +29,7 @@ func FavouritesCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 	status.Reaction = reaction
 	status.FavouritesCount++
-	return to.JSON(w, serialiseStatus(&status))
+	return to.JSON(w, env.serialise().status(&status))
 }
 
 func FavouritesDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -50,7 +50,7 @@ func FavouritesDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 	status.Reaction = reaction
 	status.FavouritesCount--
-	return to.JSON(w, serialiseStatus(&status))
+	return to.JSON(w, env.serialise().status(&status))
 }
 
 func FavouritesShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -63,7 +63,7 @@ func FavouritesShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	return to.JSON(w, algorithms.Map(algorithms.Map(reactions, reactionActor), serialiseAccount))
+	return to.JSON(w, algorithms.Map(algorithms.Map(reactions, reactionActor), env.serialise().account))
 }
 
 func reactionActor(r *models.Reaction) *models.Actor { return r.Actor }
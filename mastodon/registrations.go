@@ -0,0 +1,146 @@
+package mastodon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/mime"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/to"
+	"github.com/go-json-experiment/json"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// validUsername matches the usernames which may be registered.
+var validUsername = regexp.MustCompile(`^[a-zA-Z0-9_]{1,30}$`)
+
+// minPasswordLength is the shortest password accepted for a new account.
+const minPasswordLength = 8
+
+// AccountsCreate registers an account on the instance, subject to its
+// registrations mode, and returns an access token for the account. The
+// application registering the account is identified by its client_id and
+// client_secret.
+func AccountsCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	var params struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		Username     string `json:"username"`
+		Email        string `json:"email"`
+		Password     string `json:"password"`
+		Agreement    bool   `json:"agreement"`
+		Reason       string `json:"reason"`
+		InviteCode   string `json:"invite_code"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		params.ClientID = r.PostFormValue("client_id")
+		params.ClientSecret = r.PostFormValue("client_secret")
+		params.Username = r.PostFormValue("username")
+		params.Email = r.PostFormValue("email")
+		params.Password = r.PostFormValue("password")
+		if agreement := formBool(r.PostForm, "agreement"); agreement != nil {
+			params.Agreement = *agreement
+		}
+		params.Reason = r.PostFormValue("reason")
+		params.InviteCode = r.PostFormValue("invite_code")
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return httpx.Error(http.StatusNotFound, err)
+	}
+	var app models.Application
+	if err := env.DB.Take(&app, "instance_id = ? AND client_id = ? AND client_secret = ?", instance.ID, params.ClientID, params.ClientSecret).Error; err != nil {
+		return httpx.Error(http.StatusUnauthorized, errors.New("invalid client credentials"))
+	}
+
+	switch {
+	case !validUsername.MatchString(params.Username):
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("username must be 1 to 30 letters, numbers, or underscores"))
+	case !strings.Contains(params.Email, "@"):
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("email is invalid"))
+	case len(params.Password) < minPasswordLength:
+		return httpx.Error(http.StatusUnprocessableEntity, fmt.Errorf("password must be at least %d characters", minPasswordLength))
+	case !params.Agreement:
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("agreement must be accepted"))
+	}
+
+	var pending bool
+	switch instance.RegistrationsMode {
+	case models.RegistrationsOpen:
+	case models.RegistrationsApproval:
+		// an invite skips approval.
+		pending = params.InviteCode == ""
+	case models.RegistrationsInvite:
+		if params.InviteCode == "" {
+			return httpx.Error(http.StatusForbidden, errors.New("registrations require an invite"))
+		}
+	default:
+		return httpx.Error(http.StatusForbidden, errors.New("registrations are closed"))
+	}
+
+	var token *models.Token
+	err = env.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Actor{}).Where("name = ? AND domain = ?", params.Username, instance.Domain).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return httpx.Error(http.StatusUnprocessableEntity, errors.New("username is taken"))
+		}
+		var inviteID *uint32
+		if params.InviteCode != "" {
+			invite, err := models.NewInvites(tx).Use(instance, params.InviteCode)
+			if err != nil {
+				if errors.Is(err, models.ErrInvalidInvite) {
+					return httpx.Error(http.StatusUnprocessableEntity, err)
+				}
+				return err
+			}
+			inviteID = &invite.ID
+		}
+		account, err := models.NewAccounts(tx).Create(instance, models.NewAccount{
+			Name:     params.Username,
+			Email:    params.Email,
+			Password: params.Password,
+			Avatar:   env.DefaultAvatar,
+			Header:   env.DefaultHeader,
+			Pending:  pending,
+			Reason:   params.Reason,
+			InviteID: inviteID,
+			URLs:     env.URLs,
+		})
+		if err != nil {
+			return err
+		}
+		token = &models.Token{
+			AccessToken:   uuid.New().String(),
+			AccountID:     account.ID,
+			ApplicationID: app.ID,
+			TokenType:     "Bearer",
+			Scope:         "read write follow push",
+		}
+		return tx.Create(token).Error
+	})
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, map[string]any{
+		"access_token": token.AccessToken,
+		"token_type":   token.TokenType,
+		"scope":        token.Scope,
+		"created_at":   token.CreatedAt.Unix(),
+	})
+}
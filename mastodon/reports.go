@@ -0,0 +1,100 @@
+package mastodon
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/mime"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/to"
+	"github.com/go-json-experiment/json"
+)
+
+// maxReportComment is the longest comment accepted with a report.
+const maxReportComment = 1000
+
+// ReportsCreate reports an account, and optionally some of its statuses, to
+// the moderators of the instance. If forward is true, and the account is
+// remote, the report is also forwarded, anonymously, to the account's server.
+func ReportsCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		AccountID string   `json:"account_id"`
+		StatusIDs []string `json:"status_ids"`
+		Comment   string   `json:"comment"`
+		Forward   bool     `json:"forward"`
+		Category  string   `json:"category"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseForm(); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+		params.AccountID = r.PostForm.Get("account_id")
+		params.StatusIDs = r.PostForm["status_ids[]"]
+		params.Comment = r.PostForm.Get("comment")
+		if forward := formBool(r.PostForm, "forward"); forward != nil {
+			params.Forward = *forward
+		}
+		params.Category = r.PostForm.Get("category")
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+
+	switch params.Category {
+	case "", "spam", "legal", "violation", "other":
+	default:
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("category must be one of spam, legal, violation, or other"))
+	}
+	if len(params.Comment) > maxReportComment {
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("comment is too long"))
+	}
+	var target models.Actor
+	if err := env.DB.Take(&target, "id = ?", params.AccountID).Error; err != nil {
+		return httpx.Error(http.StatusNotFound, err)
+	}
+	if target.ID == user.ActorID {
+		return httpx.Error(http.StatusUnprocessableEntity, errors.New("cannot report yourself"))
+	}
+	var statusIDs []snowflake.ID
+	for _, id := range params.StatusIDs {
+		statusID, err := snowflake.Parse(id)
+		if err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+		statusIDs = append(statusIDs, statusID)
+	}
+	if len(statusIDs) > 0 {
+		var count int64
+		if err := env.DB.Model(&models.Status{}).Where("id IN (?) AND actor_id = ?", statusIDs, target.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count != int64(len(statusIDs)) {
+			return httpx.Error(http.StatusUnprocessableEntity, errors.New("statuses must belong to the reported account"))
+		}
+	}
+
+	var instance models.Instance
+	if err := env.DB.Take(&instance, user.InstanceID).Error; err != nil {
+		return err
+	}
+	report, err := models.NewReports(env.DB).Create(&instance, user.Actor, &target, models.NewReport{
+		Category:  params.Category,
+		Comment:   params.Comment,
+		StatusIDs: statusIDs,
+		Forward:   params.Forward,
+	})
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, env.serialise().report(report))
+}
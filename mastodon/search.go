@@ -1,115 +1,332 @@
 package mastodon
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strings"
 
 	"github.com/davecheney/pub/activitypub"
+	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/httpx"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/to"
 	"github.com/davecheney/pub/internal/webfinger"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func SearchIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
-	q := r.URL.Query().Get("q")
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
 	typ := r.URL.Query().Get("type")
-	if strings.Contains(q, "@") {
+	if r.URL.Query().Get("resolve") == "true" {
+		// resolving fetches from other servers on behalf of the user.
+		if _, err := env.authenticate(r); err != nil {
+			return err
+		}
+	}
+	if !strings.HasPrefix(q, "https://") {
+		return searchIndex(env, w, r, q, typ)
+	}
+	// a URI is looked up, or fetched if resolve is true.
+	if strings.Contains(q, "/@") {
 		typ = "accounts"
 	}
-	switch typ {
-	case "accounts":
+	if typ == "accounts" {
 		return searchAccounts(env, w, r, q)
-	// case "hashtags":
-	// 	s.searchHashtags(w, r, q)
-	default:
-		return searchStatuses(env, w, r, q)
 	}
+	return searchStatuses(env, w, r, q)
+}
+
+// searchIndex searches the accounts, hashtags and statuses known to this
+// server.
+func searchIndex(env *Env, w http.ResponseWriter, r *http.Request, q, typ string) error {
+	user, authErr := env.authenticate(r)
+	authenticated := authErr == nil
+	var viewer *models.Actor
+	if authenticated {
+		viewer = user.Actor
+	}
+
+	var resp = map[string]any{
+		"accounts": []any{},
+		"hashtags": []any{},
+		"statuses": []any{},
+	}
+	if q == "" {
+		return to.JSON(w, resp)
+	}
+	params := r.URL.Query()
+
+	if typ == "" || typ == "accounts" {
+		if params.Get("following") == "true" && !authenticated {
+			return authErr
+		}
+		actors, err := findAccounts(env, r, viewer, q)
+		if err != nil {
+			return err
+		}
+		resp["accounts"] = algorithms.Map(actors, env.serialise().account)
+	}
+
+	if typ == "" || typ == "hashtags" {
+		var tags []*models.Tag
+		if err := env.DB.Scopes(models.SearchTags(q), models.PaginateSearch(r, "")).Order("LENGTH(name)").Order("name").Find(&tags).Error; err != nil {
+			return httpx.Error(http.StatusInternalServerError, err)
+		}
+		resp["hashtags"] = algorithms.Map(tags, env.serialise().tag)
+	}
+
+	if typ == "" || typ == "statuses" {
+		hidden, err := hiddenDomains(env, r, clause.Column{Table: "Actor", Name: "domain"}, false)
+		if err != nil {
+			return err
+		}
+		suspended, err := hiddenActors(env, r, "statuses.actor_id", false)
+		if err != nil {
+			return err
+		}
+		scope := env.DB.Scopes(models.SearchStatuses(q), models.StatusesVisibleTo(viewer), hidden, suspended, models.PaginateSearch(r, "statuses.id"))
+		if accountID := params.Get("account_id"); accountID != "" {
+			scope = scope.Where("statuses.actor_id = ?", accountID)
+		}
+		query := scope.Joins("Actor")                           // author, one join and one join only
+		query = query.Preload("Reblog").Preload("Reblog.Actor") // boosts
+		query = query.Preload("Attachments")                    // media
+		if authenticated {
+			query = query.Preload("Reaction", "actor_id = ?", viewer.ID) // reactions
+		}
+		query = query.Preload("Mentions").Preload("Mentions.Actor") // mentions
+		query = query.Preload("Tags").Preload("Tags.Tag")           // tags
+		var statuses []*models.Status
+		if err := query.Order("statuses.id desc").Find(&statuses).Error; err != nil {
+			return httpx.Error(http.StatusInternalServerError, err)
+		}
+		resp["statuses"] = algorithms.Map(statuses, env.serialise().status)
+	}
+	return to.JSON(w, resp)
 }
 
+// searchAccounts looks up the actor with the URI q, or fetches it if resolve
+// is true.
 func searchAccounts(env *Env, w http.ResponseWriter, r *http.Request, q string) error {
+	var resp = map[string]any{
+		"accounts": []any{},
+		"hashtags": []any{},
+		"statuses": []any{},
+	}
 	var actor *models.Actor
 	var err error
 	switch r.URL.Query().Get("resolve") == "true" {
 	case true:
-		// true to fix up search query
-		switch {
-		case strings.HasPrefix(q, "https://"):
-			u, err := url.Parse(q)
-			if err != nil {
-				return httpx.Error(http.StatusBadRequest, err)
-			}
-			user := strings.TrimPrefix(u.Path[1:], "@")
-			q = "acct:" + user + "@" + u.Host
-			fallthrough
-		case strings.Contains(q, "@"):
-			fmt.Println("webfinger", q)
-			acct, err := webfinger.Parse(q)
-			if err != nil {
-				fmt.Println("webfinger.Parse", err)
-				return httpx.Error(http.StatusBadRequest, err)
-			}
-			wf, err := acct.Fetch(r.Context())
-			if err != nil {
-				fmt.Println("acct.Fetch", acct, wf, err)
-				return httpx.Error(http.StatusBadRequest, err)
-			}
-			q, err = wf.ActivityPub()
-			if err != nil {
-				fmt.Println("wf.ActivityPub", err)
-				return httpx.Error(http.StatusBadRequest, err)
-			}
-		}
-		// find admin of this request's domain
-		var instance models.Instance
-		if err := env.DB.Joins("Admin").Preload("Admin.Actor").Where("domain = ?", r.Host).First(&instance).Error; err != nil {
-			return httpx.Error(http.StatusInternalServerError, err)
+		actor, err = resolveAccount(env, r, q)
+		if err != nil {
+			// the actor could not be resolved, there is nothing to find.
+			fmt.Println("searchAccounts: resolveAccount:", q, err)
+			return to.JSON(w, resp)
 		}
-		fetcher := activitypub.NewRemoteActorFetcher(instance.Admin, env.DB)
-		actor, err = models.NewActors(env.DB).FindOrCreate(q, fetcher.Fetch)
 	default:
 		actor, err = models.NewActors(env.DB).FindByURI(q)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return to.JSON(w, resp)
+		}
+		if err != nil {
+			return httpx.Error(http.StatusInternalServerError, err)
+		}
+	}
+	if !actor.IsLocal() {
+		// we only know what has arrived in our inbox, fetch the actor's recent statuses.
+		if err := requestBackfill(env, r, "outbox", actor.URI); err != nil {
+			fmt.Println("searchAccounts: requestBackfill:", actor.URI, err)
+		}
+	}
+	resp["accounts"] = []any{env.serialise().account(actor)}
+	return to.JSON(w, resp)
+}
+
+// findAccounts returns the actors whose names start with q, ranked for
+// viewer, who may be nil. If resolve is true, and q is the name@domain of an
+// actor which is not known, the actor is resolved with webfinger and returned
+// first.
+func findAccounts(env *Env, r *http.Request, viewer *models.Actor, q string) ([]*models.Actor, error) {
+	params := r.URL.Query()
+	if q == "" {
+		return nil, nil
 	}
+	hidden, err := hiddenDomains(env, r, clause.Column{Name: "domain"}, false)
 	if err != nil {
-		return httpx.Error(http.StatusInternalServerError, err)
+		return nil, err
+	}
+	suspended, err := hiddenActors(env, r, "actors.id", false)
+	if err != nil {
+		return nil, err
+	}
+	query := env.DB.Scopes(models.SearchActors(q), hidden, suspended, models.RankActors(viewer, r.Host), models.PaginateSearch(r, ""), models.NotPending)
+	if params.Get("following") == "true" && viewer != nil {
+		query = query.Where("id IN (?)", env.DB.Model(&models.Relationship{}).Select("target_id").Where("actor_id = ? AND following = true", viewer.ID))
+	}
+	var actors []*models.Actor
+	if err := query.Find(&actors).Error; err != nil {
+		return nil, httpx.Error(http.StatusInternalServerError, err)
 	}
 
-	var resp = map[string]any{
-		"accounts": []any{
-			serialiseAccount(actor),
-		},
-		"hashtags": []any{},
-		"statuses": []any{},
+	name, domain, ok := parseAcct(q)
+	if !ok || params.Get("resolve") != "true" || params.Get("following") == "true" {
+		return actors, nil
 	}
-	return to.JSON(w, resp)
+	for _, actor := range actors {
+		if strings.EqualFold(actor.Name, name) && strings.EqualFold(actor.Domain, domain) {
+			return actors, nil
+		}
+	}
+	actor, err := resolveAccount(env, r, q)
+	if err != nil {
+		// the actor could not be resolved, return what is known.
+		fmt.Println("findAccounts: resolveAccount:", q, err)
+		return actors, nil
+	}
+	if !actor.IsLocal() {
+		if err := requestBackfill(env, r, "outbox", actor.URI); err != nil {
+			fmt.Println("findAccounts: requestBackfill:", actor.URI, err)
+		}
+	}
+	return append([]*models.Actor{actor}, actors...), nil
+}
+
+// resolveAccount returns the actor for q, the URI or profile URL of an actor,
+// or its name@domain. If the actor is not known, it is fetched, via webfinger
+// if q is not its URI.
+func resolveAccount(env *Env, r *http.Request, q string) (*models.Actor, error) {
+	uri := q
+	acct := ""
+	if strings.HasPrefix(q, "https://") {
+		u, err := url.Parse(q)
+		if err != nil {
+			return nil, err
+		}
+		if !isDomain(u.Host) {
+			return nil, fmt.Errorf("invalid account: %q", q)
+		}
+		if name, ok := strings.CutPrefix(u.Path, "/@"); ok {
+			acct = name + "@" + u.Host
+		}
+	} else {
+		acct = q
+	}
+	if acct != "" {
+		name, domain, ok := parseAcct(acct)
+		if !ok {
+			return nil, fmt.Errorf("invalid account: %q", q)
+		}
+		var actors []*models.Actor
+		if err := env.DB.Scopes(models.NotPending).Where("LOWER(name) = LOWER(?) AND LOWER(domain) = LOWER(?)", name, domain).Find(&actors).Error; err != nil {
+			return nil, err
+		}
+		if len(actors) > 0 {
+			return actors[0], nil
+		}
+		wf, err := (&webfinger.Acct{User: name, Host: domain}).Fetch(r.Context())
+		if err != nil {
+			return nil, err
+		}
+		uri, err = wf.ActivityPub()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// find the service account of this request's domain
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return nil, err
+	}
+	fetcher := activitypub.NewRemoteActorFetcher(instance.ServiceAccount, env.DB, env.ClientTimeout)
+	return env.Actors(instance).FindOrCreate(uri, fetcher.Fetch)
 }
 
+// parseAcct splits q, of the form name@domain with an optional leading @, or
+// acct: scheme, into its name and domain.
+func parseAcct(q string) (string, string, bool) {
+	q = strings.TrimPrefix(strings.TrimPrefix(q, "acct:"), "@")
+	name, domain, ok := strings.Cut(q, "@")
+	if !ok || name == "" || !isDomain(domain) {
+		return "", "", false
+	}
+	return name, domain, true
+}
+
+// isDomain returns true if host is a domain name, without a port. IP
+// addresses and ports are rejected, so resolving an account cannot be used
+// to reach arbitrary hosts and services.
+func isDomain(host string) bool {
+	if host == "" || strings.ContainsAny(host, "@/:[]") {
+		return false
+	}
+	_, err := netip.ParseAddr(host)
+	return err != nil
+}
+
+// searchStatuses looks up the status with the URI q, or fetches it if resolve
+// is true. The status is returned only if the user may see it.
 func searchStatuses(env *Env, w http.ResponseWriter, r *http.Request, q string) error {
+	var resp = map[string]any{
+		"accounts": []any{},
+		"hashtags": []any{},
+		"statuses": []any{},
+	}
+	var viewer *models.Actor
+	if user, err := env.authenticate(r); err == nil {
+		viewer = user.Actor
+	}
 	var status *models.Status
 	var err error
 	switch r.URL.Query().Get("resolve") == "true" {
 	case true:
-		// find admin of this request's domain
-		var instance models.Instance
-		if err := env.DB.Joins("Admin").Preload("Admin.Actor").Where("domain = ?", r.Host).First(&instance).Error; err != nil {
+		// find the service account of this request's domain
+		var instance *models.Instance
+		instance, err = models.NewInstances(env.DB).FindByDomain(r.Host)
+		if err != nil {
 			return httpx.Error(http.StatusInternalServerError, err)
 		}
-		fetcher := activitypub.NewRemoteStatusFetcher(instance.Admin, env.DB)
+		fetcher := activitypub.NewRemoteStatusFetcher(instance.ServiceAccount, env.DB, env.ClientTimeout)
 		status, err = models.NewStatuses(env.DB).FindOrCreate(q, fetcher.Fetch)
+		if err != nil {
+			// the status could not be resolved, there is nothing to find.
+			fmt.Println("searchStatuses: FindOrCreate:", q, err)
+			return to.JSON(w, resp)
+		}
 	default:
 		status, err = models.NewStatuses(env.DB).FindByURI(q)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return to.JSON(w, resp)
+		}
+		if err != nil {
+			return httpx.Error(http.StatusInternalServerError, err)
+		}
 	}
+
+	hidden, err := hiddenDomains(env, r, clause.Column{Table: "Actor", Name: "domain"}, false)
 	if err != nil {
-		return httpx.Error(http.StatusInternalServerError, err)
+		return err
 	}
-	var resp = map[string]any{
-		"accounts": []any{},
-		"hashtags": []any{},
-		"statuses": []any{
-			serialiseStatus(status),
-		},
+	suspended, err := hiddenActors(env, r, "statuses.actor_id", false)
+	if err != nil {
+		return err
+	}
+	query := env.DB.Scopes(models.StatusesVisibleTo(viewer), hidden, suspended).Where("statuses.id = ?", status.ID)
+	query = query.Joins("Actor")                            // author, one join and one join only
+	query = query.Preload("Reblog").Preload("Reblog.Actor") // boosts
+	query = query.Preload("Attachments")                    // media
+	if viewer != nil {
+		query = query.Preload("Reaction", "actor_id = ?", viewer.ID) // reactions
+	}
+	query = query.Preload("Mentions").Preload("Mentions.Actor") // mentions
+	query = query.Preload("Tags").Preload("Tags.Tag")           // tags
+	var statuses []*models.Status
+	if err := query.Find(&statuses).Error; err != nil {
+		return httpx.Error(http.StatusInternalServerError, err)
 	}
+	resp["statuses"] = algorithms.Map(statuses, env.serialise().status)
 	return to.JSON(w, resp)
 }
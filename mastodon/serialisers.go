@@ -1,12 +1,16 @@
 package mastodon
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/davecheney/pub/internal/algorithms"
 	"github.com/davecheney/pub/internal/models"
 	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
 	"github.com/davecheney/pub/media"
 )
 
@@ -35,12 +39,13 @@ type Account struct {
 	NoIndex        bool             `json:"noindex"` // default false
 	Emojis         []map[string]any `json:"emojis"`
 	Fields         []Field          `json:"fields"`
+	Moved          *Account         `json:"moved,omitempty"`
 }
 
 type Field struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
-	// TODO verified_at
+	Name       string  `json:"name"`
+	Value      string  `json:"value"`
+	VerifiedAt *string `json:"verified_at"`
 }
 
 type CredentialAccount struct {
@@ -69,8 +74,17 @@ type Source struct {
 	Fields              []map[string]any `json:"fields"`
 }
 
-func serialiseAccount(a *models.Actor) *Account {
-	return &Account{
+// serialiser serialises models to their Mastodon API entities, with URLs on
+// the instance the request was made to.
+type serialiser struct {
+	urls *urls.Builder
+	// rejectsMedia returns true if the instance does not show the
+	// attachments of domain.
+	rejectsMedia func(domain string) bool
+}
+
+func (s *serialiser) account(a *models.Actor) *Account {
+	account := &Account{
 		ID:             a.ID,
 		Username:       a.Name,
 		Acct:           a.Acct(),
@@ -80,11 +94,11 @@ func serialiseAccount(a *models.Actor) *Account {
 		Group:          a.IsGroup(),
 		CreatedAt:      a.ID.ToTime().Round(time.Hour).Format("2006-01-02T00:00:00.000Z"),
 		Note:           a.Note,
-		URL:            fmt.Sprintf("https://%s/@%s", a.Domain, a.Name),
-		Avatar:         media.ProxyAvatarURL(a),
-		AvatarStatic:   media.ProxyAvatarURL(a),
-		Header:         media.ProxyHeaderURL(a),
-		HeaderStatic:   media.ProxyHeaderURL(a),
+		URL:            a.URL(),
+		Avatar:         media.ProxyAvatarURL(s.urls, a),
+		AvatarStatic:   media.ProxyAvatarURL(s.urls, a),
+		Header:         media.ProxyHeaderURL(s.urls, a),
+		HeaderStatic:   media.ProxyHeaderURL(s.urls, a),
 		FollowersCount: a.FollowersCount,
 		FollowingCount: a.FollowingCount,
 		StatusesCount:  a.StatusesCount,
@@ -100,19 +114,36 @@ func serialiseAccount(a *models.Actor) *Account {
 			return Field{
 				Name:  a.Name,
 				Value: a.Value,
+				VerifiedAt: func() *string {
+					if a.VerifiedAt == nil {
+						return nil
+					}
+					st := a.VerifiedAt.UTC().Format("2006-01-02T15:04:05.000Z")
+					return &st
+				}(),
 			}
 		}),
 	}
+	if a.MovedTo != nil {
+		account.Moved = s.account(a.MovedTo)
+	}
+	return account
 }
 
-func serialiseCredentialAccount(a *models.Account) *CredentialAccount {
+func (s *serialiser) credentialAccount(a *models.Account) *CredentialAccount {
 	ca := CredentialAccount{
-		Account: serialiseAccount(a.Actor),
+		Account: s.account(a.Actor),
 		Source: Source{
-			Privacy:   "public",
-			Sensitive: false,
-			Language:  "en",
+			Privacy:   a.DefaultPrivacy,
+			Sensitive: a.DefaultSensitive,
+			Language:  a.DefaultLanguage,
 			Note:      a.Actor.Note,
+			Fields: algorithms.Map(a.Actor.Attributes, func(a *models.ActorAttribute) map[string]any {
+				return map[string]any{
+					"name":  a.Name,
+					"value": a.Value,
+				}
+			}),
 		},
 	}
 	if a.Role != nil {
@@ -130,6 +161,258 @@ func serialiseCredentialAccount(a *models.Account) *CredentialAccount {
 	return &ca
 }
 
+// AdminAccount is the admin API's view of an account.
+type AdminAccount struct {
+	ID        snowflake.ID `json:"id,string"`
+	Username  string       `json:"username"`
+	Domain    *string      `json:"domain"`
+	CreatedAt string       `json:"created_at"`
+	Email     string       `json:"email"`
+	Locale    string       `json:"locale"`
+	Confirmed bool         `json:"confirmed"`
+	Approved  bool         `json:"approved"`
+	Disabled  bool         `json:"disabled"`
+	Silenced  bool         `json:"silenced"`
+	Suspended bool         `json:"suspended"`
+	// InviteRequest is the reason given when signing up.
+	InviteRequest *string  `json:"invite_request"`
+	Role          *Role    `json:"role"`
+	Account       *Account `json:"account"`
+}
+
+// adminAccount serialises the actor, and its local account if it has one,
+// with the instance's moderation of the actor, if it has moderated it.
+func (s *serialiser) adminAccount(actor *models.Actor, account *models.Account, moderation *models.ActorModeration) *AdminAccount {
+	aa := &AdminAccount{
+		ID:        actor.ID,
+		Username:  actor.Name,
+		CreatedAt: actor.ID.ToTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+		Approved:  true,
+		Account:   s.account(actor),
+	}
+	if moderation != nil {
+		aa.Silenced = moderation.IsSilenced()
+		aa.Suspended = moderation.IsSuspended()
+	}
+	if account == nil {
+		aa.Domain = &actor.Domain
+		return aa
+	}
+	aa.Email = account.Email
+	aa.Locale = account.DefaultLanguage
+	aa.Confirmed = true
+	aa.Approved = !account.Pending
+	if account.Reason != "" {
+		aa.InviteRequest = &account.Reason
+	}
+	if account.Role != nil {
+		account.Actor = actor
+		aa.Role = s.credentialAccount(account).Role
+	}
+	return aa
+}
+
+// DomainBlock is the admin API's view of a domain block.
+type DomainBlock struct {
+	ID             uint32  `json:"id,string"`
+	Domain         string  `json:"domain"`
+	CreatedAt      string  `json:"created_at"`
+	Severity       string  `json:"severity"`
+	RejectMedia    bool    `json:"reject_media"`
+	RejectReports  bool    `json:"reject_reports"`
+	PrivateComment *string `json:"private_comment"`
+	PublicComment  *string `json:"public_comment"`
+	Obfuscate      bool    `json:"obfuscate"`
+}
+
+func serialiseDomainBlock(b *models.DomainBlock) *DomainBlock {
+	return &DomainBlock{
+		ID:             b.ID,
+		Domain:         b.Domain,
+		CreatedAt:      b.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Severity:       b.Severity,
+		RejectMedia:    b.RejectMedia,
+		PrivateComment: stringOrNull(b.PrivateComment),
+		PublicComment:  stringOrNull(b.PublicComment),
+	}
+}
+
+// stringOrNull returns a pointer to s, or nil if s is empty.
+func stringOrNull(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// DomainAllow is the admin API's view of a domain allow.
+type DomainAllow struct {
+	ID        uint32 `json:"id,string"`
+	Domain    string `json:"domain"`
+	CreatedAt string `json:"created_at"`
+}
+
+func serialiseDomainAllow(a *models.DomainAllow) *DomainAllow {
+	return &DomainAllow{
+		ID:        a.ID,
+		Domain:    a.Domain,
+		CreatedAt: a.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+	}
+}
+
+// PublicDomainBlock is the public view of a domain block, published by the
+// instance.
+type PublicDomainBlock struct {
+	Domain   string  `json:"domain"`
+	Digest   string  `json:"digest"`
+	Severity string  `json:"severity"`
+	Comment  *string `json:"comment"`
+}
+
+func serialisePublicDomainBlock(b *models.DomainBlock) *PublicDomainBlock {
+	return &PublicDomainBlock{
+		Domain:   b.Domain,
+		Digest:   fmt.Sprintf("%x", sha256.Sum256([]byte(b.Domain))),
+		Severity: b.Severity,
+		Comment:  stringOrNull(b.PublicComment),
+	}
+}
+
+// AdminReport is the admin API's view of a report.
+type AdminReport struct {
+	ID                   snowflake.ID  `json:"id,string"`
+	ActionTaken          bool          `json:"action_taken"`
+	ActionTakenAt        *string       `json:"action_taken_at"`
+	Category             string        `json:"category"`
+	Comment              string        `json:"comment"`
+	Forwarded            bool          `json:"forwarded"`
+	CreatedAt            string        `json:"created_at"`
+	UpdatedAt            string        `json:"updated_at"`
+	Account              *AdminAccount `json:"account"`
+	TargetAccount        *AdminAccount `json:"target_account"`
+	AssignedAccount      *AdminAccount `json:"assigned_account"`
+	ActionTakenByAccount *AdminAccount `json:"action_taken_by_account"`
+	Statuses             []*Status     `json:"statuses"`
+	Rules                []Rule        `json:"rules"`
+}
+
+// adminReport serialises the report, with its reported statuses, and the
+// instance's moderations of the actors involved, by actor ID.
+func (s *serialiser) adminReport(r *models.Report, statuses []*models.Status, moderations map[snowflake.ID]*models.ActorModeration) *AdminReport {
+	ar := &AdminReport{
+		ID:            r.ID,
+		ActionTaken:   r.IsResolved(),
+		Category:      r.Category,
+		Comment:       r.Comment,
+		Forwarded:     r.Forwarded,
+		CreatedAt:     r.ID.ToTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+		UpdatedAt:     r.UpdatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Account:       s.adminAccount(r.Actor, nil, moderations[r.ActorID]),
+		TargetAccount: s.adminAccount(r.Target, nil, moderations[r.TargetID]),
+		Statuses:      algorithms.Map(statuses, s.status),
+		Rules:         []Rule{},
+	}
+	if r.ActionTakenAt != nil {
+		at := r.ActionTakenAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		ar.ActionTakenAt = &at
+	}
+	if r.ActionTakenBy != nil && r.ActionTakenBy.Actor != nil {
+		ar.ActionTakenByAccount = s.adminAccount(r.ActionTakenBy.Actor, r.ActionTakenBy, moderations[r.ActionTakenBy.ActorID])
+	}
+	return ar
+}
+
+// Report is a report made by the user.
+type Report struct {
+	ID            snowflake.ID `json:"id,string"`
+	ActionTaken   bool         `json:"action_taken"`
+	ActionTakenAt *string      `json:"action_taken_at"`
+	Category      string       `json:"category"`
+	Comment       string       `json:"comment"`
+	Forwarded     bool         `json:"forwarded"`
+	CreatedAt     string       `json:"created_at"`
+	StatusIDs     []string     `json:"status_ids"`
+	RuleIDs       []string     `json:"rule_ids"`
+	TargetAccount *Account     `json:"target_account"`
+}
+
+func (s *serialiser) report(r *models.Report) *Report {
+	rep := &Report{
+		ID:            r.ID,
+		ActionTaken:   r.IsResolved(),
+		Category:      r.Category,
+		Comment:       r.Comment,
+		Forwarded:     r.Forwarded,
+		CreatedAt:     r.ID.ToTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+		StatusIDs:     algorithms.Map(r.StatusIDs, func(id snowflake.ID) string { return fmt.Sprint(uint64(id)) }),
+		RuleIDs:       []string{},
+		TargetAccount: s.account(r.Target),
+	}
+	if rep.StatusIDs == nil {
+		rep.StatusIDs = []string{}
+	}
+	if r.ActionTakenAt != nil {
+		at := r.ActionTakenAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		rep.ActionTakenAt = &at
+	}
+	return rep
+}
+
+// AdminAction is an entry in the audit log.
+type AdminAction struct {
+	ID         snowflake.ID `json:"id,string"`
+	CreatedAt  string       `json:"created_at"`
+	Action     string       `json:"action"`
+	TargetType string       `json:"target_type"`
+	TargetID   string       `json:"target_id"`
+	Target     string       `json:"target"`
+	Text       string       `json:"text"`
+	Account    *Account     `json:"account"`
+}
+
+func (s *serialiser) adminAction(a *models.AdminAction) *AdminAction {
+	aa := &AdminAction{
+		ID:         a.ID,
+		CreatedAt:  a.ID.ToTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+		Action:     a.Action,
+		TargetType: a.TargetType,
+		TargetID:   fmt.Sprint(a.TargetID),
+		Target:     a.Target,
+		Text:       a.Text,
+	}
+	if a.Account != nil && a.Account.Actor != nil {
+		aa.Account = s.account(a.Account.Actor)
+	}
+	return aa
+}
+
+// Invite is an invite to sign up to the instance.
+type Invite struct {
+	ID        uint32  `json:"id,string"`
+	Code      string  `json:"code"`
+	CreatedAt string  `json:"created_at"`
+	ExpiresAt *string `json:"expires_at"`
+	MaxUses   *int32  `json:"max_uses"`
+	Uses      int32   `json:"uses"`
+}
+
+func (s *serialiser) invite(i *models.Invite) *Invite {
+	inv := &Invite{
+		ID:        i.ID,
+		Code:      i.Code,
+		CreatedAt: i.CreatedAt.UTC().Format("2006-01-02T15:04:05.000Z"),
+		Uses:      i.Uses,
+	}
+	if i.ExpiresAt != nil {
+		expiresAt := i.ExpiresAt.UTC().Format("2006-01-02T15:04:05.000Z")
+		inv.ExpiresAt = &expiresAt
+	}
+	if i.MaxUses > 0 {
+		inv.MaxUses = &i.MaxUses
+	}
+	return inv
+}
+
 type Relationship struct {
 	ID                  snowflake.ID `json:"id,string"`
 	Following           bool         `json:"following"`
@@ -205,45 +488,40 @@ type Status struct {
 	Application        any                `json:"application"`
 }
 
-func serialiseStatus(s *models.Status) *Status {
-	if s == nil {
+func (s *serialiser) status(st *models.Status) *Status {
+	if st == nil {
 		return nil
 	}
 	return &Status{
-		ID:                 s.ID,
-		CreatedAt:          s.ID.ToTime().Round(time.Second).Format("2006-01-02T15:04:05.000Z"),
+		ID:                 st.ID,
+		CreatedAt:          st.ID.ToTime().Round(time.Second).Format("2006-01-02T15:04:05.000Z"),
 		EditedAt:           nil,
-		InReplyToID:        s.InReplyToID,
-		InReplyToAccountID: s.InReplyToActorID,
-		Sensitive:          s.Sensitive,
-		SpoilerText:        s.SpoilerText,
-		Visibility:         s.Visibility,
-		Language:           s.Language,
-		URI:                s.URI,
+		InReplyToID:        st.InReplyToID,
+		InReplyToAccountID: st.InReplyToActorID,
+		Sensitive:          st.Sensitive,
+		SpoilerText:        st.SpoilerText,
+		Visibility:         st.Visibility,
+		Language:           st.Language,
+		URI:                st.URI,
 		URL:                nil,
 		Text:               nil, // not optional!!
-		RepliesCount:       s.RepliesCount,
-		ReblogsCount:       s.ReblogsCount,
-		FavouritesCount:    s.FavouritesCount,
-		Favourited:         s.Reaction != nil && s.Reaction.Favourited,
-		Reblogged:          s.Reaction != nil && s.Reaction.Reblogged,
-		Muted:              s.Reaction != nil && s.Reaction.Muted,
-		Bookmarked:         s.Reaction != nil && s.Reaction.Bookmarked,
-		Content:            s.Note,
-		Reblog:             serialiseStatus(s.Reblog),
-		Account:            serialiseAccount(s.Actor),
-		MediaAttachments:   algorithms.Map(algorithms.Map(s.Attachments, statusAttachmentToAttachment), serialiseAttachment),
-		Mentions:           algorithms.Map(algorithms.Map(s.Mentions, statusMentionToActor), serialiseMention),
-		Tags: algorithms.Map(algorithms.Map(s.Tags, statusTagToTag), func(t *models.Tag) *Tag {
-			return &Tag{
-				Name: t.Name,
-				URL:  fmt.Sprintf("/tags/%s", t.Name), // todo, this URL should be absolute to the instance
-			}
-		}),
-		Emojis:      []any{},
-		Card:        nil,
-		Poll:        nil,
-		Application: nil,
+		RepliesCount:       st.RepliesCount,
+		ReblogsCount:       st.ReblogsCount,
+		FavouritesCount:    st.FavouritesCount,
+		Favourited:         st.Reaction != nil && st.Reaction.Favourited,
+		Reblogged:          st.Reaction != nil && st.Reaction.Reblogged,
+		Muted:              st.Reaction != nil && st.Reaction.Muted,
+		Bookmarked:         st.Reaction != nil && st.Reaction.Bookmarked,
+		Content:            st.Note,
+		Reblog:             s.status(st.Reblog),
+		Account:            s.account(st.Actor),
+		MediaAttachments:   algorithms.Map(algorithms.Map(s.statusAttachments(st), statusAttachmentToAttachment), s.attachment),
+		Mentions:           algorithms.Map(algorithms.Map(st.Mentions, statusMentionToActor), serialiseMention),
+		Tags:               algorithms.Map(algorithms.Map(st.Tags, statusTagToTag), s.tag),
+		Emojis:             []any{},
+		Card:               nil,
+		Poll:               nil,
+		Application:        nil,
 	}
 }
 
@@ -270,13 +548,23 @@ func statusTagToTag(st models.StatusTag) *models.Tag {
 	return st.Tag
 }
 
-func serialiseAttachment(att *models.Attachment) *MediaAttachment {
+// statusAttachments returns the attachments of st, unless the instance rejects
+// the media of its actor's domain. Attachments are stored while any instance
+// on this server accepts them, so they are hidden here.
+func (s *serialiser) statusAttachments(st *models.Status) []models.StatusAttachment {
+	if st.Actor != nil && s.rejectsMedia != nil && s.rejectsMedia(st.Actor.Domain) {
+		return nil
+	}
+	return st.Attachments
+}
+
+func (s *serialiser) attachment(att *models.Attachment) *MediaAttachment {
 	return &MediaAttachment{
 		ID:         att.ID,
 		Type:       attachmentType(att),
-		URL:        att.URL,
-		PreviewURL: att.URL,
-		RemoteURL:  nil,
+		URL:        media.ProxyAttachmentURL(s.urls, att),
+		PreviewURL: media.ProxyAttachmentURL(s.urls, att),
+		RemoteURL:  att.URL,
 		Meta: map[string]any{
 			"original": map[string]any{
 				"width":  att.Width,
@@ -321,7 +609,7 @@ func attachmentType(att *models.Attachment) string {
 	}
 }
 
-func serialiseInstanceV1(i *models.Instance) map[string]any {
+func (s *serialiser) instanceV1(i *models.Instance) map[string]any {
 	return map[string]any{
 		"uri":               i.Domain,
 		"title":             i.Title,
@@ -339,9 +627,9 @@ func serialiseInstanceV1(i *models.Instance) map[string]any {
 		},
 		"thumbnail":         i.Thumbnail,
 		"languages":         []any{"en"},
-		"registrations":     false,
-		"approval_required": false,
-		"invites_enabled":   false,
+		"registrations":     i.RegistrationsEnabled(),
+		"approval_required": i.RegistrationsMode == models.RegistrationsApproval,
+		"invites_enabled":   i.RegistrationsMode != models.RegistrationsClosed,
 		"configuration": map[string]any{
 			"accounts": map[string]any{
 				"max_featured_tags": 4,
@@ -392,12 +680,12 @@ func serialiseInstanceV1(i *models.Instance) map[string]any {
 				"max_expiration":            2629746,
 			},
 		},
-		"contact_account": serialiseAccount(i.Admin.Actor),
+		"contact_account": s.account(i.Admin.Actor),
 		"rules":           serialiseRules(i),
 	}
 }
 
-func serialiseInstanceV2(i *models.Instance) map[string]any {
+func (s *serialiser) instanceV2(i *models.Instance) map[string]any {
 	return map[string]any{
 		"domain":      i.Domain,
 		"title":       i.Title,
@@ -468,13 +756,13 @@ func serialiseInstanceV2(i *models.Instance) map[string]any {
 				},
 			},
 			"registrations": map[string]any{
-				"enabled":           false,
-				"approval_required": false,
+				"enabled":           i.RegistrationsEnabled(),
+				"approval_required": i.RegistrationsMode == models.RegistrationsApproval,
 				"message":           nil,
 			},
 			"contact": map[string]any{
 				"email":   i.Admin.Email,
-				"account": serialiseAccount(i.Admin.Actor),
+				"account": s.account(i.Admin.Actor),
 			},
 			"rules": serialiseRules(i),
 		},
@@ -569,4 +857,135 @@ type Tag struct {
 	Name    string           `json:"name"`
 	URL     string           `json:"url"`
 	History []map[string]any `json:"history,omitempty"`
+	// Following is only set when the tag is returned to an authenticated user.
+	Following *bool `json:"following,omitempty"`
+}
+
+func (s *serialiser) tag(t *models.Tag) *Tag {
+	return &Tag{
+		Name: t.Name,
+		URL:  s.urls.Tag(t.Name),
+	}
+}
+
+// serialiseTagHistory serialises the daily uses of a tag, Mastodon uses strings
+// for the numbers.
+func serialiseTagHistory(history []models.TagUse) []map[string]any {
+	return algorithms.Map(history, func(use models.TagUse) map[string]any {
+		return map[string]any{
+			"day":      strconv.FormatInt(use.Day.Unix(), 10),
+			"uses":     strconv.Itoa(use.Uses),
+			"accounts": strconv.Itoa(use.Accounts),
+		}
+	})
+}
+
+// AdminTag is a trending hashtag, as seen by an admin.
+// https://docs.joinmastodon.org/entities/Admin_Tag
+type AdminTag struct {
+	ID             uint32           `json:"id,string"`
+	Name           string           `json:"name"`
+	URL            string           `json:"url"`
+	History        []map[string]any `json:"history"`
+	Trendable      bool             `json:"trendable"`
+	Usable         bool             `json:"usable"`
+	RequiresReview bool             `json:"requires_review"`
+}
+
+// adminTag serialises the tag of trend, and whether instance shows it given
+// its review, which may be nil.
+func (s *serialiser) adminTag(trend *models.Trend, instance *models.Instance, review *models.TrendReview) *AdminTag {
+	return &AdminTag{
+		ID:             trend.Tag.ID,
+		Name:           trend.Tag.Name,
+		URL:            s.urls.Tag(trend.Tag.Name),
+		History:        []map[string]any{},
+		Trendable:      trendable(instance, review),
+		Usable:         true,
+		RequiresReview: review == nil,
+	}
+}
+
+// TrendsLink is a trending link, a preview card with its history. Without link
+// previews, the title is the URL and the provider is its host.
+// https://docs.joinmastodon.org/entities/PreviewCard/#trends-link
+type TrendsLink struct {
+	// ID, Trendable, and RequiresReview are only set for admins.
+	ID             uint32           `json:"id,omitzero,string"`
+	URL            string           `json:"url"`
+	Title          string           `json:"title"`
+	Description    string           `json:"description"`
+	Type           string           `json:"type"`
+	AuthorName     string           `json:"author_name"`
+	AuthorURL      string           `json:"author_url"`
+	ProviderName   string           `json:"provider_name"`
+	ProviderURL    string           `json:"provider_url"`
+	HTML           string           `json:"html"`
+	Width          int              `json:"width"`
+	Height         int              `json:"height"`
+	Image          *string          `json:"image"`
+	EmbedURL       string           `json:"embed_url"`
+	Blurhash       *string          `json:"blurhash"`
+	History        []map[string]any `json:"history"`
+	Trendable      *bool            `json:"trendable,omitempty"`
+	RequiresReview *bool            `json:"requires_review,omitempty"`
+}
+
+func (s *serialiser) trendsLink(trend *models.Trend) *TrendsLink {
+	link := &TrendsLink{
+		URL:     trend.URL,
+		Title:   trend.URL,
+		Type:    "link",
+		History: trendHistory(trend),
+	}
+	if u, err := url.Parse(trend.URL); err == nil {
+		link.ProviderName = u.Host
+		link.ProviderURL = u.Scheme + "://" + u.Host
+	}
+	return link
+}
+
+// adminTrendsLink serialises the link of trend, and whether instance shows it
+// given its review, which may be nil.
+func (s *serialiser) adminTrendsLink(trend *models.Trend, instance *models.Instance, review *models.TrendReview) *TrendsLink {
+	link := s.trendsLink(trend)
+	link.ID = trend.ID
+	shown, requiresReview := trendable(instance, review), review == nil
+	link.Trendable = &shown
+	link.RequiresReview = &requiresReview
+	return link
+}
+
+// trendHistory returns the use of a trend over the window as one day of
+// history, as uses are not kept by day.
+func trendHistory(trend *models.Trend) []map[string]any {
+	return serialiseTagHistory([]models.TagUse{{
+		Day:      time.Now().UTC().Truncate(24 * time.Hour),
+		Uses:     trend.Uses,
+		Accounts: trend.Accounts,
+	}})
+}
+
+// FeaturedTag is a hashtag featured on the profile of an account.
+// https://docs.joinmastodon.org/entities/FeaturedTag
+type FeaturedTag struct {
+	ID            snowflake.ID `json:"id,string"`
+	Name          string       `json:"name"`
+	URL           string       `json:"url"`
+	StatusesCount int          `json:"statuses_count"`
+	LastStatusAt  *string      `json:"last_status_at"`
+}
+
+func (s *serialiser) featuredTag(actor *models.Actor, f *models.FeaturedTag) *FeaturedTag {
+	featured := &FeaturedTag{
+		ID:            f.ID,
+		Name:          f.Tag.Name,
+		URL:           actor.URL() + "/tagged/" + f.Tag.Name,
+		StatusesCount: f.StatusesCount,
+	}
+	if f.LastStatusAt != nil {
+		day := f.LastStatusAt.UTC().Format("2006-01-02")
+		featured.LastStatusAt = &day
+	}
+	return featured
 }
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/davecheney/pub/internal/algorithms"
@@ -60,17 +62,41 @@ func StatusesCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
 		Actor:          actor,
 		ConversationID: conv.ID,
 		InReplyToID:    toot.InReplyToID,
-		URI:            fmt.Sprintf("https://%s/users/%s/%d", actor.Domain, actor.Name, id),
+		URI:            env.URLs.Status(actor.Name, id),
 		Sensitive:      toot.Sensitive,
 		SpoilerText:    toot.SpoilerText,
 		Visibility:     toot.Visibility,
 		Language:       toot.Language,
 		Note:           toot.Status,
 	}
+	for _, name := range hashtags(toot.Status) {
+		status.Tags = append(status.Tags, models.StatusTag{
+			StatusID: id,
+			Tag:      &models.Tag{Name: name},
+		})
+	}
 	if err := env.DB.Create(&status).Error; err != nil {
 		return err
 	}
-	return to.JSON(w, serialiseStatus(&status))
+	return to.JSON(w, env.serialise().status(&status))
+}
+
+// hashtagRE matches a hashtag which is not part of a word, URL, or HTML entity.
+var hashtagRE = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&/])#([\p{L}\p{N}_]+)`)
+
+// hashtags returns the distinct hashtags in text, without the #.
+func hashtags(text string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, m := range hashtagRE.FindAllStringSubmatch(text, -1) {
+		name := m[1]
+		if len(name) > 64 || seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
 }
 
 func StatusesDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -92,7 +118,7 @@ func StatusesDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
 	if err := env.DB.Delete(&status).Error; err != nil {
 		return err
 	}
-	return to.JSON(w, serialiseStatus(&status))
+	return to.JSON(w, env.serialise().status(&status))
 }
 
 func StatusesShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -113,7 +139,7 @@ func StatusesShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 		}
 		return err
 	}
-	return to.JSON(w, serialiseStatus(&status))
+	return to.JSON(w, env.serialise().status(&status))
 }
 
 func StatusesContextsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -123,12 +149,20 @@ func StatusesContextsShow(env *Env, w http.ResponseWriter, r *http.Request) erro
 	}
 
 	var status models.Status
-	if err := env.DB.Take(&status, chi.URLParam(r, "id")).Error; err != nil {
+	if err := env.DB.Joins("Actor").Take(&status, chi.URLParam(r, "id")).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return httpx.Error(http.StatusNotFound, err)
 		}
 		return err
 	}
+	if !status.Actor.IsLocal() {
+		// fetch the parts of the thread which did not arrive in our inbox.
+		for _, kind := range []string{"replies", "context"} {
+			if err := requestBackfill(env, r, kind, status.URI); err != nil {
+				fmt.Println("StatusesContextsShow: requestBackfill:", status.URI, err)
+			}
+		}
+	}
 
 	// load conversation statuses
 	var statuses []models.Status
@@ -149,8 +183,8 @@ func StatusesContextsShow(env *Env, w http.ResponseWriter, r *http.Request) erro
 		Ancestors   []*Status `json:"ancestors"`
 		Descendants []*Status `json:"descendants"`
 	}{
-		Ancestors:   algorithms.Map(ancestors, serialiseStatus),
-		Descendants: algorithms.Map(descendants, serialiseStatus),
+		Ancestors:   algorithms.Map(ancestors, env.serialise().status),
+		Descendants: algorithms.Map(descendants, env.serialise().status),
 	})
 }
 
@@ -0,0 +1,234 @@
+package mastodon
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/davecheney/pub/internal/algorithms"
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/mime"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/to"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-json-experiment/json"
+	"gorm.io/gorm"
+)
+
+// tagHistoryDays is the number of days of history returned with a tag.
+const tagHistoryDays = 7
+
+// TagsShow returns the tag, with its history, and whether the user follows it.
+func TagsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	tag, err := models.NewTags(env.DB).FindByName(chi.URLParam(r, "name"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	return serialiseTagFor(env, w, user.Actor, tag)
+}
+
+// TagsFollow adds the statuses carrying the tag to the user's home timeline.
+func TagsFollow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	name := chi.URLParam(r, "name")
+	if !validTagName(name) {
+		return httpx.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid tag name: %q", name))
+	}
+	tags := models.NewTags(env.DB)
+	tag, err := tags.FindOrCreate(name)
+	if err != nil {
+		return err
+	}
+	if err := tags.Follow(user.Actor, tag); err != nil {
+		return err
+	}
+	return serialiseTagFor(env, w, user.Actor, tag)
+}
+
+// TagsUnfollow removes the statuses carrying the tag from the user's home
+// timeline.
+func TagsUnfollow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	tags := models.NewTags(env.DB)
+	tag, err := tags.FindByName(chi.URLParam(r, "name"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	if err := tags.Unfollow(user.Actor, tag); err != nil {
+		return err
+	}
+	return serialiseTagFor(env, w, user.Actor, tag)
+}
+
+// serialiseTagFor writes the tag, with its history, and whether actor follows
+// it.
+func serialiseTagFor(env *Env, w http.ResponseWriter, actor *models.Actor, t *models.Tag) error {
+	tags := models.NewTags(env.DB)
+	history, err := tags.History(t, tagHistoryDays)
+	if err != nil {
+		return err
+	}
+	following, err := tags.IsFollowing(actor, t)
+	if err != nil {
+		return err
+	}
+	tag := env.serialise().tag(t)
+	tag.History = serialiseTagHistory(history)
+	tag.Following = &following
+	return to.JSON(w, tag)
+}
+
+// FollowedTagsIndex returns the tags the user follows.
+func FollowedTagsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	var followed []*models.FollowedTag
+	if err := env.DB.Scopes(models.PaginateByID(r, "followed_tags.id")).Preload("Tag").Where("actor_id = ?", user.Actor.ID).Find(&followed).Error; err != nil {
+		return httpx.Error(http.StatusInternalServerError, err)
+	}
+	if len(followed) > 0 {
+		w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/followed_tags?max_id=%d>; rel=\"next\", <%s/api/v1/followed_tags?min_id=%d>; rel=\"prev\"", env.URLs.Base(), followed[len(followed)-1].ID, env.URLs.Base(), followed[0].ID))
+	}
+	following := true
+	return to.JSON(w, algorithms.Map(followed, func(f *models.FollowedTag) *Tag {
+		tag := env.serialise().tag(f.Tag)
+		tag.Following = &following
+		return tag
+	}))
+}
+
+// FeaturedTagsIndex returns the tags featured on the user's profile.
+func FeaturedTagsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	return featuredTags(env, w, user.Actor)
+}
+
+// AccountsFeaturedTagsShow returns the tags featured on the profile of the
+// account.
+func AccountsFeaturedTagsShow(env *Env, w http.ResponseWriter, r *http.Request) error {
+	if _, err := env.authenticate(r); err != nil {
+		return err
+	}
+	var actor models.Actor
+	if err := env.DB.Take(&actor, chi.URLParam(r, "id")).Error; err != nil {
+		return httpx.Error(http.StatusNotFound, err)
+	}
+	return featuredTags(env, w, &actor)
+}
+
+func featuredTags(env *Env, w http.ResponseWriter, actor *models.Actor) error {
+	featured, err := models.NewTags(env.DB).Featured(actor)
+	if err != nil {
+		return httpx.Error(http.StatusInternalServerError, err)
+	}
+	return to.JSON(w, algorithms.Map(featured, func(f *models.FeaturedTag) *FeaturedTag {
+		return env.serialise().featuredTag(actor, f)
+	}))
+}
+
+// FeaturedTagsCreate features a tag on the user's profile.
+func FeaturedTagsCreate(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	var params struct {
+		Name string `json:"name"`
+	}
+	switch mt := mime.MediaType(r); mt {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseForm(); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+		params.Name = r.PostForm.Get("name")
+	case "application/json":
+		if err := json.UnmarshalFull(r.Body, &params); err != nil {
+			return httpx.Error(http.StatusBadRequest, err)
+		}
+	default:
+		return httpx.Error(http.StatusUnsupportedMediaType, errors.New("unsupported media type: "+mt))
+	}
+	name := strings.TrimPrefix(params.Name, "#")
+	if !validTagName(name) {
+		return httpx.Error(http.StatusUnprocessableEntity, fmt.Errorf("invalid tag name: %q", params.Name))
+	}
+	tags := models.NewTags(env.DB)
+	tag, err := tags.FindOrCreate(name)
+	if err != nil {
+		return err
+	}
+	featured, err := tags.Feature(user.Actor, tag)
+	if err != nil {
+		if errors.Is(err, models.ErrTooManyFeaturedTags) {
+			return httpx.Error(http.StatusUnprocessableEntity, err)
+		}
+		return err
+	}
+	return to.JSON(w, env.serialise().featuredTag(user.Actor, featured))
+}
+
+// FeaturedTagsDestroy stops featuring a tag on the user's profile.
+func FeaturedTagsDestroy(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	res := env.DB.Where("id = ? AND actor_id = ?", chi.URLParam(r, "id"), user.Actor.ID).Delete(&models.FeaturedTag{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return httpx.Error(http.StatusNotFound, errors.New("featured tag not found"))
+	}
+	return to.JSON(w, map[string]any{})
+}
+
+// FeaturedTagsSuggestions returns the tags the user uses most which they do
+// not feature.
+func FeaturedTagsSuggestions(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	if err != nil {
+		return err
+	}
+	tags, err := models.NewTags(env.DB).Suggested(user.Actor, models.MaxFeaturedTags)
+	if err != nil {
+		return httpx.Error(http.StatusInternalServerError, err)
+	}
+	return to.JSON(w, algorithms.Map(tags, env.serialise().tag))
+}
+
+// validTagName returns true if name is a valid hashtag, without the #.
+func validTagName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '_' {
+			return false
+		}
+	}
+	return true
+}
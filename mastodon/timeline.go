@@ -10,6 +10,7 @@ import (
 	"github.com/davecheney/pub/internal/to"
 	"github.com/go-chi/chi/v5"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func TimelinesHome(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -24,9 +25,23 @@ func TimelinesHome(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 	followingIDs = append(followingIDs, int64(user.ID))
 
+	hidden, err := hiddenDomains(env, r, clause.Column{Table: "Actor", Name: "domain"}, false)
+	if err != nil {
+		return err
+	}
+	suspended, err := hiddenActors(env, r, "statuses.actor_id", false)
+	if err != nil {
+		return err
+	}
+
+	// public statuses carrying the tags the user follows, except those of
+	// the actors who block, or are blocked or muted by, the user.
+	followedTags := env.DB.Model(&models.StatusTag{}).Select("status_id").Where("tag_id IN (?)", env.DB.Model(&models.FollowedTag{}).Select("tag_id").Where("actor_id = ?", user.Actor.ID))
+	blocked := models.Blocked(env.DB, user.Actor, true)
+
 	var statuses []*models.Status
 	// TODO stop copying and pasting this query
-	scope := env.DB.Scopes(models.PaginateStatuses(r)).Where("(actor_id IN (?) AND in_reply_to_actor_id is null) or (actor_id in (?) and in_reply_to_actor_id IN (?))", followingIDs, followingIDs, followingIDs)
+	scope := env.DB.Scopes(models.PaginateStatuses(r), hidden, suspended).Where("(actor_id IN (?) AND in_reply_to_actor_id is null) or (actor_id in (?) and in_reply_to_actor_id IN (?)) or (visibility = ? AND statuses.id IN (?) AND statuses.actor_id NOT IN (?))", followingIDs, followingIDs, followingIDs, "public", followedTags, blocked)
 	query := scope.Joins("Actor")                                    // author, one join and one join only
 	query = query.Preload("Reblog").Preload("Reblog.Actor")          // boosts
 	query = query.Preload("Attachments")                             // media
@@ -38,20 +53,29 @@ func TimelinesHome(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if len(statuses) > 0 {
-		w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/timelines/home?max_id=%d>; rel=\"next\", <https://%s/api/v1/timelines/home?min_id=%d>; rel=\"prev\"", r.Host, statuses[len(statuses)-1].ID, r.Host, statuses[0].ID))
+		w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/timelines/home?max_id=%d>; rel=\"next\", <%s/api/v1/timelines/home?min_id=%d>; rel=\"prev\"", env.URLs.Base(), statuses[len(statuses)-1].ID, env.URLs.Base(), statuses[0].ID))
 	}
-	return to.JSON(w, algorithms.Map(statuses, serialiseStatus))
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
 }
 
 func TimelinesPublic(env *Env, w http.ResponseWriter, r *http.Request) error {
 	user, err := env.authenticate(r)
 	authenticated := err == nil
+	hidden, err := hiddenDomains(env, r, clause.Column{Table: "Actor", Name: "domain"}, true)
+	if err != nil {
+		return err
+	}
+	moderated, err := hiddenActors(env, r, "statuses.actor_id", true)
+	if err != nil {
+		return err
+	}
 
 	var statuses []*models.Status
-	scope := env.DB.Scopes(models.PaginateStatuses(r)).Where("visibility = ? and reblog_id is null and in_reply_to_id is null", "public")
+	// silenced and suspended actors, and domains, are hidden from the public timelines.
+	scope := env.DB.Scopes(models.PaginateStatuses(r), hidden, moderated).Where("visibility = ? and reblog_id is null and in_reply_to_id is null", "public")
 	switch r.URL.Query().Get("local") {
 	case "true":
-		scope = scope.Joins("Actor").Where("Actor.domain = ?", r.Host)
+		scope = scope.Joins("Actor").Where(clause.Eq{Column: clause.Column{Table: "Actor", Name: "domain"}, Value: r.Host})
 	default:
 		scope = scope.Joins("Actor")
 	}
@@ -67,9 +91,9 @@ func TimelinesPublic(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	if len(statuses) > 0 {
-		w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/timelines/public?max_id=%d>; rel=\"next\", <https://%s/api/v1/timelines/public?min_id=%d>; rel=\"prev\"", r.Host, statuses[len(statuses)-1].ID, r.Host, statuses[0].ID))
+		w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/timelines/public?max_id=%d>; rel=\"next\", <%s/api/v1/timelines/public?min_id=%d>; rel=\"prev\"", env.URLs.Base(), statuses[len(statuses)-1].ID, env.URLs.Base(), statuses[0].ID))
 	}
-	return to.JSON(w, algorithms.Map(statuses, serialiseStatus))
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
 }
 
 func TimelinesListShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -96,9 +120,9 @@ func TimelinesListShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// if len(statuses) > 0 {
-	// 	w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/timelines/home?max_id=%d>; rel=\"next\", <https://%s/api/v1/timelines/home?min_id=%d>; rel=\"prev\"", r.Host, statuses[len(statuses)-1].ID, r.Host, statuses[0].ID))
+	// 	w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/timelines/home?max_id=%d>; rel=\"next\", <%s/api/v1/timelines/home?min_id=%d>; rel=\"prev\"", env.URLs.Base(), statuses[len(statuses)-1].ID, env.URLs.Base(), statuses[0].ID))
 	// }
-	return to.JSON(w, algorithms.Map(statuses, serialiseStatus))
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
 }
 
 func TimelinesTagShow(env *Env, w http.ResponseWriter, r *http.Request) error {
@@ -107,8 +131,8 @@ func TimelinesTagShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 		return err
 	}
 
-	var tag models.Tag
-	if err := env.DB.Where("name = ?", chi.URLParam(r, "tag")).First(&tag).Error; err != nil {
+	tag, err := models.NewTags(env.DB).FindByName(chi.URLParam(r, "tag"))
+	if err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// TODO move this tag lookup in a join in the query below so an unknown tag returns an empty result set.
 			return to.JSON(w, []any{})
@@ -132,7 +156,7 @@ func TimelinesTagShow(env *Env, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	// if len(statuses) > 0 {
-	// 	w.Header().Set("Link", fmt.Sprintf("<https://%s/api/v1/timelines/home?max_id=%d>; rel=\"next\", <https://%s/api/v1/timelines/home?min_id=%d>; rel=\"prev\"", r.Host, statuses[len(statuses)-1].ID, r.Host, statuses[0].ID))
+	// 	w.Header().Set("Link", fmt.Sprintf("<%s/api/v1/timelines/home?max_id=%d>; rel=\"next\", <%s/api/v1/timelines/home?min_id=%d>; rel=\"prev\"", env.URLs.Base(), statuses[len(statuses)-1].ID, env.URLs.Base(), statuses[0].ID))
 	// }
-	return to.JSON(w, algorithms.Map(statuses, serialiseStatus))
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
 }
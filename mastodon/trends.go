@@ -0,0 +1,274 @@
+package mastodon
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/davecheney/pub/internal/algorithms"
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/to"
+	"github.com/go-chi/chi/v5"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TrendsTagsIndex returns the tags trending on the instance, with their
+// history.
+func TrendsTagsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	trends, err := shownTrends(env, r, models.TrendTag)
+	if err != nil {
+		return err
+	}
+	tags := models.NewTags(env.DB)
+	serialised := make([]*Tag, 0, len(trends))
+	for _, trend := range trends {
+		history, err := tags.History(trend.Tag, tagHistoryDays)
+		if err != nil {
+			return err
+		}
+		tag := env.serialise().tag(trend.Tag)
+		tag.History = serialiseTagHistory(history)
+		serialised = append(serialised, tag)
+	}
+	return to.JSON(w, serialised)
+}
+
+// TrendsStatusesIndex returns the statuses trending on the instance.
+func TrendsStatusesIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	user, err := env.authenticate(r)
+	authenticated := err == nil
+	trends, err := shownTrends(env, r, models.TrendStatus)
+	if err != nil {
+		return err
+	}
+	hidden, err := hiddenDomains(env, r, clause.Column{Table: "Actor", Name: "domain"}, true)
+	if err != nil {
+		return err
+	}
+	var viewer *models.Actor
+	if authenticated {
+		viewer = user.Actor
+	}
+	statuses, err := trendingStatuses(env, trends, viewer, hidden)
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
+}
+
+// TrendsLinksIndex returns the links trending on the instance.
+func TrendsLinksIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	trends, err := shownTrends(env, r, models.TrendLink)
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(trends, env.serialise().trendsLink))
+}
+
+// shownTrends returns the trends of kind shown by the request's instance, in
+// order of their score.
+func shownTrends(env *Env, r *http.Request, kind string) ([]*models.Trend, error) {
+	instance, err := models.NewInstances(env.DB).FindByDomain(r.Host)
+	if err != nil {
+		return nil, httpx.Error(http.StatusNotFound, err)
+	}
+	var trends []*models.Trend
+	if err := env.DB.Scopes(models.NewTrends(env.DB).Shown(instance, kind), models.PaginateSearch(r, "")).
+		Preload("Tag").Order("trends.score DESC, trends.id").Find(&trends).Error; err != nil {
+		return nil, httpx.Error(http.StatusInternalServerError, err)
+	}
+	return trends, nil
+}
+
+// trendingStatuses returns the statuses of trends, in the order of trends,
+// with the reactions of viewer, if not nil. Statuses matched by hidden are
+// omitted.
+func trendingStatuses(env *Env, trends []*models.Trend, viewer *models.Actor, hidden func(*gorm.DB) *gorm.DB) ([]*models.Status, error) {
+	ids := make([]any, 0, len(trends))
+	for _, trend := range trends {
+		ids = append(ids, *trend.StatusID)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query := env.DB.Scopes(hidden).Joins("Actor").Where("statuses.id IN (?)", ids)
+	query = query.Preload("Attachments")
+	if viewer != nil {
+		query = query.Preload("Reaction", "actor_id = ?", viewer.ID)
+	}
+	query = query.Preload("Mentions").Preload("Mentions.Actor")
+	query = query.Preload("Tags").Preload("Tags.Tag")
+	var found []*models.Status
+	if err := query.Find(&found).Error; err != nil {
+		return nil, httpx.Error(http.StatusInternalServerError, err)
+	}
+	byID := make(map[uint64]*models.Status, len(found))
+	for _, st := range found {
+		byID[uint64(st.ID)] = st
+	}
+	statuses := make([]*models.Status, 0, len(found))
+	for _, trend := range trends {
+		if st, ok := byID[uint64(*trend.StatusID)]; ok {
+			statuses = append(statuses, st)
+		}
+	}
+	return statuses, nil
+}
+
+// AdminTrendsTagsIndex returns the tags trending on the server, whether or not
+// the instance shows them.
+func AdminTrendsTagsIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	_, instance, trends, reviews, err := adminTrends(env, r, models.TrendTag)
+	if err != nil {
+		return err
+	}
+	tags := models.NewTags(env.DB)
+	serialised := make([]*AdminTag, 0, len(trends))
+	for _, trend := range trends {
+		history, err := tags.History(trend.Tag, tagHistoryDays)
+		if err != nil {
+			return err
+		}
+		tag := env.serialise().adminTag(trend, instance, reviews[trend.ID])
+		tag.History = serialiseTagHistory(history)
+		serialised = append(serialised, tag)
+	}
+	return to.JSON(w, serialised)
+}
+
+// AdminTrendsStatusesIndex returns the statuses trending on the server,
+// whether or not the instance shows them.
+func AdminTrendsStatusesIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	admin, _, trends, _, err := adminTrends(env, r, models.TrendStatus)
+	if err != nil {
+		return err
+	}
+	statuses, err := trendingStatuses(env, trends, admin.Actor, func(db *gorm.DB) *gorm.DB { return db })
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(statuses, env.serialise().status))
+}
+
+// AdminTrendsLinksIndex returns the links trending on the server, whether or
+// not the instance shows them.
+func AdminTrendsLinksIndex(env *Env, w http.ResponseWriter, r *http.Request) error {
+	_, instance, trends, reviews, err := adminTrends(env, r, models.TrendLink)
+	if err != nil {
+		return err
+	}
+	return to.JSON(w, algorithms.Map(trends, func(trend *models.Trend) *TrendsLink {
+		return env.serialise().adminTrendsLink(trend, instance, reviews[trend.ID])
+	}))
+}
+
+// adminTrends returns the admin, their instance, the trends of kind, and the
+// instance's reviews of those trends.
+func adminTrends(env *Env, r *http.Request, kind string) (*models.Account, *models.Instance, []*models.Trend, map[uint32]*models.TrendReview, error) {
+	admin, err := env.authorize(r, models.PermissionManageTaxonomies)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var instance models.Instance
+	if err := env.DB.Take(&instance, admin.InstanceID).Error; err != nil {
+		return nil, nil, nil, nil, err
+	}
+	var trends []*models.Trend
+	if err := env.DB.Scopes(models.PaginateSearch(r, "")).Preload("Tag").
+		Where("kind = ? AND score > 0", kind).Order("score DESC, id").Find(&trends).Error; err != nil {
+		return nil, nil, nil, nil, httpx.Error(http.StatusInternalServerError, err)
+	}
+	reviews, err := models.NewTrends(env.DB).Reviews(&instance, trends)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	return admin, &instance, trends, reviews, nil
+}
+
+// trendable returns true if instance shows a trend with review.
+func trendable(instance *models.Instance, review *models.TrendReview) bool {
+	if review != nil {
+		return review.Approved
+	}
+	return !instance.TrendsRequireApproval
+}
+
+// AdminTrendsTagsApprove shows the trending tag on the instance.
+func AdminTrendsTagsApprove(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminTrendsReview(env, w, r, models.TrendTag, true)
+}
+
+// AdminTrendsTagsReject hides the trending tag from the instance.
+func AdminTrendsTagsReject(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminTrendsReview(env, w, r, models.TrendTag, false)
+}
+
+// AdminTrendsStatusesApprove shows the trending status on the instance.
+func AdminTrendsStatusesApprove(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminTrendsReview(env, w, r, models.TrendStatus, true)
+}
+
+// AdminTrendsStatusesReject hides the trending status from the instance.
+func AdminTrendsStatusesReject(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminTrendsReview(env, w, r, models.TrendStatus, false)
+}
+
+// AdminTrendsLinksApprove shows the trending link on the instance.
+func AdminTrendsLinksApprove(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminTrendsReview(env, w, r, models.TrendLink, true)
+}
+
+// AdminTrendsLinksReject hides the trending link from the instance.
+func AdminTrendsLinksReject(env *Env, w http.ResponseWriter, r *http.Request) error {
+	return adminTrendsReview(env, w, r, models.TrendLink, false)
+}
+
+// adminTrendsReview records the admin's review of the trend of kind, the tag,
+// status, or trend ID in the URL, and returns it.
+func adminTrendsReview(env *Env, w http.ResponseWriter, r *http.Request, kind string, approved bool) error {
+	admin, err := env.authorize(r, models.PermissionManageTaxonomies)
+	if err != nil {
+		return err
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		return httpx.Error(http.StatusNotFound, err)
+	}
+	trends := models.NewTrends(env.DB)
+	trend, err := trends.Find(kind, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		return err
+	}
+	if err := models.NewAdminActions(env.DB).ReviewTrend(admin, trend, approved); err != nil {
+		return err
+	}
+	var instance models.Instance
+	if err := env.DB.Take(&instance, admin.InstanceID).Error; err != nil {
+		return err
+	}
+	reviews, err := trends.Reviews(&instance, []*models.Trend{trend})
+	if err != nil {
+		return err
+	}
+	review := reviews[trend.ID]
+	switch kind {
+	case models.TrendTag:
+		return to.JSON(w, env.serialise().adminTag(trend, &instance, review))
+	case models.TrendStatus:
+		statuses, err := trendingStatuses(env, []*models.Trend{trend}, admin.Actor, func(db *gorm.DB) *gorm.DB { return db })
+		if err != nil {
+			return err
+		}
+		if len(statuses) == 0 {
+			return httpx.Error(http.StatusNotFound, errors.New("status not found"))
+		}
+		return to.JSON(w, env.serialise().status(statuses[0]))
+	default:
+		return to.JSON(w, env.serialise().adminTrendsLink(trend, &instance, review))
+	}
+}
@@ -0,0 +1,356 @@
+package media
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davecheney/pub/internal/netx"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// fetchTimeout is the time allowed to fetch a remote file.
+const fetchTimeout = 30 * time.Second
+
+// maxPixels is the largest image, in pixels, which will be decoded to be
+// resized. A small file can describe a very large image.
+const maxPixels = 25_000_000
+
+// A variant describes how remote files of a kind are cached.
+type variant struct {
+	// maxSize is the maximum size of the remote file.
+	maxSize int64
+	// types maps the accepted content types to their file extension.
+	types map[string]string
+	// width and height are the dimensions images are resized to fill, zero
+	// if they are cached as is.
+	width, height int
+}
+
+var attachmentTypes = map[string]string{
+	"image/gif":       ".gif",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"video/mp4":       ".mp4",
+	"video/webm":      ".webm",
+	"audio/mpeg":      ".mp3",
+	"application/ogg": ".ogg",
+}
+
+var variants = map[string]variant{
+	"avatar":     {maxSize: 4 << 20, types: uploadTypes, width: 400, height: 400},
+	"header":     {maxSize: 8 << 20, types: uploadTypes, width: 1500, height: 500},
+	"attachment": {maxSize: 40 << 20, types: attachmentTypes},
+}
+
+// Cache is a read through cache of remote avatars, headers, and attachments,
+// stored in a directory. When the files in the directory exceed the size
+// budget of the cache, the least recently used are removed.
+type Cache struct {
+	dir    string
+	budget int64
+	client *http.Client
+	// defaultAvatar and defaultHeader are the images served for actors
+	// without an avatar or header.
+	defaultAvatar, defaultHeader string
+
+	mu sync.Mutex // protects the fields below
+	// loaded is true once the files in the directory have been indexed,
+	// which happens the first time the cache may need to evict.
+	loaded bool
+	// files are the cached files, by path, in lru.
+	files map[string]*list.Element
+	// lru holds the *cacheEntry of each cached file, least recently used
+	// first.
+	lru *list.List
+	// size is the total size of the cached files.
+	size int64
+}
+
+// A cacheEntry is a file in the cache.
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// NewCache returns a Cache which stores files in dir. If budget is positive,
+// the cache is limited to budget bytes. Actors without an avatar or header
+// are served defaultAvatar or defaultHeader.
+func NewCache(dir string, budget int64, defaultAvatar, defaultHeader string) *Cache {
+	return &Cache{
+		dir:           dir,
+		budget:        budget,
+		client:        netx.NewClient(fetchTimeout),
+		defaultAvatar: defaultAvatar,
+		defaultHeader: defaultHeader,
+		files:         make(map[string]*list.Element),
+		lru:           list.New(),
+	}
+}
+
+// Open returns the cached copy of the remote file of kind at url, fetching it
+// if it is not already cached. The caller must close the file.
+func (c *Cache) Open(ctx context.Context, kind, url string) (*os.File, error) {
+	v, ok := variants[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q", kind)
+	}
+	dir := filepath.Join(c.dir, kind)
+	key := b64Hash(sha256.New(), url)
+	if f, err := c.lookup(dir, key); err == nil {
+		return f, nil
+	}
+	if err := c.fetch(ctx, v, url, dir, key); err != nil {
+		return nil, err
+	}
+	// open the file before evicting, so it can be served even if the
+	// budget is too small to keep it.
+	f, err := c.lookup(dir, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.evict(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// lookup opens the cached file for key in dir, and marks it as recently used.
+func (c *Cache) lookup(dir, key string) (*os.File, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, key+".*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fs.ErrNotExist
+	}
+	// the modification time records use across restarts.
+	now := time.Now()
+	if err := os.Chtimes(matches[0], now, now); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(matches[0])
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.used(matches[0], info.Size())
+	return f, nil
+}
+
+// used marks the file at path, of size bytes, as the most recently used.
+func (c *Cache) used(path string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		// the file will be found when the directory is indexed.
+		return
+	}
+	if e, ok := c.files[path]; ok {
+		c.lru.MoveToBack(e)
+		return
+	}
+	c.files[path] = c.lru.PushBack(&cacheEntry{path: path, size: size})
+	c.size += size
+}
+
+// fetch fetches url and stores it in dir, named by key.
+func (c *Cache) fetch(ctx context.Context, v variant, url, dir, key string) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: unexpected status code %d", url, resp.StatusCode)
+	}
+	if resp.ContentLength > v.maxSize {
+		return fmt.Errorf("%s: size %d exceeds maximum of %d bytes", url, resp.ContentLength, v.maxSize)
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, v.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) > v.maxSize {
+		return fmt.Errorf("%s: size exceeds maximum of %d bytes", url, v.maxSize)
+	}
+	contentType := http.DetectContentType(buf)
+	ext, ok := v.types[contentType]
+	if !ok {
+		return fmt.Errorf("%s: unsupported media type %q", url, contentType)
+	}
+	if v.width > 0 {
+		if buf, ext, err = resize(buf, contentType, v.width, v.height); err != nil {
+			return fmt.Errorf("%s: %w", url, err)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, key+ext))
+}
+
+// evict removes the least recently used files until the cache is within its
+// budget.
+func (c *Cache) evict() error {
+	if c.budget <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.loaded {
+		if err := c.load(); err != nil {
+			return err
+		}
+	}
+	for c.size > c.budget {
+		e := c.lru.Front()
+		if e == nil {
+			break
+		}
+		entry := e.Value.(*cacheEntry)
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		c.lru.Remove(e)
+		delete(c.files, entry.path)
+		c.size -= entry.size
+	}
+	return nil
+}
+
+// load indexes the files in the directory, ordered by their modification
+// time. The caller must hold c.mu.
+func (c *Cache) load() error {
+	var entries []*cacheEntry
+	modTimes := make(map[*cacheEntry]time.Time)
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			// the file is still being fetched.
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entry := &cacheEntry{path: path, size: info.Size()}
+		entries = append(entries, entry)
+		modTimes[entry] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return modTimes[entries[i]].Before(modTimes[entries[j]])
+	})
+	for _, entry := range entries {
+		c.files[entry.path] = c.lru.PushBack(entry)
+		c.size += entry.size
+	}
+	c.loaded = true
+	return nil
+}
+
+// resize decodes the image in buf and scales it to fill width by height,
+// cropping the centre of the image to match the aspect ratio. Images smaller
+// than width by height are cropped but not enlarged. JPEG images are encoded
+// as JPEG, others as PNG.
+func resize(buf []byte, contentType string, width, height int) ([]byte, string, error) {
+	var decode func(io.Reader) (image.Image, error)
+	var decodeConfig func(io.Reader) (image.Config, error)
+	switch contentType {
+	case "image/gif":
+		decode, decodeConfig = gif.Decode, gif.DecodeConfig
+	case "image/jpeg":
+		decode, decodeConfig = jpeg.Decode, jpeg.DecodeConfig
+	case "image/png":
+		decode, decodeConfig = png.Decode, png.DecodeConfig
+	case "image/webp":
+		decode, decodeConfig = webp.Decode, webp.DecodeConfig
+	default:
+		return nil, "", fmt.Errorf("cannot resize %q", contentType)
+	}
+	cfg, err := decodeConfig(bytes.NewReader(buf))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return nil, "", fmt.Errorf("image of %dx%d pixels exceeds maximum of %d pixels", cfg.Width, cfg.Height, maxPixels)
+	}
+	src, err := decode(bytes.NewReader(buf))
+	if err != nil {
+		return nil, "", err
+	}
+
+	crop := src.Bounds()
+	if crop.Dx()*height > crop.Dy()*width {
+		// too wide, trim the sides.
+		w := crop.Dy() * width / height
+		crop.Min.X += (crop.Dx() - w) / 2
+		crop.Max.X = crop.Min.X + w
+	} else {
+		// too tall, trim the top and bottom.
+		h := crop.Dx() * height / width
+		crop.Min.Y += (crop.Dy() - h) / 2
+		crop.Max.Y = crop.Min.Y + h
+	}
+	if crop.Dx() < width {
+		width, height = crop.Dx(), crop.Dy()
+	}
+	if width == 0 || height == 0 {
+		return nil, "", fmt.Errorf("image too small")
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+	var out bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85})
+		return out.Bytes(), ".jpg", err
+	}
+	err = png.Encode(&out, dst)
+	return out.Bytes(), ".png", err
+}
@@ -0,0 +1,252 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/glebarez/sqlite"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestCache returns a Cache in a temporary directory which fetches files
+// from the test server srv.
+func newTestCache(t *testing.T, srv *httptest.Server, budget int64) *Cache {
+	t.Helper()
+	c := NewCache(t.TempDir(), budget, srv.URL+"/default-avatar.png", srv.URL+"/default-header.png")
+	// the test server listens on loopback, which the cache refuses.
+	c.client = srv.Client()
+	return c
+}
+
+// encodePNG returns a PNG image of width by height pixels.
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))))
+	return buf.Bytes()
+}
+
+// pngHeader returns the start of a PNG image of width by height pixels,
+// enough to decode its config but not the image.
+func pngHeader(width, height int) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(width))
+	binary.BigEndian.PutUint32(ihdr[8:], uint32(height))
+	ihdr[12] = 8 // bit depth
+	ihdr[13] = 6 // RGBA
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(len(ihdr)-4))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestCacheOpenRejects(t *testing.T) {
+	files := map[string][]byte{
+		"/too-big.png":  append(encodePNG(t, 1, 1), make([]byte, variants["avatar"].maxSize)...),
+		"/text.txt":     []byte("hello, world"),
+		"/too-wide.png": pngHeader(50_000, 50_000),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf)
+	}))
+	defer srv.Close()
+	c := newTestCache(t, srv, 0)
+
+	tc := []struct {
+		path string
+		err  string
+	}{
+		{"/too-big.png", "exceeds maximum"},
+		{"/text.txt", "unsupported media type"},
+		{"/too-wide.png", "pixels exceeds maximum"},
+		{"/missing.png", "unexpected status code 404"},
+	}
+	for _, tt := range tc {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := c.Open(context.Background(), "avatar", srv.URL+tt.path)
+			require.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestCacheOpenRefusesLoopback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(encodePNG(t, 1, 1))
+	}))
+	defer srv.Close()
+	c := NewCache(t.TempDir(), 0, "", "")
+
+	_, err := c.Open(context.Background(), "avatar", srv.URL+"/avatar.png")
+	require.ErrorContains(t, err, "refusing to connect")
+}
+
+func TestCacheOpenResizes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var width, height int
+		switch r.URL.Path {
+		case "/wide.png":
+			width, height = 1200, 600
+		case "/tall.png":
+			width, height = 600, 3000
+		case "/small.png":
+			width, height = 100, 50
+		}
+		w.Write(encodePNG(t, width, height))
+	}))
+	defer srv.Close()
+	c := newTestCache(t, srv, 0)
+
+	tc := []struct {
+		kind, path    string
+		width, height int
+	}{
+		{"avatar", "/wide.png", 400, 400},
+		{"avatar", "/tall.png", 400, 400},
+		{"header", "/wide.png", 1200, 400},
+		{"header", "/tall.png", 600, 200},
+		// small images are cropped, but not enlarged.
+		{"avatar", "/small.png", 50, 50},
+	}
+	for _, tt := range tc {
+		t.Run(tt.kind+tt.path, func(t *testing.T) {
+			f, err := c.Open(context.Background(), tt.kind, srv.URL+tt.path)
+			require.NoError(t, err)
+			defer f.Close()
+			cfg, err := png.DecodeConfig(f)
+			require.NoError(t, err)
+			require.Equal(t, tt.width, cfg.Width)
+			require.Equal(t, tt.height, cfg.Height)
+		})
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	buf := encodePNG(t, 10, 10)
+	fetches := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches[r.URL.Path]++
+		w.Write(buf)
+	}))
+	defer srv.Close()
+	// room for two attachments.
+	c := newTestCache(t, srv, int64(2*len(buf)))
+
+	open := func(path string) {
+		t.Helper()
+		f, err := c.Open(context.Background(), "attachment", srv.URL+path)
+		require.NoError(t, err)
+		f.Close()
+	}
+	open("/a.png")
+	open("/b.png")
+	open("/a.png") // a is now more recently used than b.
+	open("/c.png") // evicts b.
+
+	matches, err := filepath.Glob(filepath.Join(c.dir, "attachment", "*"))
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+
+	open("/a.png")
+	open("/b.png")
+	require.Equal(t, map[string]int{"/a.png": 1, "/b.png": 2, "/c.png": 1}, fetches)
+	require.Equal(t, int64(2*len(buf)), c.size)
+
+	// a new cache in the same directory finds the files, and their use.
+	c2 := newTestCache(t, srv, int64(2*len(buf)))
+	c2.dir = c.dir
+	open = func(path string) {
+		t.Helper()
+		f, err := c2.Open(context.Background(), "attachment", srv.URL+path)
+		require.NoError(t, err)
+		f.Close()
+	}
+	open("/c.png") // evicts a, the least recently used.
+	open("/b.png")
+	require.Equal(t, map[string]int{"/a.png": 1, "/b.png": 2, "/c.png": 2}, fetches)
+	require.Equal(t, int64(2*len(buf)), c2.size)
+}
+
+func TestCacheShow(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".png") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(encodePNG(t, 500, 500))
+	}))
+	defer srv.Close()
+	c := newTestCache(t, srv, 0)
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+	require.NoError(t, models.AutoMigrate(db))
+	actor := &models.Actor{
+		ID:        snowflake.Now(),
+		URI:       "https://example.com/users/alice",
+		Name:      "alice",
+		Domain:    "example.com",
+		PublicKey: []byte("key"),
+	}
+	require.NoError(t, db.Create(actor).Error)
+
+	r := chi.NewRouter()
+	r.Get("/media/{kind}/{hash}/{id}", httpx.HandlerFunc(func(r *http.Request) *models.Env {
+		return &models.Env{DB: db}
+	}, c.Show))
+	get := func(path, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the actor has no avatar, so the default is served.
+	rec := get(fmt.Sprintf("/media/avatar/hash/%d", actor.ID), "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	files, err := os.ReadDir(filepath.Join(c.dir, "avatar"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	require.Equal(t, `"`+files[0].Name()+`"`, etag)
+
+	rec = get(fmt.Sprintf("/media/avatar/hash/%d", actor.ID), etag)
+	require.Equal(t, http.StatusNotModified, rec.Code)
+
+	rec = get("/media/avatar/hash/1", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
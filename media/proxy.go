@@ -8,63 +8,99 @@ import (
 	"hash"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/davecheney/pub/internal/httpx"
 	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/urls"
 	"github.com/go-chi/chi/v5"
 )
 
-func Show(env *models.Env, w http.ResponseWriter, r *http.Request) error {
-	kind := chi.URLParam(r, "kind")
-	switch kind {
-	case "avatar":
-		return showAvatar(env, w, r)
-	case "header":
-		return showHeader(env, w, r)
+// Show serves the cached copy of the avatar or header of the actor, or the
+// status attachment, identified by the {kind} and {id} URL parameters.
+func (c *Cache) Show(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+	var url string
+	switch kind := chi.URLParam(r, "kind"); kind {
+	case "avatar", "header":
+		var actor models.Actor
+		if err := env.DB.Take(&actor, chi.URLParam(r, "id")).Error; err != nil {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		if kind == "avatar" {
+			url = stringOrDefault(actor.Avatar, c.defaultAvatar)
+		} else {
+			url = stringOrDefault(actor.Header, c.defaultHeader)
+		}
+	case "attachment":
+		var att models.StatusAttachment
+		if err := env.DB.Take(&att, chi.URLParam(r, "id")).Error; err != nil {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		if err := checkMedia(env, r.Host, &att); err != nil {
+			return httpx.Error(http.StatusNotFound, err)
+		}
+		url = att.URL
 	default:
 		return httpx.Error(http.StatusNotFound, fmt.Errorf("unknown kind %q", kind))
 	}
-}
 
-func showAvatar(env *models.Env, w http.ResponseWriter, r *http.Request) error {
-	var actor models.Actor
-	if err := env.DB.Take(&actor, chi.URLParam(r, "id")).Error; err != nil {
-		return httpx.Error(http.StatusNotFound, err)
+	f, err := c.Open(r.Context(), chi.URLParam(r, "kind"), url)
+	if err != nil {
+		return httpx.Error(http.StatusBadGateway, err)
 	}
-	return fetch(w, stringOrDefault(actor.Avatar, "https://avatars.githubusercontent.com/u/1024?v=4"))
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	// the name of the cached file is the hash of its remote URL, and the
+	// extension of the type it was stored as.
+	w.Header().Set("ETag", `"`+fi.Name()+`"`)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	return nil
 }
 
-func showHeader(env *models.Env, w http.ResponseWriter, r *http.Request) error {
+// checkMedia returns an error if the instance for host rejects the media of the
+// domain of the actor of the status att is attached to.
+func checkMedia(env *models.Env, host string, att *models.StatusAttachment) error {
+	var instance models.Instance
+	if err := env.DB.Take(&instance, "domain = ?", host).Error; err != nil {
+		return err
+	}
 	var actor models.Actor
-	if err := env.DB.Take(&actor, chi.URLParam(r, "id")).Error; err != nil {
-		return httpx.Error(http.StatusNotFound, err)
+	if err := env.DB.Joins("JOIN statuses ON statuses.actor_id = actors.id").Take(&actor, "statuses.id = ?", att.StatusID).Error; err != nil {
+		return err
 	}
-	return fetch(w, stringOrDefault(actor.Header, "https://static.ma-cdn.net/headers/original/missing.png"))
-}
-
-func fetch(w http.ResponseWriter, url string) error {
-	resp, err := http.DefaultClient.Get(url)
+	policy, err := models.NewDomainBlocks(env.DB).Policy(&instance, actor.Domain)
 	if err != nil {
-		return httpx.Error(http.StatusBadGateway, err)
+		return err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return httpx.Error(http.StatusBadGateway, fmt.Errorf("unexpected status code %d", resp.StatusCode))
+	if policy.RejectMedia {
+		return fmt.Errorf("media from %s is rejected", actor.Domain)
 	}
-	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
-	w.WriteHeader(http.StatusOK)
-	_, err = io.Copy(w, resp.Body)
-	return err
+	return nil
 }
 
-func ProxyAvatarURL(actor *models.Actor) string {
-	url := stringOrDefault(actor.Avatar, "https://avatars.githubusercontent.com/u/1024?v=4")
-	return fmt.Sprintf("https://cheney.net/media/avatar/%s/%d", b64Hash(sha256.New(), url), actor.ID)
+// ProxyAvatarURL returns the URL of the cached copy of the actor's avatar on
+// the instance u. The URL changes when the actor's avatar does.
+func ProxyAvatarURL(u *urls.Builder, actor *models.Actor) string {
+	return u.Media("avatar", b64Hash(sha256.New(), actor.Avatar), actor.ID)
 }
 
-func ProxyHeaderURL(actor *models.Actor) string {
-	url := stringOrDefault(actor.Header, "https://avatars.githubusercontent.com/u/1024?v=4")
-	return fmt.Sprintf("https://cheney.net/media/header/%s/%d", b64Hash(sha256.New(), url), actor.ID)
+// ProxyHeaderURL returns the URL of the cached copy of the actor's header on
+// the instance u. The URL changes when the actor's header does.
+func ProxyHeaderURL(u *urls.Builder, actor *models.Actor) string {
+	return u.Media("header", b64Hash(sha256.New(), actor.Header), actor.ID)
+}
+
+// ProxyAttachmentURL returns the URL of the cached copy of a remote attachment
+// on the instance u. Attachments uploaded to u are served directly.
+func ProxyAttachmentURL(u *urls.Builder, att *models.Attachment) string {
+	if strings.HasPrefix(att.URL, u.Upload("")) {
+		return att.URL
+	}
+	return u.Media("attachment", b64Hash(sha256.New(), att.URL), att.ID)
 }
 
 func b64Hash(h hash.Hash, s string) string {
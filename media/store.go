@@ -0,0 +1,108 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// MaxUploadSize is the maximum size of an uploaded media file.
+const MaxUploadSize = 8 << 20
+
+// ErrTooLarge is returned by Store.Put if the file exceeds MaxUploadSize.
+var ErrTooLarge = errors.New("file exceeds maximum upload size")
+
+// ErrUnsupportedType is returned by Store.Put if the file is not one of the
+// accepted media types.
+var ErrUnsupportedType = errors.New("unsupported media type")
+
+// uploadTypes maps the content types accepted for upload to their file extension.
+var uploadTypes = map[string]string{
+	"image/gif":  ".gif",
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// Store stores uploaded media files in a directory.
+type Store struct {
+	dir string
+}
+
+// NewStore returns a Store which stores files in dir.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Put stores the contents of r and returns the name of the stored file.
+// Files are named by the hash of their contents, so storing the same file
+// twice returns the same name.
+func (s *Store) Put(r io.Reader) (string, error) {
+	buf, err := io.ReadAll(io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(buf) > MaxUploadSize {
+		return "", fmt.Errorf("%w of %d bytes", ErrTooLarge, MaxUploadSize)
+	}
+	contentType := http.DetectContentType(buf)
+	ext, ok := uploadTypes[contentType]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnsupportedType, contentType)
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return "", err
+	}
+	name := b64Hash(sha256.New(), string(buf)) + ext
+	f, err := os.CreateTemp(s.dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, bytes.NewReader(buf)); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return name, os.Rename(f.Name(), filepath.Join(s.dir, name))
+}
+
+// Open opens the stored file name.
+func (s *Store) Open(name string) (*os.File, error) {
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, fmt.Errorf("invalid name %q", name)
+	}
+	return os.Open(filepath.Join(s.dir, name))
+}
+
+// UploadName returns the name of the stored file at url, and true if url is
+// the URL of a stored file.
+func UploadName(url string) (string, bool) {
+	const prefix = "/media/uploads/"
+	i := strings.Index(url, prefix)
+	if !strings.HasPrefix(url, "https://") || i < 0 {
+		return "", false
+	}
+	return url[i+len(prefix):], true
+}
+
+// Show serves the stored file named by the {name} URL parameter.
+func (s *Store) Show(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeFile(w, r, filepath.Join(s.dir, name))
+}
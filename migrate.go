@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/davecheney/pub/internal/migrations"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/urls"
+	"gorm.io/gorm"
+)
+
+type MigrateCmd struct {
+	Status MigrateStatusCmd `cmd:"" help:"Show the migrations applied to the database."`
+	Up     MigrateUpCmd     `cmd:"" help:"Apply pending migrations."`
+	Down   MigrateDownCmd   `cmd:"" help:"Revert applied migrations."`
+}
+
+type MigrateStatusCmd struct{}
+
+func (m *MigrateStatusCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := migrations.Statuses(db)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%4d  %-19s  %s\n", s.Version, applied, s.Name)
+	}
+	return nil
+}
+
+type MigrateUpCmd struct{}
+
+func (m *MigrateUpCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+	return migrateUp(db, ctx.Settings.BaseURL)
+}
+
+type MigrateDownCmd struct {
+	Steps int `help:"number of migrations to revert" default:"1"`
+}
+
+func (m *MigrateDownCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	reverted, err := migrations.Down(db, m.Steps)
+	for _, m := range reverted {
+		fmt.Println("reverted", m.Version, m.Name)
+	}
+	return err
+}
+
+// AutoMigrateCmd is the previous name of MigrateUpCmd.
+type AutoMigrateCmd struct {
+	MigrateUpCmd
+}
+
+// migrateUp applies the pending migrations to db, then creates the service
+// accounts of instances created before they were introduced, and removes the
+// passwords of service accounts created with one.
+func migrateUp(db *gorm.DB, baseURL func(domain string) string) error {
+	applied, err := migrations.Up(db)
+	for _, m := range applied {
+		fmt.Println("applied", m.Version, m.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	var instances []models.Instance
+	if err := db.Where("service_account_id is null").Find(&instances).Error; err != nil {
+		return err
+	}
+	for i := range instances {
+		if err := withTransaction(db, func(tx *gorm.DB) error {
+			return createServiceAccount(tx, &instances[i], urls.New(baseURL(instances[i].Domain)))
+		}); err != nil {
+			return err
+		}
+	}
+	services := db.Model(&models.Actor{}).Select("id").Where("type = ?", "Application")
+	return db.Model(&models.Account{}).Where("actor_id IN (?)", services).Update("encrypted_password", []byte{}).Error
+}
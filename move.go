@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/davecheney/pub/activitypub"
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type MoveCmd struct {
+	Name   string `required:"" help:"name of the account to move"`
+	Domain string `required:"" help:"domain of the account to move"`
+	Target string `required:"" help:"URI of the actor to move to, which must have this account as an alias"`
+}
+
+func (m *MoveCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", m.Name, m.Domain).Error; err != nil {
+		return err
+	}
+	target, err := activitypub.MoveAccount(db, &account, m.Target, ctx.Settings.HTTP.ClientTimeout)
+	if err != nil {
+		return err
+	}
+	fmt.Println("moved", account.Actor.URI, "to", target.URI)
+	return nil
+}
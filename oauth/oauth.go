@@ -64,11 +64,26 @@ func AuthorizeCreate(env *activitypub.Env, w http.ResponseWriter, r *http.Reques
 	if err := env.DB.Joins("Actor").First(&account, "name = ? and domain = ?", username, r.Host).Error; err != nil {
 		return httpx.Error(http.StatusUnauthorized, fmt.Errorf("invalid username"))
 	}
+	if account.Actor.IsService() {
+		// the instance actor signs requests, it cannot log in.
+		return httpx.Error(http.StatusUnauthorized, fmt.Errorf("invalid username"))
+	}
 
 	if err := bcrypt.CompareHashAndPassword(account.EncryptedPassword, []byte(password)); err != nil {
 		return httpx.Error(http.StatusUnauthorized, fmt.Errorf("invalid password"))
 	}
 
+	if account.Pending {
+		return httpx.Error(http.StatusForbidden, fmt.Errorf("account is pending approval"))
+	}
+	moderation, err := models.NewActorModerations(env.DB).Find(account.InstanceID, account.Actor)
+	if err != nil {
+		return err
+	}
+	if moderation.IsSuspended() {
+		return httpx.Error(http.StatusForbidden, fmt.Errorf("account is suspended"))
+	}
+
 	token := &models.Token{
 		AccessToken:       uuid.New().String(),
 		AccountID:         account.ID,
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type PruneCmd struct {
+	Days      int `help:"delete remote statuses older than this many days" default:"90"`
+	BatchSize int `help:"number of rows to delete at a time" default:"1000"`
+}
+
+func (p *PruneCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	stats, err := models.Prune(db, time.Now().AddDate(0, 0, -p.Days), p.BatchSize)
+	fmt.Printf("pruned %d statuses, %d attachments, %d actors, %d conversations\n", stats.Statuses, stats.Attachments, stats.Actors, stats.Conversations)
+	return err
+}
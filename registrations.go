@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type RegistrationsCmd struct {
+	Mode    RegistrationsModeCmd    `cmd:"" help:"Set who may sign up to an instance."`
+	List    RegistrationsListCmd    `cmd:"" help:"List the accounts pending approval."`
+	Approve RegistrationsApproveCmd `cmd:"" help:"Approve a pending account."`
+	Reject  RegistrationsRejectCmd  `cmd:"" help:"Reject, and delete, a pending account."`
+	Invite  RegistrationsInviteCmd  `cmd:"" help:"Create an invite to an instance."`
+}
+
+type RegistrationsModeCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+	Mode   string `required:"" enum:"closed,invite,approval,open" help:"one of closed, invite, approval, or open"`
+}
+
+func (r *RegistrationsModeCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var instance models.Instance
+	if err := db.Where("domain = ?", r.Domain).First(&instance).Error; err != nil {
+		return err
+	}
+	return db.Model(&instance).Update("registrations_mode", r.Mode).Error
+}
+
+type RegistrationsListCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+}
+
+func (r *RegistrationsListCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var accounts []models.Account
+	if err := db.Joins("Actor").Where("domain = ? AND pending = ?", r.Domain, true).Order("accounts.id").Find(&accounts).Error; err != nil {
+		return err
+	}
+	for _, a := range accounts {
+		fmt.Printf("%-30s  %-40s  %s\n", a.Actor.Name, a.Email, a.Reason)
+	}
+	return nil
+}
+
+type RegistrationsApproveCmd struct {
+	Name   string `required:"" help:"name of the account to approve"`
+	Domain string `required:"" help:"domain of the account to approve"`
+}
+
+func (r *RegistrationsApproveCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, r.Domain)
+	if err != nil {
+		return err
+	}
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", r.Name, r.Domain).Error; err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).Approve(admin, &account)
+}
+
+type RegistrationsRejectCmd struct {
+	Name   string `required:"" help:"name of the account to reject"`
+	Domain string `required:"" help:"domain of the account to reject"`
+}
+
+func (r *RegistrationsRejectCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, r.Domain)
+	if err != nil {
+		return err
+	}
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", r.Name, r.Domain).Error; err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).Reject(admin, &account)
+}
+
+type RegistrationsInviteCmd struct {
+	Domain    string        `required:"" help:"domain name of the instance"`
+	MaxUses   int32         `help:"number of times the invite may be used, zero for no limit"`
+	ExpiresIn time.Duration `help:"how long the invite is valid for, zero for no expiry"`
+}
+
+func (r *RegistrationsInviteCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var instance models.Instance
+	if err := db.Where("domain = ?", r.Domain).First(&instance).Error; err != nil {
+		return err
+	}
+	admin, err := instanceAdmin(db, r.Domain)
+	if err != nil {
+		return err
+	}
+	invite, err := models.NewInvites(db).Create(&instance, admin, r.MaxUses, r.ExpiresIn)
+	if err != nil {
+		return err
+	}
+	fmt.Println(invite.Code)
+	return nil
+}
+
+// instanceAdmin returns the admin account of the instance for domain, which
+// commands act as when they take moderation actions.
+func instanceAdmin(db *gorm.DB, domain string) (*models.Account, error) {
+	var instance models.Instance
+	if err := db.Preload("Admin.Actor").Where("domain = ?", domain).First(&instance).Error; err != nil {
+		return nil, err
+	}
+	if instance.Admin == nil {
+		return nil, fmt.Errorf("instance %s has no admin account", domain)
+	}
+	return instance.Admin, nil
+}
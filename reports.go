@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type ReportsCmd struct {
+	List    ReportsListCmd    `cmd:"" help:"List the open reports of an instance."`
+	Resolve ReportsResolveCmd `cmd:"" help:"Mark a report as resolved."`
+	Reopen  ReportsReopenCmd  `cmd:"" help:"Reopen a resolved report."`
+}
+
+type ReportsListCmd struct {
+	Domain   string `required:"" help:"domain name of the instance"`
+	Resolved bool   `help:"list the resolved reports"`
+}
+
+func (r *ReportsListCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var instance models.Instance
+	if err := db.Where("domain = ?", r.Domain).First(&instance).Error; err != nil {
+		return err
+	}
+	tx := db.Preload("Actor").Preload("Target").Where("instance_id = ?", instance.ID)
+	if r.Resolved {
+		tx = tx.Where("action_taken_at IS NOT NULL")
+	} else {
+		tx = tx.Where("action_taken_at IS NULL")
+	}
+	var reports []models.Report
+	if err := tx.Order("id").Find(&reports).Error; err != nil {
+		return err
+	}
+	for _, rep := range reports {
+		fmt.Printf("%-20d  %-30s  %-30s  %-10s  %s\n", rep.ID, rep.Actor.Acct(), rep.Target.Acct(), rep.Category, rep.Comment)
+	}
+	return nil
+}
+
+type ReportsResolveCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+	ID     uint64 `arg:"" help:"id of the report"`
+}
+
+func (r *ReportsResolveCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, report, err := instanceReport(db, r.Domain, r.ID)
+	if err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).ResolveReport(admin, report)
+}
+
+type ReportsReopenCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+	ID     uint64 `arg:"" help:"id of the report"`
+}
+
+func (r *ReportsReopenCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, report, err := instanceReport(db, r.Domain, r.ID)
+	if err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).ReopenReport(admin, report)
+}
+
+// instanceReport returns the admin account of the instance for domain, and
+// the instance's report id.
+func instanceReport(db *gorm.DB, domain string, id uint64) (*models.Account, *models.Report, error) {
+	admin, err := instanceAdmin(db, domain)
+	if err != nil {
+		return nil, nil, err
+	}
+	var report models.Report
+	if err := db.Take(&report, "id = ? AND instance_id = ?", id, admin.InstanceID).Error; err != nil {
+		return nil, nil, err
+	}
+	return admin, &report, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type RotateKeysCmd struct {
+	Name        string        `required:"" help:"name of the account"`
+	Domain      string        `required:"" help:"domain of the account"`
+	GracePeriod time.Duration `help:"how long the previous key remains valid for verification" default:"24h"`
+}
+
+func (r *RotateKeysCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var account models.Account
+	if err := db.Joins("Actor").First(&account, "name = ? AND domain = ?", r.Name, r.Domain).Error; err != nil {
+		return err
+	}
+	return models.NewAccounts(db).RotateKeys(&account, r.GracePeriod)
+}
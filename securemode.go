@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type SecureModeCmd struct {
+	Domain  string `required:"" help:"domain name of the instance"`
+	Disable bool   `help:"disable secure mode"`
+}
+
+func (s *SecureModeCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var instance models.Instance
+	if err := db.Where("domain = ?", s.Domain).First(&instance).Error; err != nil {
+		return err
+	}
+	return db.Model(&instance).Update("secure_mode", !s.Disable).Error
+}
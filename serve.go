@@ -12,9 +12,12 @@ import (
 	"time"
 
 	"github.com/davecheney/pub/activitypub"
+	"github.com/davecheney/pub/internal/config"
 	"github.com/davecheney/pub/internal/group"
 	"github.com/davecheney/pub/internal/httpx"
+	"github.com/davecheney/pub/internal/migrations"
 	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/urls"
 	"github.com/davecheney/pub/mastodon"
 	"github.com/davecheney/pub/media"
 	"github.com/davecheney/pub/oauth"
@@ -26,45 +29,154 @@ import (
 )
 
 type ServeCmd struct {
-	Addr             string `help:"address to listen" default:"127.0.0.1:9999"`
+	Addr             string `help:"address to listen, overrides listen"`
 	DebugPrintRoutes bool   `help:"print routes to stdout on startup"`
-	LogHTTP          bool   `help:"log HTTP requests"`
+	LogHTTP          bool   `help:"log HTTP requests, overrides logging.http"`
+	Migrate          bool   `help:"apply pending database migrations before serving"`
+	MediaDir         string `help:"directory to store uploaded media, overrides media.dir"`
+
+	BackfillOutboxLimit  int `help:"maximum number of statuses to fetch from a remote actor's outbox, overrides federation.backfill_outbox_limit"`
+	BackfillRepliesLimit int `help:"maximum number of statuses to fetch for a remote thread, overrides federation.backfill_replies_limit"`
+
+	StaleActorAge time.Duration `help:"age after which remote actors are refetched, overrides federation.stale_actor_age"`
+	PruneAfter    time.Duration `help:"delete remote statuses which no local account has interacted with after this long, overrides federation.prune_after"`
 }
 
 func (s *ServeCmd) Run(ctx *Context) error {
+	cfg := ctx.Settings
+	s.apply(cfg)
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
 	if err != nil {
 		return err
 	}
 
-	if err := configureDB(db); err != nil {
+	if err := configureDB(db, &cfg.Database); err != nil {
+		return err
+	}
+
+	if s.Migrate {
+		if err := migrateUp(db, cfg.BaseURL); err != nil {
+			return err
+		}
+	}
+	pending, err := migrations.Pending(db)
+	if err != nil {
 		return err
 	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is out of date, %d migrations are pending; run pub migrate up, or pass --migrate", len(pending))
+	}
+
+	r := s.routes(db, cfg)
+
+	if s.DebugPrintRoutes {
+		walkFunc := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			route = strings.Replace(route, "/*/", "/", -1)
+			fmt.Printf("%s %s\n", method, route)
+			return nil
+		}
+
+		if err := chi.Walk(r, walkFunc); err != nil {
+			fmt.Printf("Logging err: %s\n", err.Error())
+		}
+	}
+
+	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	g := group.New(signalCtx)
+	g.AddContext(func(ctx context.Context) error {
+		fmt.Println("http.ListenAndServe", cfg.Listen, "started")
+		defer fmt.Println("http.ListenAndServe", cfg.Listen, "stopped")
+		svr := &http.Server{
+			Addr:         cfg.Listen,
+			Handler:      r,
+			WriteTimeout: cfg.HTTP.WriteTimeout,
+			ReadTimeout:  cfg.HTTP.ReadTimeout,
+		}
+		go func() {
+			<-ctx.Done()
+			svr.Shutdown(ctx)
+		}()
+		return svr.ListenAndServe()
+	})
+	workers := cfg.Workers
+	if workers.Relationships > 0 {
+		g.Add(activitypub.NewRelationshipRequestProcessor(db, workers.Relationships, cfg.HTTP.ClientTimeout).Run)
+	}
+	if workers.Reactions > 0 {
+		g.Add(activitypub.NewReactionRequestProcessor(db, workers.Reactions, cfg.HTTP.ClientTimeout).Run)
+	}
+	if workers.Actors > 0 {
+		g.Add(activitypub.NewActorRequestProcessor(db, workers.Actors, cfg.HTTP.ClientTimeout).Run)
+	}
+	if workers.RelMe > 0 {
+		g.Add(activitypub.NewRelMeVerifier(db, workers.RelMe).Run)
+	}
+	if workers.Backfill > 0 {
+		g.Add(activitypub.NewBackfillProcessor(db, workers.Backfill, cfg.Federation.BackfillOutboxLimit, cfg.Federation.BackfillRepliesLimit, cfg.HTTP.ClientTimeout).Run)
+	}
+	if workers.Reports > 0 {
+		g.Add(activitypub.NewReportRequestProcessor(db, workers.Reports, cfg.HTTP.ClientTimeout).Run)
+	}
+	if cfg.Federation.PruneAfter > 0 {
+		g.Add(activitypub.NewPruner(db, cfg.Federation.PruneAfter).Run)
+	}
+	if cfg.Trends.Interval > 0 {
+		g.Add(activitypub.NewTrendsUpdater(db, cfg.Trends.Interval, cfg.Trends.Window, cfg.Trends.HalfLife).Run)
+	}
 
+	return g.Wait()
+}
+
+// routes returns the router which serves the instances in db.
+func (s *ServeCmd) routes(db *gorm.DB, cfg *config.Config) chi.Router {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
 	r.Use(middleware.RealIP)
-	if s.LogHTTP {
+	if cfg.Logging.HTTP {
 		r.Use(middleware.Logger)
 	}
 
-	r.Route("/api", func(r chi.Router) {
-		envFn := func(r *http.Request) *mastodon.Env {
-			return &mastodon.Env{
-				Env: &models.Env{
-					DB: db.WithContext(r.Context()),
-				},
-			}
+	store := media.NewStore(cfg.Media.Dir)
+
+	mastodonEnvFn := func(r *http.Request) *mastodon.Env {
+		return &mastodon.Env{
+			Env: &models.Env{
+				DB:            db.WithContext(r.Context()),
+				StaleActorAge: cfg.Federation.StaleActorAge,
+				ClientTimeout: cfg.HTTP.ClientTimeout,
+			},
+			Media:         store,
+			VapidKey:      cfg.Federation.VapidKey,
+			URLs:          urls.New(cfg.BaseURL(r.Host)),
+			DefaultAvatar: cfg.Media.DefaultAvatar,
+			DefaultHeader: cfg.Media.DefaultHeader,
 		}
+	}
+
+	r.Route("/api", func(r chi.Router) {
+		envFn := mastodonEnvFn
 		r.Route("/v1", func(r chi.Router) {
 			r.Post("/apps", httpx.HandlerFunc(envFn, mastodon.AppsCreate))
 			r.Route("/accounts", func(r chi.Router) {
+				r.Post("/", httpx.HandlerFunc(envFn, mastodon.AccountsCreate))
 				r.Get("/verify_credentials", httpx.HandlerFunc(envFn, mastodon.AccountsVerifyCredentials))
 				r.Patch("/update_credentials", httpx.HandlerFunc(envFn, mastodon.AccountsUpdateCredentials))
 				r.Get("/relationships", httpx.HandlerFunc(envFn, mastodon.RelationshipsShow))
+				r.Post("/rotate_keys", httpx.HandlerFunc(envFn, mastodon.AccountsRotateKeys))
+				r.Post("/aliases", httpx.HandlerFunc(envFn, mastodon.AccountsAliasesCreate))
+				r.Delete("/aliases", httpx.HandlerFunc(envFn, mastodon.AccountsAliasesDestroy))
+				r.Post("/move", httpx.HandlerFunc(envFn, mastodon.AccountsMove))
+				r.Get("/lookup", httpx.HandlerFunc(envFn, mastodon.AccountsLookup))
+				r.Get("/search", httpx.HandlerFunc(envFn, mastodon.AccountsSearch))
 				r.Get("/{id}", httpx.HandlerFunc(envFn, mastodon.AccountsShow))
 				r.Get("/{id}/lists", httpx.HandlerFunc(envFn, mastodon.AccountsShowListMembership)) // todo
 				r.Get("/{id}/statuses", httpx.HandlerFunc(envFn, mastodon.AccountsStatusesShow))
+				r.Get("/{id}/featured_tags", httpx.HandlerFunc(envFn, mastodon.AccountsFeaturedTagsShow))
 				r.Post("/{id}/follow", httpx.HandlerFunc(envFn, mastodon.RelationshipsCreate))
 				r.Get("/{id}/followers", httpx.HandlerFunc(envFn, mastodon.AccountsFollowersShow))
 				r.Get("/{id}/following", httpx.HandlerFunc(envFn, mastodon.AccountsFollowingShow))
@@ -74,6 +186,40 @@ func (s *ServeCmd) Run(ctx *Context) error {
 				r.Post("/{id}/block", httpx.HandlerFunc(envFn, mastodon.BlocksCreate))
 				r.Post("/{id}/unblock", httpx.HandlerFunc(envFn, mastodon.BlocksDestroy))
 			})
+			r.Route("/admin", func(r chi.Router) {
+				r.Get("/accounts", httpx.HandlerFunc(envFn, mastodon.AdminAccountsIndex))
+				r.Get("/accounts/{id}", httpx.HandlerFunc(envFn, mastodon.AdminAccountsShow))
+				r.Post("/accounts/{id}/approve", httpx.HandlerFunc(envFn, mastodon.AdminAccountsApprove))
+				r.Post("/accounts/{id}/reject", httpx.HandlerFunc(envFn, mastodon.AdminAccountsReject))
+				r.Post("/accounts/{id}/action", httpx.HandlerFunc(envFn, mastodon.AdminAccountsAction))
+				r.Post("/accounts/{id}/unsuspend", httpx.HandlerFunc(envFn, mastodon.AdminAccountsUnsuspend))
+				r.Post("/accounts/{id}/unsilence", httpx.HandlerFunc(envFn, mastodon.AdminAccountsUnsilence))
+				r.Get("/action_logs", httpx.HandlerFunc(envFn, mastodon.AdminActionLogsIndex))
+				r.Get("/domain_allows", httpx.HandlerFunc(envFn, mastodon.AdminDomainAllowsIndex))
+				r.Post("/domain_allows", httpx.HandlerFunc(envFn, mastodon.AdminDomainAllowsCreate))
+				r.Get("/domain_allows/{id}", httpx.HandlerFunc(envFn, mastodon.AdminDomainAllowsShow))
+				r.Delete("/domain_allows/{id}", httpx.HandlerFunc(envFn, mastodon.AdminDomainAllowsDestroy))
+				r.Get("/domain_blocks", httpx.HandlerFunc(envFn, mastodon.AdminDomainBlocksIndex))
+				r.Post("/domain_blocks", httpx.HandlerFunc(envFn, mastodon.AdminDomainBlocksCreate))
+				r.Get("/domain_blocks/{id}", httpx.HandlerFunc(envFn, mastodon.AdminDomainBlocksShow))
+				r.Put("/domain_blocks/{id}", httpx.HandlerFunc(envFn, mastodon.AdminDomainBlocksUpdate))
+				r.Delete("/domain_blocks/{id}", httpx.HandlerFunc(envFn, mastodon.AdminDomainBlocksDestroy))
+				r.Get("/invites", httpx.HandlerFunc(envFn, mastodon.AdminInvitesIndex))
+				r.Post("/invites", httpx.HandlerFunc(envFn, mastodon.AdminInvitesCreate))
+				r.Get("/reports", httpx.HandlerFunc(envFn, mastodon.AdminReportsIndex))
+				r.Get("/reports/{id}", httpx.HandlerFunc(envFn, mastodon.AdminReportsShow))
+				r.Post("/reports/{id}/resolve", httpx.HandlerFunc(envFn, mastodon.AdminReportsResolve))
+				r.Post("/reports/{id}/reopen", httpx.HandlerFunc(envFn, mastodon.AdminReportsReopen))
+				r.Get("/trends/tags", httpx.HandlerFunc(envFn, mastodon.AdminTrendsTagsIndex))
+				r.Post("/trends/tags/{id}/approve", httpx.HandlerFunc(envFn, mastodon.AdminTrendsTagsApprove))
+				r.Post("/trends/tags/{id}/reject", httpx.HandlerFunc(envFn, mastodon.AdminTrendsTagsReject))
+				r.Get("/trends/statuses", httpx.HandlerFunc(envFn, mastodon.AdminTrendsStatusesIndex))
+				r.Post("/trends/statuses/{id}/approve", httpx.HandlerFunc(envFn, mastodon.AdminTrendsStatusesApprove))
+				r.Post("/trends/statuses/{id}/reject", httpx.HandlerFunc(envFn, mastodon.AdminTrendsStatusesReject))
+				r.Get("/trends/links", httpx.HandlerFunc(envFn, mastodon.AdminTrendsLinksIndex))
+				r.Post("/trends/links/{id}/approve", httpx.HandlerFunc(envFn, mastodon.AdminTrendsLinksApprove))
+				r.Post("/trends/links/{id}/reject", httpx.HandlerFunc(envFn, mastodon.AdminTrendsLinksReject))
+			})
 			r.Get("/blocks", httpx.HandlerFunc(envFn, mastodon.BlocksIndex))
 			r.Get("/conversations", httpx.HandlerFunc(envFn, mastodon.ConversationsIndex))
 			r.Get("/custom_emojis", httpx.HandlerFunc(envFn, mastodon.EmojisIndex))
@@ -85,6 +231,11 @@ func (s *ServeCmd) Run(ctx *Context) error {
 			r.Get("/lists/{id}/accounts", httpx.HandlerFunc(envFn, mastodon.ListsViewMembers)) // todo
 			r.Post("/lists/{id}/accounts", httpx.HandlerFunc(envFn, mastodon.ListsAddMembers))
 			r.Delete("/lists/{id}/accounts", httpx.HandlerFunc(envFn, mastodon.ListsRemoveMembers))
+			r.Get("/featured_tags", httpx.HandlerFunc(envFn, mastodon.FeaturedTagsIndex))
+			r.Post("/featured_tags", httpx.HandlerFunc(envFn, mastodon.FeaturedTagsCreate))
+			r.Get("/featured_tags/suggestions", httpx.HandlerFunc(envFn, mastodon.FeaturedTagsSuggestions))
+			r.Delete("/featured_tags/{id}", httpx.HandlerFunc(envFn, mastodon.FeaturedTagsDestroy))
+			r.Get("/followed_tags", httpx.HandlerFunc(envFn, mastodon.FollowedTagsIndex))
 			r.Get("/instance", httpx.HandlerFunc(envFn, mastodon.InstancesIndexV1))
 			r.Options("/instance", func(w http.ResponseWriter, r *http.Request) {
 				// wtf elk, why do you send an OPTIONS request to /instance?
@@ -100,6 +251,7 @@ func (s *ServeCmd) Run(ctx *Context) error {
 			r.Post("/markers", httpx.HandlerFunc(envFn, mastodon.MarkersCreate))
 			r.Get("/mutes", httpx.HandlerFunc(envFn, mastodon.MutesIndex))
 			r.Get("/notifications", httpx.HandlerFunc(envFn, mastodon.NotificationsIndex))
+			r.Post("/reports", httpx.HandlerFunc(envFn, mastodon.ReportsCreate))
 
 			r.Post("/statuses", httpx.HandlerFunc(envFn, mastodon.StatusesCreate))
 			r.Get("/statuses/{id}/context", httpx.HandlerFunc(envFn, mastodon.StatusesContextsShow))
@@ -108,12 +260,21 @@ func (s *ServeCmd) Run(ctx *Context) error {
 			r.Get("/statuses/{id}/favourited_by", httpx.HandlerFunc(envFn, mastodon.FavouritesShow))
 			r.Get("/statuses/{id}", httpx.HandlerFunc(envFn, mastodon.StatusesShow))
 			r.Delete("/statuses/{id}", httpx.HandlerFunc(envFn, mastodon.StatusesDestroy))
+			r.Get("/tags/{name}", httpx.HandlerFunc(envFn, mastodon.TagsShow))
+			r.Post("/tags/{name}/follow", httpx.HandlerFunc(envFn, mastodon.TagsFollow))
+			r.Post("/tags/{name}/unfollow", httpx.HandlerFunc(envFn, mastodon.TagsUnfollow))
 			r.Route("/timelines", func(r chi.Router) {
 				r.Get("/home", httpx.HandlerFunc(envFn, mastodon.TimelinesHome))
 				r.Get("/public", httpx.HandlerFunc(envFn, mastodon.TimelinesPublic))
 				r.Get("/list/{id}", httpx.HandlerFunc(envFn, mastodon.TimelinesListShow))
 				r.Get("/tag/{tag}", httpx.HandlerFunc(envFn, mastodon.TimelinesTagShow))
 			})
+			r.Route("/trends", func(r chi.Router) {
+				r.Get("/", httpx.HandlerFunc(envFn, mastodon.TrendsTagsIndex))
+				r.Get("/tags", httpx.HandlerFunc(envFn, mastodon.TrendsTagsIndex))
+				r.Get("/statuses", httpx.HandlerFunc(envFn, mastodon.TrendsStatusesIndex))
+				r.Get("/links", httpx.HandlerFunc(envFn, mastodon.TrendsLinksIndex))
+			})
 
 		})
 		r.Route("/v2", func(r chi.Router) {
@@ -125,11 +286,18 @@ func (s *ServeCmd) Run(ctx *Context) error {
 	envFn := func(r *http.Request) *activitypub.Env {
 		return &activitypub.Env{
 			Env: &models.Env{
-				DB: db.WithContext(r.Context()),
+				DB:            db.WithContext(r.Context()),
+				StaleActorAge: cfg.Federation.StaleActorAge,
+				ClientTimeout: cfg.HTTP.ClientTimeout,
 			},
+			URLs: urls.New(cfg.BaseURL(r.Host)),
 		}
 	}
 	r.Post("/inbox", httpx.HandlerFunc(envFn, activitypub.InboxCreate))
+	r.Get("/actor", httpx.HandlerFunc(envFn, activitypub.InstanceActorShow))
+	r.Post("/actor/inbox", httpx.HandlerFunc(envFn, activitypub.InboxCreate))
+
+	r.Get("/settings/export", httpx.HandlerFunc(mastodonEnvFn, mastodon.ExportsShow))
 
 	r.Route("/oauth", func(r chi.Router) {
 		r.Get("/authorize", httpx.HandlerFunc(envFn, oauth.AuthorizeNew))
@@ -139,12 +307,13 @@ func (s *ServeCmd) Run(ctx *Context) error {
 	})
 
 	r.Route("/u/{username}", func(r chi.Router) {
+		r.Use(activitypub.AuthorizedFetch(envFn))
 		r.Get("/", httpx.HandlerFunc(envFn, activitypub.UsersShow))
 		r.Post("/inbox", httpx.HandlerFunc(envFn, activitypub.InboxCreate))
-		r.Get("/outbox", activitypub.OutboxIndex)
-		r.Get("/followers", activitypub.FollowersIndex)
-		r.Get("/following", activitypub.FollowingIndex)
-		r.Get("/collections/{collection}", activitypub.CollectionsShow)
+		r.Get("/outbox", httpx.HandlerFunc(envFn, activitypub.OutboxIndex))
+		r.Get("/followers", httpx.HandlerFunc(envFn, activitypub.FollowersIndex))
+		r.Get("/following", httpx.HandlerFunc(envFn, activitypub.FollowingIndex))
+		r.Get("/collections/{collection}", httpx.HandlerFunc(envFn, activitypub.CollectionsShow))
 	})
 
 	r.Route("/.well-known", func(r chi.Router) {
@@ -160,58 +329,48 @@ func (s *ServeCmd) Run(ctx *Context) error {
 		}
 	}
 
-	r.Get("/media/{kind}/{hash}/{id}", httpx.HandlerFunc(modelEnvFn, media.Show))
+	cache := media.NewCache(cfg.Media.CacheDir, cfg.Media.CacheSize, cfg.Media.DefaultAvatar, cfg.Media.DefaultHeader)
+	r.Get("/media/{kind}/{hash}/{id}", httpx.HandlerFunc(modelEnvFn, cache.Show))
+	r.Get("/media/uploads/{name}", store.Show)
 
-	if s.DebugPrintRoutes {
-		walkFunc := func(method string, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
-			route = strings.Replace(route, "/*/", "/", -1)
-			fmt.Printf("%s %s\n", method, route)
-			return nil
-		}
+	return r
+}
 
-		if err := chi.Walk(r, walkFunc); err != nil {
-			fmt.Printf("Logging err: %s\n", err.Error())
-		}
+// apply overrides the settings in cfg with the flags which were given.
+func (s *ServeCmd) apply(cfg *config.Config) {
+	cfg.Listen = stringOrDefault(s.Addr, cfg.Listen)
+	cfg.Media.Dir = stringOrDefault(s.MediaDir, cfg.Media.Dir)
+	if s.LogHTTP {
+		cfg.Logging.HTTP = true
+	}
+	if s.BackfillOutboxLimit > 0 {
+		cfg.Federation.BackfillOutboxLimit = s.BackfillOutboxLimit
+	}
+	if s.BackfillRepliesLimit > 0 {
+		cfg.Federation.BackfillRepliesLimit = s.BackfillRepliesLimit
+	}
+	if s.StaleActorAge > 0 {
+		cfg.Federation.StaleActorAge = s.StaleActorAge
+	}
+	if s.PruneAfter > 0 {
+		cfg.Federation.PruneAfter = s.PruneAfter
 	}
-
-	signalCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer stop()
-	g := group.New(signalCtx)
-	g.AddContext(func(ctx context.Context) error {
-		fmt.Println("http.ListenAndServe", s.Addr, "started")
-		defer fmt.Println("http.ListenAndServe", s.Addr, "stopped")
-		svr := &http.Server{
-			Addr:         s.Addr,
-			Handler:      r,
-			WriteTimeout: 15 * time.Second,
-			ReadTimeout:  15 * time.Second,
-		}
-		go func() {
-			<-ctx.Done()
-			svr.Shutdown(ctx)
-		}()
-		return svr.ListenAndServe()
-	})
-	g.Add(activitypub.NewRelationshipRequestProcessor(db).Run)
-	g.Add(activitypub.NewReactionRequestProcessor(db).Run)
-
-	return g.Wait()
 }
 
-func configureDB(db *gorm.DB) error {
+func configureDB(db *gorm.DB, cfg *config.Database) error {
 	sqlDB, err := db.DB()
 	if err != nil {
 		return err
 	}
 
 	// SetMaxIdleConns sets the maximum number of connections in the idle connection pool.
-	sqlDB.SetMaxIdleConns(10)
+	sqlDB.SetMaxIdleConns(cfg.MaxIdleConns)
 
 	// SetMaxOpenConns sets the maximum number of open connections to the database.
-	sqlDB.SetMaxOpenConns(100)
+	sqlDB.SetMaxOpenConns(cfg.MaxOpenConns)
 
 	// SetConnMaxLifetime sets the maximum amount of time a connection may be reused.
-	sqlDB.SetConnMaxLifetime(time.Hour)
+	sqlDB.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
 	return nil
 }
@@ -0,0 +1,1491 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	internalarchive "github.com/davecheney/pub/internal/archive"
+	"github.com/davecheney/pub/internal/config"
+	"github.com/davecheney/pub/internal/httpsig"
+	"github.com/davecheney/pub/internal/keypair"
+	"github.com/davecheney/pub/internal/models"
+	"github.com/davecheney/pub/internal/snowflake"
+	"github.com/davecheney/pub/internal/urls"
+	"github.com/davecheney/pub/media"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-json-experiment/json"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupInstances creates a database in a temporary directory serving
+// a.example and b.example, with an account on each, and returns the router
+// serving them, the access tokens of the accounts, and the Context for
+// running commands against the database.
+func setupInstances(t *testing.T) (chi.Router, map[string]string, *Context) {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Database.Driver = "sqlite"
+	cfg.Database.DSN = filepath.Join(t.TempDir(), "pub.db")
+	cfg.Media.Dir = t.TempDir()
+	cfg.Media.CacheDir = t.TempDir()
+	cfg.Instances = map[string]config.Instance{
+		"b.example": {BaseURL: "http://b.example"},
+	}
+
+	dialector, err := newDialector(cfg.Database.Driver, cfg.Database.DSN)
+	require.NoError(t, err)
+	ctx := &Context{
+		Settings:  cfg,
+		Config:    gorm.Config{Logger: logger.Discard},
+		Dialector: dialector,
+	}
+	require.NoError(t, (&MigrateUpCmd{}).Run(ctx))
+
+	tokens := make(map[string]string)
+	for _, acct := range []struct{ name, domain string }{
+		{"alice", "a.example"},
+		{"bob", "b.example"},
+	} {
+		require.NoError(t, (&CreateInstanceCmd{
+			Domain:      acct.domain,
+			Title:       acct.domain,
+			Description: acct.domain,
+			AdminEmail:  "admin@" + acct.domain,
+		}).Run(ctx))
+		require.NoError(t, (&CreateAccountCmd{
+			Name:     acct.name,
+			Domain:   acct.domain,
+			Email:    acct.name + "@" + acct.domain,
+			Password: "sssh",
+		}).Run(ctx))
+		tokens[acct.domain] = createToken(t, ctx, acct.name, acct.domain)
+	}
+
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	return (&ServeCmd{}).routes(db, cfg), tokens, ctx
+}
+
+// createToken creates an access token for the account name@domain.
+func createToken(t *testing.T, ctx *Context, name, domain string) string {
+	t.Helper()
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	var account models.Account
+	require.NoError(t, db.Joins("Actor").First(&account, "name = ? AND domain = ?", name, domain).Error)
+	app := &models.Application{
+		ID:           snowflake.Now(),
+		InstanceID:   account.InstanceID,
+		Name:         "test",
+		ClientID:     name + "-client",
+		ClientSecret: name + "-secret",
+	}
+	require.NoError(t, db.Create(app).Error)
+	token := &models.Token{
+		AccessToken:   name + "-token",
+		AccountID:     account.ID,
+		ApplicationID: app.ID,
+		TokenType:     "Bearer",
+		Scope:         "read write",
+	}
+	require.NoError(t, db.Create(token).Error)
+	return token.AccessToken
+}
+
+// createAdmin creates an account with the admin role, name@domain, and returns
+// its access token.
+func createAdmin(t *testing.T, ctx *Context, name, domain string) string {
+	t.Helper()
+	require.NoError(t, (&CreateAccountCmd{
+		Name:     name,
+		Domain:   domain,
+		Email:    name + "@" + domain,
+		Password: "sssh",
+		Admin:    true,
+	}).Run(ctx))
+	return createToken(t, ctx, name, domain)
+}
+
+// do makes a request for path on host, authenticated by token if it is not
+// empty, and returns the response.
+func do(t *testing.T, h http.Handler, method, host, path, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, "https://"+host+path, r)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// doSigned makes a request for path on host, signed by keyID with the
+// private key of kp, and returns the response.
+func doSigned(t *testing.T, h http.Handler, method, host, path, keyID string, kp *keypair.Keypair, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, "https://"+host+path, r)
+	req.Header.Set("Accept", "application/activity+json")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/activity+json")
+	}
+	block, _ := pem.Decode(kp.PrivateKey)
+	require.NotNil(t, block)
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	require.NoError(t, err)
+	require.NoError(t, httpsig.Sign(req, keyID, key, []byte(body)))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// createRemoteActor creates the remote actor name@domain, and returns its
+// keypair.
+func createRemoteActor(t *testing.T, ctx *Context, name, domain string) (*models.Actor, *keypair.Keypair) {
+	t.Helper()
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	kp, err := keypair.Generate()
+	require.NoError(t, err)
+	actor := &models.Actor{
+		ID:        snowflake.Now(),
+		Type:      "Person",
+		Name:      name,
+		Domain:    domain,
+		URI:       "https://" + domain + "/users/" + name,
+		PublicKey: kp.PublicKey,
+	}
+	require.NoError(t, db.Create(actor).Error)
+	return actor, kp
+}
+
+func TestServeSecureMode(t *testing.T) {
+	h, _, ctx := setupInstances(t)
+	require.NoError(t, (&SecureModeCmd{Domain: "a.example"}).Run(ctx))
+
+	t.Run("unsigned", func(t *testing.T) {
+		rec := do(t, h, "GET", "a.example", "/u/alice", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var actor map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &actor))
+		// only the key is published to unsigned requests.
+		require.NotNil(t, actor["publicKey"])
+		require.Nil(t, actor["outbox"])
+		rec = do(t, h, "GET", "a.example", "/u/alice/outbox", "", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		// instances without secure mode answer unsigned requests.
+		rec = do(t, h, "GET", "b.example", "/u/bob/outbox", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("signed but blocked", func(t *testing.T) {
+		require.NoError(t, (&BlockDomainCmd{Instance: "a.example", Domain: "bad.example", Severity: "suspend"}).Run(ctx))
+		kp, err := keypair.Generate()
+		require.NoError(t, err)
+		// the key owner is unknown, it must not be fetched from a blocked domain.
+		rec := doSigned(t, h, "GET", "a.example", "/u/alice/outbox", "https://bad.example/users/mallory#main-key", kp, "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+		actor, kp := createRemoteActor(t, ctx, "eve", "good.example")
+		rec = doSigned(t, h, "GET", "a.example", "/u/alice/outbox", actor.PublicKeyID(), kp, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, (&BlockDomainCmd{Instance: "a.example", Domain: "good.example", Severity: "suspend"}).Run(ctx))
+		rec = doSigned(t, h, "GET", "a.example", "/u/alice/outbox", actor.PublicKeyID(), kp, "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	})
+
+	t.Run("signed and allowed", func(t *testing.T) {
+		actor, kp := createRemoteActor(t, ctx, "carol", "remote.example")
+		rec := doSigned(t, h, "GET", "a.example", "/u/alice", actor.PublicKeyID(), kp, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var doc map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+		require.NotNil(t, doc["outbox"])
+		rec = doSigned(t, h, "GET", "a.example", "/u/alice/outbox", actor.PublicKeyID(), kp, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+		// a signature from another key is refused.
+		other, err := keypair.Generate()
+		require.NoError(t, err)
+		rec = doSigned(t, h, "GET", "a.example", "/u/alice/outbox", actor.PublicKeyID(), other, "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestServeMultipleInstances(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+
+	for domain, token := range tokens {
+		rec := do(t, h, "POST", domain, "/api/v1/statuses", token, `{"status":"hello from `+domain+`","visibility":"public"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	t.Run("tokens are only valid on their instance", func(t *testing.T) {
+		rec := do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", tokens["a.example"], "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		rec = do(t, h, "GET", "b.example", "/api/v1/accounts/verify_credentials", tokens["a.example"], "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("local timeline", func(t *testing.T) {
+		for domain := range tokens {
+			rec := do(t, h, "GET", domain, "/api/v1/timelines/public?local=true", "", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+			var statuses []struct {
+				URI     string `json:"uri"`
+				Content string `json:"content"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+			require.Len(t, statuses, 1)
+			require.Equal(t, "hello from "+domain, statuses[0].Content)
+			require.True(t, strings.HasPrefix(statuses[0].URI, ctx.Settings.BaseURL(domain)+"/u/"), statuses[0].URI)
+		}
+	})
+
+	t.Run("local directory", func(t *testing.T) {
+		rec := do(t, h, "GET", "a.example", "/api/v1/directory?local=true", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var accounts []struct {
+			Acct   string `json:"acct"`
+			Avatar string `json:"avatar"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accounts))
+		require.NotEmpty(t, accounts)
+		for _, a := range accounts {
+			require.NotEqual(t, "bob", a.Acct)
+			require.True(t, strings.HasPrefix(a.Avatar, "https://a.example/media/avatar/"), a.Avatar)
+		}
+	})
+
+	t.Run("actors", func(t *testing.T) {
+		rec := do(t, h, "GET", "a.example", "/u/bob", "", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		rec = do(t, h, "GET", "a.example", "/.well-known/webfinger?resource=acct:bob@b.example", "", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = do(t, h, "GET", "b.example", "/.well-known/webfinger?resource=acct:bob@b.example", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `"http://b.example/u/bob"`)
+
+		rec = do(t, h, "GET", "b.example", "/u/bob", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var actor map[string]any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &actor))
+		require.Equal(t, "http://b.example/u/bob", actor["id"])
+		require.Equal(t, "http://b.example/inbox", actor["endpoints"].(map[string]any)["sharedInbox"])
+	})
+}
+
+func TestServeRegistrations(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	signUp := func(name, invite string) *httptest.ResponseRecorder {
+		return do(t, h, "POST", "a.example", "/api/v1/accounts", "", `{"client_id":"alice-client","client_secret":"alice-secret","username":"`+name+`","email":"`+name+`@a.example","password":"correct horse","agreement":true,"invite_code":"`+invite+`"}`)
+	}
+	openRegistrations := func() bool {
+		rec := do(t, h, "GET", "a.example", "/nodeinfo/2.0", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var nodeinfo struct {
+			OpenRegistrations bool `json:"openRegistrations"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &nodeinfo))
+		return nodeinfo.OpenRegistrations
+	}
+	accessToken := func(rec *httptest.ResponseRecorder) string {
+		var token struct {
+			AccessToken string `json:"access_token"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &token))
+		return token.AccessToken
+	}
+
+	admin := createAdmin(t, ctx, "admin2", "a.example")
+	// visible returns the number of places the local account name is
+	// visible; webfinger, its actor, lookup, search, and the directory.
+	visible := func(name string) int {
+		n := 0
+		for _, path := range []string{
+			"/.well-known/webfinger?resource=acct:" + name + "@a.example",
+			"/u/" + name,
+			"/api/v1/accounts/lookup?acct=" + name,
+		} {
+			if do(t, h, "GET", "a.example", path, "", "").Code == http.StatusOK {
+				n++
+			}
+		}
+		var search struct {
+			Accounts []struct {
+				Username string `json:"username"`
+			} `json:"accounts"`
+		}
+		rec := do(t, h, "GET", "a.example", "/api/v2/search?type=accounts&q="+name, tokens["a.example"], "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &search))
+		for _, a := range search.Accounts {
+			if a.Username == name {
+				n++
+			}
+		}
+		var directory []struct {
+			Username string `json:"username"`
+		}
+		rec = do(t, h, "GET", "a.example", "/api/v1/directory?local=true", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &directory))
+		for _, a := range directory {
+			if a.Username == name {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("closed", func(t *testing.T) {
+		rec := signUp("carol", "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+		require.False(t, openRegistrations())
+	})
+
+	t.Run("approval", func(t *testing.T) {
+		require.NoError(t, (&RegistrationsModeCmd{Domain: "a.example", Mode: "approval"}).Run(ctx))
+		require.True(t, openRegistrations())
+
+		rec := signUp("carol", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		carol := accessToken(rec)
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", carol, "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = signUp("carol", "")
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, "username is taken")
+		// carol is hidden until approved.
+		require.Equal(t, 0, visible("carol"))
+
+		rec = do(t, h, "GET", "a.example", "/api/v1/admin/accounts?pending=true", tokens["a.example"], "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+		rec = do(t, h, "GET", "a.example", "/api/v1/admin/accounts?pending=true", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var pending []struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pending))
+		require.Len(t, pending, 1)
+		require.Equal(t, "carol", pending[0].Username)
+
+		rec = do(t, h, "POST", "a.example", "/api/v1/admin/accounts/"+pending[0].ID+"/approve", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", carol, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, 5, visible("carol"))
+	})
+
+	t.Run("approval rejected", func(t *testing.T) {
+		rec := signUp("dave", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		dave := accessToken(rec)
+		rec = do(t, h, "GET", "a.example", "/api/v1/admin/accounts?pending=true&username=dave", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var pending []struct {
+			ID string `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pending))
+		require.Len(t, pending, 1)
+
+		rec = do(t, h, "POST", "a.example", "/api/v1/admin/accounts/"+pending[0].ID+"/reject", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", dave, "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, 0, visible("dave"))
+
+		// the name of a rejected account can be taken again.
+		rec = signUp("dave", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	})
+
+	t.Run("invite", func(t *testing.T) {
+		require.NoError(t, (&RegistrationsModeCmd{Domain: "a.example", Mode: "invite"}).Run(ctx))
+		rec := signUp("erin", "")
+		require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+
+		rec = do(t, h, "POST", "a.example", "/api/v1/admin/invites", admin, `{"max_uses":1,"expires_in":3600}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var invite struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &invite))
+
+		rec = signUp("erin", invite.Code)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", accessToken(rec), "")
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		rec = signUp("frank", invite.Code)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		rec = signUp("frank", "not-an-invite")
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		require.Equal(t, 0, visible("frank"))
+
+		// a used invite does not fall back to approval.
+		require.NoError(t, (&RegistrationsModeCmd{Domain: "a.example", Mode: "approval"}).Run(ctx))
+		rec = signUp("frank", invite.Code)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+		require.Equal(t, 0, visible("frank"))
+		rec = do(t, h, "GET", "a.example", "/api/v1/admin/accounts?pending=true&username=frank", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, "[]", strings.TrimSpace(rec.Body.String()))
+	})
+}
+
+func TestServeAdmin(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	admin := createAdmin(t, ctx, "admin2", "a.example")
+	alice := tokens["a.example"]
+	rec := do(t, h, "POST", "a.example", "/api/v1/statuses", alice, `{"status":"hello","visibility":"public"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	t.Run("permissions", func(t *testing.T) {
+		for _, path := range []string{"/api/v1/admin/accounts", "/api/v1/admin/domain_blocks", "/api/v1/admin/reports", "/api/v1/admin/action_logs"} {
+			rec := do(t, h, "GET", "a.example", path, alice, "")
+			require.Equal(t, http.StatusForbidden, rec.Code, path)
+			rec = do(t, h, "GET", "a.example", path, admin, "")
+			require.Equal(t, http.StatusOK, rec.Code, path)
+		}
+	})
+
+	type adminAccount struct {
+		ID        string  `json:"id"`
+		Username  string  `json:"username"`
+		Domain    *string `json:"domain"`
+		Suspended bool    `json:"suspended"`
+		Silenced  bool    `json:"silenced"`
+	}
+	findAccounts := func(query string) []adminAccount {
+		rec := do(t, h, "GET", "a.example", "/api/v1/admin/accounts?"+query, admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var accounts []adminAccount
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accounts))
+		return accounts
+	}
+
+	t.Run("search", func(t *testing.T) {
+		accounts := findAccounts("local=true&username=ali")
+		require.Len(t, accounts, 1)
+		require.Equal(t, "alice", accounts[0].Username)
+		require.Nil(t, accounts[0].Domain)
+		// bob is local to b.example, and cannot be managed by a.example.
+		require.Empty(t, findAccounts("username=bob"))
+		require.Empty(t, findAccounts("username=a%25"))
+	})
+
+	aliceID := findAccounts("local=true&username=alice")[0].ID
+	publicTimeline := func() int {
+		rec := do(t, h, "GET", "a.example", "/api/v1/timelines/public?local=true", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var statuses []any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+		return len(statuses)
+	}
+
+	t.Run("silence", func(t *testing.T) {
+		require.Equal(t, 1, publicTimeline())
+		rec := do(t, h, "POST", "a.example", "/api/v1/admin/accounts/"+aliceID+"/action", admin, `{"type":"silence","text":"calm down"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, 0, publicTimeline())
+		require.True(t, findAccounts("silenced=true")[0].Silenced)
+
+		rec = do(t, h, "POST", "a.example", "/api/v1/admin/accounts/"+aliceID+"/unsilence", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, 1, publicTimeline())
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		rec := do(t, h, "POST", "a.example", "/api/v1/admin/accounts/"+aliceID+"/action", admin, `{"type":"suspend"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", alice, "")
+		require.Equal(t, http.StatusForbidden, rec.Code)
+
+		rec = do(t, h, "POST", "a.example", "/api/v1/admin/accounts/"+aliceID+"/unsuspend", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", alice, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("suspend is per instance", func(t *testing.T) {
+		remote, _ := createRemoteActor(t, ctx, "mallory", "remote.example")
+		rec := do(t, h, "POST", "a.example", fmt.Sprintf("/api/v1/admin/accounts/%d/action", remote.ID), admin, `{"type":"suspend"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		accounts := findAccounts("suspended=true")
+		require.Len(t, accounts, 1)
+		require.True(t, accounts[0].Suspended)
+
+		rec = do(t, h, "GET", "a.example", "/api/v1/accounts/lookup?acct=mallory@remote.example", "", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+		rec = do(t, h, "GET", "b.example", "/api/v1/accounts/lookup?acct=mallory@remote.example", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("domain blocks", func(t *testing.T) {
+		rec := do(t, h, "POST", "a.example", "/api/v1/admin/domain_blocks", admin, `{"domain":"bad.example"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var block struct {
+			ID     string `json:"id"`
+			Domain string `json:"domain"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &block))
+		require.Equal(t, "bad.example", block.Domain)
+
+		rec = do(t, h, "POST", "a.example", "/api/v1/admin/domain_blocks", admin, `{"domain":"bad.example"}`)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+		rec = do(t, h, "DELETE", "a.example", "/api/v1/admin/domain_blocks/"+block.ID, admin, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		rec = do(t, h, "GET", "a.example", "/api/v1/admin/domain_blocks/"+block.ID, admin, "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("action logs", func(t *testing.T) {
+		rec := do(t, h, "GET", "a.example", "/api/v1/admin/action_logs", admin, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		var actions []struct {
+			Action  string `json:"action"`
+			Target  string `json:"target"`
+			Account struct {
+				Username string `json:"username"`
+			} `json:"account"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &actions))
+		var logged []string
+		for _, a := range actions {
+			require.Equal(t, "admin2", a.Account.Username)
+			logged = append(logged, a.Action+" "+a.Target)
+		}
+		require.ElementsMatch(t, []string{
+			"silence alice", "unsilence alice", "suspend alice", "unsuspend alice", "suspend mallory@remote.example",
+			"block_domain bad.example", "unblock_domain bad.example",
+		}, logged)
+
+		// b.example's admins cannot see a.example's audit log.
+		other := createAdmin(t, ctx, "admin3", "b.example")
+		rec = do(t, h, "GET", "b.example", "/api/v1/admin/action_logs", other, "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.JSONEq(t, "[]", rec.Body.String())
+	})
+}
+
+func TestServeDomainPolicy(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	admin := createAdmin(t, ctx, "admin2", "a.example")
+
+	rec := do(t, h, "POST", "a.example", "/api/v1/admin/domain_blocks", admin, `{"domain":"loud.example","severity":"silence","public_comment":"spam"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var block struct {
+		ID          string `json:"id"`
+		Severity    string `json:"severity"`
+		RejectMedia bool   `json:"reject_media"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &block))
+	require.Equal(t, "silence", block.Severity)
+
+	rec = do(t, h, "PUT", "a.example", "/api/v1/admin/domain_blocks/"+block.ID, admin, `{"severity":"everything"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = do(t, h, "PUT", "a.example", "/api/v1/admin/domain_blocks/"+block.ID, admin, `{"reject_media":true}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &block))
+	require.Equal(t, "silence", block.Severity)
+	require.True(t, block.RejectMedia)
+
+	// remote statuses are shared by the instances, but a.example hides the
+	// attachments of loud.example, and does not serve them.
+	loud, _ := createRemoteActor(t, ctx, "carol", "loud.example")
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	conv, err := models.NewConversations(db).New("public")
+	require.NoError(t, err)
+	st := &models.Status{ID: snowflake.Now(), ActorID: loud.ID, ConversationID: conv.ID, URI: "https://loud.example/users/carol/statuses/1", Visibility: "public", Note: "look"}
+	st.Attachments = []models.StatusAttachment{{Attachment: models.Attachment{ID: snowflake.Now(), MediaType: "image/png", URL: "https://loud.example/media/1.png"}}}
+	require.NoError(t, db.Create(st).Error)
+	for domain, want := range map[string]int{"a.example": 0, "b.example": 1} {
+		rec = do(t, h, "GET", domain, fmt.Sprintf("/api/v1/statuses/%d", st.ID), tokens[domain], "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var status struct {
+			MediaAttachments []any `json:"media_attachments"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+		require.Len(t, status.MediaAttachments, want, domain)
+	}
+	rec = do(t, h, "GET", "a.example", fmt.Sprintf("/media/attachment/hash/%d", st.Attachments[0].ID), "", "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.NoError(t, (&BlockDomainCmd{Instance: "a.example", Domain: "bad.example", Severity: "suspend"}).Run(ctx))
+
+	// the blocks are published, without the private comment.
+	rec = do(t, h, "GET", "a.example", "/api/v1/instance/domain_blocks", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[
+		{"domain":"bad.example","digest":"`+fmt.Sprintf("%x", sha256.Sum256([]byte("bad.example")))+`","severity":"suspend","comment":null},
+		{"domain":"loud.example","digest":"`+fmt.Sprintf("%x", sha256.Sum256([]byte("loud.example")))+`","severity":"silence","comment":"spam"}
+	]`, rec.Body.String())
+	rec = do(t, h, "GET", "b.example", "/api/v1/instance/domain_blocks", "", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, "[]", rec.Body.String())
+
+	rec = do(t, h, "POST", "a.example", "/api/v1/admin/domain_allows", admin, `{"domain":"friendly.example"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(t, h, "POST", "a.example", "/api/v1/admin/domain_allows", admin, `{"domain":"friendly.example"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	require.NoError(t, (&AllowlistModeCmd{Domain: "a.example"}).Run(ctx))
+
+	var instance models.Instance
+	require.NoError(t, db.Take(&instance, "domain = ?", "a.example").Error)
+	require.True(t, instance.AllowlistMode)
+	blocks := models.NewDomainBlocks(db)
+	for domain, want := range map[string]bool{
+		"friendly.example": false,
+		"stranger.example": true,
+		"b.example":        false,
+	} {
+		blocked, err := blocks.IsBlocked(&instance, domain)
+		require.NoError(t, err)
+		require.Equal(t, want, blocked, domain)
+	}
+
+	var actions []string
+	require.NoError(t, db.Model(&models.AdminAction{}).Where("instance_id = ?", instance.ID).Pluck("action", &actions).Error)
+	require.ElementsMatch(t, []string{"block_domain", "update_domain_block", "block_domain", "allow_domain", "enable_allowlist_mode"}, actions)
+}
+
+func TestServeReports(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	admin := createAdmin(t, ctx, "admin2", "a.example")
+	alice, bob := tokens["a.example"], tokens["b.example"]
+
+	rec := do(t, h, "POST", "b.example", "/api/v1/statuses", bob, `{"status":"buy now","visibility":"public"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var status struct {
+		ID      string `json:"id"`
+		Account struct {
+			ID string `json:"id"`
+		} `json:"account"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var self struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &self))
+
+	for body, code := range map[string]int{
+		`{"account_id":"` + self.ID + `"}`:                                            http.StatusUnprocessableEntity,
+		`{"account_id":"1"}`:                                                          http.StatusNotFound,
+		`{"account_id":"` + status.Account.ID + `","category":"rude"}`:                http.StatusUnprocessableEntity,
+		`{"account_id":"` + status.Account.ID + `","status_ids":["` + self.ID + `"]}`: http.StatusUnprocessableEntity,
+	} {
+		rec = do(t, h, "POST", "a.example", "/api/v1/reports", alice, body)
+		require.Equal(t, code, rec.Code, body)
+	}
+
+	rec = do(t, h, "POST", "a.example", "/api/v1/reports", alice, `{"account_id":"`+status.Account.ID+`","status_ids":["`+status.ID+`"],"comment":"spam","category":"spam","forward":true}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var report struct {
+		ID            string   `json:"id"`
+		Category      string   `json:"category"`
+		Comment       string   `json:"comment"`
+		StatusIDs     []string `json:"status_ids"`
+		TargetAccount struct {
+			Acct string `json:"acct"`
+		} `json:"target_account"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	require.Equal(t, "spam", report.Category)
+	require.Equal(t, []string{status.ID}, report.StatusIDs)
+
+	// the report is in a.example's queue, with the reported status.
+	rec = do(t, h, "GET", "a.example", "/api/v1/admin/reports/"+report.ID, admin, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var adminReport struct {
+		ActionTaken bool `json:"action_taken"`
+		Statuses    []struct {
+			ID string `json:"id"`
+		} `json:"statuses"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &adminReport))
+	require.False(t, adminReport.ActionTaken)
+	require.Len(t, adminReport.Statuses, 1)
+	require.Equal(t, status.ID, adminReport.Statuses[0].ID)
+
+	var id uint64
+	_, err := fmt.Sscan(report.ID, &id)
+	require.NoError(t, err)
+	require.NoError(t, (&ReportsResolveCmd{Domain: "a.example", ID: id}).Run(ctx))
+	rec = do(t, h, "GET", "a.example", "/api/v1/admin/reports/"+report.ID, admin, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &adminReport))
+	require.True(t, adminReport.ActionTaken)
+
+	// b.example cannot resolve a.example's reports.
+	require.Error(t, (&ReportsReopenCmd{Domain: "b.example", ID: id}).Run(ctx))
+}
+
+func TestServeInboxFlag(t *testing.T) {
+	h, _, ctx := setupInstances(t)
+	admin := createAdmin(t, ctx, "admin2", "a.example")
+	mallory, kp := createRemoteActor(t, ctx, "mallory", "remote.example")
+	flag := func(id string) string {
+		// the actor is embedded, rather than referenced by its id.
+		return fmt.Sprintf(`{"id":%q,"type":"Flag","actor":{"id":%q,"type":"Application"},"object":["https://a.example/u/alice"],"content":"spam"}`, id, mallory.URI)
+	}
+
+	rec := doSigned(t, h, "POST", "a.example", "/inbox", mallory.PublicKeyID(), kp, flag(""))
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+
+	rec = doSigned(t, h, "POST", "a.example", "/inbox", mallory.PublicKeyID(), kp, flag("https://remote.example/flags/1"))
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	// delivered twice, reported once.
+	rec = doSigned(t, h, "POST", "a.example", "/inbox", mallory.PublicKeyID(), kp, flag("https://remote.example/flags/1"))
+	require.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+	rec = do(t, h, "GET", "a.example", "/api/v1/admin/reports", admin, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var reports []struct {
+		Account struct {
+			Username string  `json:"username"`
+			Domain   *string `json:"domain"`
+		} `json:"account"`
+		TargetAccount struct {
+			Username string `json:"username"`
+		} `json:"target_account"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &reports))
+	require.Len(t, reports, 1)
+	// the reporter is the signer of the flag.
+	require.Equal(t, "mallory", reports[0].Account.Username)
+	require.Equal(t, "remote.example", *reports[0].Account.Domain)
+	require.Equal(t, "alice", reports[0].TargetAccount.Username)
+}
+
+func TestServeSearch(t *testing.T) {
+	h, tokens, _ := setupInstances(t)
+	alice, bob := tokens["a.example"], tokens["b.example"]
+	for _, body := range []string{
+		`{"status":"the quick brown fox","visibility":"public"}`,
+		`{"status":"the lazy dog","visibility":"public"}`,
+		`{"status":"a secret fox","visibility":"private"}`,
+	} {
+		rec := do(t, h, "POST", "a.example", "/api/v1/statuses", alice, body)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	type results struct {
+		Accounts []struct {
+			Acct string `json:"acct"`
+		} `json:"accounts"`
+		Statuses []struct {
+			ID      string `json:"id"`
+			URI     string `json:"uri"`
+			Content string `json:"content"`
+		} `json:"statuses"`
+		Hashtags []any `json:"hashtags"`
+	}
+	search := func(host, token, query string) results {
+		rec := do(t, h, "GET", host, "/api/v2/search?"+query, token, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var res results
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return res
+	}
+
+	res := search("a.example", alice, "q=fox")
+	require.Len(t, res.Statuses, 2)
+	require.Empty(t, res.Accounts)
+	// bob does not follow alice, so cannot find the followers only status.
+	res = search("b.example", bob, "q=fox&type=statuses")
+	require.Len(t, res.Statuses, 1)
+	require.Equal(t, "the quick brown fox", res.Statuses[0].Content)
+	require.Len(t, search("a.example", alice, "q=quick+fox").Statuses, 1)
+	require.Len(t, search("a.example", alice, "q=the&limit=1&offset=1").Statuses, 1)
+	require.Len(t, search("a.example", alice, "q=fox&min_id="+res.Statuses[0].ID).Statuses, 1)
+
+	res = search("a.example", alice, "q=bo&type=accounts")
+	require.Len(t, res.Accounts, 1)
+	require.Equal(t, "bob", res.Accounts[0].Acct)
+	require.Empty(t, search("a.example", alice, "q=bo&type=accounts&following=true").Accounts)
+	rec := do(t, h, "GET", "a.example", "/api/v2/search?q=bo&following=true", "", "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// a status is found by its URI only by those who may see it.
+	public, private := search("a.example", alice, "q=quick").Statuses[0], search("a.example", alice, "q=secret").Statuses[0]
+	require.Len(t, search("a.example", "", "q="+url.QueryEscape(public.URI)).Statuses, 1)
+	require.Len(t, search("a.example", alice, "q="+url.QueryEscape(private.URI)).Statuses, 1)
+	require.Empty(t, search("b.example", bob, "q="+url.QueryEscape(private.URI)).Statuses)
+	require.Empty(t, search("a.example", "", "q="+url.QueryEscape(private.URI)).Statuses)
+	require.Empty(t, search("a.example", alice, "q="+url.QueryEscape(public.URI+"0")).Statuses)
+}
+
+// stubDNS makes every DNS lookup fail, as if the name does not exist, until
+// the end of the test, so the test does not depend on the network.
+func stubDNS(t *testing.T) {
+	resolver := net.DefaultResolver
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return nil, &net.DNSError{Err: "no such host", Server: address, IsNotFound: true}
+		},
+	}
+	t.Cleanup(func() { net.DefaultResolver = resolver })
+}
+
+func TestServeBackfillIsBestEffort(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	alice := tokens["a.example"]
+	carol, _ := createRemoteActor(t, ctx, "carol", "remote.example")
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	conv, err := models.NewConversations(db).New("public")
+	require.NoError(t, err)
+	st := &models.Status{ID: snowflake.Now(), ActorID: carol.ID, ConversationID: conv.ID, URI: "https://remote.example/users/carol/statuses/1", Visibility: "public", Note: "hello"}
+	require.NoError(t, db.Create(st).Error)
+	// without the table the backfills cannot be queued, but what is known is
+	// still returned.
+	require.NoError(t, db.Migrator().DropTable(&models.BackfillRequest{}))
+
+	rec := do(t, h, "GET", "a.example", fmt.Sprintf("/api/v1/statuses/%d/context", st.ID), alice, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(t, h, "GET", "a.example", "/api/v2/search?type=accounts&q="+url.QueryEscape(carol.URI), alice, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res struct {
+		Accounts []struct {
+			Acct string `json:"acct"`
+		} `json:"accounts"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Accounts, 1)
+	require.Equal(t, "carol@remote.example", res.Accounts[0].Acct)
+}
+
+func TestServeAccountsLookup(t *testing.T) {
+	stubDNS(t)
+	h, tokens, ctx := setupInstances(t)
+	alice := tokens["a.example"]
+	require.NoError(t, (&CreateAccountCmd{
+		Name:     "bobby",
+		Domain:   "a.example",
+		Email:    "bobby@a.example",
+		Password: "sssh",
+	}).Run(ctx))
+
+	type account struct {
+		Acct     string `json:"acct"`
+		Username string `json:"username"`
+	}
+	lookup := func(acct string) (int, account) {
+		rec := do(t, h, "GET", "a.example", "/api/v1/accounts/lookup?acct="+acct, "", "")
+		var a account
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &a))
+		}
+		return rec.Code, a
+	}
+	code, a := lookup("alice")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "alice", a.Username)
+	code, a = lookup("@Bob@b.example")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "bob", a.Username)
+	// bob is not an actor of a.example.
+	code, _ = lookup("bob")
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = lookup("")
+	require.Equal(t, http.StatusNotFound, code)
+
+	search := func(query string) []account {
+		rec := do(t, h, "GET", "a.example", "/api/v1/accounts/search?"+query, alice, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var accounts []account
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &accounts))
+		return accounts
+	}
+	// local actors are ranked first.
+	accounts := search("q=bo")
+	require.Len(t, accounts, 2)
+	require.Equal(t, "bobby", accounts[0].Username)
+	require.Equal(t, "bob", accounts[1].Username)
+	require.Len(t, search("q=bo&limit=1"), 1)
+	require.Empty(t, search("q="))
+	require.Empty(t, search("q=nobody"))
+
+	// followed actors are ranked before local actors.
+	rec := do(t, h, "GET", "a.example", "/api/v2/search?q=bob@b.example&resolve=true&type=accounts", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var res struct {
+		Accounts []struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"accounts"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res.Accounts, 1)
+	rec = do(t, h, "POST", "a.example", "/api/v1/accounts/"+res.Accounts[0].ID+"/follow", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	accounts = search("q=bo")
+	require.Equal(t, "bob", accounts[0].Username)
+	accounts = search("q=bo&following=true")
+	require.Len(t, accounts, 1)
+	require.Equal(t, "bob", accounts[0].Username)
+
+	// unknown accounts which cannot be resolved are not found.
+	require.Empty(t, search("q=nobody@nowhere.invalid&resolve=true"))
+
+	// resolving fetches from other servers, which requires authentication.
+	rec = do(t, h, "GET", "a.example", "/api/v2/search?q=nobody@nowhere.invalid&resolve=true", "", "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// accounts on IP addresses, or with ports, are not resolved.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			conn.Close()
+		}
+	}()
+	for _, q := range []string{"nobody@127.0.0.1", "nobody@" + l.Addr().String(), "nobody@[::1]", "https://" + l.Addr().String() + "/@nobody", "https://" + l.Addr().String() + "/users/nobody"} {
+		rec = do(t, h, "GET", "a.example", "/api/v2/search?type=accounts&resolve=true&q="+url.QueryEscape(q), alice, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		require.Empty(t, res.Accounts, q)
+	}
+	require.Zero(t, connections.Load())
+
+	rec = do(t, h, "GET", "a.example", "/api/v1/accounts/search?q=bo", "", "")
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestServeTags(t *testing.T) {
+	h, tokens, _ := setupInstances(t)
+	alice, bob := tokens["a.example"], tokens["b.example"]
+
+	home := func() []string {
+		rec := do(t, h, "GET", "a.example", "/api/v1/timelines/home", alice, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var statuses []struct {
+			Content string `json:"content"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+		var content []string
+		for _, st := range statuses {
+			content = append(content, st.Content)
+		}
+		return content
+	}
+	type tag struct {
+		Name    string `json:"name"`
+		History []struct {
+			Uses string `json:"uses"`
+		} `json:"history"`
+		Following bool `json:"following"`
+	}
+
+	rec := do(t, h, "GET", "a.example", "/api/v1/tags/golang", alice, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(t, h, "POST", "a.example", "/api/v1/tags/golang/follow", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(t, h, "POST", "a.example", "/api/v1/tags/not-a-tag/follow", alice, "")
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = do(t, h, "POST", "b.example", "/api/v1/statuses", bob, `{"status":"I like #GoLang","visibility":"public"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = do(t, h, "POST", "b.example", "/api/v1/statuses", bob, `{"status":"unrelated","visibility":"public"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"I like #GoLang"}, home())
+
+	// followed tags do not show the statuses of actors who are muted or
+	// blocked by, or who block, alice.
+	rec = do(t, h, "GET", "a.example", "/api/v1/accounts/lookup?acct=bob@b.example", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var account struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &account))
+	for _, action := range []string{"mute", "block"} {
+		rec = do(t, h, "POST", "a.example", "/api/v1/accounts/"+account.ID+"/"+action, alice, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Empty(t, home(), action)
+		rec = do(t, h, "POST", "a.example", "/api/v1/accounts/"+account.ID+"/un"+action, alice, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, []string{"I like #GoLang"}, home())
+	}
+	rec = do(t, h, "GET", "b.example", "/api/v1/accounts/lookup?acct=alice@a.example", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &account))
+	rec = do(t, h, "POST", "b.example", "/api/v1/accounts/"+account.ID+"/block", bob, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Empty(t, home())
+	rec = do(t, h, "POST", "b.example", "/api/v1/accounts/"+account.ID+"/unblock", bob, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"I like #GoLang"}, home())
+
+	rec = do(t, h, "GET", "a.example", "/api/v1/tags/GOLANG", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var got tag
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.Equal(t, "golang", got.Name)
+	require.True(t, got.Following)
+	require.Len(t, got.History, 7)
+	require.Equal(t, "1", got.History[0].Uses)
+
+	rec = do(t, h, "GET", "a.example", "/api/v1/followed_tags", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var followed []tag
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &followed))
+	require.Len(t, followed, 1)
+
+	rec = do(t, h, "POST", "a.example", "/api/v1/tags/golang/unfollow", alice, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	require.False(t, got.Following)
+	require.Empty(t, home())
+
+	// featured tags.
+	rec = do(t, h, "GET", "b.example", "/api/v1/featured_tags/suggestions", bob, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var suggestions []tag
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &suggestions))
+	require.Len(t, suggestions, 1)
+	rec = do(t, h, "POST", "b.example", "/api/v1/featured_tags", bob, `{"name":"#golang"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var featured struct {
+		ID            string  `json:"id"`
+		Name          string  `json:"name"`
+		URL           string  `json:"url"`
+		StatusesCount int     `json:"statuses_count"`
+		LastStatusAt  *string `json:"last_status_at"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &featured))
+	require.Equal(t, "golang", featured.Name)
+	require.Equal(t, "http://b.example/@bob/tagged/golang", featured.URL)
+	require.Equal(t, 1, featured.StatusesCount)
+	require.NotNil(t, featured.LastStatusAt)
+
+	rec = do(t, h, "GET", "b.example", "/u/bob/collections/tags", "", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var collection struct {
+		TotalItems int `json:"totalItems"`
+		Items      []struct {
+			Type string `json:"type"`
+			Href string `json:"href"`
+			Name string `json:"name"`
+		} `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &collection))
+	require.Equal(t, 1, collection.TotalItems)
+	require.Equal(t, "Hashtag", collection.Items[0].Type)
+	require.Equal(t, "http://b.example/tags/golang", collection.Items[0].Href)
+	require.Equal(t, "#golang", collection.Items[0].Name)
+
+	rec = do(t, h, "DELETE", "a.example", "/api/v1/featured_tags/"+featured.ID, alice, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(t, h, "DELETE", "b.example", "/api/v1/featured_tags/"+featured.ID, bob, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	rec = do(t, h, "GET", "b.example", "/api/v1/featured_tags", bob, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `[]`, rec.Body.String())
+}
+
+func TestServeTrends(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	alice, bob := tokens["a.example"], tokens["b.example"]
+	admin := createAdmin(t, ctx, "admin2", "a.example")
+
+	var first struct {
+		ID string `json:"id"`
+	}
+	for i, token := range []string{alice, bob} {
+		rec := do(t, h, "POST", []string{"a.example", "b.example"}[i], "/api/v1/statuses", token, `{"status":"#golang https://go.dev/blog/","visibility":"public"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		if i == 0 {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &first))
+		}
+	}
+	for host, token := range map[string]string{"b.example": bob, "a.example": admin} {
+		rec := do(t, h, "POST", host, "/api/v1/statuses/"+first.ID+"/favourite", token, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	}
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	require.NoError(t, models.UpdateTrends(db, time.Now(), 48*time.Hour, 6*time.Hour))
+
+	type trend struct {
+		ID             string `json:"id"`
+		Name           string `json:"name"`
+		URL            string `json:"url"`
+		RequiresReview bool   `json:"requires_review"`
+		History        []struct {
+			Accounts string `json:"accounts"`
+		} `json:"history"`
+	}
+	trends := func(host, path, token string) []trend {
+		t.Helper()
+		rec := do(t, h, "GET", host, path, token, "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var got []trend
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		return got
+	}
+
+	// trends are shown unless rejected.
+	tags := trends("a.example", "/api/v1/trends/tags", "")
+	require.Len(t, tags, 1)
+	require.Equal(t, "golang", tags[0].Name)
+	require.Equal(t, "2", tags[0].History[0].Accounts)
+	require.Len(t, trends("a.example", "/api/v1/trends", ""), 1)
+	links := trends("a.example", "/api/v1/trends/links", "")
+	require.Len(t, links, 1)
+	require.Equal(t, "https://go.dev/blog/", links[0].URL)
+	statuses := trends("a.example", "/api/v1/trends/statuses", "")
+	require.Len(t, statuses, 1)
+	require.Equal(t, first.ID, statuses[0].ID)
+
+	// once a.example requires approval only approved trends are shown, b.example
+	// is unaffected.
+	require.NoError(t, (&TrendsReviewCmd{Domain: "a.example"}).Run(ctx))
+	require.Empty(t, trends("a.example", "/api/v1/trends/tags", ""))
+	require.Len(t, trends("b.example", "/api/v1/trends/tags", ""), 1)
+
+	rec := do(t, h, "GET", "a.example", "/api/v1/admin/trends/tags", alice, "")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+	pending := trends("a.example", "/api/v1/admin/trends/tags", admin)
+	require.Len(t, pending, 1)
+	require.True(t, pending[0].RequiresReview)
+	rec = do(t, h, "POST", "a.example", "/api/v1/admin/trends/tags/"+pending[0].ID+"/approve", admin, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, trends("a.example", "/api/v1/trends/tags", ""), 1)
+	require.False(t, trends("a.example", "/api/v1/admin/trends/tags", admin)[0].RequiresReview)
+
+	rec = do(t, h, "POST", "a.example", "/api/v1/admin/trends/statuses/"+first.ID+"/approve", admin, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Len(t, trends("a.example", "/api/v1/trends/statuses", ""), 1)
+
+	links = trends("a.example", "/api/v1/admin/trends/links", admin)
+	require.Len(t, links, 1)
+	rec = do(t, h, "POST", "a.example", "/api/v1/admin/trends/links/"+links[0].ID+"/reject", admin, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Empty(t, trends("a.example", "/api/v1/trends/links", ""))
+	rec = do(t, h, "POST", "a.example", "/api/v1/admin/trends/links/12345/approve", admin, "")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServeServiceAccount(t *testing.T) {
+	h, _, ctx := setupInstances(t)
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	var service models.Account
+	require.NoError(t, db.Joins("Actor").Take(&service, "name = ? AND domain = ? AND type = ?", "a.example", "a.example", "Application").Error)
+	require.Empty(t, service.EncryptedPassword)
+	// migrate up removes the password of service accounts created with one.
+	require.NoError(t, db.Model(&service).Update("encrypted_password", []byte("hash")).Error)
+	require.NoError(t, (&MigrateUpCmd{}).Run(ctx))
+	require.NoError(t, db.Take(&service, service.ID).Error)
+	require.Empty(t, service.EncryptedPassword)
+
+	// the service account cannot log in, or be used with a token.
+	token := createToken(t, ctx, "a.example", "a.example")
+	form := url.Values{"username": {"a.example"}, "password": {""}, "client_id": {"a.example-client"}}
+	req := httptest.NewRequest("POST", "https://a.example/oauth/authorize", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec = do(t, h, "GET", "a.example", "/api/v1/accounts/verify_credentials", token, "")
+	require.Equal(t, http.StatusForbidden, rec.Code)
+
+	// nodeinfo is served before migrate up creates the service account.
+	require.NoError(t, db.Model(&models.Instance{}).Where("domain = ?", "b.example").Update("service_account_id", nil).Error)
+	for domain, actor := range map[string]any{"a.example": "https://a.example/actor", "b.example": nil} {
+		rec = do(t, h, "GET", domain, "/nodeinfo/2.0", "", "")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		var nodeinfo struct {
+			Metadata map[string]any `json:"metadata"`
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &nodeinfo))
+		require.Equal(t, actor, nodeinfo.Metadata["instanceActor"])
+	}
+}
+
+func TestServeUpdateCredentials(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	var alice models.Account
+	require.NoError(t, db.Joins("Actor").Take(&alice, "name = ? AND domain = ?", "alice", "a.example").Error)
+	require.NoError(t, db.Model(alice.Actor).Updates(map[string]any{"note": "hello", "followers_count": 7}).Error)
+
+	rec := do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"display_name":"Alice"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var actor models.Actor
+	require.NoError(t, db.Take(&actor, alice.ActorID).Error)
+	require.Equal(t, "Alice", actor.DisplayName)
+	require.Equal(t, "hello", actor.Note)
+	require.EqualValues(t, 7, actor.FollowersCount)
+	var request models.ActorRequest
+	require.NoError(t, db.Take(&request, "actor_id = ? AND action = ?", alice.ActorID, "update").Error)
+
+	// changing the defaults of the account does not federate the actor.
+	require.NoError(t, db.Delete(&request).Error)
+	rec = do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"source":{"privacy":"private"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, db.Take(&alice, alice.ID).Error)
+	require.Equal(t, "private", alice.DefaultPrivacy)
+	var count int64
+	require.NoError(t, db.Model(&models.ActorRequest{}).Count(&count).Error)
+	require.EqualValues(t, 0, count)
+
+	// the language must fit DefaultLanguage.
+	for _, language := range []string{"english", "en-AU-sydney", "EN", ""} {
+		rec = do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"source":{"language":"`+language+`"}}`)
+		require.Equal(t, http.StatusUnprocessableEntity, rec.Code, language)
+	}
+	rec = do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"source":{"language":"pt-BR"}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.NoError(t, db.Take(&alice, alice.ID).Error)
+	require.Equal(t, "pt-BR", alice.DefaultLanguage)
+
+	// fields are sent as an array, or as an object keyed by index.
+	attributes := func() []string {
+		var attrs []*models.ActorAttribute
+		require.NoError(t, db.Where("actor_id = ?", alice.ActorID).Order("id").Find(&attrs).Error)
+		var fields []string
+		for _, a := range attrs {
+			fields = append(fields, a.Name+"="+a.Value)
+		}
+		return fields
+	}
+	rec = do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"fields_attributes":[{"name":"web","value":"example.com"},{"name":"pronouns","value":"she/her"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"web=example.com", "pronouns=she/her"}, attributes())
+	rec = do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"fields_attributes":{"10":{"name":"c","value":"3"},"2":{"name":"b","value":"2"},"0":{"name":"a","value":"1"}}}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []string{"a=1", "b=2", "c=3"}, attributes())
+	rec = do(t, h, "PATCH", "a.example", "/api/v1/accounts/update_credentials", tokens["a.example"], `{"fields_attributes":{"first":{"name":"a","value":"1"}}}`)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestServeMove(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	var alice models.Account
+	require.NoError(t, db.Joins("Actor").Take(&alice, "name = ? AND domain = ?", "alice", "a.example").Error)
+
+	t.Run("forged", func(t *testing.T) {
+		carol, _ := createRemoteActor(t, ctx, "carol", "c.example")
+		require.NoError(t, db.Create(&models.Relationship{ActorID: alice.ActorID, TargetID: carol.ID, Following: true}).Error)
+		mallory, kp := createRemoteActor(t, ctx, "mallory", "evil.example")
+
+		// mallory signs a Move of carol to mallory.
+		move := fmt.Sprintf(`{"id":"%[1]s#moves/1","type":"Move","actor":"%[1]s","object":"%[1]s","target":"%[2]s"}`, carol.URI, mallory.URI)
+		rec := doSigned(t, h, "POST", "a.example", "/inbox", mallory.PublicKeyID(), kp, move)
+		require.Equal(t, http.StatusUnauthorized, rec.Code, rec.Body.String())
+		var actor models.Actor
+		require.NoError(t, db.Take(&actor, carol.ID).Error)
+		require.Nil(t, actor.MovedToID)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		require.NoError(t, db.Create(&models.DomainBlock{InstanceID: alice.InstanceID, Domain: "d.example"}).Error)
+		for _, tt := range []struct {
+			password string
+			expect   int
+		}{
+			{"", http.StatusForbidden},
+			{"wrong", http.StatusForbidden},
+			// the password is correct, but the target's domain is suspended.
+			{"sssh", http.StatusUnprocessableEntity},
+		} {
+			body := fmt.Sprintf(`{"target":"https://d.example/users/alice","password":%q}`, tt.password)
+			rec := do(t, h, "POST", "a.example", "/api/v1/accounts/move", tokens["a.example"], body)
+			require.Equal(t, tt.expect, rec.Code, rec.Body.String())
+		}
+	})
+}
+
+func TestServeExportImport(t *testing.T) {
+	h, tokens, ctx := setupInstances(t)
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	var alice models.Account
+	require.NoError(t, db.Joins("Actor").Take(&alice, "name = ? AND domain = ?", "alice", "a.example").Error)
+
+	// alice has an uploaded header, and an avatar whose upload is missing.
+	var img bytes.Buffer
+	require.NoError(t, png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	header, err := media.NewStore(ctx.Settings.Media.Dir).Put(&img)
+	require.NoError(t, err)
+	missing := urls.New("https://a.example").Upload("missing.png")
+	require.NoError(t, db.Model(alice.Actor).Updates(map[string]any{
+		"display_name": "Alice",
+		"avatar":       missing,
+		"header":       urls.New("https://a.example").Upload(header),
+	}).Error)
+	rec := do(t, h, "POST", "a.example", "/api/v1/statuses", tokens["a.example"], `{"status":"hello","visibility":"public"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	// a direct status to bob, with a hashtag. Mentions are not parsed from
+	// the text of a status, so bob is mentioned directly.
+	rec = do(t, h, "POST", "a.example", "/api/v1/statuses", tokens["a.example"], `{"status":"psst #secret","visibility":"direct"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var direct struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &direct))
+	var bob models.Actor
+	require.NoError(t, db.Take(&bob, "name = ? AND domain = ?", "bob", "b.example").Error)
+	var directID snowflake.ID
+	_, err = fmt.Sscan(direct.ID, &directID)
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&models.StatusMention{StatusID: directID, ActorID: bob.ID}).Error)
+	// a reblog of a status which has since been deleted.
+	rec = do(t, h, "POST", "b.example", "/api/v1/statuses", tokens["b.example"], `{"status":"boost me","visibility":"public"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var boosted struct {
+		ID string `json:"id"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &boosted))
+	var boostedID snowflake.ID
+	_, err = fmt.Sscan(boosted.ID, &boostedID)
+	require.NoError(t, err)
+	conv, err := models.NewConversations(db).New("public")
+	require.NoError(t, err)
+	reblogID := snowflake.Now()
+	require.NoError(t, db.Create(&models.Status{ID: reblogID, ActorID: alice.ActorID, ConversationID: conv.ID, URI: alice.Actor.URLs().Status("alice", reblogID), Visibility: "public", ReblogID: &boostedID}).Error)
+	// the foreign key prevents this, unless it is not enforced.
+	require.NoError(t, db.Connection(func(tx *gorm.DB) error {
+		if err := tx.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer tx.Exec("PRAGMA foreign_keys = ON")
+		return tx.Exec("DELETE FROM statuses WHERE id = ?", boostedID).Error
+	}))
+
+	archive := filepath.Join(t.TempDir(), "alice.tar.gz")
+	require.NoError(t, (&ExportCmd{Name: "alice", Domain: "a.example", Output: archive}).Run(ctx))
+	fsys, closer, err := internalarchive.Open(archive)
+	require.NoError(t, err)
+	outbox, err := fs.ReadFile(fsys, "outbox.json")
+	require.NoError(t, err)
+	require.NoError(t, closer())
+	// the reblog of the deleted status is not exported.
+	require.NotContains(t, string(outbox), "Announce")
+	require.Contains(t, string(outbox), `"name": "@bob@b.example"`)
+
+	require.NoError(t, (&CreateAccountCmd{
+		Name:     "carol",
+		Domain:   "a.example",
+		Email:    "carol@a.example",
+		Password: "sssh",
+	}).Run(ctx))
+	mediaDir := t.TempDir()
+	require.NoError(t, (&ImportCmd{Name: "carol", Domain: "a.example", Archive: archive, MediaDir: mediaDir}).Run(ctx))
+
+	var carol models.Account
+	require.NoError(t, db.Joins("Actor").Take(&carol, "name = ? AND domain = ?", "carol", "a.example").Error)
+	require.Equal(t, "Alice", carol.Actor.DisplayName)
+	require.Equal(t, missing, carol.Actor.Avatar)
+	require.Equal(t, urls.New("https://a.example").Upload(header), carol.Actor.Header)
+	_, err = os.Stat(filepath.Join(mediaDir, header))
+	require.NoError(t, err)
+	var statuses []*models.Status
+	require.NoError(t, db.Preload("Mentions").Preload("Tags.Tag").Where("actor_id = ?", carol.ActorID).Order("id").Find(&statuses).Error)
+	require.Len(t, statuses, 2)
+	require.Contains(t, statuses[0].Note, "hello")
+	require.Equal(t, "direct", statuses[1].Visibility)
+	require.Len(t, statuses[1].Mentions, 1)
+	require.Equal(t, bob.ID, statuses[1].Mentions[0].ActorID)
+	require.Len(t, statuses[1].Tags, 1)
+	require.Equal(t, "secret", statuses[1].Tags[0].Tag.Name)
+}
+
+func TestSynchroniseFollowers(t *testing.T) {
+	_, _, ctx := setupInstances(t)
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	require.NoError(t, err)
+	var bob models.Account
+	require.NoError(t, db.Joins("Actor").Take(&bob, "name = ? AND domain = ?", "bob", "b.example").Error)
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/users/src/following" && r.URL.RawQuery == "":
+			fmt.Fprintf(w, `{"id":"%[1]s/users/src/following","type":"OrderedCollection","first":"%[1]s/users/src/following?page=1"}`, srv.URL)
+		case r.URL.Path == "/users/src/following" && r.URL.RawQuery == "page=1":
+			fmt.Fprintf(w, `{"type":"OrderedCollectionPage","next":"%[1]s/users/src/following?page=2","orderedItems":["%[1]s/users/x"]}`, srv.URL)
+		case r.URL.Path == "/users/src/following" && r.URL.RawQuery == "page=2":
+			fmt.Fprintf(w, `{"type":"OrderedCollectionPage","orderedItems":["%[1]s/users/y"]}`, srv.URL)
+		default:
+			name := strings.TrimPrefix(r.URL.Path, "/users/")
+			fmt.Fprintf(w, `{"id":"%s%s","type":"Person","preferredUsername":%q,"publicKey":{"publicKeyPem":"key"}}`, srv.URL, r.URL.Path, name)
+		}
+	}))
+	defer srv.Close()
+
+	following := func() []string {
+		var relationships []*models.Relationship
+		require.NoError(t, db.Joins("Target").Where("relationships.actor_id = ? AND following = true", bob.ActorID).Find(&relationships).Error)
+		var names []string
+		for _, rel := range relationships {
+			names = append(names, rel.Target.Name)
+		}
+		return names
+	}
+
+	// an interrupted synchronisation resumes from the recorded page.
+	progress := filepath.Join(t.TempDir(), "progress")
+	require.NoError(t, os.WriteFile(progress, []byte(srv.URL+"/users/src/following?page=2\n"), 0o644))
+	cmd := &SynchroniseFollowersCmd{Source: srv.URL + "/users/src", Dest: bob.Actor.URI, Progress: progress}
+	require.NoError(t, cmd.Run(ctx))
+	require.Equal(t, []string{"y"}, following())
+	_, err = os.Stat(progress)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// the next synchronisation starts from the beginning.
+	require.NoError(t, cmd.Run(ctx))
+	require.ElementsMatch(t, []string{"x", "y"}, following())
+	var count int64
+	require.NoError(t, db.Model(&models.Relationship{}).Where("actor_id <> ? AND following = true", bob.ActorID).Count(&count).Error)
+	require.EqualValues(t, 0, count)
+}
@@ -2,58 +2,128 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
 
-	"github.com/carlmjohnson/requests"
+	"github.com/davecheney/pub/activitypub"
+	internal "github.com/davecheney/pub/internal/activitypub"
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SynchroniseFollowersCmd struct {
-	Source string `required:"" help:"source actor"`
-	Dest   string `required:"" help:"destination actor"`
+	Source   string        `required:"" help:"actor whose following collection is copied"`
+	Dest     string        `required:"" help:"local actor which follows the accounts the source actor follows"`
+	DryRun   bool          `help:"report the accounts which would be followed, but do not follow them"`
+	Delay    time.Duration `help:"delay between requests to remote servers" default:"1s"`
+	Resume   string        `help:"URL of the page of the following collection to resume from"`
+	Progress string        `help:"file recording the page being synchronised; if it exists, the synchronisation resumes from its page"`
 }
 
-type OrderedCollection struct {
-	Type       string `json:"type"`
-	TotalItems int    `json:"totalItems"`
-	First      string `json:"first"`
-}
+func (s *SynchroniseFollowersCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
 
-type OrderedCollectionPage struct {
-	Type         string   `json:"type"`
-	TotalItems   int      `json:"totalItems"`
-	Next         string   `json:"next"`
-	Prev         string   `json:"prev"`
-	OrderedItems []string `json:"orderedItems"`
-}
+	var account models.Account
+	if err := db.Joins("Actor").Where(clause.Eq{Column: clause.Column{Table: "Actor", Name: "uri"}, Value: s.Dest}).Take(&account).Error; err != nil {
+		return err
+	}
 
-func (s *SynchroniseFollowersCmd) Run(_ *Context) error {
-	var col OrderedCollection
-	err := requests.URL(s.Source+"/following").
-		Header("Accept", `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`).
-		ToJSON(&col).
-		Fetch(context.Background())
+	// sign requests as the destination so that servers which require
+	// authorized fetch will answer.
+	client, err := internal.NewClient(context.Background(), &account, ctx.Settings.HTTP.ClientTimeout)
 	if err != nil {
 		return err
 	}
+	fetcher := activitypub.NewRemoteActorFetcher(&account, db, ctx.Settings.HTTP.ClientTimeout)
+	actors := models.NewActors(db)
+	relationships := models.NewRelationships(db)
 
-	url := col.First
-
-	for {
-		var page OrderedCollectionPage
-		err := requests.URL(url).
-			Header("Accept", `application/ld+json; profile="https://www.w3.org/ns/activitystreams"`).
-			ToJSON(&page).
-			Fetch(context.Background())
+	var followed, already, failed int
+	var page string
+	follow := func(uri string) error {
+		target, err := actors.FindOrCreate(uri, func(uri string) (*models.Actor, error) {
+			time.Sleep(s.Delay)
+			return fetcher.Fetch(uri)
+		})
 		if err != nil {
 			return err
 		}
-		for _, item := range page.OrderedItems {
-			fmt.Println(item)
+		var rel models.Relationship
+		if err := db.Where("actor_id = ? and target_id = ? and following = true", account.ActorID, target.ID).Limit(1).Find(&rel).Error; err != nil {
+			return err
+		}
+		if rel.Following {
+			already++
+			return nil
+		}
+		if s.DryRun {
+			fmt.Println("would follow", uri)
+		} else {
+			// the follow request is sent by the relationship request processor.
+			if _, err := relationships.Follow(account.Actor, target); err != nil {
+				return err
+			}
+			fmt.Println("followed", uri)
 		}
-		if page.Next == "" {
-			break
+		followed++
+		return nil
+	}
+
+	start := s.Resume
+	if start == "" && s.Progress != "" {
+		b, err := os.ReadFile(s.Progress)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		start = strings.TrimSpace(string(b))
+	}
+	if start == "" {
+		start = s.Source + "/following"
+	}
+	err = client.Walk(start, func(uri string, items []any) error {
+		page = uri
+		fmt.Println("page", page)
+		if s.Progress != "" && !s.DryRun {
+			// the page is recorded before it is processed; following an
+			// account twice is harmless.
+			if err := os.WriteFile(s.Progress, []byte(page+"\n"), 0o644); err != nil {
+				return err
+			}
+		}
+		for _, item := range items {
+			uri, _ := item.(string)
+			if obj, ok := item.(map[string]any); ok {
+				uri, _ = obj["id"].(string)
+			}
+			if err := follow(uri); err != nil {
+				fmt.Println("failed", uri, err)
+				failed++
+			}
+		}
+		time.Sleep(s.Delay)
+		return nil
+	})
+
+	fmt.Printf("followed: %d, already following: %d, failed: %d\n", followed, already, failed)
+	if s.DryRun {
+		fmt.Println("dry run, no accounts were followed")
+	}
+	if err != nil && page != "" {
+		fmt.Println("resume with --resume", page)
+	}
+	if err == nil && s.Progress != "" && !s.DryRun {
+		// the synchronisation is complete, the next starts from the beginning.
+		if err := os.Remove(s.Progress); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
 		}
-		url = page.Next
 	}
-	return nil
+	return err
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/davecheney/pub/internal/models"
+	"gorm.io/gorm"
+)
+
+type TrendsCmd struct {
+	List    TrendsListCmd    `cmd:"" help:"List the trends of an instance, and whether they are shown."`
+	Update  TrendsUpdateCmd  `cmd:"" help:"Score the trending tags, statuses and links now."`
+	Approve TrendsApproveCmd `cmd:"" help:"Show a trend on an instance."`
+	Reject  TrendsRejectCmd  `cmd:"" help:"Hide a trend from an instance."`
+	Review  TrendsReviewCmd  `cmd:"" help:"Require, or stop requiring, approval for the trends shown by an instance."`
+}
+
+type TrendsListCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+}
+
+func (t *TrendsListCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	var instance models.Instance
+	if err := db.Where("domain = ?", t.Domain).First(&instance).Error; err != nil {
+		return err
+	}
+	var trends []*models.Trend
+	if err := db.Preload("Tag").Preload("Status").Where("score > 0").Order("kind, score DESC").Find(&trends).Error; err != nil {
+		return err
+	}
+	reviews, err := models.NewTrends(db).Reviews(&instance, trends)
+	if err != nil {
+		return err
+	}
+	for _, trend := range trends {
+		review := "pending"
+		if r, ok := reviews[trend.ID]; ok {
+			review = "rejected"
+			if r.Approved {
+				review = "approved"
+			}
+		}
+		fmt.Printf("%-10d  %-6s  %8.2f  %-8s  %s\n", trend.ID, trend.Kind, trend.Score, review, trend.Describe())
+	}
+	return nil
+}
+
+type TrendsUpdateCmd struct {
+	Window   time.Duration `help:"age of the oldest statuses counted, overrides trends.window"`
+	HalfLife time.Duration `help:"age at which a use counts half, overrides trends.half_life"`
+}
+
+func (t *TrendsUpdateCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	window, halfLife := ctx.Settings.Trends.Window, ctx.Settings.Trends.HalfLife
+	if t.Window > 0 {
+		window = t.Window
+	}
+	if t.HalfLife > 0 {
+		halfLife = t.HalfLife
+	}
+	return models.UpdateTrends(db, time.Now(), window, halfLife)
+}
+
+type TrendsApproveCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+	ID     uint32 `arg:"" help:"id of the trend"`
+}
+
+func (t *TrendsApproveCmd) Run(ctx *Context) error {
+	return reviewTrend(ctx, t.Domain, t.ID, true)
+}
+
+type TrendsRejectCmd struct {
+	Domain string `required:"" help:"domain name of the instance"`
+	ID     uint32 `arg:"" help:"id of the trend"`
+}
+
+func (t *TrendsRejectCmd) Run(ctx *Context) error {
+	return reviewTrend(ctx, t.Domain, t.ID, false)
+}
+
+func reviewTrend(ctx *Context, domain string, id uint32, approved bool) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, domain)
+	if err != nil {
+		return err
+	}
+	var trend models.Trend
+	if err := db.Preload("Tag").Preload("Status").Take(&trend, id).Error; err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).ReviewTrend(admin, &trend, approved)
+}
+
+type TrendsReviewCmd struct {
+	Domain  string `required:"" help:"domain name of the instance"`
+	Disable bool   `help:"show trends unless they are rejected"`
+}
+
+func (t *TrendsReviewCmd) Run(ctx *Context) error {
+	db, err := gorm.Open(ctx.Dialector, &ctx.Config)
+	if err != nil {
+		return err
+	}
+
+	admin, err := instanceAdmin(db, t.Domain)
+	if err != nil {
+		return err
+	}
+	return models.NewAdminActions(db).SetTrendsRequireApproval(admin, !t.Disable)
+}
@@ -12,7 +12,7 @@ func HostMetaIndex(env *activitypub.Env, w http.ResponseWriter, r *http.Request)
 	_, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
 		<XRD xmlns="http://docs.oasis-open.org/ns/xri/xrd-1.0">
 		<Subject>`+r.Host+`</Subject>
-		<Link rel="lrdd" template="https://`+r.Host+`/.well-known/webfinger?resource={uri}"/>
+		<Link rel="lrdd" template="`+env.URLs.URL("/.well-known/webfinger?resource={uri}")+`"/>
 		</XRD>`)
 	return err
 }
@@ -1,7 +1,6 @@
 package wellknown
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/davecheney/pub/activitypub"
@@ -16,15 +15,16 @@ func NodeInfoIndex(env *activitypub.Env, w http.ResponseWriter, r *http.Request)
 		"links": []map[string]any{
 			{
 				"rel":  "http://nodeinfo.diaspora.software/ns/schema/2.0",
-				"href": fmt.Sprintf("https://%s/api/nodeinfo/2.0", r.Host),
+				"href": env.URLs.URL("/nodeinfo/2.0"),
 			},
 		},
 	})
 }
 
 func NodeInfoShow(env *activitypub.Env, w http.ResponseWriter, r *http.Request) error {
+	// the service account may not exist until migrate up has been run.
 	var instance models.Instance
-	if err := env.DB.Where("domain = ?", r.Host).First(&instance).Error; err != nil {
+	if err := env.DB.Preload("ServiceAccount.Actor").Take(&instance, "domain = ?", r.Host).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return httpx.Error(http.StatusNotFound, err)
 		}
@@ -34,6 +34,10 @@ func NodeInfoShow(env *activitypub.Env, w http.ResponseWriter, r *http.Request)
 }
 
 func serializeNodeInfo(i *models.Instance) map[string]any {
+	metadata := map[string]any{}
+	if i.ServiceAccount != nil && i.ServiceAccount.Actor != nil {
+		metadata["instanceActor"] = i.ServiceAccount.Actor.URI
+	}
 	return map[string]any{
 		"version": "2.0", // https://github.com/jhass/nodeinfo/blob/main/schemas/2.0/schema.json
 		"software": map[string]any{
@@ -55,7 +59,7 @@ func serializeNodeInfo(i *models.Instance) map[string]any {
 			},
 			"localPosts": i.StatusesCount,
 		},
-		"openRegistrations": false,
-		"metadata":          map[string]any{},
+		"openRegistrations": i.RegistrationsEnabled(),
+		"metadata":          metadata,
 	}
 }
@@ -1,7 +1,6 @@
 package wellknown
 
 import (
-	"fmt"
 	"net/http"
 
 	"github.com/davecheney/pub/activitypub"
@@ -18,24 +17,25 @@ func WebfingerShow(env *activitypub.Env, w http.ResponseWriter, r *http.Request)
 		return httpx.Error(http.StatusBadRequest, err)
 	}
 	var actor models.Actor
-	if err := env.DB.First(&actor, "name = ? AND domain = ?", acct.User, r.Host).Error; err != nil {
+	if err := env.DB.Scopes(models.NotPending).First(&actor, "name = ? AND domain = ?", acct.User, r.Host).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return httpx.Error(http.StatusNotFound, err)
 		}
 		return err
 	}
-	self := acct.ID()
+	self := actor.URI
+	u := env.URLs
 	return to.JSON(w, map[string]any{
 		"subject": acct.String(),
 		"aliases": []string{
-			fmt.Sprintf("https://%s/@%s", actor.Domain, actor.Name),
+			u.Profile(actor.Name),
 			self,
 		},
 		"links": []map[string]any{
 			{
 				"rel":  "http://webfinger.net/rel/profile-page",
 				"type": "text/html",
-				"href": acct.Webfinger(),
+				"href": u.Profile(actor.Name),
 			},
 			{
 				"rel":  "self",
@@ -44,7 +44,7 @@ func WebfingerShow(env *activitypub.Env, w http.ResponseWriter, r *http.Request)
 			},
 			{
 				"rel":      "http://ostatus.org/schema/1.0/subscribe",
-				"template": fmt.Sprintf("https://%s/authorize_interaction?uri={uri}", actor.Domain),
+				"template": u.URL("/authorize_interaction?uri={uri}"),
 			},
 		},
 	})